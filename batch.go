@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/dabealu/s3-copy-dir/pkg/s3copy"
+	"io/ioutil"
+	"time"
+)
+
+// copy tenants of batch file, failure of one tenant doesn't stop the others
+func runBatchCommand(name string, args []string) int {
+	fs := newFlagSet(name)
+	g := addGlobalFlags(fs)
+	file := fs.String("file", "batch.json", "location of batch file")
+	reportFile := fs.String("report-file", "", "write json results of all tenants to this file")
+	reportDir := fs.String("report-dir", "", "write report of every tenant to <dir>/<name>.json, overrides report_dir of batch file")
+	maxParallel := fs.Int("max-parallel", 0, "tenants copied at the same time, overrides max_parallel of batch file")
+	maxConcurrency := fs.Int("max-concurrency", 0, "objects copied by all tenants at the same time, overrides max_concurrency of batch file")
+	gracePeriod := fs.Duration("grace-period", time.Second*30, "on SIGINT/SIGTERM wait this long for in-flight copies of running tenants before cancelling them")
+	parseFlags(fs, args)
+	g.setupLogging()
+
+	b, err := s3copy.LoadBatch(*file)
+	configFatal(err)
+	if *maxParallel > 0 {
+		b.MaxParallel = *maxParallel
+	}
+	if *maxConcurrency > 0 {
+		b.MaxConcurrency = *maxConcurrency
+	}
+	if *reportDir != "" {
+		b.ReportDir = *reportDir
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	defer handleShutdown(b, cancel, *gracePeriod)()
+
+	results := b.Run(ctx)
+	var succeeded, failed, skipped int
+	for _, res := range results {
+		switch res.Status {
+		case s3copy.StepSucceeded:
+			succeeded++
+		case s3copy.StepFailed:
+			failed++
+		case s3copy.StepSkipped:
+			skipped++
+		}
+	}
+	logInfo("batch completed, %d tenants succeeded, %d failed, %d skipped", succeeded, failed, skipped)
+	if *reportFile != "" {
+		out, err := json.MarshalIndent(map[string]interface{}{"tenants": results}, "", "    ")
+		if err == nil {
+			err = ioutil.WriteFile(*reportFile, out, 0644)
+		}
+		if err != nil {
+			logError("writing batch report: %s", err)
+		}
+	}
+	return s3copy.BatchExitCode(results)
+}
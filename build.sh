@@ -1,7 +1,7 @@
 #!/bin/bash
 set -e
-SRC_DIR=/usr/src/s3-copy-dir
+SRC_DIR=/go/src/github.com/dabealu/s3-copy-dir
 docker run --rm \
     -v $PWD:$SRC_DIR \
     -w $SRC_DIR golang:1.10-stretch \
-    bash -c "go get github.com/minio/minio-go && go build -v"
+    bash -c "go get github.com/minio/minio-go github.com/boltdb/bolt github.com/pkg/sftp golang.org/x/crypto/ssh golang.org/x/net/http2/h2c golang.org/x/text/unicode/norm && go build -v"
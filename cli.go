@@ -0,0 +1,433 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/dabealu/s3-copy-dir/pkg/s3copy"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// subcommand of the cli, run returns exit code
+type command struct {
+	usage string
+	run   func(name string, args []string) int
+}
+
+var commands = map[string]command{
+	"copy":            {"copy objects missing in destination (default command)", runCopyCommand},
+	"sync":            {"copy missing objects and re-copy objects modified in source", runCopyCommand},
+	"verify":          {"compare source and destination objects without copying", runVerifyCommand},
+	"count":           {"count objects and their size in source or destination", runCountCommand},
+	"ls":              {"list objects in source or destination", runLsCommand},
+	"analyze":         {"report objects and bytes per prefix and histogram of object sizes", runAnalyzeCommand},
+	"rm":              {"remove objects of the directory from destination or source", runRmCommand},
+	"purge":           {"remove objects moved to trash of destination before given time", runPurgeCommand},
+	"restore":         {"copy objects of backup generation from destination back to source", runRestoreCommand},
+	"cleanup-uploads": {"abort stale incomplete multipart uploads in destination", runCleanupCommand},
+	"estimate":        {"list source and print estimated requests, cost and duration of the copy", runEstimateCommand},
+	"bench":           {"measure throughput and latency of endpoints with synthetic objects", runBenchCommand},
+	"serve":           {"run as daemon accepting copy jobs over REST API", runServeCommand},
+	"coordinate":      {"list source and lease units of objects to workers of distributed copy", runCoordinateCommand},
+	"pipeline":        {"run copy, verify and removal steps of pipeline file in order of their dependencies", runPipelineCommand},
+	"batch":           {"copy buckets of many tenants listed in batch file, each with its own config and report", runBatchCommand},
+	"sample-config":   {"print sample config", runSampleConfigCommand},
+}
+
+func printUsage() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintf(os.Stderr, "usage: %s [command] [flags]\n\ncommands:\n", os.Args[0])
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-16s %s\n", name, commands[name].usage)
+	}
+	fmt.Fprintf(os.Stderr, "\nrun '%s <command> --help' for flags of the command\n", os.Args[0])
+}
+
+func main() {
+	// copy is the default command, so existing invocations with flags only keep working
+	name, args := "copy", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	if name == "help" {
+		printUsage()
+		os.Exit(s3copy.ExitOK)
+	}
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command '%s'\n\n", name)
+		printUsage()
+		os.Exit(s3copy.ExitConfigError)
+	}
+	os.Exit(cmd.run(name, args))
+}
+
+// flags shared by all commands: config location and logging
+type globalFlags struct {
+	confPath   *string
+	logFmt     *string
+	logLvl     *string
+	quiet      *bool
+	logEvery   *int64
+	logFile    *string
+	logMaxSize *string
+	logMaxAge  *time.Duration
+	logKeep    *int
+	relist     *bool
+}
+
+func addGlobalFlags(fs *flag.FlagSet) *globalFlags {
+	return &globalFlags{
+		confPath:   fs.String("config", "config.json", "location of config file"),
+		logFmt:     fs.String("log-format", s3copy.LogFormatText, "log format: text or json (one event per object)"),
+		logLvl:     fs.String("log-level", "info", "log level: debug, info, warn or error"),
+		quiet:      fs.Bool("quiet", false, "print only periodic summary and final result"),
+		logEvery:   fs.Int64("log-every", 1, "log only every Nth copied or skipped object, failures are always logged"),
+		logFile:    fs.String("log-file", "", "write logs to file instead of stderr"),
+		logMaxSize: fs.String("log-max-size", "100MiB", "rotate log file when it grows over this size, 0 - never"),
+		logMaxAge:  fs.Duration("log-max-age", 0, "rotate log file when it was written for longer than this since it was opened, 0 - never"),
+		logKeep:    fs.Int("log-keep", 7, "number of rotated log files to keep, 0 - keep all"),
+		relist:     fs.Bool("refresh-list-cache", false, "list source even if listing cached with list_cache option is fresh"),
+	}
+}
+
+// configure logging and load config file
+func (g *globalFlags) setup() *s3copy.Config {
+	g.setupLogging()
+	c, err := s3copy.LoadConfig(*g.confPath)
+	configFatal(err)
+	c.Run.RefreshListCache = *g.relist
+	if c.Options.AuditFile != "" {
+		configFatal(s3copy.OpenAuditLog(c.Options.AuditFile, c.Destination.String()))
+	}
+	return c
+}
+
+func (g *globalFlags) setupLogging() {
+	if *g.logFile != "" {
+		maxSize, err := s3copy.ParseByteSize(*g.logMaxSize)
+		configFatal(err)
+		rf, err := openRotatingFile(*g.logFile, maxSize, *g.logMaxAge, *g.logKeep)
+		configFatal(err)
+		s3copy.SetLogOutput(rf)
+	}
+	configFatal(s3copy.SetLogFormat(*g.logFmt))
+	configFatal(s3copy.SetLogLevel(*g.logLvl))
+	s3copy.SetLogEvery(*g.logEvery)
+	if *g.quiet {
+		configFatal(s3copy.SetLogLevel("quiet"))
+	}
+}
+
+// new flag set of command, errors are handled by parseFlags
+func newFlagSet(name string) *flag.FlagSet {
+	return flag.NewFlagSet(os.Args[0]+" "+name, flag.ContinueOnError)
+}
+
+func runSampleConfigCommand(name string, args []string) int {
+	parseFlags(newFlagSet(name), args)
+	data, err := json.MarshalIndent(s3copy.SampleConfig(), "", "    ")
+	if err != nil {
+		log.Println("ERROR:", err)
+		return s3copy.ExitError
+	}
+	fmt.Println(string(data))
+	return s3copy.ExitOK
+}
+
+// copy and sync commands, sync also re-copies objects modified in source since previous copy
+func runCopyCommand(name string, args []string) int {
+	fs := newFlagSet(name)
+	g := addGlobalFlags(fs)
+	showProgress := fs.Bool("progress", false, "show progress estimation, it requires to count objects before copying")
+	retryFailed := fs.Bool("retry-failed", false, "copy only objects listed in failed_file by previous run")
+	failFast := fs.Bool("fail-fast", false, "abort on the first failed object, same as --max-errors 1")
+	maxErrors := fs.Int64("max-errors", 0, "abort after given number of failed objects, 0 - never abort")
+	reconcilePasses := fs.Int("reconcile-passes", 0, "after copy, re-list source up to N times to copy objects created or modified during the run")
+	sinceLastRun := fs.Bool("since-last-run", false, "copy only objects modified since the last successful run recorded in state_file")
+	compare := fs.Bool("compare", false, "after copy, list source and destination and report object count and size of both sides")
+	watch := fs.Bool("watch", false, "after copy keep re-syncing new and modified objects every --interval until stopped")
+	interval := fs.Duration("interval", time.Minute*15, "interval of re-sync passes with --watch")
+	listen := fs.Bool("listen", false, "after copy keep replicating changes received from bucket notifications of MinIO source or sqs_queue_url until stopped")
+	consume := fs.Bool("consume", false, "copy keys consumed from work queue set in config instead of listing source, until stopped")
+	coordinator := fs.String("coordinator", "", "work for coordinator of distributed copy at this url, copying units of objects it leases")
+	coordinatorToken := fs.String("coordinator-token", os.Getenv("S3_COPY_DIR_TOKEN"), "bearer token of coordinator, defaults to $S3_COPY_DIR_TOKEN")
+	workerName := fs.String("worker-name", "", "name of worker reported to coordinator, hostname with random suffix by default")
+	shardIndex := fs.Int("shard-index", 0, "copy only shard with this index (0 to --shard-count - 1) of keys partitioned by hash")
+	shardCount := fs.Int("shard-count", 1, "number of shards instances started with different --shard-index partition the directory into")
+	heal := fs.Bool("heal", false, "compare checksums of objects existing in destination and re-copy mismatched ones")
+	retries := fs.Int("retries", 3, "number of retries of transient errors per object")
+	retryDelay := fs.Duration("retry-delay", time.Second, "delay before the first retry, doubled on every attempt")
+	gracePeriod := fs.Duration("grace-period", time.Second*30, "on SIGINT/SIGTERM wait this long for in-flight copies before cancelling them")
+	noBar := fs.Bool("no-progress-bar", false, "don't render progress bar when attached to terminal")
+	summaryInterval := fs.Duration("summary-interval", 0, "print progress summary with given interval, defaults to 1m with --quiet")
+	otlpEndpoint := fs.String("otlp-endpoint", "", "export traces to OTLP/HTTP collector, e.g. http://localhost:4318")
+	statusAddr := fs.String("status-addr", "", "serve json progress on /status at this address, e.g. :8080")
+	statusControl := fs.Bool("status-control", false, "accept POST of concurrency, bandwidth limit and pause of running copy on /control of --status-addr")
+	metricsAddr := fs.String("metrics-addr", "", "serve prometheus metrics on this address, e.g. :9100")
+	include := fs.String("include", "", "comma separated glob patterns, copy only matching objects, e.g. '*.jpg,thumbs/*'")
+	exclude := fs.String("exclude", "", "comma separated glob patterns, skip matching objects")
+	metadata := fs.String("metadata", "", "comma separated name=pattern pairs, copy only objects with matching user metadata, e.g. 'export=true', added to metadata_filter option")
+	bwLimit := fs.String("bandwidth-limit", "", "limit rate of data read from source per second, e.g. 50MiB")
+	preflight := fs.Bool("preflight", false, "check permissions of source and destination before copying, same as preflight option")
+	checkCapacity := fs.Bool("check-capacity", false, "check size of source objects against free capacity of destination before copying, same as check_capacity option")
+	bucketConfig := fs.Bool("bucket-config", false, "mirror settings of source bucket (policy, lifecycle, tags, CORS, encryption, notifications) to destination, same as copy_bucket_config option")
+	skipPolicy := fs.String("skip-policy", "", "when object existing in destination is kept: never, if-exists, if-same-size, if-same-etag, if-not-newer or always, same as skip_policy option")
+	conditionalPut := fs.Bool("conditional-put", false, "write objects only if destination object didn't change since it was checked, same as conditional_put option")
+	enableVersioning := fs.Bool("enable-versioning", false, "enable versioning of destination bucket before copying, same as enable_versioning option")
+	ndjson := fs.Bool("ndjson", false, "write outcome of every processed object to stdout as json line, logs stay on stderr")
+	confirmAbove := fs.String("confirm-above", "", "count source first and ask for confirmation if directory is larger than this, e.g. 10TiB")
+	yes := fs.Bool("yes", false, "don't ask for confirmation with --confirm-above, required when stdin isn't a terminal")
+	entireBucket := fs.Bool("entire-bucket", false, "copy whole bucket when directory is empty, same as entire_bucket option")
+	parseFlags(fs, args)
+	c := g.setup()
+	if *g.quiet && *summaryInterval == 0 {
+		*summaryInterval = time.Minute
+	}
+
+	c.Run = s3copy.RunOptions{
+		Progress:         *showProgress,
+		RetryFailed:      *retryFailed,
+		MaxErrors:        *maxErrors,
+		Retries:          *retries,
+		RetryDelay:       *retryDelay,
+		Heal:             *heal,
+		Sync:             name == "sync",
+		ReconcilePasses:  *reconcilePasses,
+		Compare:          *compare,
+		SinceLastRun:     *sinceLastRun,
+		Listen:           *listen,
+		Consume:          *consume || *coordinator != "",
+		Coordinator:      *coordinator,
+		CoordinatorToken: *coordinatorToken,
+		WorkerName:       *workerName,
+		ShardIndex:       *shardIndex,
+		ShardCount:       *shardCount,
+		SummaryInterval:  *summaryInterval,
+		MetricsAddr:      *metricsAddr,
+		StatusAddr:       *statusAddr,
+		StatusControl:    *statusControl,
+		OTLPEndpoint:     *otlpEndpoint,
+		RefreshListCache: c.Run.RefreshListCache,
+		ProgressBar:      !*noBar && *g.logFile == "" && *g.logFmt == s3copy.LogFormatText && isTerminal(os.Stderr),
+	}
+	if *failFast {
+		c.Run.MaxErrors = 1
+	}
+	if *ndjson {
+		c.Run.ResultsOutput = os.Stdout
+	}
+	if *enableVersioning {
+		c.Options.EnableVersioning = true
+	}
+	if *preflight {
+		c.Options.Preflight = true
+	}
+	if *checkCapacity {
+		c.Options.CheckCapacity = true
+	}
+	if *bucketConfig {
+		c.Options.CopyBucketConfig = true
+	}
+	if *conditionalPut {
+		c.Options.ConditionalPut = true
+	}
+	if *skipPolicy != "" {
+		c.Options.SkipPolicy = *skipPolicy
+		configFatal(c.Validate())
+	}
+	if *metadata != "" {
+		if c.Options.MetadataFilter == nil {
+			c.Options.MetadataFilter = map[string]string{}
+		}
+		for _, pair := range strings.Split(*metadata, ",") {
+			i := strings.Index(pair, "=")
+			if i <= 0 {
+				configFatal(fmt.Errorf("invalid --metadata '%s', must be name=pattern", pair))
+			}
+			configFatal(s3copy.ValidatePatterns(pair[i+1:]))
+			c.Options.MetadataFilter[pair[:i]] = pair[i+1:]
+		}
+	}
+	// copy of whole bucket must be requested explicitly or confirmed
+	if c.Options.Directory == "" && !c.Options.EntireBucket && !c.Run.Consume {
+		if !*entireBucket && !isTerminal(os.Stdin) {
+			configFatal(fmt.Errorf("directory is empty, pass --entire-bucket to copy whole bucket '%s'", c.Options.Bucket))
+		}
+		summary := []string{fmt.Sprintf("directory is empty, whole bucket '%s' is copied from %s to %s",
+			c.Options.Bucket, c.Source.String(), c.Destination.String())}
+		if !*entireBucket && !confirm(false, summary) {
+			return s3copy.ExitError
+		}
+		c.Options.EntireBucket = true
+	}
+	if *watch {
+		if *interval <= 0 {
+			configFatal(fmt.Errorf("--interval must be positive"))
+		}
+		c.Run.WatchInterval = *interval
+	}
+
+	var opts []s3copy.Option
+	// patterns match the whole key or its trailing part, e.g. '*.log' or 'thumbs/*'
+	if *include != "" {
+		patterns := strings.Split(*include, ",")
+		configFatal(s3copy.ValidatePatterns(patterns...))
+		opts = append(opts, s3copy.WithFilters(s3copy.Include(patterns...)))
+	}
+	if *exclude != "" {
+		patterns := strings.Split(*exclude, ",")
+		configFatal(s3copy.ValidatePatterns(patterns...))
+		opts = append(opts, s3copy.WithFilters(s3copy.Exclude(patterns...)))
+	}
+	if *bwLimit != "" {
+		limit, err := s3copy.ParseByteSize(*bwLimit)
+		configFatal(err)
+		opts = append(opts, s3copy.WithBandwidthLimit(limit))
+	}
+
+	cp, err := s3copy.NewCopier(c, opts...)
+	configFatal(err)
+	if *confirmAbove != "" {
+		threshold, err := s3copy.ParseByteSize(*confirmAbove)
+		configFatal(err)
+		count, size, err := s3copy.Count(c, s3copy.TargetSource)
+		if err != nil {
+			logError("%s", err)
+			return s3copy.ExitError
+		}
+		summary := []string{
+			fmt.Sprintf("%s '%s/%s' from %s to %s", name, c.Options.Bucket, c.Options.Directory, c.Source.String(), c.Destination.String()),
+			fmt.Sprintf("objects in source: %d, %s", count, s3copy.FormatBytes(size)),
+		}
+		if *listen {
+			summary = append(summary, "objects removed from source are removed from destination")
+		}
+		if size > threshold && !confirm(*yes, summary) {
+			return s3copy.ExitError
+		}
+	}
+
+	// cancelling context aborts in-flight copies, signals stop copier gracefully first
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	defer handleShutdown(cp, cancel, *gracePeriod)()
+	defer handlePause(cp)()
+	defer handleStatsDump(cp)()
+
+	sdStopCh := make(chan struct{})
+	go sdSupervise(copyStatus(cp), sdStopCh)
+	defer close(sdStopCh)
+
+	res, err := cp.Run(ctx)
+	if err != nil {
+		log.Println("ERROR:", err)
+		if _, ok := err.(*s3copy.LockError); ok {
+			return s3copy.ExitLocked
+		}
+		return s3copy.ExitError
+	}
+	return res.ExitCode
+}
+
+func runBenchCommand(name string, args []string) int {
+	fs := newFlagSet(name)
+	g := addGlobalFlags(fs)
+	sizesFlag := fs.String("sizes", "4KiB,1MiB,16MiB", "comma separated list of object sizes")
+	count := fs.Int("count", 20, "number of objects of each size")
+	concurrency := fs.Int("concurrency", 0, "number of concurrent operations, defaults to concurrency from config")
+	prefix := fs.String("prefix", "s3-copy-dir-bench/", "prefix for synthetic objects, removed after benchmark")
+	target := fs.String("target", "both", "endpoint to benchmark: source, destination or both")
+	parseFlags(fs, args)
+
+	sizes, err := s3copy.ParseSizeList(*sizesFlag)
+	configFatal(err)
+	c := g.setup()
+
+	err = s3copy.Bench(c, s3copy.BenchOptions{
+		Sizes:       sizes,
+		Count:       *count,
+		Concurrency: *concurrency,
+		Prefix:      *prefix,
+		Target:      *target,
+	})
+	if err != nil {
+		logError("%s", err)
+		return s3copy.ExitError
+	}
+	return s3copy.ExitOK
+}
+
+func runCleanupCommand(name string, args []string) int {
+	fs := newFlagSet(name)
+	g := addGlobalFlags(fs)
+	olderThan := fs.Duration("older-than", time.Hour*24, "abort uploads started more than this ago")
+	dryRun := fs.Bool("dry-run", false, "only print uploads which would be aborted")
+	parseFlags(fs, args)
+
+	code, err := s3copy.CleanupUploads(g.setup(), *olderThan, *dryRun)
+	if err != nil {
+		log.Println("ERROR:", err)
+	}
+	return code
+}
+
+func runEstimateCommand(name string, args []string) int {
+	fs := newFlagSet(name)
+	g := addGlobalFlags(fs)
+	probe := fs.Duration("probe", time.Second*10, "copy sample of source objects this long to predict duration of the copy, 0 - estimate only cost")
+	probePrefix := fs.String("probe-prefix", "s3-copy-dir-estimate/", "prefix of destination for probe copies, removed right after they're measured")
+	concurrency := fs.Int("concurrency", 0, "number of concurrent copies, defaults to concurrency from config")
+	bwLimit := fs.String("bandwidth-limit", "", "limit rate of data read from source per second the copy will run with, e.g. 50MiB")
+	parseFlags(fs, args)
+
+	o := s3copy.EstimateOptions{Probe: *probe, ProbePrefix: *probePrefix, Concurrency: *concurrency}
+	if *bwLimit != "" {
+		limit, err := s3copy.ParseByteSize(*bwLimit)
+		configFatal(err)
+		o.BandwidthLimit = limit
+	}
+	if _, err := s3copy.EstimateRun(g.setup(), o); err != nil {
+		logError("%s", err)
+		return s3copy.ExitError
+	}
+	return s3copy.ExitOK
+}
+
+// exit with config error code
+func configFatal(err error) {
+	if err != nil {
+		log.Println("ERROR: config:", err)
+		os.Exit(s3copy.ExitConfigError)
+	}
+}
+
+// parse flags, invalid flags are reported with config error exit code
+func parseFlags(fs *flag.FlagSet, args []string) {
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			os.Exit(s3copy.ExitOK)
+		}
+		os.Exit(s3copy.ExitConfigError)
+	}
+}
+
+// check if file is attached to terminal
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+func logDebug(format string, args ...interface{}) { s3copy.Logf(s3copy.LevelDebug, format, args...) }
+func logInfo(format string, args ...interface{})  { s3copy.Logf(s3copy.LevelInfo, format, args...) }
+func logWarn(format string, args ...interface{})  { s3copy.Logf(s3copy.LevelWarn, format, args...) }
+func logError(format string, args ...interface{}) { s3copy.Logf(s3copy.LevelError, format, args...) }
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/dabealu/s3-copy-dir/pkg/s3copy"
+	"log"
+	"strings"
+	"time"
+)
+
+// print objects of the directory, one per line: size, last modified time and key
+func runLsCommand(name string, args []string) int {
+	fs := newFlagSet(name)
+	g := addGlobalFlags(fs)
+	target := fs.String("target", s3copy.TargetSource, "endpoint to list: source or destination")
+	parseFlags(fs, args)
+
+	err := s3copy.List(g.setup(), *target, func(obj s3copy.Object) {
+		fmt.Printf("%12d  %s  %s\n", obj.Size, obj.LastModified.UTC().Format("2006-01-02T15:04:05Z"), obj.Key)
+	})
+	if err != nil {
+		logError("%s", err)
+		return s3copy.ExitError
+	}
+	return s3copy.ExitOK
+}
+
+func runCountCommand(name string, args []string) int {
+	fs := newFlagSet(name)
+	g := addGlobalFlags(fs)
+	target := fs.String("target", s3copy.TargetSource, "endpoint to count objects in: source or destination")
+	parseFlags(fs, args)
+
+	count, size, err := s3copy.Count(g.setup(), *target)
+	if err != nil {
+		logError("%s", err)
+		return s3copy.ExitError
+	}
+	fmt.Printf("%d objects, %d bytes (%s)\n", count, size, s3copy.FormatBytes(size))
+	return s3copy.ExitOK
+}
+
+// print objects and bytes per prefix of the directory and histogram of object sizes
+func runAnalyzeCommand(name string, args []string) int {
+	fs := newFlagSet(name)
+	g := addGlobalFlags(fs)
+	target := fs.String("target", s3copy.TargetSource, "endpoint to analyze: source or destination")
+	depth := fs.Int("depth", 1, "number of path segments below directory grouping objects into prefixes")
+	top := fs.Int("top", 20, "print only this many largest prefixes, 0 - all")
+	asJSON := fs.Bool("json", false, "print analysis as json")
+	parseFlags(fs, args)
+
+	a, err := s3copy.Analyze(g.setup(), *target, *depth)
+	if err != nil {
+		logError("%s", err)
+		return s3copy.ExitError
+	}
+	if *asJSON {
+		data, err := json.MarshalIndent(a, "", "    ")
+		configFatal(err)
+		fmt.Println(string(data))
+		return s3copy.ExitOK
+	}
+	share := func(bytes int64) float64 {
+		if a.Bytes == 0 {
+			return 0
+		}
+		return float64(bytes) / float64(a.Bytes) * 100
+	}
+	fmt.Printf("%d objects, %d bytes (%s)", a.Objects, a.Bytes, s3copy.FormatBytes(a.Bytes))
+	if a.Objects > 0 {
+		fmt.Printf(", average %s, largest %s '%s'", s3copy.FormatBytes(a.Bytes/a.Objects), s3copy.FormatBytes(a.Largest.Size), a.Largest.Key)
+	}
+	fmt.Printf("\n\n%-40s  %12s  %10s  %6s\n", "prefix", "objects", "bytes", "share")
+	for i, ps := range a.Prefixes {
+		if *top > 0 && i == *top {
+			fmt.Printf("... %d more prefixes\n", len(a.Prefixes)-i)
+			break
+		}
+		fmt.Printf("%-40s  %12d  %10s  %5.1f%%\n", ps.Prefix, ps.Objects, s3copy.FormatBytes(ps.Bytes), share(ps.Bytes))
+	}
+	fmt.Printf("\n%-40s  %12s  %10s  %6s\n", "size", "objects", "bytes", "share")
+	for _, sc := range a.Histogram {
+		fmt.Printf("%-40s  %12d  %10s  %5.1f%%\n", sc.Label(), sc.Objects, s3copy.FormatBytes(sc.Bytes), share(sc.Bytes))
+	}
+	return s3copy.ExitOK
+}
+
+// remove all objects of the directory, by default from destination
+func runRmCommand(name string, args []string) int {
+	fs := newFlagSet(name)
+	g := addGlobalFlags(fs)
+	target := fs.String("target", s3copy.TargetDestination, "endpoint to remove objects from: destination or source")
+	dryRun := fs.Bool("dry-run", false, "only print objects which would be removed")
+	orphans := fs.Bool("orphans", false, "remove only objects of destination which don't exist in source, they're moved to trash if it's set")
+	yes := fs.Bool("yes", false, "don't ask for confirmation, required when stdin isn't a terminal")
+	parseFlags(fs, args)
+	c := g.setup()
+	if c.Options.Directory == "" {
+		configFatal(fmt.Errorf("refusing to remove whole bucket '%s', directory is empty", c.Options.Bucket))
+	}
+	if *orphans && *target != s3copy.TargetDestination {
+		configFatal(fmt.Errorf("--orphans removes objects from destination only"))
+	}
+
+	if !*dryRun {
+		summary := []string{fmt.Sprintf("remove objects of '%s/%s' from %s", c.Options.Bucket, c.Options.Directory, *target)}
+		if *orphans {
+			summary[0] = fmt.Sprintf("remove objects of '%s/%s' from destination which don't exist in source", c.Options.Bucket, c.Options.Directory)
+		}
+		summary = append(summary, countSummary(c, *target))
+		if !confirm(*yes, summary) {
+			return s3copy.ExitError
+		}
+	}
+
+	var failed int64
+	var err error
+	if *orphans {
+		_, failed, err = s3copy.RemoveOrphans(c, *dryRun)
+	} else {
+		_, failed, err = s3copy.Remove(c, *target, *dryRun)
+	}
+	if err != nil {
+		logError("%s", err)
+		return s3copy.ExitError
+	}
+	if failed > 0 {
+		return s3copy.ExitPartial
+	}
+	return s3copy.ExitOK
+}
+
+// remove objects moved to trash of destination before given time
+func runPurgeCommand(name string, args []string) int {
+	fs := newFlagSet(name)
+	g := addGlobalFlags(fs)
+	olderThan := fs.Duration("older-than", time.Hour*24*7, "remove objects moved to trash more than this ago, 0 - all")
+	dryRun := fs.Bool("dry-run", false, "only print objects which would be removed")
+	yes := fs.Bool("yes", false, "don't ask for confirmation, required when stdin isn't a terminal")
+	parseFlags(fs, args)
+	c := g.setup()
+	if c.Options.Trash == "" {
+		configFatal(fmt.Errorf("trash isn't set in config"))
+	}
+
+	if !*dryRun {
+		summary := []string{fmt.Sprintf("remove objects moved to trash '%s/%s' of destination more than %s ago", c.Options.Bucket, c.Options.Trash, *olderThan)}
+		if !confirm(*yes, summary) {
+			return s3copy.ExitError
+		}
+	}
+	_, failed, err := s3copy.PurgeTrash(c, *olderThan, *dryRun)
+	if err != nil {
+		logError("%s", err)
+		return s3copy.ExitError
+	}
+	if failed > 0 {
+		return s3copy.ExitPartial
+	}
+	return s3copy.ExitOK
+}
+
+// check every source object exists in destination with the same size,
+// with --checksum content of objects is compared as well, --sample checks content of some of them
+func runVerifyCommand(name string, args []string) int {
+	fs := newFlagSet(name)
+	g := addGlobalFlags(fs)
+	checksum := fs.Bool("checksum", false, "compare content of objects, downloads objects without plain md5 ETags")
+	sample := fs.Float64("sample", 0, "download and compare content of this percent of objects only, e.g. 5")
+	seed := fs.Int64("seed", 0, "seed of --sample, the same seed picks the same objects, random by default")
+	confidence := fs.Float64("confidence", 0.95, "confidence of estimated share of mismatched objects with --sample")
+	parseFlags(fs, args)
+
+	if *sample != 0 {
+		res, err := s3copy.VerifySample(g.setup(), s3copy.SampleOptions{Percent: *sample, Seed: *seed, Confidence: *confidence})
+		if err != nil {
+			logError("%s", err)
+			return s3copy.ExitError
+		}
+		if res.Missing+res.Differ+res.Failed > 0 {
+			return s3copy.ExitPartial
+		}
+		return s3copy.ExitOK
+	}
+	res, err := s3copy.Verify(g.setup(), *checksum)
+	if err != nil {
+		logError("%s", err)
+		return s3copy.ExitError
+	}
+	if res.Missing+res.Differ+res.Failed > 0 {
+		return s3copy.ExitPartial
+	}
+	return s3copy.ExitOK
+}
+
+// copy objects of backup generation from destination back to the source directory
+func runRestoreCommand(name string, args []string) int {
+	fs := newFlagSet(name)
+	g := addGlobalFlags(fs)
+	generation := fs.String("generation", "", "name of backup generation to restore, the latest complete one by default")
+	at := fs.String("at", "", "restore the latest complete generation finished at or before this time, RFC3339 or date, e.g. 2024-06-01")
+	list := fs.Bool("list", false, "only print generations of backup")
+	dryRun := fs.Bool("dry-run", false, "only print objects which would be restored")
+	overwrite := fs.Bool("overwrite", false, "re-copy objects existing in source with content different from the generation")
+	include := fs.String("include", "", "comma separated glob patterns, restore only matching objects, e.g. '*.jpg,thumbs/*'")
+	exclude := fs.String("exclude", "", "comma separated glob patterns, skip matching objects")
+	retries := fs.Int("retries", 3, "number of retries of transient errors per object")
+	gracePeriod := fs.Duration("grace-period", time.Second*30, "on SIGINT/SIGTERM wait this long for in-flight copies before cancelling them")
+	yes := fs.Bool("yes", false, "don't ask for confirmation with --overwrite, required when stdin isn't a terminal")
+	parseFlags(fs, args)
+	c := g.setup()
+	if *generation != "" && *at != "" {
+		configFatal(fmt.Errorf("--generation and --at can't be used together"))
+	}
+
+	if *list {
+		gens, err := s3copy.BackupGenerations(c)
+		configFatal(err)
+		for _, gen := range gens {
+			finished, state := "-", "incomplete"
+			if gen.Finished != nil {
+				finished = gen.Finished.UTC().Format(time.RFC3339)
+			}
+			if gen.Complete {
+				state = "complete"
+			}
+			fmt.Printf("%-24s  %s  %-20s  %s\n", gen.Name, gen.Started.UTC().Format(time.RFC3339), finished, state)
+		}
+		return s3copy.ExitOK
+	}
+
+	ro := s3copy.RestoreOptions{Generation: *generation, Overwrite: *overwrite}
+	if *at != "" {
+		t, err := time.Parse(time.RFC3339, *at)
+		if err != nil {
+			// generations finished during the day are restored
+			if t, err = time.Parse("2006-01-02", *at); err != nil {
+				configFatal(fmt.Errorf("invalid --at '%s', must be RFC3339 time or date, e.g. 2024-06-01", *at))
+			}
+			t = t.Add(time.Hour*24 - time.Nanosecond)
+		}
+		ro.At = t
+	}
+	c.Run.Retries, c.Run.RetryDelay = *retries, time.Second
+	var opts []s3copy.Option
+	if *include != "" {
+		patterns := strings.Split(*include, ",")
+		configFatal(s3copy.ValidatePatterns(patterns...))
+		opts = append(opts, s3copy.WithFilters(s3copy.Include(patterns...)))
+	}
+	if *exclude != "" {
+		patterns := strings.Split(*exclude, ",")
+		configFatal(s3copy.ValidatePatterns(patterns...))
+		opts = append(opts, s3copy.WithFilters(s3copy.Exclude(patterns...)))
+	}
+
+	if *dryRun {
+		_, err := s3copy.PlanRestore(c, ro, opts...)
+		if err != nil {
+			logError("%s", err)
+			return s3copy.ExitError
+		}
+		return s3copy.ExitOK
+	}
+	cp, err := s3copy.NewRestorer(c, ro, opts...)
+	configFatal(err)
+	if *overwrite {
+		summary := []string{
+			fmt.Sprintf("restore '%s/%s' from %s to %s", c.Options.Bucket, c.Options.Directory, c.Destination.String(), c.Source.String()),
+			"objects of source with content different from the generation are overwritten",
+			countSummary(c, s3copy.TargetSource),
+		}
+		if !confirm(*yes, summary) {
+			return s3copy.ExitError
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	defer handleShutdown(cp, cancel, *gracePeriod)()
+	defer handlePause(cp)()
+
+	res, err := cp.Run(ctx)
+	if err != nil {
+		log.Println("ERROR:", err)
+		if _, ok := err.(*s3copy.LockError); ok {
+			return s3copy.ExitLocked
+		}
+		return s3copy.ExitError
+	}
+	return res.ExitCode
+}
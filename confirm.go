@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/dabealu/s3-copy-dir/pkg/s3copy"
+	"os"
+	"strings"
+)
+
+// print pre-flight summary of destructive or large operation and ask for confirmation.
+// with --yes operation proceeds right away, without terminal it's refused, so unattended
+// runs have to pass --yes explicitly. returns false if operation must not proceed
+func confirm(yes bool, summary []string) bool {
+	fmt.Fprintln(os.Stderr, "pre-flight summary:")
+	for _, line := range summary {
+		fmt.Fprintln(os.Stderr, "  "+line)
+	}
+	if yes {
+		return true
+	}
+	if !isTerminal(os.Stdin) {
+		logError("confirmation required, stdin isn't a terminal, pass --yes to proceed")
+		return false
+	}
+	fmt.Fprint(os.Stderr, "proceed? [y/N] ")
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	}
+	logWarn("not confirmed, aborting")
+	return false
+}
+
+// number and size of objects of the directory shown in summary
+func countSummary(c *s3copy.Config, target string) string {
+	count, size, err := s3copy.Count(c, target)
+	if err != nil {
+		return fmt.Sprintf("objects in %s: unknown, counting failed: %s", target, err)
+	}
+	return fmt.Sprintf("objects in %s '%s/%s': %d, %s", target, c.Options.Bucket, c.Options.Directory, count, s3copy.FormatBytes(size))
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"github.com/dabealu/s3-copy-dir/pkg/s3copy"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// coordinator keeps serving after copy completed, so idle workers learn they can exit
+const coordinatorLinger = time.Second * 10
+
+// coordinate distributed copy: list source once and lease units of objects to workers
+// started with `copy --coordinator <url>`, until all units are done or SIGINT/SIGTERM
+func runCoordinateCommand(name string, args []string) int {
+	fs := newFlagSet(name)
+	g := addGlobalFlags(fs)
+	addr := fs.String("addr", ":7070", "address of API used by workers")
+	token := fs.String("token", os.Getenv("S3_COPY_DIR_TOKEN"), "bearer token required from workers, defaults to $S3_COPY_DIR_TOKEN")
+	shardBy := fs.String("shard-by", s3copy.ShardByHash, "group keys into units by hash of the key or by top-level prefix: hash or prefix")
+	shards := fs.Int("shards", 64, "number of hash shards with --shard-by hash")
+	unitSize := fs.Int("unit-size", 0, "objects per unit leased to a worker, list_page_size by default")
+	lease := fs.Duration("lease", time.Minute*5, "unit is re-assigned to another worker unless its lease is renewed within this time")
+	parseFlags(fs, args)
+	c := g.setup()
+
+	co, err := s3copy.NewCoordinator(c, s3copy.CoordinatorOptions{ShardBy: *shardBy, Shards: *shards, UnitSize: *unitSize, Lease: *lease})
+	configFatal(err)
+	if *token == "" {
+		logWarn("API token isn't set, anyone reaching the API can lease objects")
+	}
+	ln, err := net.Listen("tcp", *addr)
+	configFatal(err)
+	logInfo("serving coordinator API on http://%s, status on /status", ln.Addr())
+	go func() {
+		logError("http server: %s", http.Serve(ln, co.Handler(*token)))
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-sigCh
+		logWarn("received %s, stopping distributed copy, workers finish their units", sig)
+		cancel()
+	}()
+
+	status, err := co.Run(ctx)
+	if err != nil {
+		logError("%s", err)
+		return s3copy.ExitError
+	}
+	time.Sleep(coordinatorLinger)
+	return status.ExitCode
+}
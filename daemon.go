@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"github.com/dabealu/s3-copy-dir/pkg/s3copy"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// serve REST and/or gRPC job API and submit scheduled jobs until SIGINT/SIGTERM,
+// running jobs are stopped and started again on the next start of the daemon
+func runServeCommand(name string, args []string) int {
+	fs := newFlagSet(name)
+	g := addGlobalFlags(fs)
+	addr := fs.String("addr", ":8080", "address of REST API, empty to disable it")
+	grpcAddr := fs.String("grpc-addr", "", "address of gRPC API (plaintext HTTP/2), disabled if empty")
+	jobsDir := fs.String("jobs-dir", "jobs", "directory where jobs are persisted")
+	maxJobs := fs.Int("max-jobs", 1, "number of jobs running at the same time")
+	token := fs.String("token", os.Getenv("S3_COPY_DIR_TOKEN"), "bearer token required by API, defaults to $S3_COPY_DIR_TOKEN")
+	scheduleFile := fs.String("schedule", "", "json file of jobs submitted on cron schedules")
+	gracePeriod := fs.Duration("grace-period", time.Second*30, "on SIGINT/SIGTERM wait this long for in-flight copies of running jobs")
+	parseFlags(fs, args)
+	g.setupLogging()
+
+	var schedule []s3copy.ScheduledJob
+	if *scheduleFile != "" {
+		var err error
+		schedule, err = s3copy.LoadSchedule(*scheduleFile)
+		configFatal(err)
+	}
+	if *addr == "" && *grpcAddr == "" && *scheduleFile == "" {
+		configFatal(fmt.Errorf("at least one of --addr, --grpc-addr or --schedule must be set"))
+	}
+	m, err := s3copy.OpenJobManager(*jobsDir, *maxJobs)
+	configFatal(err)
+	if *token == "" && (*addr != "" || *grpcAddr != "") {
+		logWarn("API token isn't set, anyone reaching the API can submit jobs")
+	}
+	var listeners []net.Listener
+	if *addr != "" {
+		ln, err := net.Listen("tcp", *addr)
+		configFatal(err)
+		listeners = append(listeners, ln)
+		logInfo("serving job API on http://%s/jobs, jobs are persisted in '%s'", ln.Addr(), *jobsDir)
+		go func() {
+			logError("http server: %s", http.Serve(ln, m.Handler(*token)))
+		}()
+	}
+	if *grpcAddr != "" {
+		ln, err := net.Listen("tcp", *grpcAddr)
+		configFatal(err)
+		listeners = append(listeners, ln)
+		logInfo("serving gRPC job API on %s, jobs are persisted in '%s'", ln.Addr(), *jobsDir)
+		go func() {
+			logError("grpc server: %s", http.Serve(ln, h2c.NewHandler(m.GRPCHandler(*token), &http2.Server{})))
+		}()
+	}
+
+	scheduleStopCh, scheduleDoneCh := make(chan struct{}), make(chan struct{})
+	go func() {
+		m.RunSchedule(schedule, scheduleStopCh)
+		close(scheduleDoneCh)
+	}()
+
+	// listeners are bound, systemd is notified that daemon is ready
+	sdStopCh, sdDoneCh := make(chan struct{}), make(chan struct{})
+	go func() {
+		sdSupervise(daemonStatus(m), sdStopCh)
+		close(sdDoneCh)
+	}()
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-sigCh
+	logWarn("received %s, stopping running jobs, waiting up to %s for in-flight copies", sig, *gracePeriod)
+	close(sdStopCh)
+	<-sdDoneCh
+	for _, ln := range listeners {
+		ln.Close()
+	}
+	close(scheduleStopCh)
+	<-scheduleDoneCh
+	m.Shutdown(*gracePeriod)
+	return s3copy.ExitOK
+}
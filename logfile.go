@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// log file which is rotated when it grows over maxSize or gets older than maxAge,
+// writes are unbuffered. only `keep` most recent rotated files are retained
+type rotatingFile struct {
+	sync.Mutex
+	path    string
+	maxSize int64
+	maxAge  time.Duration
+	keep    int
+
+	file    *os.File
+	size    int64
+	created time.Time
+}
+
+func openRotatingFile(path string, maxSize int64, maxAge time.Duration, keep int) (*rotatingFile, error) {
+	rf := &rotatingFile{path: path, maxSize: maxSize, maxAge: maxAge, keep: keep}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+// open log file for appending, existing file continues to grow. creation time of files isn't
+// kept by all filesystems, so age of file is measured from its opening
+func (rf *rotatingFile) open() error {
+	f, size, err := openLogFile(rf.path)
+	if err != nil {
+		return err
+	}
+	rf.file, rf.size, rf.created = f, size, time.Now()
+	return nil
+}
+
+func openLogFile(path string) (*os.File, int64, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, 0, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	return f, fi.Size(), nil
+}
+
+func (rf *rotatingFile) Write(p []byte) (int, error) {
+	rf.Lock()
+	defer rf.Unlock()
+
+	if (rf.maxSize > 0 && rf.size+int64(len(p)) > rf.maxSize && rf.size > 0) ||
+		(rf.maxAge > 0 && time.Since(rf.created) > rf.maxAge) {
+		if err := rf.rotate(); err != nil {
+			fmt.Fprintln(os.Stderr, "ERROR: rotating log file:", err)
+		}
+	}
+
+	n, err := rf.file.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+// rename current file with timestamp suffix, open new file and remove old rotated files.
+// current file is closed only once the new one is open, so if rotation fails logs are still
+// written to it
+func (rf *rotatingFile) rotate() error {
+	rotated := rf.path + "." + time.Now().Format("20060102-150405.000")
+	if err := os.Rename(rf.path, rotated); err != nil {
+		return err
+	}
+	f, size, err := openLogFile(rf.path)
+	if err != nil {
+		os.Rename(rotated, rf.path)
+		return err
+	}
+	old := rf.file
+	rf.file, rf.size, rf.created = f, size, time.Now()
+	if err := old.Close(); err != nil {
+		return err
+	}
+
+	if rf.keep <= 0 {
+		return nil
+	}
+	files, err := filepath.Glob(rf.path + ".*")
+	if err != nil {
+		return err
+	}
+	// timestamp suffix sorts lexicographically
+	sort.Strings(files)
+	for len(files) > rf.keep {
+		if err := os.Remove(files[0]); err != nil {
+			return err
+		}
+		files = files[1:]
+	}
+	return nil
+}
+
+func (rf *rotatingFile) Close() error {
+	rf.Lock()
+	defer rf.Unlock()
+	return rf.file.Close()
+}
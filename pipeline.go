@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/dabealu/s3-copy-dir/pkg/s3copy"
+	"io/ioutil"
+	"time"
+)
+
+// run steps of pipeline file in order of their dependencies, e.g. copy, then verify,
+// then delete-orphans only if verify passed. independent steps run at the same time
+func runPipelineCommand(name string, args []string) int {
+	fs := newFlagSet(name)
+	g := addGlobalFlags(fs)
+	file := fs.String("file", "pipeline.json", "location of pipeline file")
+	reportFile := fs.String("report-file", "", "write json results of steps to this file")
+	maxParallel := fs.Int("max-parallel", 0, "steps running at the same time, overrides max_parallel of pipeline file")
+	maxConcurrency := fs.Int("max-concurrency", 0, "objects processed by all running steps at the same time, overrides max_concurrency of pipeline file")
+	yes := fs.Bool("yes", false, "don't ask for confirmation of rm and delete-orphans steps, required when stdin isn't a terminal")
+	gracePeriod := fs.Duration("grace-period", time.Second*30, "on SIGINT/SIGTERM wait this long for in-flight copies of running steps before cancelling them")
+	parseFlags(fs, args)
+	g.setupLogging()
+
+	p, err := s3copy.LoadPipeline(*file)
+	configFatal(err)
+	if *maxParallel > 0 {
+		p.MaxParallel = *maxParallel
+	}
+	if *maxConcurrency > 0 {
+		p.MaxConcurrency = *maxConcurrency
+	}
+	var summary []string
+	for _, st := range p.Steps {
+		if (st.Action == s3copy.StepRemove || st.Action == s3copy.StepRemoveOrphans) && !st.DryRun {
+			c := st.Config
+			summary = append(summary, fmt.Sprintf("step '%s' removes objects (%s) of '%s/%s'", st.Name, st.Action, c.Options.Bucket, c.Options.Directory))
+		}
+	}
+	if len(summary) > 0 && !confirm(*yes, summary) {
+		return s3copy.ExitError
+	}
+	if p.Config != nil && p.Config.Options.AuditFile != "" {
+		configFatal(s3copy.OpenAuditLog(p.Config.Options.AuditFile, p.Config.Destination.String()))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	defer handleShutdown(p, cancel, *gracePeriod)()
+
+	results := p.Run(ctx)
+	var succeeded, failed, skipped int
+	for _, res := range results {
+		switch res.Status {
+		case s3copy.StepSucceeded:
+			succeeded++
+		case s3copy.StepFailed:
+			failed++
+		case s3copy.StepSkipped:
+			skipped++
+		}
+	}
+	logInfo("pipeline completed, %d steps succeeded, %d failed, %d skipped", succeeded, failed, skipped)
+	if *reportFile != "" {
+		b, err := json.MarshalIndent(map[string]interface{}{"steps": results}, "", "    ")
+		if err == nil {
+			err = ioutil.WriteFile(*reportFile, b, 0644)
+		}
+		if err != nil {
+			logError("writing pipeline report: %s", err)
+		}
+	}
+	return s3copy.PipelineExitCode(results)
+}
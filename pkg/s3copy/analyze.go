@@ -0,0 +1,112 @@
+package s3copy
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// upper bounds of size classes of analyze histogram, the last class is open. 5GiB is the
+// largest object S3 accepts in a single PUT
+var histogramBounds = []int64{1 << 10, 16 << 10, 256 << 10, 1 << 20, 8 << 20, 64 << 20, 256 << 20, 1 << 30, 5 << 30}
+
+// objects and bytes of the directory broken down by key prefix and size, see Analyze
+type Analysis struct {
+	Objects int64 `json:"objects"`
+	Bytes   int64 `json:"bytes"`
+	// prefixes of depth path segments below directory, largest first
+	Depth    int            `json:"depth"`
+	Prefixes []PrefixTotals `json:"prefixes"`
+	// objects by size class, smallest first
+	Histogram []SizeClass `json:"histogram"`
+	Largest   Object      `json:"largest"`
+}
+
+type PrefixTotals struct {
+	// path below directory, "/" for objects directly in it
+	Prefix  string `json:"prefix"`
+	Objects int64  `json:"objects"`
+	Bytes   int64  `json:"bytes"`
+}
+
+// objects of size at least Min and below Max, Max is 0 for the last class
+type SizeClass struct {
+	Min     int64 `json:"min"`
+	Max     int64 `json:"max"`
+	Objects int64 `json:"objects"`
+	Bytes   int64 `json:"bytes"`
+}
+
+// list directory in target endpoint and count objects and bytes per prefix of depth path segments
+// and per size class, e.g. to choose sharding of distributed copy before migration
+func Analyze(c *Config, target string, depth int) (*Analysis, error) {
+	if depth < 1 {
+		return nil, fmt.Errorf("invalid depth %d, must be at least 1", depth)
+	}
+	store, err := targetStore(c, target)
+	if err != nil {
+		return nil, err
+	}
+	a := &Analysis{Depth: depth, Histogram: make([]SizeClass, len(histogramBounds)+1)}
+	for i, max := range histogramBounds {
+		a.Histogram[i].Max = max
+		a.Histogram[i+1].Min = max
+	}
+	prefixes := map[string]*PrefixTotals{}
+	dir := c.listPrefix()
+	logInfo("analyzing objects in '%s/%s'", c.Options.Bucket, dir)
+	doneCh := make(chan struct{})
+	defer close(doneCh)
+	for obj := range listObjects(store, c.Options.Bucket, dir, c.Options.ListPageSize, nil, doneCh) {
+		if obj.Err != nil {
+			return nil, fmt.Errorf("listing objects: %s", obj.Err)
+		}
+		a.Objects++
+		a.Bytes += obj.Size
+		if obj.Size > a.Largest.Size || a.Objects == 1 {
+			a.Largest = obj.Object
+		}
+		prefix := keyPrefix(dir, obj.Key, depth)
+		ps := prefixes[prefix]
+		if ps == nil {
+			ps = &PrefixTotals{Prefix: prefix}
+			prefixes[prefix] = ps
+		}
+		ps.Objects++
+		ps.Bytes += obj.Size
+		class := sort.Search(len(histogramBounds), func(i int) bool { return obj.Size < histogramBounds[i] })
+		a.Histogram[class].Objects++
+		a.Histogram[class].Bytes += obj.Size
+	}
+	for _, ps := range prefixes {
+		a.Prefixes = append(a.Prefixes, *ps)
+	}
+	sort.Slice(a.Prefixes, func(i, j int) bool {
+		if a.Prefixes[i].Bytes != a.Prefixes[j].Bytes {
+			return a.Prefixes[i].Bytes > a.Prefixes[j].Bytes
+		}
+		return a.Prefixes[i].Prefix < a.Prefixes[j].Prefix
+	})
+	return a, nil
+}
+
+// first depth path elements of key relative to dir like topPrefix, "/" for objects directly in it
+func keyPrefix(dir, key string, depth int) string {
+	parts := strings.Split(strings.TrimPrefix(strings.TrimPrefix(key, dir), "/"), "/")
+	parts = parts[:len(parts)-1]
+	if len(parts) > depth {
+		parts = parts[:depth]
+	}
+	return strings.Join(parts, "/") + "/"
+}
+
+// label of size class, e.g. "1MiB - 8MiB"
+func (sc SizeClass) Label() string {
+	switch {
+	case sc.Max == 0:
+		return ">= " + FormatBytes(sc.Min)
+	case sc.Min == 0:
+		return "< " + FormatBytes(sc.Max)
+	}
+	return FormatBytes(sc.Min) + " - " + FormatBytes(sc.Max)
+}
@@ -0,0 +1,70 @@
+package s3copy
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// audited operations
+const (
+	auditPut               = "put"
+	auditRetry             = "retry"
+	auditDelete            = "delete"
+	auditMultipartCreate   = "multipart_create"
+	auditMultipartComplete = "multipart_complete"
+	auditMultipartAbort    = "multipart_abort"
+)
+
+// single line of audit log
+type auditEntry struct {
+	Time     time.Time `json:"time"`
+	Op       string    `json:"op"`
+	Endpoint string    `json:"endpoint"`
+	Bucket   string    `json:"bucket"`
+	Key      string    `json:"key"`
+	Size     int64     `json:"size,omitempty"`
+	UploadID string    `json:"upload_id,omitempty"`
+	Attempt  int       `json:"attempt,omitempty"`
+	Outcome  string    `json:"outcome"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// global audit log of mutating operations in destination, nil if disabled
+var auditTrail *auditLog
+
+// append-only audit trail, one json object per line. file is never truncated,
+// entries of every run are appended and written without buffering
+type auditLog struct {
+	sync.Mutex
+	file     *os.File
+	enc      *json.Encoder
+	endpoint string
+}
+
+// open audit log for appending, endpoint is recorded with every entry
+func OpenAuditLog(path, endpoint string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	auditTrail = &auditLog{file: f, enc: json.NewEncoder(f), endpoint: endpoint}
+	return nil
+}
+
+// record outcome of operation, err nil means operation succeeded
+func audit(e auditEntry, err error) {
+	if auditTrail == nil {
+		return
+	}
+	e.Time = time.Now().UTC()
+	e.Endpoint = auditTrail.endpoint
+	e.Outcome = "ok"
+	if err != nil {
+		e.Outcome, e.Error = "error", err.Error()
+	}
+	auditTrail.Lock()
+	defer auditTrail.Unlock()
+	logErr(auditTrail.enc.Encode(e))
+}
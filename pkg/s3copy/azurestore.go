@@ -0,0 +1,312 @@
+package s3copy
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	azureAPIVersion = "2019-12-12"
+	// larger blobs are uploaded in blocks, which are committed with block list
+	azureMaxPutBlob = 256 << 20
+	azureBlockSize  = 100 << 20
+)
+
+// object store of Azure Blob Storage container, authenticated with shared key.
+// access_key is the storage account name, secret_key is the account key.
+// endpoint defaults to https://<account>.blob.core.windows.net, custom endpoint
+// may include path of the account, e.g. Azurite's 127.0.0.1:10000/devstoreaccount1
+type azureStore struct {
+	base      *url.URL
+	account   string
+	key       []byte
+	container string
+}
+
+func newAzureStore(e Endpoint, container string) (*azureStore, error) {
+	key, err := base64.StdEncoding.DecodeString(e.SecretKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid azure account key: %s", err)
+	}
+	addr := "https://" + e.AccessKey + ".blob.core.windows.net"
+	if e.Endpoint != "" {
+		addr = "http://" + e.Endpoint
+		if e.SSL {
+			addr = "https://" + e.Endpoint
+		}
+	}
+	base, err := url.Parse(strings.TrimSuffix(addr, "/"))
+	if err != nil {
+		return nil, err
+	}
+	return &azureStore{base: base, account: e.AccessKey, key: key, container: container}, nil
+}
+
+// url of container, or of blob if key isn't empty
+func (s *azureStore) url(key string, query url.Values) *url.URL {
+	u := *s.base
+	u.Path = s.base.Path + "/" + s.container
+	if key != "" {
+		u.Path += "/" + key
+	}
+	u.RawQuery = query.Encode()
+	return &u
+}
+
+func (s *azureStore) newRequest(ctx context.Context, method string, u *url.URL) (*http.Request, error) {
+	req, err := http.NewRequest(method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	return req.WithContext(ctx), nil
+}
+
+// set Authorization header with shared key signature of request
+func (s *azureStore) sign(req *http.Request) {
+	h := req.Header
+	h.Set("x-ms-date", time.Now().UTC().Format(http.TimeFormat))
+	h.Set("x-ms-version", azureAPIVersion)
+
+	length := ""
+	if req.ContentLength > 0 {
+		length = strconv.FormatInt(req.ContentLength, 10)
+	}
+	var b bytes.Buffer
+	for _, v := range []string{req.Method, h.Get("Content-Encoding"), h.Get("Content-Language"), length,
+		h.Get("Content-MD5"), h.Get("Content-Type"), "", h.Get("If-Modified-Since"), h.Get("If-Match"),
+		h.Get("If-None-Match"), h.Get("If-Unmodified-Since"), h.Get("Range")} {
+		b.WriteString(v + "\n")
+	}
+
+	var names []string
+	for k := range h {
+		if k := strings.ToLower(k); strings.HasPrefix(k, "x-ms-") {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		b.WriteString(k + ":" + strings.TrimSpace(h.Get(k)) + "\n")
+	}
+
+	b.WriteString("/" + s.account + req.URL.EscapedPath())
+	query := req.URL.Query()
+	names = names[:0]
+	for k := range query {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		values := query[k]
+		sort.Strings(values)
+		b.WriteString("\n" + strings.ToLower(k) + ":" + strings.Join(values, ","))
+	}
+
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write(b.Bytes())
+	h.Set("Authorization", "SharedKey "+s.account+":"+base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+}
+
+// sign and send request, missing blob is reported with ErrNotFound
+func (s *azureStore) do(req *http.Request) (*http.Response, error) {
+	s.sign(req)
+	resp, err := storeHTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == 404 && resp.Header.Get("x-ms-error-code") == "BlobNotFound" {
+		resp.Body.Close()
+		return nil, ErrNotFound
+	}
+	if err := checkResponse(resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// ETag is hex encoded Content-MD5 when blob has it, so content of blobs
+// uploaded in one request can be compared with md5 ETags of S3 objects
+func azureETag(etag, contentMD5 string) string {
+	if sum, err := base64.StdEncoding.DecodeString(contentMD5); err == nil && len(sum) == 16 {
+		return hex.EncodeToString(sum)
+	}
+	return strings.Trim(etag, `"`)
+}
+
+type azureBlobList struct {
+	Blobs []struct {
+		Name       string
+		Properties struct {
+			LastModified  string `xml:"Last-Modified"`
+			Etag          string
+			ContentLength int64  `xml:"Content-Length"`
+			ContentType   string `xml:"Content-Type"`
+			ContentMD5    string `xml:"Content-MD5"`
+		}
+	} `xml:"Blobs>Blob"`
+	NextMarker string
+}
+
+func (s *azureStore) List(ctx context.Context, prefix, token string, pageSize int) ([]Object, string, error) {
+	query := url.Values{"restype": {"container"}, "comp": {"list"}, "maxresults": {strconv.Itoa(pageSize)}}
+	if prefix != "" {
+		query.Set("prefix", prefix)
+	}
+	if token != "" {
+		query.Set("marker", token)
+	}
+	req, err := s.newRequest(ctx, "GET", s.url("", query))
+	if err != nil {
+		return nil, "", err
+	}
+	resp, err := s.do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	var list azureBlobList
+	if err := xml.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, "", err
+	}
+	objs := make([]Object, len(list.Blobs))
+	for i, b := range list.Blobs {
+		p := b.Properties
+		modified, _ := http.ParseTime(p.LastModified)
+		objs[i] = Object{Key: b.Name, Size: p.ContentLength, ETag: azureETag(p.Etag, p.ContentMD5),
+			LastModified: modified, ContentType: p.ContentType}
+	}
+	return objs, list.NextMarker, nil
+}
+
+// info of blob from response headers
+func azureObject(key string, h http.Header) Object {
+	modified, _ := http.ParseTime(h.Get("Last-Modified"))
+	return Object{Key: key, Size: responseSize(h), ETag: azureETag(h.Get("ETag"), h.Get("Content-MD5")),
+		LastModified: modified, ContentType: h.Get("Content-Type")}
+}
+
+func (s *azureStore) Stat(ctx context.Context, key string) (Object, error) {
+	req, err := s.newRequest(ctx, "HEAD", s.url(key, nil))
+	if err != nil {
+		return Object{}, err
+	}
+	resp, err := s.do(req)
+	if err != nil {
+		return Object{}, err
+	}
+	resp.Body.Close()
+	obj := azureObject(key, resp.Header)
+	obj.Metadata = userMetadata(resp.Header, "X-Ms-Meta-")
+	return obj, nil
+}
+
+func (s *azureStore) Get(ctx context.Context, key string, offset, length int64) (io.ReadCloser, Object, error) {
+	req, err := s.newRequest(ctx, "GET", s.url(key, nil))
+	if err != nil {
+		return nil, Object{}, err
+	}
+	if length > 0 {
+		req.Header.Set("x-ms-range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	} else if offset > 0 {
+		req.Header.Set("x-ms-range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := s.do(req)
+	if err != nil {
+		return nil, Object{}, err
+	}
+	return resp.Body, azureObject(key, resp.Header), nil
+}
+
+// blobs up to azureMaxPutBlob are uploaded with a single request, larger ones in blocks
+func (s *azureStore) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) (int64, error) {
+	if size > azureMaxPutBlob {
+		return s.putBlocks(ctx, key, r, size, contentType)
+	}
+	req, err := s.newRequest(ctx, "PUT", s.url(key, nil))
+	if err != nil {
+		return 0, err
+	}
+	requestBody(req, r, size)
+	req.Header.Set("x-ms-blob-type", "BlockBlob")
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := s.do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return size, nil
+}
+
+func (s *azureStore) putBlocks(ctx context.Context, key string, r io.Reader, size int64, contentType string) (int64, error) {
+	var list bytes.Buffer
+	list.WriteString(`<?xml version="1.0" encoding="utf-8"?><BlockList>`)
+	var written int64
+	for i := 0; written < size; i++ {
+		length := int64(azureBlockSize)
+		if rest := size - written; rest < length {
+			length = rest
+		}
+		id := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%08d", i)))
+		req, err := s.newRequest(ctx, "PUT", s.url(key, url.Values{"comp": {"block"}, "blockid": {id}}))
+		if err != nil {
+			return written, err
+		}
+		requestBody(req, r, length)
+		resp, err := s.do(req)
+		if err != nil {
+			return written, fmt.Errorf("uploading block %d: %s", i, err)
+		}
+		resp.Body.Close()
+		written += length
+		list.WriteString("<Latest>" + id + "</Latest>")
+	}
+	list.WriteString("</BlockList>")
+
+	req, err := s.newRequest(ctx, "PUT", s.url(key, url.Values{"comp": {"blocklist"}}))
+	if err != nil {
+		return written, err
+	}
+	requestBody(req, &list, int64(list.Len()))
+	if contentType != "" {
+		req.Header.Set("x-ms-blob-content-type", contentType)
+	}
+	resp, err := s.do(req)
+	if err != nil {
+		return written, fmt.Errorf("committing block list: %s", err)
+	}
+	resp.Body.Close()
+	return written, nil
+}
+
+// removing missing blob isn't an error, same as in S3
+func (s *azureStore) Delete(ctx context.Context, key string) error {
+	req, err := s.newRequest(ctx, "DELETE", s.url(key, nil))
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(req)
+	if err == ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
@@ -0,0 +1,410 @@
+package s3copy
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	b2DefaultAPIURL = "https://api.backblazeb2.com"
+	// B2 limits of large files
+	b2MinPartSize = 5 << 20
+	b2MaxParts    = 10000
+)
+
+// object store of Backblaze B2 bucket using native API. access_key is the application
+// key id, secret_key the application key. files larger than recommended part size are
+// uploaded as large files, parts and small files go to upload urls, bypassing the
+// S3 compatibility layer
+type b2Store struct {
+	apiURL     string
+	keyID      string
+	appKey     string
+	bucketName string
+	bucketID   string
+
+	mu   sync.Mutex
+	auth *b2Auth
+	// upload urls aren't allowed to be used concurrently, free ones are reused
+	uploadURLs []*b2UploadURL
+}
+
+type b2Auth struct {
+	AccountID           string `json:"accountId"`
+	AuthorizationToken  string `json:"authorizationToken"`
+	APIURL              string `json:"apiUrl"`
+	DownloadURL         string `json:"downloadUrl"`
+	RecommendedPartSize int64  `json:"recommendedPartSize"`
+}
+
+type b2UploadURL struct {
+	UploadURL          string `json:"uploadUrl"`
+	AuthorizationToken string `json:"authorizationToken"`
+}
+
+type b2File struct {
+	FileID          string            `json:"fileId"`
+	FileName        string            `json:"fileName"`
+	Action          string            `json:"action"`
+	ContentLength   int64             `json:"contentLength"`
+	ContentType     string            `json:"contentType"`
+	ContentSha1     string            `json:"contentSha1"`
+	FileInfo        map[string]string `json:"fileInfo"`
+	UploadTimestamp int64             `json:"uploadTimestamp"`
+}
+
+func newB2Store(e Endpoint, bucket string) (*b2Store, error) {
+	s := &b2Store{apiURL: b2DefaultAPIURL, keyID: e.AccessKey, appKey: e.SecretKey, bucketName: bucket}
+	if e.Endpoint != "" {
+		s.apiURL = "https://" + strings.TrimSuffix(e.Endpoint, "/")
+	}
+	if err := s.authorize(nil); err != nil {
+		return nil, err
+	}
+
+	var res struct {
+		Buckets []struct {
+			BucketID string `json:"bucketId"`
+		} `json:"buckets"`
+	}
+	err := s.call(context.Background(), "b2_list_buckets",
+		map[string]string{"accountId": s.auth.AccountID, "bucketName": bucket}, &res)
+	if err != nil {
+		return nil, err
+	}
+	if len(res.Buckets) == 0 {
+		return nil, fmt.Errorf("b2 bucket '%s' not found", bucket)
+	}
+	s.bucketID = res.Buckets[0].BucketID
+	return s, nil
+}
+
+// get new account authorization token, unless it was already refreshed since stale was issued
+func (s *b2Store) authorize(stale *b2Auth) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if stale != nil && s.auth != stale {
+		return nil
+	}
+	req, err := http.NewRequest("GET", s.apiURL+"/b2api/v2/b2_authorize_account", nil)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(s.keyID, s.appKey)
+	resp, err := doRequest(req)
+	if err != nil {
+		return fmt.Errorf("b2 authorization: %s", err)
+	}
+	defer resp.Body.Close()
+	a := &b2Auth{}
+	if err := json.NewDecoder(resp.Body).Decode(a); err != nil {
+		return err
+	}
+	s.auth = a
+	return nil
+}
+
+func (s *b2Store) currentAuth() *b2Auth {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.auth
+}
+
+// send request signed with account token, expired token is refreshed and request is repeated once
+func (s *b2Store) send(ctx context.Context, newReq func(a *b2Auth) (*http.Request, error)) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		a := s.currentAuth()
+		req, err := newReq(a)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", a.AuthorizationToken)
+		resp, err := storeHTTPClient.Do(req.WithContext(ctx))
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode == 401 && attempt == 0 {
+			resp.Body.Close()
+			if err := s.authorize(a); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err := checkResponse(resp); err != nil {
+			return nil, err
+		}
+		return resp, nil
+	}
+}
+
+// call json api method
+func (s *b2Store) call(ctx context.Context, method string, args, res interface{}) error {
+	body, err := json.Marshal(args)
+	if err != nil {
+		return err
+	}
+	resp, err := s.send(ctx, func(a *b2Auth) (*http.Request, error) {
+		return http.NewRequest("POST", a.APIURL+"/b2api/v2/"+method, bytes.NewReader(body))
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if res == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(res)
+}
+
+// ETag is sha1 of content, large files have no sha1, so id of the file version is used
+func (f *b2File) object() Object {
+	etag := f.ContentSha1
+	if etag == "" || etag == "none" {
+		etag = f.FileID
+	}
+	modified := f.UploadTimestamp
+	if ms, err := strconv.ParseInt(f.FileInfo["src_last_modified_millis"], 10, 64); err == nil {
+		modified = ms
+	}
+	return Object{Key: f.FileName, Size: f.ContentLength, ETag: strings.TrimPrefix(etag, "unverified:"),
+		LastModified: time.Unix(0, modified*int64(time.Millisecond)).UTC(), ContentType: f.ContentType}
+}
+
+func (s *b2Store) List(ctx context.Context, prefix, token string, pageSize int) ([]Object, string, error) {
+	args := map[string]interface{}{"bucketId": s.bucketID, "prefix": prefix, "maxFileCount": pageSize}
+	if token != "" {
+		args["startFileName"] = token
+	}
+	var res struct {
+		Files        []b2File `json:"files"`
+		NextFileName *string  `json:"nextFileName"`
+	}
+	if err := s.call(ctx, "b2_list_file_names", args, &res); err != nil {
+		return nil, "", err
+	}
+	objs := make([]Object, 0, len(res.Files))
+	for _, f := range res.Files {
+		if f.Action == "upload" {
+			objs = append(objs, f.object())
+		}
+	}
+	next := ""
+	if res.NextFileName != nil {
+		next = *res.NextFileName
+	}
+	return objs, next, nil
+}
+
+// file info from headers of download response
+func b2Object(key string, h http.Header) Object {
+	f := b2File{FileID: h.Get("X-Bz-File-Id"), FileName: key, ContentLength: responseSize(h),
+		ContentType: h.Get("Content-Type"), ContentSha1: h.Get("X-Bz-Content-Sha1"), FileInfo: map[string]string{}}
+	f.UploadTimestamp, _ = strconv.ParseInt(h.Get("X-Bz-Upload-Timestamp"), 10, 64)
+	if ms := h.Get("X-Bz-Info-Src_last_modified_millis"); ms != "" {
+		f.FileInfo["src_last_modified_millis"] = ms
+	}
+	return f.object()
+}
+
+// download request of file, missing file is reported with ErrNotFound
+func (s *b2Store) download(ctx context.Context, method, key, rangeHeader string) (*http.Response, error) {
+	resp, err := s.send(ctx, func(a *b2Auth) (*http.Request, error) {
+		req, err := http.NewRequest(method, a.DownloadURL+"/file/"+escapeKey(s.bucketName)+"/"+escapeKey(key), nil)
+		if err == nil && rangeHeader != "" {
+			req.Header.Set("Range", rangeHeader)
+		}
+		return req, err
+	})
+	if se, ok := err.(*statusError); ok && se.code == 404 {
+		return nil, ErrNotFound
+	}
+	return resp, err
+}
+
+func (s *b2Store) Stat(ctx context.Context, key string) (Object, error) {
+	resp, err := s.download(ctx, "HEAD", key, "")
+	if err != nil {
+		return Object{}, err
+	}
+	resp.Body.Close()
+	obj := b2Object(key, resp.Header)
+	obj.Metadata = userMetadata(resp.Header, "X-Bz-Info-")
+	return obj, nil
+}
+
+func (s *b2Store) Get(ctx context.Context, key string, offset, length int64) (io.ReadCloser, Object, error) {
+	rangeHeader := ""
+	if length > 0 {
+		rangeHeader = fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)
+	} else if offset > 0 {
+		rangeHeader = fmt.Sprintf("bytes=%d-", offset)
+	}
+	resp, err := s.download(ctx, "GET", key, rangeHeader)
+	if err != nil {
+		return nil, Object{}, err
+	}
+	return resp.Body, b2Object(key, resp.Header), nil
+}
+
+// reader of content followed by 40 hex digits of its sha1, so content is hashed while it's sent
+type sha1Trailer struct {
+	r       io.Reader
+	h       hash.Hash
+	trailer io.Reader
+}
+
+func newSHA1Trailer(r io.Reader, size int64) *sha1Trailer {
+	h := sha1.New()
+	return &sha1Trailer{r: io.TeeReader(io.LimitReader(r, size), h), h: h}
+}
+
+func (t *sha1Trailer) Read(p []byte) (int, error) {
+	if t.trailer == nil {
+		n, err := t.r.Read(p)
+		if err != io.EOF {
+			return n, err
+		}
+		t.trailer = strings.NewReader(hex.EncodeToString(t.h.Sum(nil)))
+		if n > 0 {
+			return n, nil
+		}
+	}
+	return t.trailer.Read(p)
+}
+
+func (t *sha1Trailer) sum() string {
+	return hex.EncodeToString(t.h.Sum(nil))
+}
+
+// POST content to upload url, expired or busy upload urls are reported as unavailable
+// service, so the object is retried with a new upload url
+func (s *b2Store) upload(ctx context.Context, u *b2UploadURL, r io.Reader, size int64, headers map[string]string) (string, error) {
+	req, err := http.NewRequest("POST", u.UploadURL, nil)
+	if err != nil {
+		return "", err
+	}
+	body := newSHA1Trailer(r, size)
+	requestBody(req, body, size+sha1.Size*2)
+	req.Header.Set("Authorization", u.AuthorizationToken)
+	req.Header.Set("X-Bz-Content-Sha1", "hex_digits_at_end")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := doRequest(req.WithContext(ctx))
+	if se, ok := err.(*statusError); ok && (se.code == 401 || se.code == 408) {
+		return "", &statusError{503, se.status}
+	}
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	return body.sum(), nil
+}
+
+func (s *b2Store) getUploadURL(ctx context.Context) (*b2UploadURL, error) {
+	s.mu.Lock()
+	if n := len(s.uploadURLs); n > 0 {
+		u := s.uploadURLs[n-1]
+		s.uploadURLs = s.uploadURLs[:n-1]
+		s.mu.Unlock()
+		return u, nil
+	}
+	s.mu.Unlock()
+	u := &b2UploadURL{}
+	return u, s.call(ctx, "b2_get_upload_url", map[string]string{"bucketId": s.bucketID}, u)
+}
+
+func (s *b2Store) putUploadURL(u *b2UploadURL) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.uploadURLs = append(s.uploadURLs, u)
+}
+
+func (s *b2Store) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) (int64, error) {
+	if contentType == "" {
+		contentType = "b2/x-auto"
+	}
+	partSize := s.currentAuth().RecommendedPartSize
+	if partSize > 0 && size > partSize {
+		return s.putLargeFile(ctx, key, r, size, contentType, partSize)
+	}
+
+	u, err := s.getUploadURL(ctx)
+	if err != nil {
+		return 0, err
+	}
+	_, err = s.upload(ctx, u, r, size, map[string]string{"X-Bz-File-Name": escapeKey(key), "Content-Type": contentType})
+	if err != nil {
+		// failed upload url must not be reused
+		return 0, err
+	}
+	s.putUploadURL(u)
+	return size, nil
+}
+
+// upload file in parts, unfinished large file is cancelled on error
+func (s *b2Store) putLargeFile(ctx context.Context, key string, r io.Reader, size int64, contentType string, partSize int64) (int64, error) {
+	if min := (size + b2MaxParts - 1) / b2MaxParts; partSize < min {
+		partSize = min
+	}
+	if partSize < b2MinPartSize {
+		partSize = b2MinPartSize
+	}
+
+	var file b2File
+	err := s.call(ctx, "b2_start_large_file",
+		map[string]string{"bucketId": s.bucketID, "fileName": key, "contentType": contentType}, &file)
+	if err != nil {
+		return 0, err
+	}
+	var written int64
+	err = func() error {
+		u := &b2UploadURL{}
+		if err := s.call(ctx, "b2_get_upload_part_url", map[string]string{"fileId": file.FileID}, u); err != nil {
+			return err
+		}
+		var sums []string
+		for n := 1; written < size; n++ {
+			length := partSize
+			if rest := size - written; rest < length {
+				length = rest
+			}
+			sum, err := s.upload(ctx, u, r, length, map[string]string{"X-Bz-Part-Number": strconv.Itoa(n)})
+			if err != nil {
+				return fmt.Errorf("uploading part %d: %s", n, err)
+			}
+			sums = append(sums, sum)
+			written += length
+		}
+		return s.call(ctx, "b2_finish_large_file",
+			map[string]interface{}{"fileId": file.FileID, "partSha1Array": sums}, nil)
+	}()
+	if err != nil {
+		logErr(s.call(context.Background(), "b2_cancel_large_file", map[string]string{"fileId": file.FileID}, nil))
+		return written, err
+	}
+	return written, nil
+}
+
+// file is hidden, so it disappears from listing like deleted S3 object in versioned bucket,
+// previous versions are removed by lifecycle rules of the bucket
+func (s *b2Store) Delete(ctx context.Context, key string) error {
+	if _, err := s.Stat(ctx, key); err != nil {
+		if err == ErrNotFound {
+			return nil
+		}
+		return err
+	}
+	return s.call(ctx, "b2_hide_file", map[string]string{"bucketId": s.bucketID, "fileName": key}, nil)
+}
@@ -0,0 +1,238 @@
+package s3copy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/minio/minio-go"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+// generation name by default, e.g. "2024-06-01"
+const defaultGenerationFormat = "2006-01-02"
+
+// index of generations kept under backup prefix of destination
+const backupIndexName = ".s3-copy-dir-backup.json"
+
+// every run writes a new generation of the directory under <prefix>/<generation>/ in
+// destination, see Options.Backup. objects unchanged since the previous generation are
+// copied server-side from it, generations over keep are removed
+type BackupConfig struct {
+	Prefix string `json:"prefix"`
+	// complete generations kept, 0 - all
+	Keep int `json:"keep"`
+	// time layout of generation names, "2006-01-02" by default
+	Format string `json:"format,omitempty"`
+}
+
+// generation of backup recorded in index, incomplete one is continued by the next run
+type Generation struct {
+	Name     string     `json:"name"`
+	Started  time.Time  `json:"started"`
+	Finished *time.Time `json:"finished,omitempty"`
+	Complete bool       `json:"complete"`
+}
+
+type backupIndex struct {
+	Generations []Generation `json:"generations"`
+}
+
+// store which copies objects inside the bucket without transferring their content
+type serverCopyStore interface {
+	copyObject(ctx context.Context, srcKey, dstKey string) error
+}
+
+// generation written by the run
+type backupRun struct {
+	cfg    *BackupConfig
+	bucket string
+	index  backupIndex
+	// index of generation of the run in index, and name of the latest complete one before it
+	current  int
+	previous string
+}
+
+func (b *backupRun) generation() *Generation {
+	return &b.index.Generations[b.current]
+}
+
+// key of object in generation, keys aren't cleaned as paths
+func (b *backupRun) key(gen, key string) string {
+	return strings.Trim(b.cfg.Prefix, "/") + "/" + gen + "/" + key
+}
+
+func (b *backupRun) indexKey() string {
+	return strings.Trim(b.cfg.Prefix, "/") + "/" + backupIndexName
+}
+
+// index of generations in destination, empty if backup has no generations yet
+func loadBackupIndex(ctx context.Context, dst ObjectStore, key string) (backupIndex, error) {
+	var idx backupIndex
+	r, _, err := dst.Get(ctx, key, 0, -1)
+	if classifyError(err) == errNotFound {
+		return idx, nil
+	}
+	if err != nil {
+		return idx, err
+	}
+	defer r.Close()
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return idx, err
+	}
+	if err := json.Unmarshal(b, &idx); err != nil {
+		return idx, fmt.Errorf("decoding backup index '%s': %s", key, err)
+	}
+	return idx, nil
+}
+
+func (b *backupRun) saveIndex(ctx context.Context, dst ObjectStore) error {
+	body, err := json.MarshalIndent(b.index, "", "    ")
+	if err != nil {
+		return err
+	}
+	_, err = dst.Put(ctx, b.indexKey(), bytes.NewReader(body), int64(len(body)), "application/json")
+	audit(auditEntry{Op: auditPut, Bucket: b.bucket, Key: b.indexKey(), Size: int64(len(body))}, err)
+	return err
+}
+
+// start generation of the run: incomplete generation of the same day is continued, so
+// rerun of failed backup doesn't start over. name of complete one gets a numeric suffix
+func startBackup(ctx context.Context, dst ObjectStore, c *Config, now time.Time) (*backupRun, error) {
+	b := &backupRun{cfg: c.Options.Backup, bucket: c.Options.Bucket}
+	format := b.cfg.Format
+	if format == "" {
+		format = defaultGenerationFormat
+	}
+	var err error
+	if b.index, err = loadBackupIndex(ctx, dst, b.indexKey()); err != nil {
+		return nil, fmt.Errorf("loading backup index: %s", err)
+	}
+	name := now.UTC().Format(format)
+	gens := b.index.Generations
+	for i := len(gens) - 1; i >= 0; i-- {
+		if gens[i].Complete {
+			b.previous = gens[i].Name
+			break
+		}
+	}
+	if n := len(gens); n > 0 && !gens[n-1].Complete && strings.HasPrefix(gens[n-1].Name, name) {
+		b.current = n - 1
+		logInfo("continuing incomplete backup generation '%s' started at %s", gens[n-1].Name, gens[n-1].Started.Format(time.RFC3339))
+		return b, nil
+	}
+	taken := map[string]bool{}
+	for _, g := range gens {
+		taken[g.Name] = true
+	}
+	for i := 2; taken[name]; i++ {
+		name = fmt.Sprintf("%s-%d", now.UTC().Format(format), i)
+	}
+	b.index.Generations = append(b.index.Generations, Generation{Name: name, Started: now.UTC()})
+	b.current = len(b.index.Generations) - 1
+	if err := b.saveIndex(ctx, dst); err != nil {
+		return nil, fmt.Errorf("saving backup index: %s", err)
+	}
+	if b.previous != "" {
+		logInfo("writing backup generation '%s', unchanged objects are copied from '%s'", name, b.previous)
+	} else {
+		logInfo("writing backup generation '%s'", name)
+	}
+	return b, nil
+}
+
+// mark generation of the run complete and remove complete generations over keep, oldest
+// first, with incomplete ones older than the oldest kept generation
+func (b *backupRun) finish(ctx context.Context, dst ObjectStore, c *Config) error {
+	finished := time.Now().UTC()
+	g := b.generation()
+	g.Finished, g.Complete = &finished, true
+	if err := b.saveIndex(ctx, dst); err != nil {
+		return fmt.Errorf("saving backup index: %s", err)
+	}
+	logInfo("backup generation '%s' complete", g.Name)
+	if b.cfg.Keep <= 0 {
+		return nil
+	}
+
+	var complete []int
+	for i, g := range b.index.Generations {
+		if g.Complete {
+			complete = append(complete, i)
+		}
+	}
+	if len(complete) <= b.cfg.Keep {
+		return nil
+	}
+	oldestKept := complete[len(complete)-b.cfg.Keep]
+	var kept []Generation
+	for i, g := range b.index.Generations {
+		if i >= oldestKept {
+			kept = append(kept, g)
+			continue
+		}
+		gc := *c
+		gc.Options.Directory, gc.Options.RawPrefix = b.key(g.Name, ""), false
+		_, failed, err := removeObjects(&gc, dst, TargetDestination, false, removal{})
+		if err == nil && failed > 0 {
+			err = fmt.Errorf("%d objects failed to remove", failed)
+		}
+		if err != nil {
+			// generation stays in index, so it's pruned again by the next run
+			logError("pruning backup generation '%s': %s", g.Name, err)
+			kept = append(kept, g)
+			continue
+		}
+		logInfo("pruned backup generation '%s'", g.Name)
+	}
+	b.index.Generations = kept
+	return b.saveIndex(ctx, dst)
+}
+
+// copy object unchanged since the previous generation from it server-side, content isn't read
+// from source. object is unchanged if its copy in the previous generation was written after it
+// was last modified, with the same size unless it's transformed. returns false if it wasn't copied
+func (cp *Copier) reuseGeneration(obj Object) bool {
+	b := cp.backup
+	sc, ok := cp.dst.(serverCopyStore)
+	if !ok || b.previous == "" {
+		return false
+	}
+	key := obj.Key
+	if cp.normalizeKey != nil {
+		key = cp.normalizeKey(key)
+	}
+	prevKey := b.key(b.previous, key)
+	countRequest(true, reqHead)
+	prev, err := cp.dst.Stat(cp.ctx, prevKey)
+	if err != nil {
+		if classifyError(err) != errNotFound {
+			logWarn("checking '%s/%s' in generation '%s': %s", cp.bucket, obj.Key, b.previous, err)
+		}
+		return false
+	}
+	if prev.LastModified.Before(obj.LastModified) || len(cp.transforms) == 0 && prev.Size != obj.Size {
+		return false
+	}
+	countRequest(true, reqCopy)
+	dkey := cp.destKey(obj.Key)
+	err = sc.copyObject(cp.ctx, prevKey, dkey)
+	audit(auditEntry{Op: auditPut, Bucket: cp.bucket, Key: dkey, Size: prev.Size}, err)
+	if err != nil {
+		logWarn("copying '%s/%s' from generation '%s', it's copied from source: %s", cp.bucket, obj.Key, b.previous, err)
+		return false
+	}
+	return true
+}
+
+func (s *minioStore) copyObject(ctx context.Context, srcKey, dstKey string) error {
+	dst, err := minio.NewDestinationInfo(s.bucket, dstKey, nil, nil)
+	if err != nil {
+		return err
+	}
+	// compose copies objects over 5GiB in parts
+	return s.clnt.ComposeObject(dst, []minio.SourceInfo{minio.NewSourceInfo(s.bucket, srcKey, nil)})
+}
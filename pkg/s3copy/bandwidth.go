@@ -0,0 +1,221 @@
+package s3copy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// reads are split into chunks of this size, so throttling is smooth
+const bandwidthChunk = 64 << 10
+
+// limits total rate of data read from source, shared by all workers
+type bandwidthLimiter struct {
+	sync.Mutex
+	// bytes per second, 0 - unlimited
+	rate int64
+	// windows overriding rate at times of day, nil - rate applies all the time
+	schedule *bandwidthSchedule
+	// rate in effect, changes of it are logged
+	current int64
+	// time when the next chunk may be read, unused bandwidth isn't accumulated
+	next time.Time
+}
+
+// limiter exists even without limit, so the limit can be set while copy is running
+func newBandwidthLimiter(rate int64, schedule *bandwidthSchedule) *bandwidthLimiter {
+	return &bandwidthLimiter{rate: rate, schedule: schedule, current: rate}
+}
+
+// replace the limit for the rest of the copy, schedule no longer applies
+func (bl *bandwidthLimiter) set(rate int64) {
+	bl.Lock()
+	defer bl.Unlock()
+	if rate < 0 {
+		rate = 0
+	}
+	bl.rate, bl.schedule, bl.current = rate, nil, rate
+}
+
+// limit in effect, 0 - unlimited
+func (bl *bandwidthLimiter) limit() int64 {
+	bl.Lock()
+	defer bl.Unlock()
+	return bl.schedule.rate(time.Now(), bl.rate)
+}
+
+// block until n bytes fit into the limit or ctx is cancelled
+func (bl *bandwidthLimiter) wait(ctx context.Context, n int) {
+	bl.Lock()
+	now := time.Now()
+	rate := bl.schedule.rate(now, bl.rate)
+	if rate != bl.current {
+		bl.current = rate
+		if rate > 0 {
+			logInfo("bandwidth limit is %s/s by schedule", FormatBytes(rate))
+		} else {
+			logInfo("bandwidth is unlimited by schedule")
+		}
+	}
+	if rate <= 0 {
+		bl.Unlock()
+		return
+	}
+	if bl.next.Before(now) {
+		bl.next = now
+	}
+	delay := bl.next.Sub(now)
+	bl.next = bl.next.Add(time.Duration(int64(n) * int64(time.Second) / rate))
+	bl.Unlock()
+
+	if delay <= 0 {
+		return
+	}
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-ctx.Done():
+	}
+}
+
+// wrap reader of source object
+func (bl *bandwidthLimiter) reader(ctx context.Context, r io.Reader) io.Reader {
+	if bl == nil {
+		return r
+	}
+	return &throttledReader{r: r, bl: bl, ctx: ctx}
+}
+
+type throttledReader struct {
+	r   io.Reader
+	bl  *bandwidthLimiter
+	ctx context.Context
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > bandwidthChunk {
+		p = p[:bandwidthChunk]
+	}
+	n, err := t.r.Read(p)
+	if n > 0 {
+		t.bl.wait(t.ctx, n)
+	}
+	return n, err
+}
+
+// bandwidth limits by time of day, see Options.BandwidthSchedule
+type BandwidthSchedule struct {
+	// time zone of windows, e.g. "Europe/Berlin", local time by default
+	TimeZone string `json:"timezone,omitempty"`
+	// the first window matching current time sets the limit, outside of windows
+	// bandwidth limit of the run applies
+	Windows []BandwidthWindow `json:"windows"`
+}
+
+type BandwidthWindow struct {
+	// days of week as in cron, e.g. "mon-fri" or "sat,sun", every day if empty
+	Days string `json:"days,omitempty"`
+	// start and end of window, e.g. "08:00" and "20:00". window ending before its start
+	// runs past midnight into the next day
+	From string `json:"from"`
+	To   string `json:"to"`
+	// bytes read from source per second, e.g. "100MiB", "unlimited" - no limit
+	Limit string `json:"limit"`
+}
+
+type bandwidthSchedule struct {
+	loc     *time.Location
+	windows []bandwidthWindow
+}
+
+type bandwidthWindow struct {
+	// bitset of days of week, sunday is 0
+	days uint64
+	// minutes of day, from is included and to isn't
+	from, to int
+	rate     int64
+}
+
+func parseBandwidthSchedule(bs *BandwidthSchedule) (*bandwidthSchedule, error) {
+	if bs == nil {
+		return nil, nil
+	}
+	s := &bandwidthSchedule{loc: time.Local}
+	if bs.TimeZone != "" {
+		var err error
+		if s.loc, err = time.LoadLocation(bs.TimeZone); err != nil {
+			return nil, err
+		}
+	}
+	if len(bs.Windows) == 0 {
+		return nil, fmt.Errorf("no windows")
+	}
+	for i, w := range bs.Windows {
+		pw := bandwidthWindow{days: 1<<7 - 1}
+		var err error
+		if w.Days != "" {
+			if pw.days, err = parseCronField(w.Days, 0, 7, cronDays); err != nil {
+				return nil, fmt.Errorf("window %d: invalid days '%s': %s", i+1, w.Days, err)
+			}
+			// 7 is sunday too
+			if pw.days&(1<<7) != 0 {
+				pw.days |= 1
+			}
+		}
+		if pw.from, err = parseTimeOfDay(w.From); err != nil {
+			return nil, fmt.Errorf("window %d: %s", i+1, err)
+		}
+		if pw.to, err = parseTimeOfDay(w.To); err != nil {
+			return nil, fmt.Errorf("window %d: %s", i+1, err)
+		}
+		if pw.from == pw.to {
+			return nil, fmt.Errorf("window %d: empty window from %s to %s", i+1, w.From, w.To)
+		}
+		if !strings.EqualFold(w.Limit, "unlimited") {
+			if pw.rate, err = ParseByteSize(w.Limit); err != nil || pw.rate <= 0 {
+				return nil, fmt.Errorf("window %d: invalid limit '%s', e.g. 100MiB or unlimited", i+1, w.Limit)
+			}
+		}
+		s.windows = append(s.windows, pw)
+	}
+	return s, nil
+}
+
+// minutes of "HH:MM" since midnight, "24:00" is the end of day
+func parseTimeOfDay(s string) (int, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) == 2 {
+		h, herr := strconv.Atoi(parts[0])
+		m, merr := strconv.Atoi(parts[1])
+		if herr == nil && merr == nil && h >= 0 && m >= 0 && m < 60 && (h < 24 || h == 24 && m == 0) {
+			return h*60 + m, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid time of day '%s', e.g. 08:00", s)
+}
+
+// limit at time t, def outside of windows
+func (s *bandwidthSchedule) rate(t time.Time, def int64) int64 {
+	if s == nil {
+		return def
+	}
+	t = t.In(s.loc)
+	minute, day := t.Hour()*60+t.Minute(), uint(t.Weekday())
+	for _, w := range s.windows {
+		switch {
+		case w.from < w.to && minute >= w.from && minute < w.to && w.days&(1<<day) != 0:
+			return w.rate
+		// window past midnight belongs to the day it starts
+		case w.from > w.to && minute >= w.from && w.days&(1<<day) != 0:
+			return w.rate
+		case w.from > w.to && minute < w.to && w.days&(1<<((day+6)%7)) != 0:
+			return w.rate
+		}
+	}
+	return def
+}
@@ -0,0 +1,269 @@
+package s3copy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tenant of batch: bucket pair with its own credentials, filters and limits
+type BatchTenant struct {
+	Name string `json:"name"`
+	// config of the tenant: inline or path of config file
+	Config     *Config    `json:"config,omitempty"`
+	ConfigFile string     `json:"config_file,omitempty"`
+	Run        JobOptions `json:"run"`
+	// objects copied by the tenant at the same time, concurrency of its config by default
+	Concurrency int `json:"concurrency,omitempty"`
+}
+
+// copies of many tenants run from a single batch file, see LoadBatch. failed tenant
+// doesn't affect others, every tenant gets its own report
+type Batch struct {
+	Tenants []BatchTenant `json:"tenants"`
+	// tenants copied at the same time, 0 - all of them
+	MaxParallel int `json:"max_parallel,omitempty"`
+	// objects copied by all running tenants at the same time, shared equally
+	// between them. 0 - only limits of tenants apply
+	MaxConcurrency int `json:"max_concurrency,omitempty"`
+	// report of every tenant is written to <report_dir>/<name>.json, unless
+	// config of the tenant sets report_file
+	ReportDir string `json:"report_dir,omitempty"`
+
+	mu      sync.Mutex
+	running map[string]*tenantRun
+	stopped bool
+}
+
+// copy of running tenant and its own concurrency, the limit of its workers is lowered
+// to the fair share of max_concurrency
+type tenantRun struct {
+	cp          *Copier
+	concurrency int
+}
+
+// outcome of tenant copy, status is one of Step* outcomes
+type TenantResult struct {
+	Name     string     `json:"name"`
+	Bucket   string     `json:"bucket"`
+	Status   string     `json:"status"`
+	ExitCode int        `json:"exit_code"`
+	Error    string     `json:"error,omitempty"`
+	Started  *time.Time `json:"started,omitempty"`
+	Finished *time.Time `json:"finished,omitempty"`
+	Report   *Report    `json:"report,omitempty"`
+}
+
+// load batch file: {"max_parallel": 2, "tenants": [<BatchTenant>, ...]}. config files of
+// tenants are loaded and their run options are validated
+func LoadBatch(file string) (*Batch, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	bt := &Batch{}
+	if err := json.Unmarshal(b, bt); err != nil {
+		return nil, fmt.Errorf("loading batch '%s': %s", file, err)
+	}
+	if err := bt.load(); err != nil {
+		return nil, fmt.Errorf("batch '%s': %s", file, err)
+	}
+	return bt, nil
+}
+
+func (b *Batch) load() error {
+	if len(b.Tenants) == 0 {
+		return errors.New("no tenants")
+	}
+	if b.MaxParallel < 0 || b.MaxConcurrency < 0 {
+		return errors.New("max_parallel and max_concurrency must not be negative")
+	}
+	names := map[string]bool{}
+	for i := range b.Tenants {
+		t := &b.Tenants[i]
+		// name is part of report file name
+		if t.Name == "" || t.Name == "." || t.Name == ".." || strings.ContainsAny(t.Name, `/\`) {
+			return fmt.Errorf("invalid name '%s' of tenant %d", t.Name, i+1)
+		}
+		if names[t.Name] {
+			return fmt.Errorf("duplicate tenant name '%s'", t.Name)
+		}
+		names[t.Name] = true
+		if err := loadStepConfig(&t.Config, t.ConfigFile); err != nil {
+			return fmt.Errorf("tenant '%s': %s", t.Name, err)
+		}
+		if t.Config == nil {
+			return fmt.Errorf("tenant '%s': config or config_file must be set", t.Name)
+		}
+		c := *t.Config
+		if _, err := t.Run.apply(&c); err != nil {
+			return fmt.Errorf("tenant '%s': %s", t.Name, err)
+		}
+	}
+	return nil
+}
+
+// copy tenants in order of the batch file, up to MaxParallel at a time. results are in
+// order of tenants, tenants which didn't start before Stop are skipped
+func (b *Batch) Run(ctx context.Context) []TenantResult {
+	b.mu.Lock()
+	b.running = map[string]*tenantRun{}
+	b.mu.Unlock()
+	if b.ReportDir != "" {
+		if err := os.MkdirAll(b.ReportDir, 0755); err != nil {
+			logError("creating report directory: %s", err)
+		}
+	}
+	var shared *workerLimiter
+	if b.MaxConcurrency > 0 {
+		shared = newWorkerLimiter(b.MaxConcurrency)
+	}
+	parallel := len(b.Tenants)
+	if b.MaxParallel > 0 && b.MaxParallel < parallel {
+		parallel = b.MaxParallel
+	}
+	slots := make(chan struct{}, parallel)
+	results := make([]TenantResult, len(b.Tenants))
+	var wg sync.WaitGroup
+	for i := range b.Tenants {
+		slots <- struct{}{}
+		t, res := &b.Tenants[i], &results[i]
+		res.Name, res.Bucket = t.Name, t.Config.Options.Bucket
+		if b.isStopped() || ctx.Err() != nil {
+			res.Status, res.Error = StepSkipped, "batch stopped"
+			<-slots
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			b.runTenant(ctx, t, res, shared)
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+func (b *Batch) runTenant(ctx context.Context, t *BatchTenant, res *TenantResult, shared *workerLimiter) {
+	started := time.Now().UTC()
+	res.Started = &started
+	defer func() {
+		// panic of one tenant, e.g. in a custom transform, doesn't take down the others
+		if r := recover(); r != nil {
+			res.Error, res.ExitCode = fmt.Sprintf("panic: %v", r), ExitError
+		}
+		b.mu.Lock()
+		delete(b.running, t.Name)
+		b.rebalance()
+		b.mu.Unlock()
+
+		finished := time.Now().UTC()
+		res.Finished = &finished
+		if res.ExitCode == ExitOK {
+			res.Status = StepSucceeded
+			logInfo("tenant '%s' succeeded in %s", t.Name, finished.Sub(started).Round(time.Second))
+			return
+		}
+		res.Status = StepFailed
+		if res.Error != "" {
+			logError("tenant '%s' failed: %s", t.Name, res.Error)
+		} else {
+			logError("tenant '%s' failed with exit code %d", t.Name, res.ExitCode)
+		}
+	}()
+
+	c := *t.Config
+	opts, err := t.Run.apply(&c)
+	c.Run.sharedWorkers = shared
+	if t.Concurrency > 0 {
+		c.Options.Concurrency = t.Concurrency
+	}
+	if b.ReportDir != "" && c.Options.ReportFile == "" {
+		c.Options.ReportFile = filepath.Join(b.ReportDir, t.Name+".json")
+	}
+	logInfo("tenant '%s' started: copy of '%s/%s'", t.Name, c.Options.Bucket, c.Options.Directory)
+	var cp *Copier
+	if err == nil {
+		cp, err = NewCopier(&c, opts...)
+	}
+	if err != nil {
+		res.Error, res.ExitCode = err.Error(), ExitError
+		return
+	}
+	b.mu.Lock()
+	b.running[t.Name] = &tenantRun{cp: cp, concurrency: c.Options.Concurrency}
+	b.rebalance()
+	if b.stopped {
+		cp.Stop()
+	}
+	b.mu.Unlock()
+	r, err := cp.Run(ctx)
+	if err != nil {
+		res.Error, res.ExitCode = err.Error(), ExitError
+		return
+	}
+	res.Report, res.ExitCode = r.Report, r.ExitCode
+}
+
+// share MaxConcurrency equally between running tenants, so tenant with large concurrency
+// doesn't take all shared workers. auto-tuned tenants adjust their limits themselves.
+// must be called with b.mu held
+func (b *Batch) rebalance() {
+	if b.MaxConcurrency <= 0 || len(b.running) == 0 {
+		return
+	}
+	share := b.MaxConcurrency / len(b.running)
+	for _, tr := range b.running {
+		limit := tr.concurrency
+		if share < limit {
+			limit = share
+		}
+		if tr.cp.at == nil {
+			tr.cp.wl.setLimit(limit)
+		}
+	}
+}
+
+// stop running tenants gracefully and skip tenants which didn't start yet
+func (b *Batch) Stop() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.stopped = true
+	for _, tr := range b.running {
+		tr.cp.Stop()
+	}
+}
+
+func (b *Batch) isStopped() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.stopped
+}
+
+// exit code of batch run: ok if all tenants succeeded, partial if some of them
+// failed or were skipped, error if none succeeded
+func BatchExitCode(results []TenantResult) int {
+	var succeeded int
+	for _, res := range results {
+		if res.Status == StepSucceeded {
+			succeeded++
+		}
+	}
+	switch {
+	case succeeded == len(results):
+		return ExitOK
+	case succeeded == 0:
+		return ExitError
+	}
+	return ExitPartial
+}
@@ -0,0 +1,236 @@
+package s3copy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// size of random block repeated to produce synthetic object data
+const benchBlockSize = 1 << 20
+
+// reader producing size bytes of synthetic data by repeating a random block
+type benchReader struct {
+	block  []byte
+	offset int64
+	size   int64
+}
+
+func (br *benchReader) Read(p []byte) (int, error) {
+	if br.offset >= br.size {
+		return 0, io.EOF
+	}
+	if rest := br.size - br.offset; int64(len(p)) > rest {
+		p = p[:rest]
+	}
+	n := 0
+	for n < len(p) {
+		n += copy(p[n:], br.block[(br.offset+int64(n))%int64(len(br.block)):])
+	}
+	br.offset += int64(n)
+	return n, nil
+}
+
+// results of a single benchmarked operation type (put or get) for a given object size
+type benchResult struct {
+	sync.Mutex
+	op        string
+	size      int64
+	errors    int
+	bytes     int64
+	latencies []time.Duration
+	elapsed   time.Duration
+}
+
+func (br *benchResult) record(n int64, d time.Duration, err error) {
+	br.Lock()
+	defer br.Unlock()
+	if err != nil {
+		br.errors++
+		log.Printf("ERROR: bench %s of %s object: %s", br.op, FormatBytes(br.size), err)
+		return
+	}
+	br.bytes += n
+	br.latencies = append(br.latencies, d)
+}
+
+func (br *benchResult) print(endpoint string) {
+	sort.Slice(br.latencies, func(i, j int) bool { return br.latencies[i] < br.latencies[j] })
+	throughput := float64(br.bytes) / br.elapsed.Seconds()
+	log.Printf("%s %s %s: %d ok, %d errors, %s/s, %.1f obj/s, latency p50 %s, p95 %s, p99 %s",
+		endpoint, br.op, FormatBytes(br.size), len(br.latencies), br.errors,
+		FormatBytes(int64(throughput)), float64(len(br.latencies))/br.elapsed.Seconds(),
+		percentile(br.latencies, 50), percentile(br.latencies, 95), percentile(br.latencies, 99))
+}
+
+// run op for count objects with given concurrency, measuring overall elapsed time
+func benchRun(res *benchResult, count, concurrency int, op func(i int) (int64, error)) {
+	var wg sync.WaitGroup
+	workersCh := make(chan struct{}, concurrency)
+	start := time.Now()
+	for i := 0; i < count; i++ {
+		workersCh <- struct{}{}
+		wg.Add(1)
+		go func(i int) {
+			defer func() { <-workersCh; wg.Done() }()
+			opStart := time.Now()
+			n, err := op(i)
+			res.record(n, time.Since(opStart), err)
+		}(i)
+	}
+	wg.Wait()
+	res.elapsed = time.Since(start)
+}
+
+// upload, download and remove synthetic objects of each size, report throughput and latency
+func benchEndpoint(name string, store ObjectStore, prefix string, sizes []int64, count, concurrency int) {
+	block := make([]byte, benchBlockSize)
+	rand.Read(block)
+
+	for _, size := range sizes {
+		key := func(i int) string {
+			return fmt.Sprintf("%s%d/%06d", prefix, size, i)
+		}
+
+		put := &benchResult{op: "put", size: size}
+		benchRun(put, count, concurrency, func(i int) (int64, error) {
+			r := &benchReader{block: block, size: size}
+			return store.Put(context.Background(), key(i), r, size, "application/octet-stream")
+		})
+		put.print(name)
+
+		get := &benchResult{op: "get", size: size}
+		benchRun(get, count, concurrency, func(i int) (int64, error) {
+			obj, _, err := store.Get(context.Background(), key(i), 0, -1)
+			if err != nil {
+				return 0, err
+			}
+			defer obj.Close()
+			return io.Copy(ioutil.Discard, obj)
+		})
+		get.print(name)
+
+		for i := 0; i < count; i++ {
+			logErr(store.Delete(context.Background(), key(i)))
+		}
+	}
+}
+
+// settings of benchmark, Target is source, destination or both
+type BenchOptions struct {
+	Sizes       []int64
+	Count       int
+	Concurrency int
+	Prefix      string
+	Target      string
+}
+
+// measure throughput and latency of configured endpoints with synthetic objects,
+// helps to choose concurrency before real migration
+func Bench(c *Config, o BenchOptions) error {
+	if o.Concurrency <= 0 {
+		o.Concurrency = maxInt(c.Options.Concurrency, 1)
+	}
+
+	endpoints := map[string]Endpoint{}
+	switch o.Target {
+	case TargetSource:
+		endpoints[TargetSource] = c.Source
+	case TargetDestination:
+		endpoints[TargetDestination] = c.Destination
+	case "both":
+		endpoints[TargetSource] = c.Source
+		endpoints[TargetDestination] = c.Destination
+	default:
+		return fmt.Errorf("unknown bench target '%s'", o.Target)
+	}
+
+	for _, name := range []string{TargetSource, TargetDestination} {
+		e, ok := endpoints[name]
+		if !ok {
+			continue
+		}
+		log.Printf("benchmarking %s '%s', bucket '%s', %d objects per size, concurrency %d",
+			name, e, c.Options.Bucket, o.Count, o.Concurrency)
+		store, err := targetStore(c, name)
+		if err != nil {
+			return err
+		}
+		benchEndpoint(name, store, o.Prefix, o.Sizes, o.Count, o.Concurrency)
+	}
+	return nil
+}
+
+// return value at given percentile of sorted durations
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(float64(len(sorted))*p/100+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+	return sorted[i]
+}
+
+var sizeUnits = []struct {
+	suffix string
+	mult   int64
+}{
+	{"TiB", 1 << 40}, {"GiB", 1 << 30}, {"MiB", 1 << 20}, {"KiB", 1 << 10},
+	{"TB", 1 << 40}, {"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10},
+	{"T", 1 << 40}, {"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10},
+	{"B", 1},
+}
+
+// parse human readable size, e.g. 512, 4KiB, 16MB, 1G (all units are binary)
+func ParseByteSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	mult := int64(1)
+	for _, u := range sizeUnits {
+		if strings.HasSuffix(strings.ToUpper(s), strings.ToUpper(u.suffix)) {
+			s = strings.TrimSpace(s[:len(s)-len(u.suffix)])
+			mult = u.mult
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size '%s'", s)
+	}
+	return int64(n * float64(mult)), nil
+}
+
+// parse comma separated list of sizes
+func ParseSizeList(s string) ([]int64, error) {
+	var sizes []int64
+	for _, f := range strings.Split(s, ",") {
+		size, err := ParseByteSize(f)
+		if err != nil {
+			return nil, err
+		}
+		sizes = append(sizes, size)
+	}
+	return sizes, nil
+}
+
+// format size in human readable binary units
+func FormatBytes(n int64) string {
+	for _, u := range sizeUnits[:4] {
+		if n >= u.mult {
+			return fmt.Sprintf("%.1f%s", float64(n)/float64(u.mult), u.suffix)
+		}
+	}
+	return fmt.Sprintf("%dB", n)
+}
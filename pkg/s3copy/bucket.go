@@ -0,0 +1,177 @@
+package s3copy
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// store which can create its bucket, see Options.CreateBucket
+type bucketCreator interface {
+	// create bucket unless it exists, returns whether it was created
+	createBucket(ctx context.Context, region string, objectLock bool) (bool, error)
+}
+
+// store which can enable versioning of its bucket, see Options.EnableVersioning
+type bucketVersioner interface {
+	// enable versioning unless it's enabled, returns whether it was enabled
+	enableVersioning(ctx context.Context) (bool, error)
+}
+
+// stores of all destinations of fan-out
+func destinationStores(dst ObjectStore) []ObjectStore {
+	dst = unchunked(dst)
+	if f, ok := dst.(*fanoutStore); ok {
+		return f.stores
+	}
+	return []ObjectStore{dst}
+}
+
+// create destination bucket if it doesn't exist yet, every destination of fan-out is checked
+func createBucket(ctx context.Context, dst ObjectStore, c *Config) error {
+	for _, s := range destinationStores(dst) {
+		bc, ok := s.(bucketCreator)
+		if !ok {
+			return errors.New("destination doesn't support creating buckets, create_bucket requires S3 or local destination")
+		}
+		created, err := bc.createBucket(ctx, c.Destination.Region, c.Options.ObjectLock)
+		if err != nil {
+			return fmt.Errorf("creating bucket '%s': %s", c.Options.Bucket, err)
+		}
+		if created {
+			logInfo("created destination bucket '%s'", c.Options.Bucket)
+		}
+	}
+	return nil
+}
+
+// enable versioning of destination bucket, so overwritten and removed objects keep their history
+func enableVersioning(ctx context.Context, dst ObjectStore, c *Config) error {
+	for _, s := range destinationStores(dst) {
+		bv, ok := s.(bucketVersioner)
+		if !ok {
+			return errors.New("destination doesn't support versioning, enable_versioning requires S3 destination")
+		}
+		enabled, err := bv.enableVersioning(ctx)
+		if err != nil {
+			return fmt.Errorf("enabling versioning of bucket '%s': %s", c.Options.Bucket, err)
+		}
+		if enabled {
+			logInfo("enabled versioning of destination bucket '%s'", c.Options.Bucket)
+		}
+	}
+	return nil
+}
+
+func (s *minioStore) createBucket(ctx context.Context, region string, objectLock bool) (bool, error) {
+	exists, err := s.clnt.BucketExists(s.bucket)
+	if err != nil || exists {
+		return false, err
+	}
+	if !objectLock {
+		return true, s.clnt.MakeBucket(s.bucket, region)
+	}
+	// object lock can be enabled only when bucket is created, with header
+	// the minio client doesn't send, so the request is signed here
+	if region == "" {
+		region = "us-east-1"
+	}
+	var body []byte
+	if region != "us-east-1" {
+		body = []byte(`<CreateBucketConfiguration xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><LocationConstraint>` +
+			region + `</LocationConstraint></CreateBucketConfiguration>`)
+	}
+	resp, err := s.bucketRequest(ctx, http.MethodPut, "", region, http.Header{"X-Amz-Bucket-Object-Lock-Enabled": {"true"}}, body)
+	if err != nil {
+		if strings.Contains(err.Error(), "BucketAlreadyOwnedByYou") {
+			return false, nil
+		}
+		return false, err
+	}
+	resp.Body.Close()
+	return true, nil
+}
+
+func (s *minioStore) enableVersioning(ctx context.Context) (bool, error) {
+	region, err := s.clnt.GetBucketLocation(s.bucket)
+	if err != nil {
+		return false, err
+	}
+	resp, err := s.bucketRequest(ctx, http.MethodGet, "versioning=", region, nil, nil)
+	if err != nil {
+		return false, err
+	}
+	var conf struct {
+		Status string
+	}
+	err = xml.NewDecoder(resp.Body).Decode(&conf)
+	resp.Body.Close()
+	if err != nil {
+		return false, fmt.Errorf("decoding versioning configuration: %s", err)
+	}
+	if conf.Status == "Enabled" {
+		return false, nil
+	}
+	body := []byte(`<VersioningConfiguration xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><Status>Enabled</Status></VersioningConfiguration>`)
+	if resp, err = s.bucketRequest(ctx, http.MethodPut, "versioning=", region, nil, body); err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	return true, nil
+}
+
+// signed request of bucket subresource the minio client doesn't support, query is
+// in canonical form, e.g. "versioning=". non 2xx responses are returned as errors
+func (s *minioStore) bucketRequest(ctx context.Context, method, query, region string, header http.Header, body []byte) (*http.Response, error) {
+	return s.signedRequest(ctx, method, "/"+s.bucket, query, region, header, body)
+}
+
+func (s *minioStore) signedRequest(ctx context.Context, method, path, query, region string, header http.Header, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest(method, s.requestURL(path, query), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if len(body) > 0 {
+		sum := md5.Sum(body)
+		req.Header.Set("Content-Md5", base64.StdEncoding.EncodeToString(sum[:]))
+	}
+	payloadHash := sha256Hex(string(body))
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	signV4(req, payloadHash, s.endpoint.AccessKey, s.endpoint.SecretKey, region, "s3")
+	return doRequest(req)
+}
+
+// url of path-style request of the endpoint, path and query are escaped
+func (s *minioStore) requestURL(path, query string) string {
+	scheme := "http://"
+	if s.endpoint.SSL {
+		scheme = "https://"
+	}
+	u := scheme + s.endpoint.Endpoint + path
+	if query != "" {
+		u += "?" + query
+	}
+	return u
+}
+
+// bucket of local store is its root directory
+func (s *localStore) createBucket(ctx context.Context, region string, objectLock bool) (bool, error) {
+	if objectLock {
+		return false, errors.New("object lock isn't supported by local destination")
+	}
+	if _, err := os.Stat(s.root); err == nil {
+		return false, nil
+	}
+	return true, os.MkdirAll(s.root, 0755)
+}
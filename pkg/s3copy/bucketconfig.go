@@ -0,0 +1,125 @@
+package s3copy
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// bucket settings stored as subresources of the bucket, copied from source to destination
+const (
+	bucketPolicy       = "policy"
+	bucketLifecycle    = "lifecycle"
+	bucketTagging      = "tagging"
+	bucketCORS         = "cors"
+	bucketEncryption   = "encryption"
+	bucketNotification = "notification"
+)
+
+// store whose bucket settings can be read and written, see Options.CopyBucketConfig
+type bucketConfigStore interface {
+	// setting of bucket, nil if it isn't set
+	bucketSetting(ctx context.Context, name string) ([]byte, error)
+	putBucketSetting(ctx context.Context, name string, value []byte) error
+}
+
+// settings of source bucket copied to destination bucket by options of config
+func (c *Config) bucketSettings() []string {
+	if c.Options.CopyBucketConfig {
+		return []string{bucketPolicy, bucketLifecycle, bucketTagging, bucketCORS, bucketEncryption, bucketNotification}
+	}
+	var names []string
+	if c.Options.CopyPolicy {
+		names = append(names, bucketPolicy)
+	}
+	if c.Options.CopyLifecycle {
+		names = append(names, bucketLifecycle)
+	}
+	return names
+}
+
+// copy settings of source bucket to every destination, settings not set in source are left
+// as they are in destination. bucket has the same name in destination, so resources
+// referenced by policy stay valid. storage classes of lifecycle transitions must
+// exist in destination, as well as targets of event notifications, otherwise it rejects them
+func copyBucketSettings(ctx context.Context, src, dst ObjectStore, c *Config) error {
+	from, ok := primarySource(src).(bucketConfigStore)
+	if !ok {
+		return errors.New("source doesn't support bucket settings, copying them requires S3 source")
+	}
+	for _, name := range c.bucketSettings() {
+		value, err := from.bucketSetting(ctx, name)
+		if err != nil {
+			return fmt.Errorf("reading %s of source bucket '%s': %s", name, c.Options.Bucket, err)
+		}
+		if value == nil || emptyXMLSetting(value) {
+			logInfo("source bucket '%s' has no %s, it isn't copied", c.Options.Bucket, name)
+			continue
+		}
+		for _, s := range destinationStores(dst) {
+			to, ok := s.(bucketConfigStore)
+			if !ok {
+				return fmt.Errorf("destination doesn't support bucket settings, copying %s requires S3 destination", name)
+			}
+			if err := to.putBucketSetting(ctx, name, value); err != nil {
+				return fmt.Errorf("writing %s of destination bucket '%s': %s", name, c.Options.Bucket, err)
+			}
+		}
+		logInfo("copied %s of bucket '%s' to destination", name, c.Options.Bucket)
+	}
+	return nil
+}
+
+// xml setting without elements inside the root, e.g. notification configuration of bucket
+// without notifications, which would remove notifications of destination
+func emptyXMLSetting(value []byte) bool {
+	dec := xml.NewDecoder(bytes.NewReader(value))
+	elements := 0
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return err == io.EOF && elements == 1
+		}
+		if _, ok := tok.(xml.StartElement); ok {
+			elements++
+		}
+	}
+}
+
+func (s *minioStore) bucketSetting(ctx context.Context, name string) ([]byte, error) {
+	region, err := s.clnt.GetBucketLocation(s.bucket)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.bucketRequest(ctx, http.MethodGet, name+"=", region, nil, nil)
+	if err != nil {
+		// setting which isn't set is reported as missing, e.g. NoSuchBucketPolicy,
+		// unlike missing bucket
+		if se, ok := cause(err).(*statusError); ok && se.code == http.StatusNotFound &&
+			!strings.Contains(se.status, "<Code>NoSuchBucket</Code>") {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return ioutil.ReadAll(resp.Body)
+}
+
+func (s *minioStore) putBucketSetting(ctx context.Context, name string, value []byte) error {
+	region, err := s.clnt.GetBucketLocation(s.bucket)
+	if err != nil {
+		return err
+	}
+	resp, err := s.bucketRequest(ctx, http.MethodPut, name+"=", region, nil, value)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
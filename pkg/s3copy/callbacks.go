@@ -0,0 +1,68 @@
+package s3copy
+
+import "time"
+
+// progress is reported to OnProgress with this interval by default
+const defaultProgressInterval = time.Second
+
+// callbacks invoked during Run, so embedding applications can drive their own
+// ui and metrics. object callbacks are called concurrently from copy workers,
+// they must be safe for concurrent use and return quickly. nil callbacks are ignored
+type Callbacks struct {
+	// object is accepted by filters and its copy starts
+	OnObjectStart func(obj Object)
+	// object is processed: copied, re-copied, skipped or failed
+	OnObjectDone func(r ObjectResult)
+	// object failed to copy, listing failures are reported with empty key
+	OnError func(err *ObjectError)
+	// snapshot of progress, called periodically and once when copy completes
+	OnProgress func(p Progress)
+	// interval of OnProgress calls, defaults to 1s
+	ProgressInterval time.Duration
+}
+
+// set callbacks invoked during Run
+func WithCallbacks(cb Callbacks) Option {
+	return func(c *Config) { c.Run.Callbacks = cb }
+}
+
+func (cb *Callbacks) objectStart(obj Object) {
+	if cb.OnObjectStart != nil {
+		cb.OnObjectStart(obj)
+	}
+}
+
+func (cb *Callbacks) objectDone(r ObjectResult) {
+	if cb.OnObjectDone != nil {
+		cb.OnObjectDone(r)
+	}
+	if r.Result == ResultFailed && cb.OnError != nil {
+		cb.OnError(&ObjectError{Key: r.Key, Class: r.ErrorClass, Err: r.Err})
+	}
+}
+
+func (cb *Callbacks) listError(err error) {
+	if cb.OnError != nil {
+		cb.OnError(&ObjectError{Class: classifyError(err).String(), Err: err})
+	}
+}
+
+// call OnProgress every interval until stopCh is closed, and once more after that
+func (cp *Copier) reportProgress(stopCh <-chan struct{}) {
+	cb := &cp.cfg.Run.Callbacks
+	interval := cb.ProgressInterval
+	if interval <= 0 {
+		interval = defaultProgressInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			cb.OnProgress(cp.Progress())
+		case <-stopCh:
+			cb.OnProgress(cp.Progress())
+			return
+		}
+	}
+}
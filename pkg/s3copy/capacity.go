@@ -0,0 +1,117 @@
+package s3copy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// store which can report space left for new objects, see Options.CheckCapacity
+type capacityStore interface {
+	freeCapacity(ctx context.Context) (int64, error)
+}
+
+// abort the copy before it starts if size of source objects exceeds space left in any destination.
+// limit of capacity_limit is used if it's set, otherwise free space reported by destination.
+// size is an upper bound, objects already existing in destination aren't written again
+func checkCapacity(ctx context.Context, dst ObjectStore, c *Config, size, limit int64) error {
+	for _, s := range destinationStores(dst) {
+		free := limit
+		if limit <= 0 {
+			cs, ok := s.(capacityStore)
+			if !ok {
+				return errors.New("destination doesn't report its free capacity, set capacity_limit to check capacity")
+			}
+			var err error
+			if free, err = cs.freeCapacity(ctx); err != nil {
+				return fmt.Errorf("free capacity of destination unknown, set capacity_limit to check capacity: %s", err)
+			}
+		}
+		if size > free {
+			return fmt.Errorf("objects of '%s/%s' (%s) don't fit into destination, %s left",
+				c.Options.Bucket, c.Options.Directory, FormatBytes(size), FormatBytes(free))
+		}
+		logInfo("capacity check: %s of objects to copy, %s left in destination", FormatBytes(size), FormatBytes(free))
+	}
+	return nil
+}
+
+// free space of MinIO destination from admin api: usable space left on drives, which is
+// raw space reduced by parity of erasure coding, and space left under hard quota of bucket.
+// credentials of destination must allow admin:ServerInfo
+func (s *minioStore) freeCapacity(ctx context.Context) (int64, error) {
+	var info struct {
+		Backend struct {
+			StandardSCParity  int
+			TotalDrivesPerSet []int
+		}
+		Servers []struct {
+			Drives []struct {
+				State      string
+				AvailSpace int64
+			}
+		}
+	}
+	if err := s.adminRequest(ctx, "info", "", &info); err != nil {
+		return 0, err
+	}
+	var avail int64
+	for _, srv := range info.Servers {
+		for _, d := range srv.Drives {
+			if d.State == "ok" {
+				avail += d.AvailSpace
+			}
+		}
+	}
+	if b := info.Backend; len(b.TotalDrivesPerSet) > 0 && b.TotalDrivesPerSet[0] > b.StandardSCParity {
+		drives := int64(b.TotalDrivesPerSet[0])
+		avail = avail / drives * (drives - int64(b.StandardSCParity))
+	}
+
+	var quota struct {
+		Quota     int64
+		QuotaType string
+	}
+	if err := s.adminRequest(ctx, "get-bucket-quota", "bucket="+s.bucket, &quota); err != nil {
+		return 0, err
+	}
+	// fifo quota removes old objects instead of rejecting new ones
+	if quota.Quota <= 0 || quota.QuotaType == "fifo" {
+		return avail, nil
+	}
+	var usage struct {
+		BucketsUsageInfo map[string]struct {
+			Size int64
+		}
+	}
+	if err := s.adminRequest(ctx, "datausageinfo", "", &usage); err != nil {
+		return 0, err
+	}
+	left := quota.Quota - usage.BucketsUsageInfo[s.bucket].Size
+	if left < 0 {
+		left = 0
+	}
+	if left < avail {
+		return left, nil
+	}
+	return avail, nil
+}
+
+// signed request of MinIO admin api, json response is decoded into v
+func (s *minioStore) adminRequest(ctx context.Context, name, query string, v interface{}) error {
+	region := s.endpoint.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	resp, err := s.signedRequest(ctx, http.MethodGet, "/minio/admin/v3/"+name, query, region, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("decoding response of admin api %s: %s", name, err)
+	}
+	return nil
+}
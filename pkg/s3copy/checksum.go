@@ -0,0 +1,255 @@
+package s3copy
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding"
+	"encoding/hex"
+	"fmt"
+	"github.com/minio/minio-go"
+	"hash"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ETag of object uploaded in a single part without SSE-C/KMS is md5 of its content
+var md5ETagRe = regexp.MustCompile(`^[0-9a-fA-F]{32}$`)
+
+// ETag of multipart upload is md5 of md5 sums of its parts with number of parts
+var multipartETagRe = regexp.MustCompile(`^[0-9a-fA-F]{32}-([0-9]+)$`)
+
+// checksums recorded in destination metadata, see Options.ChecksumMetadata
+const checksumSHA256 = "sha256"
+
+// user metadata of destination object with sha256 of its content
+const checksumMetaKey = "s3-copy-dir-sha256"
+
+// part sizes of common S3 clients in MiB, tried when multipart ETag is calculated from content
+var commonPartSizes = []int64{5, 8, 10, 15, 16, 25, 32, 50, 64, 100, 128, 256, 512, 1024}
+
+// store which records checksum of content in metadata of written object
+type checksumStore interface {
+	setChecksum(ctx context.Context, key, sum, contentType string) error
+}
+
+// number of parts of multipart ETag, 0 if it isn't one
+func multipartParts(etag string) int {
+	m := multipartETagRe.FindStringSubmatch(etag)
+	if m == nil {
+		return 0
+	}
+	n, _ := strconv.Atoi(m[1])
+	return n
+}
+
+// compare content of source and destination objects. checksums recorded in metadata and ETags
+// are compared when they're of the same kind. otherwise source is downloaded once, its md5,
+// sha256 and multipart ETags of likely part sizes are matched against destination, and only if
+// part size of multipart ETag isn't guessed destination is downloaded as well
+func (cp *Copier) sameContent(srcInfo, dstInfo Object) (bool, error) {
+	if srcInfo.ETag == "" {
+		// objects from retry list have no listing info
+		var err error
+		countRequest(false, reqHead)
+		srcInfo, err = cp.src.Stat(cp.ctx, srcInfo.Key)
+		if err != nil {
+			return false, &opError{"stat", err}
+		}
+	}
+	if srcInfo.Size != dstInfo.Size {
+		return false, nil
+	}
+	if srcInfo.Checksum != "" && dstInfo.Checksum != "" {
+		return srcInfo.Checksum == dstInfo.Checksum, nil
+	}
+	if md5ETagRe.MatchString(srcInfo.ETag) && md5ETagRe.MatchString(dstInfo.ETag) {
+		return strings.EqualFold(srcInfo.ETag, dstInfo.ETag), nil
+	}
+	// the same parts give the same ETag, ETags of different part sizes don't tell anything
+	if multipartParts(srcInfo.ETag) > 0 && strings.EqualFold(srcInfo.ETag, dstInfo.ETag) {
+		return true, nil
+	}
+
+	h := newContentHasher(dstInfo, cp.partSize)
+	if err := cp.hashContent(cp.src, srcInfo.Key, h); err != nil {
+		return false, &opError{"get", err}
+	}
+	if same, known := h.matches(dstInfo); known {
+		return same, nil
+	}
+	dh := newContentHasher(Object{}, 0)
+	if err := cp.hashContent(cp.dst, dstInfo.Key, dh); err != nil {
+		return false, &opError{"get", err}
+	}
+	return bytes.Equal(h.md5.Sum(nil), dh.md5.Sum(nil)), nil
+}
+
+// download object and write its content to hasher
+func (cp *Copier) hashContent(store ObjectStore, key string, h *contentHasher) error {
+	countRequest(store == cp.dst, reqGet)
+	obj, _, err := store.Get(cp.ctx, key, 0, -1)
+	if err != nil {
+		return err
+	}
+	defer obj.Close()
+	_, err = io.Copy(h, obj)
+	return err
+}
+
+// hashes of content read once, matched against checksum or ETag of other object
+type contentHasher struct {
+	md5    hash.Hash
+	sha256 hash.Hash
+	// multipart ETags with part sizes giving the number of parts of ETag of other object
+	parts []*partHasher
+}
+
+// hasher of content to be matched against other, ownPartSize is part size of uploads of the copy
+func newContentHasher(other Object, ownPartSize int64) *contentHasher {
+	h := &contentHasher{md5: md5.New()}
+	if other.Checksum != "" {
+		h.sha256 = sha256.New()
+	}
+	n := int64(multipartParts(other.ETag))
+	if n == 0 {
+		return h
+	}
+	sizes := []int64{partSizeFor(other.Size, ownPartSize), (other.Size + n - 1) / n}
+	// clients round part size to whole MiB
+	sizes = append(sizes, (sizes[1]+1<<20-1)/(1<<20)*(1<<20))
+	for _, mib := range commonPartSizes {
+		sizes = append(sizes, mib<<20)
+	}
+	seen := map[int64]bool{}
+	for _, size := range sizes {
+		if size > 0 && !seen[size] && (other.Size+size-1)/size == n {
+			seen[size] = true
+			h.parts = append(h.parts, &partHasher{size: size, cur: md5.New()})
+		}
+	}
+	return h
+}
+
+func (h *contentHasher) Write(p []byte) (int, error) {
+	h.md5.Write(p)
+	if h.sha256 != nil {
+		h.sha256.Write(p)
+	}
+	for _, ph := range h.parts {
+		ph.write(p)
+	}
+	return len(p), nil
+}
+
+// check content against checksum or ETag of other object, known is false if there's nothing
+// to check it against, e.g. ETag of other kind or multipart ETag of unknown part size
+func (h *contentHasher) matches(other Object) (same, known bool) {
+	if other.Checksum != "" {
+		return hex.EncodeToString(h.sha256.Sum(nil)) == other.Checksum, true
+	}
+	if md5ETagRe.MatchString(other.ETag) {
+		return strings.EqualFold(hex.EncodeToString(h.md5.Sum(nil)), other.ETag), true
+	}
+	for _, ph := range h.parts {
+		if strings.EqualFold(ph.etag(), other.ETag) {
+			return true, true
+		}
+	}
+	return false, false
+}
+
+// multipart ETag of content with given part size
+type partHasher struct {
+	size    int64
+	written int64
+	cur     hash.Hash
+	sums    []byte
+	count   int
+}
+
+func (ph *partHasher) write(p []byte) {
+	for len(p) > 0 {
+		n := ph.size - ph.written
+		if int64(len(p)) < n {
+			n = int64(len(p))
+		}
+		ph.cur.Write(p[:n])
+		ph.written += n
+		p = p[n:]
+		if ph.written == ph.size {
+			ph.finishPart()
+		}
+	}
+}
+
+func (ph *partHasher) finishPart() {
+	ph.sums = append(ph.sums, ph.cur.Sum(nil)...)
+	ph.count++
+	ph.cur.Reset()
+	ph.written = 0
+}
+
+func (ph *partHasher) etag() string {
+	if ph.written > 0 {
+		ph.finishPart()
+	}
+	sum := md5.Sum(ph.sums)
+	return fmt.Sprintf("%s-%d", hex.EncodeToString(sum[:]), ph.count)
+}
+
+// sha256 of content written to destination, restored from state of resumed upload. nil if
+// checksums aren't recorded or state of upload has no hash of its parts
+func (cp *Copier) uploadHash(state []byte, resumed bool) hash.Hash {
+	if cp.checksum == nil {
+		return nil
+	}
+	h := sha256.New()
+	if !resumed {
+		return h
+	}
+	if len(state) == 0 {
+		return nil
+	}
+	if err := h.(encoding.BinaryUnmarshaler).UnmarshalBinary(state); err != nil {
+		return nil
+	}
+	return h
+}
+
+// state of hash saved with parts of upload, so checksum survives restart
+func hashState(h hash.Hash) []byte {
+	if h == nil {
+		return nil
+	}
+	b, _ := h.(encoding.BinaryMarshaler).MarshalBinary()
+	return b
+}
+
+// record sha256 of written object in its metadata, failure leaves object without checksum
+func (cp *Copier) recordChecksum(key string, h hash.Hash, contentType string) {
+	if h == nil {
+		return
+	}
+	countRequest(true, reqCopy)
+	err := cp.checksum.setChecksum(cp.ctx, key, hex.EncodeToString(h.Sum(nil)), contentType)
+	audit(auditEntry{Op: auditPut, Bucket: cp.bucket, Key: key}, err)
+	if err != nil {
+		logWarn("recording checksum of '%s/%s': %s", cp.bucket, key, err)
+	}
+}
+
+// metadata of written object is replaced by copying it onto itself, content type is kept
+func (s *minioStore) setChecksum(ctx context.Context, key, sum, contentType string) error {
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	dst, err := minio.NewDestinationInfo(s.bucket, key, nil, map[string]string{"Content-Type": contentType, checksumMetaKey: sum})
+	if err != nil {
+		return err
+	}
+	return s.clnt.ComposeObject(dst, []minio.SourceInfo{minio.NewSourceInfo(s.bucket, key, nil)})
+}
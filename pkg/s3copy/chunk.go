@@ -0,0 +1,348 @@
+package s3copy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	// content type of manifest written under key of chunked object
+	chunkManifestType = "application/vnd.s3-copy-dir.chunks+json"
+	// value of format field of manifest, objects without it aren't manifests
+	chunkManifestFormat = "v1"
+	// objects without content type larger than it aren't read to check whether they're manifests
+	maxChunkManifest = 1 << 12
+	// chunks of object are stored next to it as <key>.s3cd-chunk-00000, <key>.s3cd-chunk-00001, ...
+	chunkSuffix = ".s3cd-chunk-"
+)
+
+var chunkKeyRe = regexp.MustCompile(`\.s3cd-chunk-[0-9]{5,}$`)
+
+// objects larger than destination accepts are split into chunks, see Options.ChunkObjects
+type ChunkConfig struct {
+	// objects larger than it are stored as chunks of this size, e.g. "4GiB"
+	Size string `json:"size"`
+}
+
+// object stored under key of chunked object, its chunks are reassembled by reading destination
+type chunkManifest struct {
+	Format      string `json:"s3_copy_dir_chunks"`
+	Size        int64  `json:"size"`
+	ChunkSize   int64  `json:"chunk_size"`
+	Chunks      int    `json:"chunks"`
+	MD5         string `json:"md5"`
+	ContentType string `json:"content_type,omitempty"`
+}
+
+// store writing objects larger than size as chunks with manifest under key of the object, chunks
+// are hidden from listing and reassembled on reads. info of chunked object is the one of source
+// object with MD5 of its content as ETag. with size 0 objects are only reassembled
+type chunkedStore struct {
+	ObjectStore
+	bucket string
+	size   int64
+}
+
+// destination with chunking of large objects if it's configured
+func newChunkedStore(dst ObjectStore, c *Config) (ObjectStore, error) {
+	cc := c.Options.ChunkObjects
+	if cc == nil {
+		return dst, nil
+	}
+	size, err := ParseByteSize(cc.Size)
+	if err != nil {
+		return nil, err
+	}
+	return &chunkedStore{ObjectStore: dst, bucket: c.Options.Bucket, size: size}, nil
+}
+
+// store behind chunking, optional interfaces of stores are checked on it
+func unchunked(store ObjectStore) ObjectStore {
+	if s, ok := store.(*chunkedStore); ok {
+		return s.ObjectStore
+	}
+	return store
+}
+
+func chunkKey(key string, n int) string {
+	return fmt.Sprintf("%s%s%05d", key, chunkSuffix, n)
+}
+
+func (s *chunkedStore) List(ctx context.Context, prefix, token string, pageSize int) ([]Object, string, error) {
+	objs, next, err := s.ObjectStore.List(ctx, prefix, token, pageSize)
+	if err != nil {
+		return nil, "", err
+	}
+	listed := objs[:0]
+	for _, obj := range objs {
+		if !chunkKeyRe.MatchString(obj.Key) {
+			listed = append(listed, obj)
+		}
+	}
+	return listed, next, nil
+}
+
+func (s *chunkedStore) Stat(ctx context.Context, key string) (Object, error) {
+	obj, err := s.ObjectStore.Stat(ctx, key)
+	if err != nil || !maybeManifest(obj) {
+		return obj, err
+	}
+	m, err := s.manifest(ctx, key)
+	if err != nil || m == nil {
+		return obj, err
+	}
+	return m.object(obj), nil
+}
+
+func (s *chunkedStore) Get(ctx context.Context, key string, offset, length int64) (io.ReadCloser, Object, error) {
+	r, obj, err := s.ObjectStore.Get(ctx, key, offset, length)
+	if err != nil || !maybeManifest(obj) {
+		return r, obj, err
+	}
+	r.Close()
+	m, err := s.manifest(ctx, key)
+	if err != nil {
+		return nil, Object{}, err
+	}
+	if m == nil {
+		return s.ObjectStore.Get(ctx, key, offset, length)
+	}
+	end := m.Size
+	if length >= 0 && offset+length < end {
+		end = offset + length
+	}
+	return &chunkReader{ctx: ctx, store: s.ObjectStore, key: key, m: m, pos: offset, end: end}, m.object(obj), nil
+}
+
+// objects larger than chunk size are written as chunks, then manifest replaces the object.
+// chunks of the previous version of object over the new number of chunks are removed, chunks
+// written by failed put are removed too
+func (s *chunkedStore) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) (int64, error) {
+	if s.size <= 0 || size >= 0 && size <= s.size {
+		return s.ObjectStore.Put(ctx, key, r, size, contentType)
+	}
+	m := &chunkManifest{Format: chunkManifestFormat, ChunkSize: s.size, ContentType: contentType}
+	sum := md5.New()
+	br := bufio.NewReader(io.TeeReader(r, sum))
+	var written int64
+	for n := 0; ; n++ {
+		cr, csize, last, err := s.nextChunk(br, size, written)
+		if err != nil {
+			s.removeChunks(ctx, key, 0, n)
+			return written, fmt.Errorf("reading chunk %d: %s", n+1, err)
+		}
+		if n == 0 && last {
+			// content of unknown size fits single object
+			w, err := s.ObjectStore.Put(ctx, key, cr, csize, contentType)
+			cr.Close()
+			return w, err
+		}
+		ckey := chunkKey(key, n)
+		w, err := s.ObjectStore.Put(ctx, ckey, cr, csize, "application/octet-stream")
+		cr.Close()
+		written += w
+		audit(auditEntry{Op: auditPut, Bucket: s.bucket, Key: ckey, Size: w}, err)
+		if err != nil {
+			s.removeChunks(ctx, key, 0, n+1)
+			return written, fmt.Errorf("writing chunk %d: %s", n+1, err)
+		}
+		if last {
+			m.Chunks = n + 1
+			break
+		}
+	}
+	m.Size, m.MD5 = written, hex.EncodeToString(sum.Sum(nil))
+	body, err := json.Marshal(m)
+	if err != nil {
+		return written, err
+	}
+	if _, err := s.ObjectStore.Put(ctx, key, bytes.NewReader(body), int64(len(body)), chunkManifestType); err != nil {
+		s.removeChunks(ctx, key, 0, m.Chunks)
+		return written, fmt.Errorf("writing manifest of %d chunks: %s", m.Chunks, err)
+	}
+	s.removeChunks(ctx, key, m.Chunks, -1)
+	return written, nil
+}
+
+// next chunk of content and its size, last is true if content ends with it. chunks of content of
+// unknown size are spooled to temporary file, so their size is known before upload
+func (s *chunkedStore) nextChunk(br *bufio.Reader, size, written int64) (io.ReadCloser, int64, bool, error) {
+	if size >= 0 {
+		csize := s.size
+		if size-written < csize {
+			csize = size - written
+		}
+		return ioutil.NopCloser(io.LimitReader(br, csize)), csize, written+csize >= size, nil
+	}
+	f, err := ioutil.TempFile("", "s3-copy-dir-chunk-")
+	if err != nil {
+		return nil, 0, false, err
+	}
+	tf := &tempFile{f}
+	csize, err := io.Copy(f, io.LimitReader(br, s.size))
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	var last bool
+	if err == nil {
+		_, err = br.Peek(1)
+		last = err == io.EOF
+		if last {
+			err = nil
+		}
+	}
+	if err != nil {
+		tf.Close()
+		return nil, 0, false, err
+	}
+	return tf, csize, last, nil
+}
+
+// chunks of object are removed with it, object which isn't chunked has none
+func (s *chunkedStore) Delete(ctx context.Context, key string) error {
+	if err := s.ObjectStore.Delete(ctx, key); err != nil {
+		return err
+	}
+	s.removeChunks(ctx, key, 0, -1)
+	return nil
+}
+
+// remove chunks of object with numbers from..to-1, or from on if to is negative. failures leave
+// chunks behind without breaking the object
+func (s *chunkedStore) removeChunks(ctx context.Context, key string, from, to int) {
+	prefix := key + chunkSuffix
+	doneCh := make(chan struct{})
+	defer close(doneCh)
+	for obj := range listObjects(s.ObjectStore, s.bucket, prefix, 0, nil, doneCh) {
+		if obj.Err != nil {
+			logWarn("listing chunks of '%s': %s", key, obj.Err)
+			return
+		}
+		n, err := strconv.Atoi(strings.TrimPrefix(obj.Key, prefix))
+		if err != nil || n < from || to >= 0 && n >= to {
+			continue
+		}
+		err = s.ObjectStore.Delete(ctx, obj.Key)
+		audit(auditEntry{Op: auditDelete, Bucket: s.bucket, Key: obj.Key}, err)
+		if err != nil {
+			logWarn("removing chunk '%s': %s", obj.Key, err)
+		}
+	}
+}
+
+// object may be manifest: it has content type of manifests, or store doesn't keep content types
+// and it's small enough. it's read to tell for sure
+func maybeManifest(obj Object) bool {
+	return obj.ContentType == chunkManifestType || obj.ContentType == "" && obj.Size <= maxChunkManifest
+}
+
+// manifest of chunked object, nil if object isn't a manifest
+func (s *chunkedStore) manifest(ctx context.Context, key string) (*chunkManifest, error) {
+	r, _, err := s.ObjectStore.Get(ctx, key, 0, maxChunkManifest+1)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	body, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var m chunkManifest
+	if json.Unmarshal(body, &m) != nil || m.Format != chunkManifestFormat {
+		return nil, nil
+	}
+	if m.ChunkSize <= 0 || int64(m.Chunks) != (m.Size+m.ChunkSize-1)/m.ChunkSize {
+		return nil, fmt.Errorf("invalid manifest of chunked object '%s'", key)
+	}
+	return &m, nil
+}
+
+// info of chunked object with info of manifest obj
+func (m *chunkManifest) object(obj Object) Object {
+	obj.Size, obj.ETag, obj.ContentType, obj.Checksum = m.Size, m.MD5, m.ContentType, ""
+	return obj
+}
+
+// reads content of chunked object between pos and end, chunks are opened as they're reached
+type chunkReader struct {
+	ctx   context.Context
+	store ObjectStore
+	key   string
+	m     *chunkManifest
+	pos   int64
+	end   int64
+	cur   io.ReadCloser
+	// end of range read from the current chunk
+	curEnd int64
+}
+
+func (cr *chunkReader) Read(p []byte) (int, error) {
+	for {
+		if cr.pos >= cr.end {
+			return 0, io.EOF
+		}
+		if cr.cur == nil {
+			n := int(cr.pos / cr.m.ChunkSize)
+			offset := cr.pos % cr.m.ChunkSize
+			cr.curEnd = int64(n+1) * cr.m.ChunkSize
+			if cr.end < cr.curEnd {
+				cr.curEnd = cr.end
+			}
+			r, _, err := cr.store.Get(cr.ctx, chunkKey(cr.key, n), offset, cr.curEnd-cr.pos)
+			if err != nil {
+				return 0, fmt.Errorf("reading chunk %d of '%s': %s", n+1, cr.key, err)
+			}
+			cr.cur = r
+		}
+		if int64(len(p)) > cr.curEnd-cr.pos {
+			p = p[:cr.curEnd-cr.pos]
+		}
+		n, err := cr.cur.Read(p)
+		cr.pos += int64(n)
+		if err == io.EOF || cr.pos >= cr.curEnd {
+			cr.cur.Close()
+			cr.cur = nil
+			if cr.pos < cr.curEnd {
+				return n, fmt.Errorf("chunk %d of '%s' is truncated", cr.pos/cr.m.ChunkSize+1, cr.key)
+			}
+			err = nil
+		}
+		if n > 0 || err != nil {
+			return n, err
+		}
+	}
+}
+
+func (cr *chunkReader) Close() error {
+	if cr.cur != nil {
+		return cr.cur.Close()
+	}
+	return nil
+}
+
+// create copier restoring directory of destination with chunked objects into source,
+// chunks are reassembled as objects are read
+func newChunkRestorer(c *Config, ro RestoreOptions) (*Copier, error) {
+	if ro.Generation != "" || !ro.At.IsZero() {
+		return nil, errors.New("backup isn't configured, generations can't be restored")
+	}
+	rc := restoreConfig(c, c.Options.Directory, func(key string) string { return key })
+	rc.Run.Heal = ro.Overwrite
+	cp, err := NewCopier(rc)
+	if err != nil {
+		return nil, err
+	}
+	logInfo("restoring '%s/%s' with chunked objects into source", c.Options.Bucket, c.Options.Directory)
+	return cp, nil
+}
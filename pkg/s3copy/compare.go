@@ -0,0 +1,96 @@
+package s3copy
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// number and size of objects listed on one side of the copy
+type SideTotals struct {
+	Objects int64 `json:"objects"`
+	Bytes   int64 `json:"bytes"`
+}
+
+// totals of source and destination listed after the copy, see RunOptions.Compare
+type Comparison struct {
+	Source      SideTotals `json:"source"`
+	Destination SideTotals `json:"destination"`
+	// source minus destination: positive if objects are missing in destination,
+	// negative if destination has objects which don't exist in source
+	ObjectsDelta int64 `json:"objects_delta"`
+	BytesDelta   int64 `json:"bytes_delta"`
+}
+
+// list source and destination at the same time and compare their totals. only objects of the
+// shard accepted by filters are counted, on both sides. it's a quick answer whether everything
+// was copied, verify compares objects one by one
+func (cp *Copier) compare(prefix string, pageSize int) (*Comparison, error) {
+	logInfo("comparing objects of source and destination in '%s/%s'", cp.bucket, prefix)
+	cmp := &Comparison{}
+	var srcErr, dstErr error
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		cmp.Source, srcErr = cp.sideTotals(cp.src, prefix, pageSize, "")
+	}()
+	go func() {
+		defer wg.Done()
+		// objects of backup are under prefix of the generation
+		cmp.Destination, dstErr = cp.sideTotals(cp.dst, cp.destKey(prefix), pageSize, cp.destKey(""))
+	}()
+	wg.Wait()
+	if srcErr != nil {
+		return nil, fmt.Errorf("listing source: %s", srcErr)
+	}
+	if dstErr != nil {
+		return nil, fmt.Errorf("listing destination: %s", dstErr)
+	}
+	cmp.ObjectsDelta = cmp.Source.Objects - cmp.Destination.Objects
+	cmp.BytesDelta = cmp.Source.Bytes - cmp.Destination.Bytes
+	return cmp, nil
+}
+
+// totals of objects under prefix, strip is removed from keys before they're checked by shard and filters
+func (cp *Copier) sideTotals(store ObjectStore, prefix string, pageSize int, strip string) (SideTotals, error) {
+	var t SideTotals
+	doneCh := make(chan struct{})
+	defer close(doneCh)
+	for obj := range listObjects(store, cp.bucket, prefix, pageSize, nil, doneCh) {
+		if obj.Err != nil {
+			return t, obj.Err
+		}
+		obj.Key = strings.TrimPrefix(obj.Key, strip)
+		if !cp.inShard(obj.Key) || !cp.accepted(obj.Object) {
+			continue
+		}
+		t.Objects++
+		t.Bytes += obj.Size
+	}
+	return t, nil
+}
+
+// log totals of both sides, delta is a warning
+func (cmp *Comparison) log() {
+	logSummary("comparison: source %d objects (%s), destination %d objects (%s)",
+		cmp.Source.Objects, FormatBytes(cmp.Source.Bytes), cmp.Destination.Objects, FormatBytes(cmp.Destination.Bytes))
+	switch {
+	case cmp.ObjectsDelta > 0:
+		logWarn("comparison: %d objects of source are missing in destination", cmp.ObjectsDelta)
+	case cmp.ObjectsDelta < 0:
+		logWarn("comparison: destination has %d objects more than source", -cmp.ObjectsDelta)
+	case cmp.BytesDelta != 0:
+		// transforms change size of content, otherwise some objects differ
+		logWarn("comparison: same number of objects, but size differs by %s", formatDeltaBytes(cmp.BytesDelta))
+	default:
+		logSummary("comparison: source and destination match")
+	}
+}
+
+func formatDeltaBytes(delta int64) string {
+	if delta < 0 {
+		return "-" + FormatBytes(-delta)
+	}
+	return "+" + FormatBytes(delta)
+}
@@ -0,0 +1,178 @@
+package s3copy
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"github.com/minio/minio-go"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// payload of streamed object isn't hashed for the signature, so it isn't read twice
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+const (
+	// objects larger than it can't be written with single PUT of S3
+	maxSinglePut = 5 << 30
+	// parts of conditional multipart upload, larger objects get larger parts to fit
+	// into maxParts, parts of objects of unknown size are buffered in memory
+	conditionalPartSize = 64 << 20
+)
+
+// store which writes object only if it's in the state seen by the skip-check: missing if
+// etag is empty, otherwise with this etag, see Options.ConditionalPut. object written by
+// another writer in the meantime is kept and the write fails with errConflict
+type conditionalStore interface {
+	putIf(ctx context.Context, key string, r io.Reader, size int64, contentType, etag string) (int64, error)
+	completeUploadIf(ctx context.Context, key, uploadID string, parts []minio.CompletePart, etag string) error
+}
+
+// If-None-Match or If-Match header of conditional write
+func setWriteCondition(h http.Header, etag string) {
+	if etag == "" {
+		h.Set("If-None-Match", "*")
+		return
+	}
+	h.Set("If-Match", `"`+strings.Trim(etag, `"`)+`"`)
+}
+
+// minio client doesn't pass conditional headers, so object is streamed with request of its own.
+// objects too large for single PUT or of unknown size are written with multipart upload
+func (s *minioStore) putIf(ctx context.Context, key string, r io.Reader, size int64, contentType, etag string) (int64, error) {
+	if size < 0 || size > maxSinglePut {
+		return s.putMultipartIf(ctx, key, r, size, contentType, etag)
+	}
+	region, err := s.clnt.GetBucketLocation(s.bucket)
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequest(http.MethodPut, s.requestURL("/"+s.bucket+"/"+s3EscapeKey(key), ""), nil)
+	if err != nil {
+		return 0, err
+	}
+	req = req.WithContext(ctx)
+	requestBody(req, r, size)
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	req.Header.Set("Content-Type", contentType)
+	setWriteCondition(req.Header, etag)
+	req.Header.Set("X-Amz-Content-Sha256", unsignedPayload)
+	signV4(req, unsignedPayload, s.endpoint.AccessKey, s.endpoint.SecretKey, region, "s3")
+	resp, err := doRequest(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return size, nil
+}
+
+// upload object in parts and complete the upload conditionally, upload is aborted on failure
+func (s *minioStore) putMultipartIf(ctx context.Context, key string, r io.Reader, size int64, contentType, etag string) (int64, error) {
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	uploadID, err := s.newUpload(key, contentType)
+	if err != nil {
+		return 0, err
+	}
+	written, err := s.putParts(ctx, key, uploadID, r, size, etag)
+	if err != nil {
+		if err := s.abortUpload(key, uploadID); err != nil {
+			logWarn("aborting upload of '%s/%s': %s", s.bucket, key, err)
+		}
+		return written, err
+	}
+	return written, nil
+}
+
+func (s *minioStore) putParts(ctx context.Context, key, uploadID string, r io.Reader, size int64, etag string) (int64, error) {
+	partSize := partSizeFor(size, conditionalPartSize)
+	var parts []minio.CompletePart
+	var written int64
+	var buf bytes.Buffer
+	for n := 1; size < 0 || written < size; n++ {
+		if n > maxParts {
+			return written, fmt.Errorf("object is larger than %d parts of %s", maxParts, FormatBytes(partSize))
+		}
+		part, psize := io.Reader(io.LimitReader(r, partSize)), partSize
+		if size < 0 {
+			// size of part must be known, part of stream is read first
+			buf.Reset()
+			m, err := io.CopyN(&buf, r, partSize)
+			if err != nil && err != io.EOF {
+				return written, err
+			}
+			if m == 0 && n > 1 {
+				break
+			}
+			part, psize = &buf, m
+		} else if size-written < psize {
+			psize = size - written
+		}
+		partETag, err := s.putPart(ctx, key, uploadID, n, part, psize)
+		if err != nil {
+			return written, err
+		}
+		written += psize
+		parts = append(parts, minio.CompletePart{PartNumber: n, ETag: partETag})
+		if size < 0 && psize < partSize {
+			break
+		}
+	}
+	return written, s.completeUploadIf(ctx, key, uploadID, parts, etag)
+}
+
+func (s *minioStore) completeUploadIf(ctx context.Context, key, uploadID string, parts []minio.CompletePart, etag string) error {
+	region, err := s.clnt.GetBucketLocation(s.bucket)
+	if err != nil {
+		return err
+	}
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name             `xml:"CompleteMultipartUpload"`
+		Parts   []minio.CompletePart `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		return err
+	}
+	header := http.Header{}
+	setWriteCondition(header, etag)
+	resp, err := s.signedRequest(ctx, http.MethodPost, "/"+s.bucket+"/"+s3EscapeKey(key), "uploadId="+url.QueryEscape(uploadID), region, header, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// failed completion can be reported with 200 and error in the body
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if bytes.Contains(b, []byte("<Error>")) {
+		var e minio.ErrorResponse
+		if err := xml.Unmarshal(b, &e); err != nil {
+			return fmt.Errorf("completing upload: %s", b)
+		}
+		return e
+	}
+	return nil
+}
+
+// percent-encode key for signed S3 request, everything but unreserved characters and slashes
+// is encoded, as in canonical request of the signature
+func s3EscapeKey(key string) string {
+	var b strings.Builder
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-_.~/", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,344 @@
+package s3copy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+// exit codes of copy, reported in the final report
+const (
+	ExitOK          = 0
+	ExitError       = 1   // unexpected runtime error
+	ExitPartial     = 2   // copy completed, but some objects failed
+	ExitAborted     = 3   // copy aborted by error threshold
+	ExitConfigError = 4   // invalid config file or flags
+	ExitLocked      = 5   // another copy holds the lock
+	ExitInterrupted = 130 // copy interrupted by signal
+)
+
+// types of endpoints
+const (
+	EndpointS3     = "s3"
+	EndpointLocal  = "local"
+	EndpointAzure  = "azure"
+	EndpointSFTP   = "sftp"
+	EndpointB2     = "b2"
+	EndpointSwift  = "swift"
+	EndpointWebDAV = "webdav"
+	EndpointURLs   = "urls"
+)
+
+// S3 endpoint by default, type selects other backends. for local endpoint
+// bucket is ignored, directory is relative to the path. for azure bucket is
+// the container, access and secret keys are account name and key. for sftp
+// access key is the user, path is the remote directory. for b2 access and
+// secret keys are application key id and key, endpoint is optional api host.
+// for swift endpoint is keystone url, access and secret keys are user and password.
+// for webdav endpoint is the dav url, path is the directory under it. urls
+// endpoint is read-only source of http urls listed in manifest file at path
+type Endpoint struct {
+	Type      string `json:"type,omitempty"`
+	Endpoint  string `json:"endpoint"`
+	SSL       bool   `json:"ssl"`
+	AccessKey string `json:"access_key"`
+	SecretKey string `json:"secret_key"`
+	Path      string `json:"path,omitempty"`
+	// private key and known hosts file of sftp endpoint
+	KeyFile    string `json:"key_file,omitempty"`
+	KnownHosts string `json:"known_hosts,omitempty"`
+	// keystone scope of swift endpoint, domain is Default if empty
+	Project string `json:"project,omitempty"`
+	Domain  string `json:"domain,omitempty"`
+	// region of swift or S3 endpoint, S3 bucket created with create_bucket is located in it
+	Region string `json:"region,omitempty"`
+}
+
+// endpoint address shown in logs and reports
+func (e Endpoint) String() string {
+	switch {
+	case e.Type == EndpointLocal:
+		return "file://" + e.Path
+	case e.Type == EndpointURLs:
+		return "urls:" + e.Path
+	case e.Type == EndpointAzure && e.Endpoint == "":
+		return e.AccessKey + ".blob.core.windows.net"
+	case e.Type == EndpointB2 && e.Endpoint == "":
+		return "api.backblazeb2.com"
+	case e.Type == EndpointSFTP:
+		return "sftp://" + e.AccessKey + "@" + e.Endpoint + "/" + strings.TrimPrefix(e.Path, "/")
+	}
+	return e.Endpoint
+}
+
+type Options struct {
+	Bucket string `json:"bucket"`
+	// objects under directory are copied, "path/to/files" doesn't match "path/to/files-old/..."
+	Directory string `json:"directory"`
+	// directory is used as raw prefix of keys, "path/to/files" matches also "path/to/files-old/..."
+	RawPrefix bool `json:"raw_prefix"`
+	// only objects with user metadata matching all of these glob patterns are copied, e.g.
+	// {"export": "true"}. every listed source object is read with HEAD request for its metadata
+	MetadataFilter map[string]string `json:"metadata_filter,omitempty"`
+	// whole bucket is copied if directory is empty, otherwise empty directory is rejected
+	EntireBucket    bool   `json:"entire_bucket"`
+	Concurrency     int    `json:"concurrency"`
+	AutoConcurrency bool   `json:"auto_concurrency"`
+	ListPageSize    int    `json:"list_page_size"`
+	ListCheckpoint  string `json:"list_checkpoint"`
+	StateFile       string `json:"state_file"`
+	FailedFile      string `json:"failed_file"`
+	LockFile        string `json:"lock_file"`
+	LockObject      string `json:"lock_object"`
+	LockLease       string `json:"lock_lease"`
+	// copies of objects of one key prefix at the same time, so hot prefix isn't throttled by
+	// S3 while objects of other prefixes are copied at full concurrency
+	PrefixConcurrency *PrefixLimit `json:"prefix_concurrency,omitempty"`
+	// bandwidth limits applied at times of day, e.g. lower limit during business hours
+	BandwidthSchedule *BandwidthSchedule `json:"bandwidth_schedule,omitempty"`
+	// objects of this size or larger are copied with resumable multipart upload, requires state_file
+	MultipartThreshold string `json:"multipart_threshold"`
+	PartSize           string `json:"part_size"`
+	// after the run, abort incomplete multipart uploads in destination directory started longer
+	// than this ago, e.g. "24h", like cleanup-uploads command
+	CleanupUploads string `json:"cleanup_uploads,omitempty"`
+	// final json report of the run
+	ReportFile string `json:"report_file"`
+	// every skipped object is appended as json line with reason, e.g. "exists" or "filtered"
+	SkipReport string `json:"skip_report,omitempty"`
+	// final report is posted to webhook, signed with HMAC-SHA256 of secret
+	WebhookURL    string `json:"webhook_url"`
+	WebhookSecret string `json:"webhook_secret"`
+	// start and finish messages are sent to slack incoming webhook
+	SlackWebhookURL string `json:"slack_webhook_url"`
+	// dead-man switch url pinged on start, success (<url>) and failure (<url>/fail)
+	PingURL string `json:"ping_url"`
+	// prices of requests and egress used for cost estimation, AWS S3 prices by default
+	Prices *PriceTable `json:"prices"`
+	// append-only log of mutating operations in destination
+	AuditFile string `json:"audit_file"`
+	// record of every copied object, csv or ndjson
+	ManifestFile   string `json:"manifest_file"`
+	ManifestFormat string `json:"manifest_format"`
+	// shell commands run per object: content is piped through transform_hook before
+	// upload, post_copy_hook runs after object was copied
+	TransformHook string `json:"transform_hook"`
+	PostCopyHook  string `json:"post_copy_hook"`
+	// Go plugins exporting Transform, applied after transform_hook
+	TransformPlugins []string `json:"transform_plugins"`
+	// listen consumes S3 event notifications of source bucket from this SQS queue,
+	// accessed with credentials of source
+	SQSQueueURL string `json:"sqs_queue_url"`
+	// reads of source with replicas: failover (from primary while it's healthy) or round-robin
+	SourceBalance string `json:"source_balance"`
+	// destination bucket is created in region of destination if it doesn't exist,
+	// with object lock enabled if it's set
+	CreateBucket bool `json:"create_bucket"`
+	ObjectLock   bool `json:"object_lock"`
+	// versioning of destination bucket is enabled before the copy, so history
+	// of overwritten and removed objects isn't lost
+	EnableVersioning bool `json:"enable_versioning"`
+	// policy of source bucket is applied to destination bucket before the copy
+	CopyPolicy bool `json:"copy_policy"`
+	// lifecycle rules of source bucket (expiration, transitions) are applied to destination bucket
+	CopyLifecycle bool `json:"copy_lifecycle"`
+	// all settings of source bucket are applied to destination bucket: policy, lifecycle,
+	// tags, CORS rules, default encryption and event notifications
+	CopyBucketConfig bool `json:"copy_bucket_config"`
+	// when object existing in destination is kept: never, if-exists, if-same-size, if-same-etag,
+	// if-not-newer or always. overrides heal and sync, by default existing objects are kept
+	SkipPolicy string `json:"skip_policy,omitempty"`
+	// objects are written only if destination object is still as seen by the skip-check (missing,
+	// or with the same etag when re-copied), so objects of concurrent writers aren't overwritten
+	ConditionalPut bool `json:"conditional_put"`
+	// permissions of source and destination are checked before the copy, missing ones abort it
+	Preflight bool `json:"preflight"`
+	// size of source objects is checked against free capacity of destination before the copy,
+	// reported by MinIO admin api or limited by capacity_limit, e.g. "2TiB"
+	CheckCapacity bool   `json:"check_capacity"`
+	CapacityLimit string `json:"capacity_limit"`
+	// checksum of content recorded in metadata of destination objects, so content of objects with
+	// ETags of different kinds is compared without downloading destination. "sha256" or empty
+	ChecksumMetadata string `json:"checksum_metadata,omitempty"`
+	// steps applied to source keys to get destination keys, e.g. ["collapse-slashes", "nfc"]
+	KeyNormalization []string `json:"key_normalization,omitempty"`
+	// objects are stored under hashed or encrypted names of keys, e.g. in untrusted destination
+	KeyObfuscation *KeyObfuscationConfig `json:"key_obfuscation,omitempty"`
+	// objects larger than destination accepts are stored as chunks with manifest under their key
+	ChunkObjects *ChunkConfig `json:"chunk_objects,omitempty"`
+	// every run writes a new generation of the directory under dated prefix of destination
+	Backup *BackupConfig `json:"backup,omitempty"`
+	// orphans removed from destination and objects removed from source with listen are moved
+	// under <trash>/<time>/ of destination instead of deleting them, purge removes them later
+	Trash string `json:"trash,omitempty"`
+	// destination objects with content different from source found by heal or verify are copied
+	// under <quarantine>/<time>/ before they're copied again from source
+	Quarantine string `json:"quarantine,omitempty"`
+	// listing of source directory is cached in local file and reused by runs and commands
+	// within ttl, e.g. copy, verify and rm --orphans run one after another list source once
+	ListCache *ListCacheConfig `json:"list_cache,omitempty"`
+	// source objects are tagged once their copy is verified, so lifecycle of source bucket
+	// expires them, e.g. hot tier ages out once it's safely archived
+	ExpireSource *ExpireConfig `json:"expire_source,omitempty"`
+	// keys to copy are consumed from work queue with consume
+	Queue *QueueConfig `json:"queue"`
+}
+
+// configuration of the copy, loaded from json config file. Run holds settings
+// of a single run which aren't part of the config file
+type Config struct {
+	Source Endpoint `json:"source"`
+	// replicas of source with the same objects, reads fail over to them
+	Sources     []Endpoint `json:"sources,omitempty"`
+	Destination Endpoint   `json:"destination"`
+	// objects are written also to these destinations, content is read from source once
+	Destinations []Endpoint `json:"destinations,omitempty"`
+	Options      `json:"options"`
+	Run          RunOptions `json:"-"`
+}
+
+// settings of a single copy run, set from command line flags by the cli
+type RunOptions struct {
+	// count objects before copying to show progress
+	Progress bool
+	// copy only objects listed in failed_file by previous run
+	RetryFailed bool
+	// abort copying once number of failed objects reaches MaxErrors (0 - unlimited)
+	MaxErrors int64
+	// transient errors are retried up to Retries times with exponential backoff
+	Retries    int
+	RetryDelay time.Duration
+	// re-copy existing destination objects with content different from source
+	Heal bool
+	// re-copy objects existing in destination which are older than source
+	Sync bool
+	// after copy, re-list source up to ReconcilePasses times
+	ReconcilePasses int
+	// after copy, list source and destination and report object count and size of both sides
+	Compare bool
+	// process only objects modified after start of the last successful run recorded in state_file
+	SinceLastRun bool
+	// keep re-syncing new and modified objects with this interval until copy is stopped
+	WatchInterval time.Duration
+	// after copy, replicate changes received from bucket notifications of MinIO source
+	// or from sqs_queue_url until copy is stopped
+	Listen bool
+	// copy keys consumed from queue instead of listing source, until copy is stopped
+	Consume bool
+	// with Consume, copy units of objects leased from coordinator of distributed copy
+	// at this url instead of queue. worker name defaults to hostname with random suffix
+	Coordinator      string
+	CoordinatorToken string
+	WorkerName       string
+
+	SummaryInterval time.Duration
+	MetricsAddr     string
+	StatusAddr      string
+	OTLPEndpoint    string
+	// accept changes of concurrency, bandwidth limit and pause on /control of status endpoint
+	StatusControl bool
+	// render progress bar on stderr instead of per-object log lines
+	ProgressBar bool
+
+	// copy only keys with hash modulo ShardCount equal to ShardIndex, so independently
+	// started instances partition the directory. 0 or 1 - whole directory
+	ShardIndex int
+	ShardCount int
+	// copy only objects accepted by all filters
+	Filters []Filter
+	// limit of bytes per second read from source, 0 - unlimited
+	BandwidthLimit int64
+	// collect outcome of every object in Result.Objects
+	ObjectResults bool
+	// outcome of every processed object is written to this writer as json line
+	ResultsOutput io.Writer
+	// transforms of object content applied after configured ones
+	Transforms []Transform
+	// hooks of embedding application
+	Callbacks Callbacks
+	// source is listed even if cached listing is fresh, the listing is cached again
+	RefreshListCache bool
+	// custom stores used instead of configured endpoints
+	SourceStore      ObjectStore
+	DestinationStore ObjectStore
+	// workers shared with other copies running at the same time, e.g. steps of pipeline
+	sharedWorkers *workerLimiter
+	// source is destination with chunked objects, e.g. of restore, they're reassembled
+	chunkedSource bool
+}
+
+// sample configuration with all options set
+func SampleConfig() *Config {
+	return &Config{
+		Source: Endpoint{
+			Endpoint:  "s3.amazonaws.com",
+			SSL:       true,
+			AccessKey: "AWSACCESSKEY",
+			SecretKey: "AWSSECRETKEY",
+		},
+		Destination: Endpoint{
+			Endpoint:  "minio.example.com",
+			SSL:       true,
+			AccessKey: "MINIOACCESSKEY",
+			SecretKey: "MINIOSECRETKEY",
+		},
+		Options: Options{
+			Concurrency:        4,
+			AutoConcurrency:    false,
+			Bucket:             "bucketname",
+			Directory:          "path/to/files",
+			ListPageSize:       1000,
+			ListCheckpoint:     "list.checkpoint",
+			StateFile:          "state.db",
+			FailedFile:         "failed.jsonl",
+			LockFile:           "s3-copy-dir.lock",
+			LockObject:         ".s3-copy-dir.lock",
+			LockLease:          "5m",
+			MultipartThreshold: "128MiB",
+			PartSize:           "64MiB",
+			ReportFile:         "report.json",
+			WebhookURL:         "https://example.com/s3-copy-dir/hook",
+			WebhookSecret:      "secret",
+			SlackWebhookURL:    "https://hooks.slack.com/services/T000/B000/XXXX",
+			PingURL:            "https://hc-ping.com/your-uuid",
+			AuditFile:          "audit.jsonl",
+			ManifestFile:       "manifest.csv",
+			ManifestFormat:     "csv",
+			// selfhosted destination doesn't charge for requests
+			Prices: &PriceTable{Source: &defaultPrices, Destination: &Prices{}},
+		},
+	}
+}
+
+// prefix of listed keys: directory with trailing delimiter, unless raw_prefix is set
+func (c *Config) listPrefix() string {
+	dir := c.Options.Directory
+	if c.Options.RawPrefix || dir == "" || strings.HasSuffix(dir, "/") {
+		return dir
+	}
+	return dir + "/"
+}
+
+// load configuration file
+func LoadConfig(path string) (*Config, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c := &Config{}
+	// misspelled option would be silently ignored
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(c); err != nil {
+		return nil, fmt.Errorf("loading config '%s': %s", path, err)
+	}
+	if err := c.Validate(); err != nil {
+		err.(*ConfigError).File = path
+		return nil, err
+	}
+	return c, nil
+}
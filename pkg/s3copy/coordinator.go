@@ -0,0 +1,489 @@
+package s3copy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ways of grouping listed keys into units of work
+const (
+	ShardByHash   = "hash"
+	ShardByPrefix = "prefix"
+)
+
+const (
+	defaultShards        = 64
+	defaultLeaseDuration = time.Minute * 5
+	// listing pauses while this many units wait for workers
+	maxPendingUnits = 100
+)
+
+// settings of distributed copy coordinator
+type CoordinatorOptions struct {
+	// keys are grouped into units by hash of the key modulo Shards, or by
+	// the first path segment below directory
+	ShardBy string
+	Shards  int
+	// objects per unit, list_page_size by default
+	UnitSize int
+	// unit is re-assigned to another worker unless its lease is renewed within this time
+	Lease time.Duration
+}
+
+// objects leased to one worker at a time
+type workUnit struct {
+	id      string
+	shard   string
+	objects []Object
+	// number of times unit was leased
+	attempts int
+	// worker holding the lease, empty while pending
+	worker  string
+	expires time.Time
+}
+
+// progress of distributed copy, served on /status of coordinator
+type CoordinatorStatus struct {
+	ExitCode      int                    `json:"exit_code"`
+	Listed        bool                   `json:"listed"`
+	ObjectsListed int64                  `json:"objects_listed"`
+	UnitsPending  int                    `json:"units_pending"`
+	UnitsLeased   int                    `json:"units_leased"`
+	UnitsDone     int                    `json:"units_done"`
+	Reassigned    int                    `json:"reassigned"`
+	Processed     int64                  `json:"processed"`
+	Failed        int64                  `json:"failed"`
+	Workers       map[string]WorkerState `json:"workers"`
+}
+
+type WorkerState struct {
+	LastSeen time.Time `json:"last_seen"`
+	Leases   int       `json:"leases"`
+	// objects processed and failed by the worker
+	Processed int64 `json:"processed"`
+	Failed    int64 `json:"failed"`
+}
+
+// lists source, groups objects into units and leases them to workers over http, see Handler.
+// units of workers which stopped renewing their leases are re-assigned to other workers
+type Coordinator struct {
+	cfg  *Config
+	opts CoordinatorOptions
+	src  ObjectStore
+
+	mu sync.Mutex
+	// signalled when pending units are leased, so listing can continue
+	cond    *sync.Cond
+	pending []*workUnit
+	// units which aren't done, pending or leased
+	units    map[string]*workUnit
+	nextID   int
+	stopped  bool
+	status   CoordinatorStatus
+	failures *failureLog
+	doneCh   chan struct{}
+}
+
+func NewCoordinator(c *Config, o CoordinatorOptions) (*Coordinator, error) {
+	src, err := sourceStore(c)
+	if err != nil {
+		return nil, err
+	}
+	switch o.ShardBy {
+	case "":
+		o.ShardBy = ShardByHash
+	case ShardByHash, ShardByPrefix:
+	default:
+		return nil, fmt.Errorf("unknown shard-by '%s', must be %s or %s", o.ShardBy, ShardByHash, ShardByPrefix)
+	}
+	if o.Shards <= 0 {
+		o.Shards = defaultShards
+	}
+	if o.UnitSize <= 0 {
+		o.UnitSize = c.Options.ListPageSize
+	}
+	if o.UnitSize <= 0 || o.UnitSize > maxListPageSize {
+		o.UnitSize = maxListPageSize
+	}
+	if o.Lease <= 0 {
+		o.Lease = defaultLeaseDuration
+	}
+	co := &Coordinator{cfg: c, opts: o, src: src, units: map[string]*workUnit{}, doneCh: make(chan struct{}),
+		status: CoordinatorStatus{Workers: map[string]WorkerState{}}}
+	co.cond = sync.NewCond(&co.mu)
+	return co, nil
+}
+
+// list source and hand out units until all of them are done or ctx is cancelled
+func (co *Coordinator) Run(ctx context.Context) (CoordinatorStatus, error) {
+	c := co.cfg
+	if c.Options.FailedFile != "" {
+		var err error
+		if co.failures, err = openFailureLog(c.Options.FailedFile); err != nil {
+			return co.Status(), err
+		}
+		defer co.failures.close()
+	}
+	logInfo("coordinating copy of '%s/%s' from %s to %s, units of %d objects sharded by %s, lease %s",
+		c.Options.Bucket, c.Options.Directory, c.Source.String(), c.Destination.String(),
+		co.opts.UnitSize, co.opts.ShardBy, co.opts.Lease)
+	start := time.Now()
+
+	listDoneCh := make(chan struct{})
+	go func() {
+		defer close(listDoneCh)
+		co.list(ctx)
+	}()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	code := ExitOK
+	for done := false; !done; {
+		select {
+		case <-co.doneCh:
+			done = true
+		case <-ctx.Done():
+			code, done = ExitInterrupted, true
+		case <-ticker.C:
+			co.reassignExpired()
+		}
+	}
+	co.stop()
+	<-listDoneCh
+
+	co.mu.Lock()
+	defer co.mu.Unlock()
+	switch {
+	case code != ExitOK:
+	case !co.status.Listed:
+		code = ExitError
+	case co.status.Failed > 0:
+		code = ExitPartial
+	}
+	co.status.ExitCode = code
+	logInfo("distributed copy finished, %d objects listed, %d processed, %d failed by %d workers, %d units re-assigned in %s",
+		co.status.ObjectsListed, co.status.Processed, co.status.Failed, len(co.status.Workers), co.status.Reassigned,
+		time.Since(start).Round(time.Second))
+	return co.snapshot(), nil
+}
+
+// no more units are handed out, idle workers are told to exit
+func (co *Coordinator) stop() {
+	co.mu.Lock()
+	defer co.mu.Unlock()
+	co.stopped = true
+	co.cond.Broadcast()
+}
+
+func (co *Coordinator) list(ctx context.Context) {
+	c := co.cfg
+	doneCh := make(chan struct{})
+	defer close(doneCh)
+	open := map[string]*workUnit{}
+	for entry := range listObjects(co.src, c.Options.Bucket, c.listPrefix(), c.Options.ListPageSize, nil, doneCh) {
+		if entry.Err != nil {
+			logError("listing objects: %s, stopping distributed copy", entry.Err)
+			co.finish()
+			return
+		}
+		shard := co.shard(entry.Key)
+		u := open[shard]
+		if u == nil {
+			// listing is sorted, so units of previous prefix won't get more objects
+			if co.opts.ShardBy == ShardByPrefix {
+				for _, u := range open {
+					if !co.enqueue(u) {
+						return
+					}
+				}
+				open = map[string]*workUnit{}
+			}
+			u = &workUnit{shard: shard}
+			open[shard] = u
+		}
+		u.objects = append(u.objects, entry.Object)
+		co.mu.Lock()
+		co.status.ObjectsListed++
+		co.mu.Unlock()
+		if len(u.objects) >= co.opts.UnitSize {
+			delete(open, shard)
+			if !co.enqueue(u) {
+				return
+			}
+		}
+	}
+	for _, u := range open {
+		if !co.enqueue(u) {
+			return
+		}
+	}
+	co.mu.Lock()
+	defer co.mu.Unlock()
+	co.status.Listed = true
+	logInfo("listing completed, %d objects in %d units", co.status.ObjectsListed, co.nextID)
+	co.checkDoneLocked()
+}
+
+// shard of key: hash of key modulo number of shards, or its first path segment below directory
+func (co *Coordinator) shard(key string) string {
+	if co.opts.ShardBy == ShardByPrefix {
+		rest := strings.TrimPrefix(strings.TrimPrefix(key, co.cfg.Options.Directory), "/")
+		if i := strings.Index(rest, "/"); i >= 0 {
+			return rest[:i+1]
+		}
+		return ""
+	}
+	return strconv.Itoa(keyShard(key, co.opts.Shards))
+}
+
+// add unit to pending ones, waits while too many units are pending. false if coordinator stopped
+func (co *Coordinator) enqueue(u *workUnit) bool {
+	co.mu.Lock()
+	defer co.mu.Unlock()
+	for len(co.pending) >= maxPendingUnits && !co.stopped {
+		co.cond.Wait()
+	}
+	if co.stopped {
+		return false
+	}
+	co.nextID++
+	u.id = strconv.Itoa(co.nextID)
+	co.units[u.id] = u
+	co.pending = append(co.pending, u)
+	return true
+}
+
+// lease next pending unit to worker, nil if there's none
+func (co *Coordinator) lease(worker string) (*workUnit, bool) {
+	co.mu.Lock()
+	defer co.mu.Unlock()
+	co.seen(worker)
+	if co.stopped {
+		return nil, false
+	}
+	if len(co.pending) == 0 {
+		return nil, true
+	}
+	u := co.pending[0]
+	co.pending = co.pending[1:]
+	u.worker, u.expires = worker, time.Now().Add(co.opts.Lease)
+	u.attempts++
+	ws := co.status.Workers[worker]
+	ws.Leases++
+	co.status.Workers[worker] = ws
+	co.cond.Broadcast()
+	return u, true
+}
+
+func (co *Coordinator) renew(id, worker string) bool {
+	co.mu.Lock()
+	defer co.mu.Unlock()
+	co.seen(worker)
+	u := co.units[id]
+	if u == nil || u.worker != worker {
+		return false
+	}
+	u.expires = time.Now().Add(co.opts.Lease)
+	return true
+}
+
+// record results of unit. unit may have been re-assigned already,
+// the first completion counts and the unit isn't handed out again
+func (co *Coordinator) complete(id, worker string, processed int64, failed []string) bool {
+	co.mu.Lock()
+	defer co.mu.Unlock()
+	co.seen(worker)
+	u := co.units[id]
+	if u == nil {
+		return false
+	}
+	delete(co.units, id)
+	if u.worker == "" {
+		for i, p := range co.pending {
+			if p == u {
+				co.pending = append(co.pending[:i], co.pending[i+1:]...)
+				break
+			}
+		}
+	}
+	co.status.Processed += processed
+	co.status.Failed += int64(len(failed))
+	ws := co.status.Workers[worker]
+	ws.Processed += processed
+	ws.Failed += int64(len(failed))
+	co.status.Workers[worker] = ws
+	co.status.UnitsDone++
+	if co.failures != nil {
+		for _, key := range failed {
+			co.failures.record(key, errOther, fmt.Errorf("failed on worker '%s'", worker))
+		}
+	}
+	co.checkDoneLocked()
+	return true
+}
+
+// put units with expired leases back in front of pending ones
+func (co *Coordinator) reassignExpired() {
+	co.mu.Lock()
+	defer co.mu.Unlock()
+	now := time.Now()
+	for _, u := range co.units {
+		if u.worker == "" || now.Before(u.expires) {
+			continue
+		}
+		logWarn("lease of unit %s (%d objects) held by worker '%s' expired, re-assigning it", u.id, len(u.objects), u.worker)
+		u.worker = ""
+		co.pending = append([]*workUnit{u}, co.pending...)
+		co.status.Reassigned++
+	}
+}
+
+func (co *Coordinator) seen(worker string) {
+	ws := co.status.Workers[worker]
+	ws.LastSeen = time.Now().UTC()
+	co.status.Workers[worker] = ws
+}
+
+// all units are done once listing completed
+func (co *Coordinator) checkDoneLocked() {
+	if co.status.Listed && len(co.units) == 0 {
+		co.finishLocked()
+	}
+}
+
+func (co *Coordinator) finish() {
+	co.mu.Lock()
+	defer co.mu.Unlock()
+	co.finishLocked()
+}
+
+func (co *Coordinator) finishLocked() {
+	select {
+	case <-co.doneCh:
+	default:
+		close(co.doneCh)
+	}
+}
+
+// current progress of distributed copy
+func (co *Coordinator) Status() CoordinatorStatus {
+	co.mu.Lock()
+	defer co.mu.Unlock()
+	return co.snapshot()
+}
+
+func (co *Coordinator) snapshot() CoordinatorStatus {
+	s := co.status
+	s.UnitsPending = len(co.pending)
+	s.UnitsLeased = len(co.units) - len(co.pending)
+	s.Workers = make(map[string]WorkerState, len(co.status.Workers))
+	for name, ws := range co.status.Workers {
+		s.Workers[name] = ws
+	}
+	return s
+}
+
+// lease of unit returned to worker
+type unitLease struct {
+	ID      string   `json:"id"`
+	Objects []Object `json:"objects"`
+	// worker must renew the lease within this time
+	LeaseSec float64 `json:"lease_sec"`
+}
+
+// result of unit reported by worker
+type unitResult struct {
+	Worker    string   `json:"worker"`
+	Processed int64    `json:"processed"`
+	Failed    []string `json:"failed"`
+}
+
+// http API of coordinator used by workers:
+//
+//	POST /lease                {"worker": name}, returns unitLease, 204 if no unit is
+//	                           available yet, 410 once copy is complete
+//	POST /leases/<id>/renew    {"worker": name}, 404 if lease expired and unit was re-assigned
+//	POST /leases/<id>/complete unitResult
+//	GET  /status               CoordinatorStatus
+//
+// requests must carry "Authorization: Bearer <token>" if token isn't empty
+func (co *Coordinator) Handler(token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, co.Status())
+	})
+	mux.HandleFunc("/lease", co.handleLease)
+	mux.HandleFunc("/leases/", co.handleLeaseOp)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !validToken(r, token) {
+			writeJSONError(w, http.StatusUnauthorized, "invalid or missing token")
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func decodeWorkerRequest(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	if r.Method != "POST" {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return errors.New("method not allowed")
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<20)).Decode(v); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid request: "+err.Error())
+		return err
+	}
+	return nil
+}
+
+func (co *Coordinator) handleLease(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Worker string `json:"worker"`
+	}
+	if decodeWorkerRequest(w, r, &req) != nil {
+		return
+	}
+	u, ok := co.lease(req.Worker)
+	switch {
+	case !ok:
+		writeJSONError(w, http.StatusGone, "copy is complete")
+	case u == nil:
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		logDebug("leased unit %s (%d objects, shard %s) to worker '%s', attempt %d", u.id, len(u.objects), u.shard, req.Worker, u.attempts)
+		writeJSON(w, http.StatusOK, unitLease{ID: u.id, Objects: u.objects, LeaseSec: co.opts.Lease.Seconds()})
+	}
+}
+
+func (co *Coordinator) handleLeaseOp(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/leases/"), "/")
+	if len(parts) != 2 {
+		writeJSONError(w, http.StatusNotFound, "not found")
+		return
+	}
+	id := parts[0]
+	var res unitResult
+	if decodeWorkerRequest(w, r, &res) != nil {
+		return
+	}
+	ok := false
+	switch parts[1] {
+	case "renew":
+		ok = co.renew(id, res.Worker)
+	case "complete":
+		ok = co.complete(id, res.Worker, res.Processed, res.Failed)
+	default:
+		writeJSONError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, "lease expired, unit was re-assigned")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,477 @@
+package s3copy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// copies directory between source and destination endpoints, created with NewCopier.
+// holds shared dependencies of copy workers
+type Copier struct {
+	cfg      *Config
+	src, dst ObjectStore
+	bucket   string
+	wl       *workerLimiter
+	at       *autoTuner
+	oc       *objCounter
+	inflight *inflightObjects
+	// results by error class and prefix for the final report
+	breakdown *breakdown
+	// histograms exposed on /metrics
+	metrics *copyMetrics
+	// most recent failures shown on status endpoint
+	recentErrors *recentErrors
+	// sampled latencies of source and destination requests
+	latency *opLatencies
+	// counters sampled over time for dashboard graphs
+	history *throughputHistory
+	state   *copyState
+	// objects in sync with destination in watch mode without state database
+	synced   *syncedObjects
+	failures *failureLog
+	manifest *manifest
+	// skipped objects with reasons, nil if skip_report isn't set
+	skips *skipReport
+	// listing checkpoint advanced as listed objects are finished, nil without list_checkpoint
+	checkpoint *listCheckpoint
+	// outcomes of objects returned by Run
+	results *resultCollector
+	// limit of data rate read from source, nil if unlimited
+	bandwidth *bandwidthLimiter
+	// content is passed through transforms before upload
+	transforms []Transform
+	// changes of source replicated after copy, nil if not enabled
+	feed changeFeed
+	// work queue of consume mode, results are published to it
+	queue queueFeed
+
+	// multipart upload settings of resumable uploads
+	multipartThreshold int64
+	partSize           int64
+	// maps source key to destination key, nil - keys are the same
+	normalizeKey func(key string) string
+	// names keys below directory in untrusted destination, nil - keys aren't obfuscated
+	obfuscator *keyObfuscator
+	// space left in destination checked before the copy, 0 - reported by destination
+	capacityLimit int64
+	// incomplete uploads older than it are aborted after the run, 0 - they're kept
+	cleanupAge time.Duration
+	// destination with conditional writes, nil - objects are written unconditionally
+	conditional conditionalStore
+	// destination recording checksums of written objects, nil - they aren't recorded
+	checksum checksumStore
+	// generation written in backup mode, nil - objects are copied to the directory
+	backup *backupRun
+	// objects removed from destination are moved to it, nil - they're deleted
+	trash *aside
+	// mismatched objects are copied to it before they're re-copied, nil - they're overwritten
+	quarantine *aside
+	// source objects are tagged for expiry once their copy is verified, nil - they're kept
+	expiry *sourceExpiry
+	// copies of objects sharing key prefix are limited, nil - only by concurrency
+	prefixes *prefixLimiter
+	// objects modified before it aren't processed at all with since last run, zero - all are
+	since time.Time
+	// objects passed over as not modified since last run
+	unmodified int64
+
+	// heal mode re-copies existing destination objects with content different from source
+	heal bool
+
+	// transient errors are retried up to retries times with exponential backoff
+	retries    int
+	retryDelay time.Duration
+
+	// ctx is cancelled to interrupt in-flight copies
+	ctx context.Context
+
+	lockLease time.Duration
+	stats     *statsDumper
+
+	// abort copying once number of failed objects reaches maxErrors (0 - unlimited)
+	maxErrors int64
+
+	// closed when dispatching of new objects must stop, either by abort or interrupt
+	stopCh   chan struct{}
+	stopOnce sync.Once
+	stopCode int
+}
+
+// stop dispatching new objects with given exit code, in-flight copies are allowed to finish
+func (cp *Copier) stop(code int) {
+	cp.stopOnce.Do(func() {
+		cp.stopCode = code
+		close(cp.stopCh)
+		// unblock dispatching if copy is paused
+		cp.wl.resume()
+	})
+}
+
+// exit code copy was stopped with, ExitOK if copy is still running
+func (cp *Copier) stopped() int {
+	select {
+	case <-cp.stopCh:
+		return cp.stopCode
+	default:
+		return ExitOK
+	}
+}
+
+// dispatch objects from objCh to copy workers until channel is closed or copy is stopped,
+// returns false if listing failed
+func (cp *Copier) dispatch(objCh <-chan listEntry, overwriteOlder bool) bool {
+	for {
+		var obj listEntry
+		var ok bool
+		select {
+		case <-cp.stopCh:
+			return true
+		case obj, ok = <-objCh:
+		}
+		if !ok {
+			return true
+		}
+		if obj.Err != nil {
+			logError("listing objects: %s", obj.Err)
+			cp.cfg.Run.Callbacks.listError(obj.Err)
+			return false
+		}
+		if !cp.inShard(obj.Key) {
+			cp.checkpoint.done(obj.Key)
+			continue
+		}
+		if !cp.modifiedSince(obj.Object) {
+			atomic.AddInt64(&cp.unmodified, 1)
+			cp.checkpoint.done(obj.Key)
+			continue
+		}
+		if cp.prefixes != nil {
+			prefix := cp.prefixes.prefix(cp.cfg.listPrefix(), obj.Key)
+			if !cp.prefixes.acquire(prefix, obj.Object) {
+				continue
+			}
+			cp.wl.acquire()
+			if cp.stopped() != ExitOK {
+				cp.wl.release()
+				cp.prefixes.drop(prefix)
+				return true
+			}
+			go cp.copyInPrefix(obj.Object, prefix, overwriteOlder)
+			continue
+		}
+		cp.wl.acquire()
+		if cp.stopped() != ExitOK {
+			cp.wl.release()
+			return true
+		}
+		go cp.copyObj(obj.Object, overwriteOlder)
+	}
+}
+
+// wait untill all workers completed
+func (cp *Copier) wait() {
+	for cp.wl.running() > 0 || cp.prefixes.busy() {
+		time.Sleep(time.Second * 1)
+	}
+}
+
+// copy object from source to destination, skip if object already exists in destination.
+// with overwriteOlder existing object is re-copied if it's older than source object,
+// skip_policy overrides both it and heal. returns result of the object
+func (cp *Copier) copyObj(obj Object, overwriteOlder bool) string {
+	defer cp.wl.release()
+	defer cp.checkpoint.done(obj.Key)
+	start := time.Now()
+	bucket, objPath := cp.bucket, obj.Key
+	cp.inflight.add(objPath, start)
+	defer cp.inflight.remove(objPath)
+	sp := startSpan("copy object", nil, spanKindInternal, strAttr("aws.s3.bucket", bucket), strAttr("aws.s3.key", objPath))
+
+	if !cp.accepted(obj) {
+		return cp.report(objectEvent{Key: objPath, Result: ResultSkipped, Skip: SkipFiltered, Reason: "excluded by filter"}, start, sp)
+	}
+	if ok, err := cp.metadataAccepted(obj, sp); err != nil {
+		class := classifyError(err)
+		if class == errNotFound {
+			return cp.report(objectEvent{Key: objPath, Result: ResultSkipped, Skip: SkipRemoved, Reason: "removed from source after listing", Error: err.Error()}, start, sp)
+		}
+		if cp.failures != nil {
+			cp.failures.record(objPath, class, err)
+		}
+		return cp.report(objectEvent{Key: objPath, Result: ResultFailed, Reason: "reading metadata of source object",
+			Error: err.Error(), ErrorClass: class.String(), err: err}, start, sp)
+	} else if !ok {
+		return cp.report(objectEvent{Key: objPath, Result: ResultSkipped, Skip: SkipFiltered, Reason: "excluded by metadata filter"}, start, sp)
+	}
+	cp.cfg.Run.Callbacks.objectStart(obj)
+	policy, checkOlder, compare := cp.existingChecks(overwriteOlder)
+
+	// skip objects recorded in state database without any requests, heal mode and
+	// policies re-copying existing objects check all objects in destination regardless of the state
+	if cp.state != nil && !compare && policy != SkipPolicyNever && cp.state.isCopied(objPath, obj.ETag) {
+		cp.expireSource(obj, Object{})
+		return cp.report(objectEvent{Key: objPath, Result: ResultSkipped, Skip: SkipState, Reason: "already copied according to state file"}, start, sp)
+	}
+
+	// check and skip if object already exists in dest, in heal mode existing object
+	// is skipped only if its content matches source, skip policy decides it otherwise
+	dstObjStat, err := cp.statDest(objPath, sp)
+	if err != nil {
+		class := classifyError(err)
+		if cp.failures != nil {
+			cp.failures.record(objPath, class, err)
+		}
+		return cp.report(objectEvent{Key: objPath, Result: ResultFailed, Reason: "checking destination object",
+			Error: err.Error(), ErrorClass: class.String(), err: err}, start, sp)
+	}
+	if dstObjStat.Key == "" && policy == SkipPolicyAlways {
+		return cp.report(objectEvent{Key: objPath, Result: ResultSkipped, Skip: SkipPolicy, Reason: "missing in destination, skip policy is always"}, start, sp)
+	}
+	recopy, skip, reason := "", SkipExists, "already exists in destination"
+	switch {
+	case dstObjStat.Key == "":
+	case policy == SkipPolicyNever:
+		recopy = "skip policy is never"
+	case policy == SkipPolicyIfSameSize && dstObjStat.Size != obj.Size:
+		recopy = "size differs from source"
+	case policy == SkipPolicyIfSameSize:
+		skip, reason = SkipSameSize, reason+", size matches source"
+	case checkOlder && dstObjStat.LastModified.Before(obj.LastModified):
+		recopy = "source was modified"
+	case checkOlder:
+		skip, reason = SkipNotNewer, reason+", source isn't newer"
+	}
+	if dstObjStat.Key != "" && recopy == "" && compare {
+		same, err := cp.sameContent(obj, dstObjStat)
+		if err != nil {
+			logError("comparing '%s/%s': %s", bucket, objPath, err)
+		}
+		if err == nil && same {
+			skip, reason = SkipSameContent, "already exists in destination, content matches source"
+		}
+		if err == nil && !same {
+			recopy = "destination content didn't match source"
+			if cp.quarantine != nil {
+				if err := cp.quarantineObj(cp.destKey(objPath)); err != nil {
+					class := classifyError(err)
+					if cp.failures != nil {
+						cp.failures.record(objPath, class, err)
+					}
+					return cp.report(objectEvent{Key: objPath, Result: ResultFailed, Reason: recopy,
+						Error: err.Error(), ErrorClass: class.String(), err: err}, start, sp)
+				}
+				recopy += ", it's quarantined"
+			}
+		}
+	}
+	if dstObjStat.Key != "" && recopy == "" {
+		cp.markSynced(objPath, obj.ETag)
+		cp.expireSource(obj, dstObjStat)
+		return cp.report(objectEvent{Key: objPath, Result: ResultSkipped, Skip: skip, Reason: reason}, start, sp)
+	}
+
+	if dstObjStat.Key == "" && cp.backup != nil && cp.reuseGeneration(obj) {
+		return cp.report(objectEvent{Key: objPath, Result: ResultCopied,
+			Reason: "unchanged since generation '" + cp.backup.previous + "', copied server-side"}, start, sp)
+	}
+
+	// copy, conditional write fails if destination object changed since the check
+	size, etag, err := cp.transfer(obj, dstObjStat.ETag, sp)
+	if cp.at != nil {
+		cp.at.record(size, time.Since(start), err)
+	}
+	if err == nil {
+		cp.markSynced(objPath, obj.ETag)
+		cp.expireSource(obj, Object{})
+	}
+	if cp.manifest != nil && err == nil {
+		cp.manifest.record(manifestEntry{SourceKey: objPath, DestinationKey: cp.destKey(objPath), Size: size, ETag: etag, CopiedAt: time.Now().UTC()})
+	}
+	class := classifyError(err)
+	if cp.failures != nil && err != nil && class != errConflict {
+		cp.failures.record(objPath, class, err)
+	}
+
+	ev := objectEvent{Key: objPath, Bytes: size, Result: ResultCopied, Reason: recopy}
+	switch {
+	case err == nil && recopy != "":
+		ev.Result = ResultRecopied
+	case err == nil:
+	case class == errNotFound:
+		ev.Result, ev.Skip, ev.Reason, ev.Error = ResultSkipped, SkipRemoved, "removed from source after listing", err.Error()
+	case class == errConflict:
+		ev.Result, ev.Skip, ev.Reason, ev.Error = ResultSkipped, SkipConflict, "written to destination concurrently, it's kept", err.Error()
+	case class == errArchived:
+		ev.Skip, ev.Reason = SkipArchived, "archived in source, it must be restored before copy"
+		ev.Result, ev.Error, ev.ErrorClass, ev.err = ResultFailed, err.Error(), class.String(), err
+	case class == errTooLarge:
+		ev.Skip, ev.Reason = SkipTooLarge, "too large for destination"
+		ev.Result, ev.Error, ev.ErrorClass, ev.err = ResultFailed, err.Error(), class.String(), err
+	default:
+		ev.Result, ev.Error, ev.ErrorClass, ev.err = ResultFailed, err.Error(), class.String(), err
+	}
+	if err == nil && cp.cfg.Options.PostCopyHook != "" {
+		cp.postCopy(obj, ev.Result, size)
+	}
+	return cp.report(ev, start, sp)
+}
+
+// update counters with result of object copy, log it and abort copy if errors limit is reached.
+// sp is the span of object copy, ended with the result. returns the result
+func (cp *Copier) report(ev objectEvent, start time.Time, sp *span) string {
+	ev.Bucket = cp.bucket
+	ev.Duration = time.Since(start).Seconds()
+	sp.setAttr(strAttr("s3_copy_dir.result", ev.Result))
+	sp.setAttr(intAttr("s3_copy_dir.size", ev.Bytes))
+	if ev.Result == ResultFailed {
+		sp.end(errors.New(ev.Error))
+	} else {
+		sp.end(nil)
+	}
+	cp.breakdown.record(ev)
+	cp.metrics.record(ev)
+	// failed objects which weren't copied for a known reason are recorded as well
+	if cp.skips != nil && (ev.Result == ResultSkipped || ev.Skip != "") {
+		cp.skips.record(ev)
+	}
+	r := ev.objectResult()
+	cp.results.record(r)
+	cp.results.write(ev)
+	cp.cfg.Run.Callbacks.objectDone(r)
+	cp.publishResult(ev)
+	if ev.Result == ResultFailed {
+		cp.recentErrors.record(ev)
+	}
+
+	current, failed := cp.oc.record(ev)
+	logObject(ev, current, cp.oc.total())
+
+	if ev.Result != ResultFailed {
+		return ev.Result
+	}
+	if ev.ErrorClass == errAuth.String() {
+		logError("aborting copy, credentials or permissions are invalid")
+		cp.stop(ExitAborted)
+	}
+	if cp.maxErrors > 0 && failed >= cp.maxErrors {
+		logError("aborting copy, reached max errors limit of %d", cp.maxErrors)
+		cp.stop(ExitAborted)
+	}
+	return ev.Result
+}
+
+// copy object, transient errors are retried with exponential backoff. dstETag is the etag
+// of destination object seen by the skip-check, empty if it's missing.
+// returns size and ETag of copied source object
+func (cp *Copier) transfer(obj Object, dstETag string, sp *span) (int64, string, error) {
+	delay := cp.retryDelay
+	for attempt := 0; ; attempt++ {
+		size, etag, err := cp.transferOnce(obj, dstETag, sp)
+		if err == nil || attempt >= cp.retries || !classifyError(err).retryable() {
+			return size, etag, err
+		}
+
+		logWarn("retrying '%s/%s' in %s, attempt %d of %d: %s", cp.bucket, obj.Key, delay, attempt+1, cp.retries, err)
+		audit(auditEntry{Op: auditRetry, Bucket: cp.bucket, Key: obj.Key, Attempt: attempt + 1}, err)
+		select {
+		case <-time.After(delay):
+		case <-cp.ctx.Done():
+			return 0, "", err
+		}
+		delay *= 2
+	}
+}
+
+// stat destination object of key, transient errors are retried like transfers. missing
+// object is returned empty without error
+func (cp *Copier) statDest(key string, sp *span) (Object, error) {
+	delay := cp.retryDelay
+	for attempt := 0; ; attempt++ {
+		statSp := startRequestSpan("STAT", sp, true, cp.bucket, key)
+		statStart := time.Now()
+		countRequest(true, reqHead)
+		obj, err := cp.dst.Stat(cp.ctx, cp.destKey(key))
+		cp.latency.record("STAT", time.Since(statStart))
+		class := classifyError(err)
+		if class == errNotFound {
+			obj, err = Object{}, nil
+		}
+		statSp.end(err)
+		if err == nil || attempt >= cp.retries || !class.retryable() {
+			if err != nil {
+				return Object{}, &opError{"stat", err}
+			}
+			return obj, nil
+		}
+
+		logWarn("retrying stat of '%s/%s' in %s, attempt %d of %d: %s", cp.bucket, key, delay, attempt+1, cp.retries, err)
+		audit(auditEntry{Op: auditRetry, Bucket: cp.bucket, Key: key, Attempt: attempt + 1}, err)
+		select {
+		case <-time.After(delay):
+		case <-cp.ctx.Done():
+			return Object{}, &opError{"stat", err}
+		}
+		delay *= 2
+	}
+}
+
+// get object from source and put it to destination,
+// large objects are uploaded in parts which survive restart
+func (cp *Copier) transferOnce(obj Object, dstETag string, sp *span) (int64, string, error) {
+	if cp.resumable(obj) {
+		size, err := cp.resumableUpload(obj, dstETag, sp)
+		return size, obj.ETag, err
+	}
+
+	// GET span ends once response headers are received, body is streamed during PUT
+	getSp := startRequestSpan("GET", sp, false, cp.bucket, obj.Key)
+	getStart := time.Now()
+	countRequest(false, reqGet)
+	srcObj, srcStat, err := cp.src.Get(cp.ctx, obj.Key, 0, -1)
+	cp.latency.record("GET", time.Since(getStart))
+	getSp.setAttr(intAttr("s3_copy_dir.size", srcStat.Size))
+	getSp.end(err)
+	if err != nil {
+		return 0, "", &opError{"get", err}
+	}
+	defer srcObj.Close()
+
+	body, bodySize := cp.bandwidth.reader(cp.ctx, srcObj), srcStat.Size
+	contentType := srcStat.ContentType
+	if len(cp.transforms) > 0 {
+		tf, transformed, err := cp.transform(srcStat, body)
+		if err != nil {
+			return 0, "", &opError{"transform", err}
+		}
+		defer tf.Close()
+		body, bodySize, contentType = tf, transformed.Size, transformed.ContentType
+	}
+
+	sum := cp.uploadHash(nil, false)
+	if sum != nil {
+		body = io.TeeReader(body, sum)
+	}
+
+	putSp := startRequestSpan("PUT", sp, true, cp.bucket, obj.Key)
+	putStart := time.Now()
+	countRequest(true, reqPut)
+	var size int64
+	if cp.conditional != nil {
+		size, err = cp.conditional.putIf(cp.ctx, cp.destKey(obj.Key), body, bodySize, contentType, dstETag)
+	} else {
+		size, err = cp.dst.Put(cp.ctx, cp.destKey(obj.Key), body, bodySize, contentType)
+	}
+	cp.latency.record("PUT", time.Since(putStart))
+	audit(auditEntry{Op: auditPut, Bucket: cp.bucket, Key: cp.destKey(obj.Key), Size: size}, err)
+	putSp.setAttr(intAttr("s3_copy_dir.size", size))
+	putSp.end(err)
+	if err != nil {
+		return size, "", &opError{"put", err}
+	}
+	cp.recordChecksum(cp.destKey(obj.Key), sum, contentType)
+	return size, srcStat.ETag, nil
+}
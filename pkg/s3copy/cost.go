@@ -0,0 +1,142 @@
+package s3copy
+
+import (
+	"fmt"
+	"math"
+	"sync"
+)
+
+// request types, priced in two tiers like AWS S3
+const (
+	reqList     = "LIST"
+	reqGet      = "GET"
+	reqHead     = "HEAD"
+	reqPut      = "PUT"
+	reqPutPart  = "PUT_PART"
+	reqCreateMP = "CREATE_MULTIPART"
+	reqComplete = "COMPLETE_MULTIPART"
+	reqAbort    = "ABORT_MULTIPART"
+	reqCopy     = "COPY"
+)
+
+// prices of requests and data transfer of a single endpoint, in USD
+type Prices struct {
+	// PUT, COPY, POST, LIST requests
+	Tier1Per1000 float64 `json:"put_list_per_1000"`
+	// GET, HEAD requests
+	Tier2Per1000 float64 `json:"get_head_per_1000"`
+	// data transferred out of the endpoint
+	EgressPerGiB float64 `json:"egress_per_gib"`
+}
+
+// AWS S3 standard storage class, us-east-1, egress to internet
+var defaultPrices = Prices{Tier1Per1000: 0.005, Tier2Per1000: 0.0004, EgressPerGiB: 0.09}
+
+// price table for source and destination endpoints, defaults to AWS prices when not configured
+type PriceTable struct {
+	Source      *Prices `json:"source,omitempty"`
+	Destination *Prices `json:"destination,omitempty"`
+}
+
+func (pt *PriceTable) get() (src, dst Prices) {
+	src, dst = defaultPrices, defaultPrices
+	if pt != nil && pt.Source != nil {
+		src = *pt.Source
+	}
+	if pt != nil && pt.Destination != nil {
+		dst = *pt.Destination
+	}
+	return src, dst
+}
+
+// number of requests sent to source and destination by type
+type requestCounts struct {
+	sync.Mutex
+	source      map[string]int64
+	destination map[string]int64
+}
+
+// global request counters of the run
+var requests = &requestCounts{source: map[string]int64{}, destination: map[string]int64{}}
+
+func countRequest(dst bool, req string) {
+	requests.Lock()
+	defer requests.Unlock()
+	if dst {
+		requests.destination[req]++
+	} else {
+		requests.source[req]++
+	}
+}
+
+func (rc *requestCounts) snapshot() (src, dst map[string]int64) {
+	rc.Lock()
+	defer rc.Unlock()
+	src, dst = map[string]int64{}, map[string]int64{}
+	for k, v := range rc.source {
+		src[k] = v
+	}
+	for k, v := range rc.destination {
+		dst[k] = v
+	}
+	return src, dst
+}
+
+// estimated cost of requests and egress of a single endpoint
+type EndpointCost struct {
+	Requests    map[string]int64 `json:"requests"`
+	EgressBytes int64            `json:"egress_bytes"`
+	USD         float64          `json:"usd"`
+}
+
+type CostEstimate struct {
+	Source      EndpointCost `json:"source"`
+	Destination EndpointCost `json:"destination"`
+	TotalUSD    float64      `json:"total_usd"`
+}
+
+func (p Prices) cost(reqs map[string]int64, egress int64) float64 {
+	usd := float64(egress) / (1 << 30) * p.EgressPerGiB
+	for req, n := range reqs {
+		if req == reqGet || req == reqHead {
+			usd += float64(n) / 1000 * p.Tier2Per1000
+		} else {
+			usd += float64(n) / 1000 * p.Tier1Per1000
+		}
+	}
+	return usd
+}
+
+// price requests and bytes read from source, data is transferred out of source only
+func estimateCost(pt *PriceTable, src, dst map[string]int64, egress int64) *CostEstimate {
+	srcPrices, dstPrices := pt.get()
+	ce := &CostEstimate{
+		Source:      EndpointCost{Requests: src, EgressBytes: egress, USD: srcPrices.cost(src, egress)},
+		Destination: EndpointCost{Requests: dst, USD: dstPrices.cost(dst, 0)},
+	}
+	ce.TotalUSD = ce.Source.USD + ce.Destination.USD
+	return ce
+}
+
+// predict requests of copying count objects of total size into empty destination
+func predictRequests(count, size int64, pageSize int, multipartThreshold, partSize int64) (src, dst map[string]int64) {
+	if pageSize <= 0 || pageSize > maxListPageSize {
+		pageSize = maxListPageSize
+	}
+	src = map[string]int64{reqList: int64(math.Ceil(float64(count) / float64(pageSize))), reqGet: count}
+	dst = map[string]int64{reqHead: count, reqPut: count}
+	// with unknown size distribution assume objects of average size
+	if multipartThreshold > 0 && count > 0 && size/count >= multipartThreshold {
+		avg := size / count
+		ps := partSizeFor(avg, partSize)
+		parts := (avg + ps - 1) / ps
+		src[reqGet] = count * parts
+		dst = map[string]int64{reqHead: count, reqCreateMP: count, reqPutPart: count * parts, reqComplete: count}
+	}
+	return src, dst
+}
+
+func (ce *CostEstimate) summary() string {
+	return fmt.Sprintf("estimated cost $%.2f: source $%.2f (%s egress), destination $%.2f",
+		ce.TotalUSD, ce.Source.USD, FormatBytes(ce.Source.EgressBytes), ce.Destination.USD)
+}
@@ -0,0 +1,171 @@
+package s3copy
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// parsed cron expression: bitsets of matching minutes, hours, days of month,
+// months and days of week, or fixed interval of @every
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	// day matches if both dom and dow match when one of them is *, otherwise if either matches
+	domStar, dowStar bool
+	every            time.Duration
+	loc              *time.Location
+}
+
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var (
+	cronMonths = map[string]int{"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12}
+	cronDays = map[string]int{"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6}
+)
+
+// parse standard 5-field cron expression (minute hour day-of-month month day-of-week)
+// with lists, ranges, steps and names, @daily-like macros or "@every <duration>".
+// schedule is evaluated in loc
+func parseCron(expr string, loc *time.Location) (*cronSchedule, error) {
+	expr = strings.TrimSpace(expr)
+	s := &cronSchedule{loc: loc}
+	if strings.HasPrefix(expr, "@every ") {
+		d, err := time.ParseDuration(strings.TrimSpace(strings.TrimPrefix(expr, "@every ")))
+		if err != nil {
+			return nil, fmt.Errorf("invalid cron expression '%s': %s", expr, err)
+		}
+		if d < time.Minute {
+			return nil, fmt.Errorf("invalid cron expression '%s': interval must be at least 1m", expr)
+		}
+		s.every = d
+		return s, nil
+	}
+	if m, ok := cronMacros[expr]; ok {
+		expr = m
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid cron expression '%s': expected 5 fields", expr)
+	}
+	// day matching differs when one of day fields is unrestricted
+	s.domStar, s.dowStar = strings.HasPrefix(fields[2], "*"), strings.HasPrefix(fields[4], "*")
+	var err error
+	for i, f := range []struct {
+		set      *uint64
+		min, max int
+		names    map[string]int
+	}{
+		{&s.minute, 0, 59, nil},
+		{&s.hour, 0, 23, nil},
+		{&s.dom, 1, 31, nil},
+		{&s.month, 1, 12, cronMonths},
+		{&s.dow, 0, 7, cronDays},
+	} {
+		if *f.set, err = parseCronField(fields[i], f.min, f.max, f.names); err != nil {
+			return nil, fmt.Errorf("invalid cron expression '%s': %s", expr, err)
+		}
+	}
+	// 7 is sunday too
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	return s, nil
+}
+
+func parseCronField(field string, min, max int, names map[string]int) (uint64, error) {
+	value := func(s string) (int, error) {
+		if n, ok := names[strings.ToLower(s)]; ok {
+			return n, nil
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil || n < min || n > max {
+			return 0, fmt.Errorf("'%s' isn't a number in range %d-%d", s, min, max)
+		}
+		return n, nil
+	}
+	var set uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			rng = part[:i]
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step in '%s'", part)
+			}
+		}
+		lo, hi := min, max
+		switch {
+		case rng == "*":
+		case strings.Contains(rng, "-"):
+			i := strings.Index(rng, "-")
+			var err error
+			if lo, err = value(rng[:i]); err != nil {
+				return 0, err
+			}
+			if hi, err = value(rng[i+1:]); err != nil {
+				return 0, err
+			}
+			if lo > hi {
+				return 0, fmt.Errorf("invalid range '%s'", rng)
+			}
+		default:
+			n, err := value(rng)
+			if err != nil {
+				return 0, err
+			}
+			// "n/step" runs from n to the end of range
+			lo, hi = n, n
+			if strings.Contains(part, "/") {
+				hi = max
+			}
+		}
+		for n := lo; n <= hi; n += step {
+			set |= 1 << uint(n)
+		}
+	}
+	return set, nil
+}
+
+func (s *cronSchedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domStar || s.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// first time after t matching the schedule, zero time if there's none within 5 years
+// (e.g. for "0 0 30 2 *")
+func (s *cronSchedule) next(t time.Time) time.Time {
+	if s.every > 0 {
+		return t.Add(s.every)
+	}
+	t = t.In(s.loc).Truncate(time.Minute).Add(time.Minute)
+	end := t.AddDate(5, 0, 0)
+	for t.Before(end) {
+		switch {
+		case s.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, s.loc)
+		case !s.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, s.loc)
+		case s.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, s.loc)
+		case s.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
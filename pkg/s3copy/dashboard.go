@@ -0,0 +1,251 @@
+package s3copy
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// throughput history starts with samples every historyInterval, once it reaches
+// historySize samples every other one is dropped and interval is doubled,
+// so history of week-long copy still fits
+const (
+	historyInterval = time.Second * 5
+	historySize     = 720
+)
+
+type throughputSample struct {
+	Time      time.Time `json:"time"`
+	Processed int64     `json:"processed"`
+	Bytes     int64     `json:"bytes"`
+	Failed    int64     `json:"failed"`
+}
+
+// counters of copy sampled over time, drawn as throughput graphs by dashboard
+type throughputHistory struct {
+	sync.Mutex
+	interval time.Duration
+	samples  []throughputSample
+}
+
+func newThroughputHistory() *throughputHistory {
+	return &throughputHistory{interval: historyInterval}
+}
+
+func (h *throughputHistory) sample(oc *objCounter) {
+	counts := oc.snapshot()
+	s := throughputSample{Time: time.Now().UTC(), Processed: counts.Current, Bytes: counts.Bytes, Failed: counts.Failed}
+
+	h.Lock()
+	defer h.Unlock()
+	if len(h.samples) >= historySize {
+		n := 0
+		for i := 0; i < len(h.samples); i += 2 {
+			h.samples[n] = h.samples[i]
+			n++
+		}
+		h.samples = h.samples[:n]
+		h.interval *= 2
+	}
+	h.samples = append(h.samples, s)
+}
+
+// sample counters until stopCh is closed
+func (h *throughputHistory) run(oc *objCounter, stopCh <-chan struct{}) {
+	h.sample(oc)
+	for {
+		h.Lock()
+		interval := h.interval
+		h.Unlock()
+		select {
+		case <-stopCh:
+			return
+		case <-time.After(interval):
+		}
+		h.sample(oc)
+	}
+}
+
+func (h *throughputHistory) list() []throughputSample {
+	h.Lock()
+	defer h.Unlock()
+	return append([]throughputSample(nil), h.samples...)
+}
+
+// dashboard page polling status of the copy ("status" mode, served with /status)
+// or jobs of job manager ("jobs" mode, served with REST API)
+func dashboardHandler(mode string) http.Handler {
+	page := strings.Replace(dashboardHTML, "{{MODE}}", mode, 1)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(page))
+	})
+}
+
+const dashboardHTML = `<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>s3-copy-dir</title>
+<style>
+body{font:14px sans-serif;margin:20px;color:#222}
+h1{font-size:18px}h2{font-size:15px;margin-top:24px}
+table{border-collapse:collapse;width:100%}td,th{text-align:left;padding:3px 8px;border-bottom:1px solid #eee}
+tr.job{cursor:pointer}tr.job:hover,tr.sel{background:#eef}
+.bar{background:#eee;width:120px;height:10px;display:inline-block;vertical-align:middle}
+.bar span{background:#4a8;height:10px;display:block}
+.cards div{display:inline-block;margin:0 28px 10px 0;color:#666}.cards b{display:block;font-size:18px;color:#222}
+.failed,#msg{color:#c33}svg{border:1px solid #eee;background:#fcfcfc}
+</style></head><body>
+<h1>s3-copy-dir</h1><div id="msg"></div><div id="jobs"></div><div id="detail"></div>
+<script>
+var mode = "{{MODE}}", selected = location.hash.slice(1), token = localStorage.getItem("s3-copy-dir-token") || "";
+
+function get(url, cb) {
+  var x = new XMLHttpRequest();
+  x.open("GET", url);
+  if (token) x.setRequestHeader("Authorization", "Bearer " + token);
+  x.onload = function() {
+    if (x.status == 401) {
+      token = prompt("API token") || "";
+      localStorage.setItem("s3-copy-dir-token", token);
+      return;
+    }
+    var v = null;
+    try { v = JSON.parse(x.responseText); } catch (e) {}
+    if (x.status >= 300) { msg((v && v.error) || x.status + " " + x.statusText); return; }
+    msg("");
+    cb(v);
+  };
+  x.onerror = function() { msg("request to " + url + " failed"); };
+  x.send();
+}
+
+function msg(s) { document.getElementById("msg").textContent = s; }
+function esc(s) {
+  return String(s == null ? "" : s).replace(/[&<>"]/g, function(c) {
+    return {"&": "&amp;", "<": "&lt;", ">": "&gt;", '"': "&quot;"}[c];
+  });
+}
+function size(n) {
+  var u = ["B", "KiB", "MiB", "GiB", "TiB", "PiB"], i = 0;
+  for (; n >= 1024 && i < u.length - 1; i++) n /= 1024;
+  return (i ? n.toFixed(1) : Math.round(n)) + u[i];
+}
+function dur(s) {
+  s = Math.round(s);
+  var d = Math.floor(s / 86400), h = Math.floor(s % 86400 / 3600), m = Math.floor(s % 3600 / 60);
+  return (d ? d + "d " : "") + (d || h ? h + "h " : "") + (d || h || m ? m + "m " : "") + s % 60 + "s";
+}
+function bar(done, total) {
+  if (!(total > 0)) return "";
+  var p = Math.min(100, 100 * done / total);
+  return '<span class="bar"><span style="width:' + p.toFixed(1) + '%"></span></span> ' + p.toFixed(1) + "%";
+}
+function cards(items) {
+  return '<div class="cards">' + items.map(function(c) {
+    return "<div>" + esc(c[0]) + "<b>" + esc(c[1]) + "</b></div>";
+  }).join("") + "</div>";
+}
+function table(head, rows) {
+  if (!rows.length) return "<p>none</p>";
+  return "<table><tr>" + head.map(function(h) { return "<th>" + esc(h) + "</th>"; }).join("") + "</tr>" +
+    rows.map(function(r) { return "<tr>" + r.map(function(c) { return "<td>" + esc(c) + "</td>"; }).join("") + "</tr>"; }).join("") +
+    "</table>";
+}
+function classes(m) {
+  return Object.keys(m || {}).map(function(k) { return k + ": " + m[k]; }).join(", ");
+}
+function prefixes(m) {
+  return table(["prefix", "copied", "skipped", "failed", "bytes", "errors"], Object.keys(m || {}).sort().map(function(k) {
+    var p = m[k];
+    return [k, p.copied, p.skipped, p.failed, size(p.bytes), classes(p.errors_by_class)];
+  }));
+}
+
+// rate per second between consecutive samples of field
+function chart(title, hist, field, fmt) {
+  var pts = [], max = 0;
+  for (var i = 1; i < hist.length; i++) {
+    var dt = (new Date(hist[i].time) - new Date(hist[i - 1].time)) / 1000;
+    var v = dt > 0 ? (hist[i][field] - hist[i - 1][field]) / dt : 0;
+    pts.push(v);
+    max = Math.max(max, v);
+  }
+  var w = 600, h = 120, line = pts.map(function(v, i) {
+    return (pts.length > 1 ? i * w / (pts.length - 1) : 0).toFixed(1) + "," + (h - (max > 0 ? v * (h - 10) / max : 0)).toFixed(1);
+  }).join(" ");
+  return "<h2>" + esc(title) + " (max " + esc(fmt(max)) + "/s)</h2>" +
+    '<svg width="' + w + '" height="' + h + '"><polyline fill="none" stroke="#4a8" stroke-width="2" points="' + line + '"/></svg>';
+}
+
+function renderStatus(st) {
+  var total = st.total_bytes ? size(st.bytes) + " of " + size(st.total_bytes) : size(st.bytes);
+  var hist = st.history || [];
+  return cards([
+    ["processed", st.processed + (st.total ? " of " + st.total : "")], ["copied", st.copied], ["skipped", st.skipped],
+    ["failed", st.failed], ["bytes", total], ["rate", size(st.rate_bytes_per_sec) + "/s"],
+    ["objects/s", st.rate_objects_per_sec.toFixed(1)], ["elapsed", dur(st.elapsed_sec)],
+    ["ETA", st.eta_sec ? dur(st.eta_sec) : "-"],
+    ["workers", st.workers_active + " of " + st.workers_limit + (st.paused ? " (paused)" : "")]]) +
+    bar(st.total_bytes ? st.bytes : st.processed, st.total_bytes || st.total) +
+    chart("throughput", hist, "bytes", size) +
+    chart("objects", hist, "processed", function(v) { return v.toFixed(1); }) +
+    chart("failures", hist, "failed", function(v) { return v.toFixed(2); }) +
+    "<h2>prefixes</h2>" + prefixes(st.prefixes) +
+    "<h2>recent errors</h2>" + table(["time", "key", "class", "error"], (st.recent_errors || []).map(function(e) {
+      return [new Date(e.time).toLocaleString(), e.key, e.class, e.error];
+    })) +
+    "<h2>slowest in-flight objects</h2>" + table(["key", "elapsed"], (st.slowest_inflight || []).map(function(o) {
+      return [o.key, dur(o.elapsed_sec)];
+    }));
+}
+
+function renderReport(r) {
+  return cards([["result", r.result], ["processed", r.processed], ["copied", r.copied], ["skipped", r.skipped],
+    ["failed", r.failed], ["bytes", size(r.bytes)], ["rate", size(r.throughput_bytes_per_sec) + "/s"],
+    ["duration", dur(r.duration_sec)]]) +
+    "<h2>errors by class</h2>" + table(["class", "count"], Object.keys(r.errors_by_class || {}).map(function(k) {
+      return [k, r.errors_by_class[k]];
+    })) +
+    "<h2>prefixes</h2>" + prefixes(r.prefixes);
+}
+
+function renderJobs(jobs) {
+  var rows = jobs.slice().reverse().map(function(j) {
+    var o = j.spec.config.options, p = j.progress || (j.report ? {processed: j.report.processed, copied: j.report.copied,
+      failed: j.report.failed, bytes: j.report.bytes} : {});
+    return '<tr class="job' + (j.id == selected ? " sel" : "") + '" data-id="' + esc(j.id) + '">' +
+      "<td>" + esc(j.id) + '</td><td class="' + (j.status == "failed" || j.error ? "failed" : "") + '">' + esc(j.status) + "</td>" +
+      "<td>" + esc(o.bucket + "/" + o.directory) + "</td><td>" + esc((p.processed || 0) + (p.total > 0 ? " of " + p.total : "")) +
+      "</td><td>" + bar(p.total_bytes ? p.bytes : p.processed, p.total_bytes || p.total) +
+      "</td><td>" + esc(p.failed || 0) + "</td><td>" + esc(size(p.bytes || 0)) + "</td>" +
+      "<td>" + esc(new Date(j.created).toLocaleString()) + "</td></tr>";
+  });
+  document.getElementById("jobs").innerHTML = "<h2>jobs</h2>" + (rows.length ?
+    "<table><tr><th>id</th><th>status</th><th>directory</th><th>processed</th><th>progress</th><th>failed</th><th>bytes</th><th>created</th></tr>" +
+    rows.join("") + "</table>" : "<p>none</p>");
+  Array.prototype.forEach.call(document.querySelectorAll("tr.job"), function(tr) {
+    tr.onclick = function() { selected = location.hash = tr.getAttribute("data-id"); refresh(); };
+  });
+
+  var detail = document.getElementById("detail"), job = jobs.filter(function(j) { return j.id == selected; })[0];
+  if (!job) { detail.innerHTML = ""; return; }
+  var head = "<h2>job " + esc(job.id) + ": " + esc(job.status) + "</h2>" + (job.error ? '<p class="failed">' + esc(job.error) + "</p>" : "");
+  if (job.status == "running") {
+    get("jobs/" + encodeURIComponent(job.id) + "/status?history=1", function(st) { detail.innerHTML = head + renderStatus(st); });
+  } else {
+    detail.innerHTML = head + (job.report ? renderReport(job.report) : "");
+  }
+}
+
+function refresh() {
+  if (mode == "jobs") get("jobs", renderJobs);
+  else get("status?history=1", function(st) { document.getElementById("detail").innerHTML = renderStatus(st); });
+}
+refresh();
+setInterval(refresh, 3000);
+</script></body></html>
+`
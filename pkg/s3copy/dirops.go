@@ -0,0 +1,334 @@
+package s3copy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// endpoints operations are run against
+const (
+	TargetSource      = "source"
+	TargetDestination = "destination"
+)
+
+// store of source or destination
+func targetStore(c *Config, target string) (ObjectStore, error) {
+	switch target {
+	case TargetSource:
+		return sourceStore(c)
+	case TargetDestination:
+		return destinationStore(c)
+	}
+	return nil, fmt.Errorf("unknown target '%s', must be source or destination", target)
+}
+
+// object of listed directory
+type Object struct {
+	Key          string
+	Size         int64
+	ETag         string
+	LastModified time.Time
+	ContentType  string
+	// sha256 of content recorded in metadata with checksum_metadata, only set by Stat
+	Checksum string
+	// user metadata with lowercase names without prefix of store, e.g. x-amz-meta-, only set by Stat
+	Metadata map[string]string
+}
+
+// call fn for every object of the directory in target endpoint
+func List(c *Config, target string, fn func(Object)) error {
+	store, err := targetStore(c, target)
+	if err != nil {
+		return err
+	}
+	doneCh := make(chan struct{})
+	defer close(doneCh)
+	for obj := range listObjects(store, c.Options.Bucket, c.listPrefix(), c.Options.ListPageSize, nil, doneCh) {
+		if obj.Err != nil {
+			return fmt.Errorf("listing objects: %s", obj.Err)
+		}
+		fn(Object{Key: obj.Key, Size: obj.Size, ETag: obj.ETag, LastModified: obj.LastModified})
+	}
+	return nil
+}
+
+// count objects of the directory and their total size in target endpoint
+func Count(c *Config, target string) (int64, int64, error) {
+	store, err := targetStore(c, target)
+	if err != nil {
+		return 0, 0, err
+	}
+	return countDirObjects(store, c.Options.Bucket, c.listPrefix(), c.Options.ListPageSize, nil)
+}
+
+// remove all objects of the directory from target endpoint, returns number of removed
+// and failed objects. with dryRun objects are only logged
+func Remove(c *Config, target string, dryRun bool) (removed, failed int64, err error) {
+	store, err := targetStore(c, target)
+	if err != nil {
+		return 0, 0, err
+	}
+	return removeObjects(c, store, target, dryRun, removal{})
+}
+
+// remove objects of the directory in destination which don't exist in source,
+// e.g. after verify confirmed the copy. with dryRun objects are only logged
+func RemoveOrphans(c *Config, dryRun bool) (removed, failed int64, err error) {
+	// normalized destination keys can't be mapped back to source keys
+	if len(c.Options.KeyNormalization) > 0 || c.Options.KeyObfuscation != nil {
+		return 0, 0, errors.New("orphans can't be found with key_normalization or key_obfuscation, destination keys differ from source keys")
+	}
+	src, err := sourceStore(c)
+	if err != nil {
+		return 0, 0, err
+	}
+	dst, err := destinationStore(c)
+	if err != nil {
+		return 0, 0, err
+	}
+	quarantine := newQuarantine(c, dst, time.Now())
+	// keys of fresh cached listing of source found in it exist in source, others are checked
+	var cached map[uint64]struct{}
+	if lc, ok := src.(*listCacheStore); ok {
+		cached = lc.keyHashes()
+	}
+	orphan := func(key string) (bool, error) {
+		// evidence of mismatched objects isn't removed
+		if quarantine != nil && quarantine.contains(key) {
+			return false, nil
+		}
+		if _, ok := cached[keyHash(key)]; ok {
+			return false, nil
+		}
+		_, err := src.Stat(context.Background(), key)
+		if classifyError(err) == errNotFound {
+			return true, nil
+		}
+		return false, err
+	}
+	return removeObjects(c, dst, TargetDestination, dryRun, removal{filter: orphan, trash: newTrash(c, dst, time.Now()), what: "objects missing in source"})
+}
+
+// how removeObjects removes objects of the directory
+type removal struct {
+	// only objects for which it returns true are removed, all if it's nil
+	filter func(key string) (bool, error)
+	// objects are moved to trash, deleted if it's nil
+	trash *aside
+	// removed objects in summary, "objects" if it's empty
+	what string
+}
+
+// remove objects of the directory from store as set by r
+func removeObjects(c *Config, store ObjectStore, target string, dryRun bool, r removal) (removed, failed int64, err error) {
+	if c.Options.Directory == "" {
+		return 0, 0, fmt.Errorf("refusing to remove whole bucket '%s', directory is empty", c.Options.Bucket)
+	}
+
+	bucket := c.Options.Bucket
+	wl := c.workerLimiter()
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	// check whether object should be removed, failure of the check counts as failed object
+	check := func(key string) bool {
+		if r.filter == nil {
+			return true
+		}
+		ok, err := r.filter(key)
+		if err != nil {
+			logError("checking '%s/%s': %s", bucket, key, err)
+			mu.Lock()
+			failed++
+			mu.Unlock()
+		}
+		return ok
+	}
+	verb := "remove"
+	if r.trash != nil {
+		verb = "move to trash"
+	}
+
+	doneCh := make(chan struct{})
+	defer close(doneCh)
+	for obj := range listObjects(store, bucket, c.listPrefix(), c.Options.ListPageSize, nil, doneCh) {
+		if obj.Err != nil {
+			err = fmt.Errorf("listing objects: %s", obj.Err)
+			break
+		}
+		if r.trash != nil && r.trash.contains(obj.Key) {
+			continue
+		}
+		if dryRun {
+			if check(obj.Key) {
+				logInfo("would %s '%s/%s'", verb, bucket, obj.Key)
+				removed++
+			}
+			continue
+		}
+		wl.acquire()
+		wg.Add(1)
+		go func(key string) {
+			defer func() { wl.release(); wg.Done() }()
+			if !check(key) {
+				return
+			}
+			err := deleteObject(context.Background(), store, r.trash, bucket, key)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				logError("removing '%s/%s': %s", bucket, key, err)
+				failed++
+				return
+			}
+			removed++
+			logDebug("removed '%s/%s'", bucket, key)
+		}(obj.Key)
+	}
+	wg.Wait()
+
+	what := r.what
+	if what == "" {
+		what = "objects"
+	}
+	if r.trash != nil {
+		logSummary("moved %d %s from %s '%s/%s' to trash '%s/%s', %d failed", removed, what, target, bucket, c.Options.Directory, bucket, r.trash.key(""), failed)
+	} else {
+		logSummary("removed %d %s from %s '%s/%s', %d failed", removed, what, target, bucket, c.Options.Directory, failed)
+	}
+	return removed, failed, err
+}
+
+// numbers of objects by verification result
+type VerifyResult struct {
+	Checked int64
+	Missing int64
+	Differ  int64
+	Failed  int64
+	// different objects quarantined and copied again from source, they aren't counted in Differ
+	Repaired int64
+}
+
+// check every source object exists in destination with the same size,
+// with checksum content of objects is compared as well. with quarantine
+// different objects are quarantined and copied again from source
+func Verify(c *Config, checksum bool) (*VerifyResult, error) {
+	src, err := sourceStore(c)
+	if err != nil {
+		return nil, err
+	}
+	dst, err := destinationStore(c)
+	if err != nil {
+		return nil, err
+	}
+
+	normalizeKey, err := newKeyNormalizer(c.Options.KeyNormalization)
+	if err != nil {
+		return nil, err
+	}
+	obfuscator, err := newKeyObfuscator(c)
+	if err != nil {
+		return nil, err
+	}
+	cp := &Copier{src: src, dst: dst, bucket: c.Options.Bucket, ctx: context.Background(), normalizeKey: normalizeKey,
+		obfuscator: obfuscator, quarantine: newQuarantine(c, dst, time.Now())}
+	// transformed content never matches source, every object would be quarantined
+	if cp.quarantine != nil && (c.Options.TransformHook != "" || len(c.Options.TransformPlugins) > 0 || len(c.Run.Transforms) > 0) {
+		return nil, errors.New("quarantine can't be used with verify of transformed objects")
+	}
+	wl := c.workerLimiter()
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	res := &VerifyResult{}
+
+	doneCh := make(chan struct{})
+	defer close(doneCh)
+	for obj := range listObjects(src, c.Options.Bucket, c.listPrefix(), c.Options.ListPageSize, nil, doneCh) {
+		if obj.Err != nil {
+			err = fmt.Errorf("listing objects: %s", obj.Err)
+			break
+		}
+		wl.acquire()
+		wg.Add(1)
+		go func(obj Object) {
+			defer func() { wl.release(); wg.Done() }()
+			result := cp.verifyObj(obj, checksum)
+			mu.Lock()
+			defer mu.Unlock()
+			res.Checked++
+			switch result {
+			case verifyMissing:
+				res.Missing++
+			case verifyDiffer:
+				res.Differ++
+			case verifyFailed:
+				res.Failed++
+			case verifyRepaired:
+				res.Repaired++
+			}
+		}(obj.Object)
+	}
+	wg.Wait()
+
+	if cp.quarantine != nil {
+		logSummary("verified %d objects: %d missing in destination, %d different quarantined and copied again, %d different, %d failed to check",
+			res.Checked, res.Missing, res.Repaired, res.Differ, res.Failed)
+	} else {
+		logSummary("verified %d objects: %d missing in destination, %d different, %d failed to check",
+			res.Checked, res.Missing, res.Differ, res.Failed)
+	}
+	return res, err
+}
+
+// results of object verification
+const (
+	verifyOK = iota
+	verifyMissing
+	verifyDiffer
+	verifyFailed
+	verifyRepaired
+)
+
+func (cp *Copier) verifyObj(obj Object, checksum bool) int {
+	result := cp.checkObj(obj, checksum)
+	if result != verifyDiffer || cp.quarantine == nil {
+		return result
+	}
+	if err := cp.repairObj(obj); err != nil {
+		logError("repairing '%s/%s': %s", cp.bucket, obj.Key, err)
+		return verifyDiffer
+	}
+	logInfo("copied '%s/%s' again from source", cp.bucket, obj.Key)
+	return verifyRepaired
+}
+
+func (cp *Copier) checkObj(obj Object, checksum bool) int {
+	dstInfo, err := cp.dst.Stat(cp.ctx, cp.destKey(obj.Key))
+	if err != nil {
+		if classifyError(err) == errNotFound {
+			logWarn("missing in destination '%s/%s'", cp.bucket, obj.Key)
+			return verifyMissing
+		}
+		logError("stat '%s/%s': %s", cp.bucket, obj.Key, err)
+		return verifyFailed
+	}
+	if dstInfo.Size != obj.Size {
+		logWarn("size differs '%s/%s': source %d, destination %d", cp.bucket, obj.Key, obj.Size, dstInfo.Size)
+		return verifyDiffer
+	}
+	if !checksum {
+		return verifyOK
+	}
+	same, err := cp.sameContent(obj, dstInfo)
+	if err != nil {
+		logError("comparing '%s/%s': %s", cp.bucket, obj.Key, err)
+		return verifyFailed
+	}
+	if !same {
+		logWarn("content differs '%s/%s'", cp.bucket, obj.Key)
+		return verifyDiffer
+	}
+	return verifyOK
+}
@@ -0,0 +1,141 @@
+package s3copy
+
+import (
+	"context"
+	"github.com/minio/minio-go"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// error of a single S3 operation (get, put, ...) of an object
+type opError struct {
+	op  string
+	err error
+}
+
+func (e *opError) Error() string {
+	return e.op + ": " + e.err.Error()
+}
+
+// unwrap error to the one returned by minio client or net package
+func cause(err error) error {
+	for {
+		switch e := err.(type) {
+		case *opError:
+			err = e.err
+		case *url.Error:
+			err = e.Err
+		default:
+			return err
+		}
+	}
+}
+
+// class of error which defines how copy handles it
+type errClass int
+
+const (
+	errOther      errClass = iota // counted as failed object
+	errAuth                       // credentials or permissions problem, aborts the run
+	errNotFound                   // object removed after listing, skipped and recorded
+	errThrottling                 // retried
+	errNetwork                    // retried
+	errServer                     // retried
+	errCanceled                   // copy interrupted
+	errConflict                   // destination object changed since the skip-check, conditional write kept it
+	errArchived                   // source object must be restored from archive storage class, counted as failed
+	errTooLarge                   // destination rejected object as too large, counted as failed
+)
+
+func (c errClass) String() string {
+	switch c {
+	case errAuth:
+		return "auth"
+	case errNotFound:
+		return "not-found"
+	case errThrottling:
+		return "throttling"
+	case errNetwork:
+		return "network"
+	case errServer:
+		return "server"
+	case errCanceled:
+		return "canceled"
+	case errConflict:
+		return "conflict"
+	case errArchived:
+		return "archived"
+	case errTooLarge:
+		return "too-large"
+	}
+	return "other"
+}
+
+// transient errors are worth to retry
+func (c errClass) retryable() bool {
+	return c == errThrottling || c == errNetwork || c == errServer
+}
+
+func classifyError(err error) errClass {
+	err = cause(err)
+	switch err {
+	case context.Canceled, context.DeadlineExceeded:
+		return errCanceled
+	case io.ErrUnexpectedEOF, io.EOF:
+		return errNetwork
+	case ErrNotFound:
+		return errNotFound
+	}
+	if _, ok := err.(net.Error); ok {
+		return errNetwork
+	}
+
+	resp := minio.ToErrorResponse(err)
+	switch resp.Code {
+	case "AccessDenied", "InvalidAccessKeyId", "SignatureDoesNotMatch", "ExpiredToken",
+		"InvalidToken", "AllAccessDisabled", "AccountProblem":
+		return errAuth
+	case "NoSuchKey":
+		return errNotFound
+	case "SlowDown", "ServiceUnavailable", "TooManyRequests":
+		return errThrottling
+	case "RequestTimeout":
+		return errNetwork
+	case "InternalError":
+		return errServer
+	case "PreconditionFailed", "ConditionalRequestConflict":
+		return errConflict
+	case "InvalidObjectState":
+		return errArchived
+	case "EntityTooLarge":
+		return errTooLarge
+	}
+
+	status := resp.StatusCode
+	if se, ok := err.(*statusError); ok {
+		// stores other than S3 report missing objects with ErrNotFound,
+		// so 404 of http response is a missing container or similar
+		status = se.code
+		if status == 412 || status == 409 && strings.Contains(se.status, "<Code>ConditionalRequestConflict</Code>") {
+			return errConflict
+		}
+		if status == 404 {
+			return errOther
+		}
+	}
+	switch {
+	case status == 401 || status == 403:
+		return errAuth
+	case status == 404 && resp.Code != "NoSuchBucket":
+		return errNotFound
+	case status == 413:
+		return errTooLarge
+	case status == 429 || status == 503:
+		return errThrottling
+	case status >= 500:
+		return errServer
+	}
+	return errOther
+}
@@ -0,0 +1,235 @@
+package s3copy
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+)
+
+// objects picked uniformly from source listing for throughput probe
+const probeSampleSize = 256
+
+// settings of estimate, see EstimateRun
+type EstimateOptions struct {
+	// copy sample of source objects for about this long to measure throughput, 0 - only cost is estimated
+	Probe time.Duration
+	// probe copies are written under this prefix of destination and removed right away
+	ProbePrefix string
+	// concurrency of the copy, concurrency of config by default
+	Concurrency int
+	// limit of bytes per second read from source during the copy, 0 - unlimited
+	BandwidthLimit int64
+}
+
+// predicted objects, bytes, cost and duration of copying directory into empty destination
+type RunEstimate struct {
+	Objects int64         `json:"objects"`
+	Bytes   int64         `json:"bytes"`
+	Cost    *CostEstimate `json:"cost"`
+	// time source listing took, the copy can't finish faster
+	ListSec float64 `json:"list_sec"`
+	// nil without probe
+	Probe *ProbeResult `json:"probe,omitempty"`
+	// predicted wall-clock time of the copy with given concurrency, 0 without probe
+	DurationSec float64 `json:"duration_sec"`
+	Concurrency int     `json:"concurrency"`
+}
+
+// copies of sampled source objects measured by probe. time of a copy is modelled as fixed
+// overhead per object (requests, latency) plus time per byte, both fitted to measured copies
+type ProbeResult struct {
+	Objects    int64   `json:"objects"`
+	Bytes      int64   `json:"bytes"`
+	Failed     int64   `json:"failed"`
+	ElapsedSec float64 `json:"elapsed_sec"`
+	// seconds per object and bytes per second of a single worker
+	OverheadSec     float64 `json:"overhead_sec"`
+	WorkerBytesPerS float64 `json:"worker_bytes_per_sec"`
+}
+
+// predict cost of copying directory into empty destination from source listing
+func EstimateCost(c *Config) (*CostEstimate, error) {
+	e, err := EstimateRun(c, EstimateOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return e.Cost, nil
+}
+
+// list source and predict requests and cost of copying the directory into empty destination.
+// with probe a uniform sample of source objects is copied under probe prefix of destination
+// with concurrency of the copy to measure throughput, and wall-clock time of the copy is predicted.
+// probe copies objects with single PUT, multipart uploads of the copy may be faster
+func EstimateRun(c *Config, o EstimateOptions) (*RunEstimate, error) {
+	if o.Concurrency <= 0 {
+		o.Concurrency = maxInt(c.Options.Concurrency, 1)
+	}
+	if o.Probe > 0 {
+		prefix, dir := strings.Trim(o.ProbePrefix, "/")+"/", strings.Trim(c.Options.Directory, "/")+"/"
+		if prefix == "/" || c.Options.Directory != "" && (strings.HasPrefix(prefix, dir) || strings.HasPrefix(dir, prefix)) {
+			return nil, fmt.Errorf("invalid probe prefix '%s', must be outside of directory", o.ProbePrefix)
+		}
+	}
+	src, err := sourceStore(c)
+	if err != nil {
+		return nil, err
+	}
+
+	var threshold, partSize int64
+	if c.Options.MultipartThreshold != "" && c.Options.StateFile != "" {
+		if threshold, err = ParseByteSize(c.Options.MultipartThreshold); err != nil {
+			return nil, err
+		}
+	}
+	if c.Options.PartSize != "" {
+		if partSize, err = ParseByteSize(c.Options.PartSize); err != nil {
+			return nil, err
+		}
+	}
+
+	// objects are counted and sampled with reservoir sampling in a single listing
+	e := &RunEstimate{Concurrency: o.Concurrency}
+	var sample []Object
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	logInfo("listing objects in '%s/%s'", c.Options.Bucket, c.listPrefix())
+	start := time.Now()
+	doneCh := make(chan struct{})
+	defer close(doneCh)
+	for obj := range listObjects(src, c.Options.Bucket, c.listPrefix(), c.Options.ListPageSize, nil, doneCh) {
+		if obj.Err != nil {
+			return nil, fmt.Errorf("listing objects: %s", obj.Err)
+		}
+		e.Objects++
+		e.Bytes += obj.Size
+		if len(sample) < probeSampleSize {
+			sample = append(sample, obj.Object)
+		} else if i := rnd.Int63n(e.Objects); i < probeSampleSize {
+			sample[i] = obj.Object
+		}
+	}
+	e.ListSec = time.Since(start).Seconds()
+
+	srcReqs, dstReqs := predictRequests(e.Objects, e.Bytes, c.Options.ListPageSize, threshold, partSize)
+	e.Cost = estimateCost(c.Options.Prices, srcReqs, dstReqs, e.Bytes)
+	logSummary("%d objects, %s in '%s/%s', listed in %s", e.Objects, FormatBytes(e.Bytes), c.Options.Bucket, c.Options.Directory,
+		time.Duration(e.ListSec*float64(time.Second)).Round(time.Millisecond))
+	logSummary("source requests: %v, destination requests: %v", srcReqs, dstReqs)
+	logSummary("%s", e.Cost.summary())
+	if o.Probe <= 0 || len(sample) == 0 {
+		return e, nil
+	}
+
+	dst, err := destinationStore(c)
+	if err != nil {
+		return nil, err
+	}
+	rnd.Shuffle(len(sample), func(i, j int) { sample[i], sample[j] = sample[j], sample[i] })
+	e.Probe = probeThroughput(src, dst, sample, strings.Trim(o.ProbePrefix, "/")+"/", o.Concurrency, o.Probe)
+	p := e.Probe
+	if p.Objects == 0 {
+		logWarn("probe copied no objects, duration isn't estimated")
+		return e, nil
+	}
+	workerSec := p.OverheadSec * float64(e.Objects)
+	if p.WorkerBytesPerS > 0 {
+		workerSec += float64(e.Bytes) / p.WorkerBytesPerS
+	}
+	e.DurationSec = math.Max(workerSec/float64(o.Concurrency), e.ListSec)
+	if o.BandwidthLimit > 0 {
+		e.DurationSec = math.Max(e.DurationSec, float64(e.Bytes)/float64(o.BandwidthLimit))
+	}
+	logSummary("probe copied %d objects (%s) in %.1fs with concurrency %d, %d failed: %s per object, %s/s per worker",
+		p.Objects, FormatBytes(p.Bytes), p.ElapsedSec, o.Concurrency, p.Failed,
+		time.Duration(p.OverheadSec*float64(time.Second)).Round(time.Millisecond), FormatBytes(int64(p.WorkerBytesPerS)))
+	logSummary("estimated duration %s with concurrency %d", time.Duration(e.DurationSec*float64(time.Second)).Round(time.Second), o.Concurrency)
+	return e, nil
+}
+
+// copy sampled objects to destination under prefix with given concurrency for about d, every
+// copy is removed once it's measured. copies still running at twice d are cancelled and not counted
+func probeThroughput(src, dst ObjectStore, sample []Object, prefix string, concurrency int, d time.Duration) *ProbeResult {
+	logInfo("probing throughput for %s with %d workers, copies are written under '%s' of destination", d, concurrency, prefix)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*d)
+	defer cancel()
+	res := &ProbeResult{}
+	var sizes, secs []float64
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	workersCh := make(chan struct{}, concurrency)
+	start := time.Now()
+	for _, obj := range sample {
+		workersCh <- struct{}{}
+		if time.Since(start) >= d {
+			<-workersCh
+			break
+		}
+		wg.Add(1)
+		go func(obj Object) {
+			defer func() { <-workersCh; wg.Done() }()
+			opStart := time.Now()
+			key := prefix + obj.Key
+			n, err := probeCopy(ctx, src, dst, obj, key)
+			took := time.Since(opStart)
+			if err == nil {
+				logErr(dst.Delete(context.Background(), key))
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				res.Failed++
+				logWarn("probe copy of '%s': %s", obj.Key, err)
+				return
+			}
+			res.Objects++
+			res.Bytes += n
+			sizes = append(sizes, float64(n))
+			secs = append(secs, took.Seconds())
+		}(obj)
+	}
+	wg.Wait()
+	res.ElapsedSec = time.Since(start).Seconds()
+	res.OverheadSec, res.WorkerBytesPerS = fitCopyTime(sizes, secs)
+	return res
+}
+
+func probeCopy(ctx context.Context, src, dst ObjectStore, obj Object, key string) (int64, error) {
+	r, info, err := src.Get(ctx, obj.Key, 0, -1)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+	return dst.Put(ctx, key, r, obj.Size, info.ContentType)
+}
+
+// least squares fit of copy time as overhead + size/rate. with objects of the same size, or
+// fits giving negative overhead or rate, all time goes to the other term
+func fitCopyTime(sizes, secs []float64) (overhead, rate float64) {
+	n := float64(len(sizes))
+	if n == 0 {
+		return 0, 0
+	}
+	var ms, mt, totalS, totalT float64
+	for i := range sizes {
+		totalS += sizes[i]
+		totalT += secs[i]
+	}
+	ms, mt = totalS/n, totalT/n
+	var cov, vs float64
+	for i := range sizes {
+		cov += (sizes[i] - ms) * (secs[i] - mt)
+		vs += (sizes[i] - ms) * (sizes[i] - ms)
+	}
+	if vs == 0 || cov <= 0 {
+		return mt, 0
+	}
+	perByte := cov / vs
+	overhead = mt - perByte*ms
+	if overhead < 0 {
+		return 0, totalS / totalT
+	}
+	return overhead, 1 / perByte
+}
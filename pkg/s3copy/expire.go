@@ -0,0 +1,247 @@
+package s3copy
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// id of lifecycle rule of source bucket expiring tagged objects
+const expireRuleID = "s3-copy-dir-expire"
+
+// copy-then-expire tiering: source objects are tagged once their copy is verified, lifecycle
+// rule of source bucket filtered by the tag expires them, see Options.ExpireSource
+type ExpireConfig struct {
+	// tag set on source objects, "key=value"
+	Tag string `json:"tag"`
+	// if set, lifecycle rule of source bucket expiring objects with the tag this many days after
+	// they were created is added before the copy, otherwise bucket must have a rule of its own
+	Days int `json:"days,omitempty"`
+}
+
+// key and value of tag, ok is false if it isn't "key=value"
+func (e *ExpireConfig) tag() (key, value string, ok bool) {
+	i := strings.Index(e.Tag, "=")
+	if i <= 0 {
+		return "", "", false
+	}
+	return e.Tag[:i], e.Tag[i+1:], true
+}
+
+// source which tags objects and expires them with lifecycle of its bucket
+type expireStore interface {
+	// set tag of object, other tags of it are kept
+	tagObject(ctx context.Context, key, name, value string) error
+	bucketConfigStore
+}
+
+// tagging of verified source objects of the copy
+type sourceExpiry struct {
+	store    expireStore
+	key      string
+	value    string
+	days     int
+	tagged   int64
+	untagged int64
+}
+
+// expiry of source objects if it's configured, source must be S3 bucket
+func newSourceExpiry(c *Config, src ObjectStore, transforms []Transform) (*sourceExpiry, error) {
+	e := c.Options.ExpireSource
+	if e == nil {
+		return nil, nil
+	}
+	if len(transforms) > 0 {
+		return nil, errors.New("expire_source can't be used with transforms, transformed copy can't be verified against source")
+	}
+	store, ok := primarySource(src).(expireStore)
+	if !ok {
+		return nil, errors.New("source doesn't support object tags, expire_source requires S3 source")
+	}
+	key, value, ok := e.tag()
+	if !ok {
+		return nil, fmt.Errorf("invalid tag '%s' of expire_source, must be key=value", e.Tag)
+	}
+	return &sourceExpiry{store: store, key: key, value: value, days: e.Days}, nil
+}
+
+// add lifecycle rule expiring tagged objects to source bucket, other rules are kept
+func (se *sourceExpiry) ensureRule(ctx context.Context, bucket string) error {
+	if se.days <= 0 {
+		return nil
+	}
+	current, err := se.store.bucketSetting(ctx, bucketLifecycle)
+	if err != nil {
+		return fmt.Errorf("reading lifecycle of source bucket '%s': %s", bucket, err)
+	}
+	updated, changed, err := withExpireRule(current, se.key, se.value, se.days)
+	if err != nil {
+		return fmt.Errorf("lifecycle of source bucket '%s': %s", bucket, err)
+	}
+	if !changed {
+		return nil
+	}
+	if err := se.store.putBucketSetting(ctx, bucketLifecycle, updated); err != nil {
+		return fmt.Errorf("setting lifecycle of source bucket '%s': %s", bucket, err)
+	}
+	logInfo("lifecycle rule '%s' of source bucket '%s' expires objects tagged %s=%s %d days after they were created",
+		expireRuleID, bucket, se.key, se.value, se.days)
+	return nil
+}
+
+// lifecycle configuration with expire rule replacing rule of the same id
+func withExpireRule(current []byte, key, value string, days int) ([]byte, bool, error) {
+	var conf struct {
+		Rules []struct {
+			ID       string `xml:"ID"`
+			TagKey   string `xml:"Filter>Tag>Key"`
+			TagValue string `xml:"Filter>Tag>Value"`
+			Days     int    `xml:"Expiration>Days"`
+			Status   string `xml:"Status"`
+			Inner    []byte `xml:",innerxml"`
+		} `xml:"Rule"`
+	}
+	if len(current) > 0 {
+		if err := xml.Unmarshal(current, &conf); err != nil {
+			return nil, false, fmt.Errorf("decoding lifecycle configuration: %s", err)
+		}
+	}
+	esc := func(s string) string {
+		var b bytes.Buffer
+		xml.EscapeText(&b, []byte(s))
+		return b.String()
+	}
+	rule := fmt.Sprintf("<ID>%s</ID><Filter><Tag><Key>%s</Key><Value>%s</Value></Tag></Filter><Status>Enabled</Status><Expiration><Days>%d</Days></Expiration>",
+		expireRuleID, esc(key), esc(value), days)
+
+	var b bytes.Buffer
+	b.WriteString(`<LifecycleConfiguration xmlns="http://s3.amazonaws.com/doc/2006-03-01/">`)
+	for _, r := range conf.Rules {
+		if r.ID == expireRuleID {
+			if r.TagKey == key && r.TagValue == value && r.Days == days && r.Status == "Enabled" {
+				return current, false, nil
+			}
+			continue
+		}
+		b.WriteString("<Rule>")
+		b.Write(r.Inner)
+		b.WriteString("</Rule>")
+	}
+	b.WriteString("<Rule>" + rule + "</Rule></LifecycleConfiguration>")
+	return b.Bytes(), true, nil
+}
+
+// tag source object for expiry once its copy is verified: content of destination object dstInfo
+// (checked if it's empty) matches source, and source wasn't modified since it was listed
+func (cp *Copier) expireSource(obj, dstInfo Object) {
+	se := cp.expiry
+	if se == nil {
+		return
+	}
+	key := cp.destKey(obj.Key)
+	err := cp.verifyCopy(obj, dstInfo)
+	if err != nil {
+		atomic.AddInt64(&se.untagged, 1)
+		logWarn("not tagging source '%s/%s' for expiry: %s", cp.bucket, obj.Key, err)
+		return
+	}
+	countRequest(false, reqPut)
+	err = se.store.tagObject(cp.ctx, obj.Key, se.key, se.value)
+	audit(auditEntry{Op: auditPut, Bucket: cp.bucket, Key: obj.Key}, err)
+	if err != nil {
+		atomic.AddInt64(&se.untagged, 1)
+		logError("tagging source '%s/%s' for expiry: %s", cp.bucket, obj.Key, err)
+		return
+	}
+	atomic.AddInt64(&se.tagged, 1)
+	logDebug("tagged source '%s/%s' for expiry, copy '%s' is verified", cp.bucket, obj.Key, key)
+}
+
+// error if copy of object in destination isn't verified
+func (cp *Copier) verifyCopy(obj, dstInfo Object) error {
+	var err error
+	if dstInfo.Key == "" {
+		countRequest(true, reqHead)
+		if dstInfo, err = cp.dst.Stat(cp.ctx, cp.destKey(obj.Key)); err != nil {
+			return fmt.Errorf("checking copy: %s", err)
+		}
+	}
+	countRequest(false, reqHead)
+	srcInfo, err := cp.src.Stat(cp.ctx, obj.Key)
+	if err != nil {
+		return fmt.Errorf("checking source: %s", err)
+	}
+	if obj.ETag != "" && !strings.EqualFold(strings.Trim(srcInfo.ETag, `"`), strings.Trim(obj.ETag, `"`)) {
+		return errors.New("source was modified since it was listed")
+	}
+	same, err := cp.sameContent(srcInfo, dstInfo)
+	if err != nil {
+		return fmt.Errorf("comparing with copy: %s", err)
+	}
+	if !same {
+		return errors.New("content of copy differs")
+	}
+	return nil
+}
+
+func (se *sourceExpiry) logSummary() {
+	if se == nil {
+		return
+	}
+	logSummary("tagged %d source objects %s=%s for expiry, %d weren't tagged", atomic.LoadInt64(&se.tagged), se.key, se.value, atomic.LoadInt64(&se.untagged))
+}
+
+// tags of object are replaced as a whole, so they're read first
+func (s *minioStore) tagObject(ctx context.Context, key, name, value string) error {
+	region, err := s.clnt.GetBucketLocation(s.bucket)
+	if err != nil {
+		return err
+	}
+	path := "/" + s.bucket + "/" + s3EscapeKey(key)
+	resp, err := s.signedRequest(ctx, http.MethodGet, path, "tagging=", region, nil, nil)
+	if err != nil {
+		return err
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	type tag struct {
+		Key   string `xml:"Key"`
+		Value string `xml:"Value"`
+	}
+	var tagging struct {
+		XMLName xml.Name `xml:"Tagging"`
+		Tags    []tag    `xml:"TagSet>Tag"`
+	}
+	if err := xml.Unmarshal(body, &tagging); err != nil {
+		return fmt.Errorf("decoding tags: %s", err)
+	}
+	found := false
+	for i, t := range tagging.Tags {
+		if t.Key == name {
+			if t.Value == value {
+				return nil
+			}
+			tagging.Tags[i].Value, found = value, true
+		}
+	}
+	if !found {
+		tagging.Tags = append(tagging.Tags, tag{Key: name, Value: value})
+	}
+	if body, err = xml.Marshal(tagging); err != nil {
+		return err
+	}
+	if resp, err = s.signedRequest(ctx, http.MethodPut, path, "tagging=", region, nil, body); err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
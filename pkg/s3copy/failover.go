@@ -0,0 +1,202 @@
+package s3copy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// balancing of reads between source and its replicas
+const (
+	SourceFailover   = "failover"
+	SourceRoundRobin = "round-robin"
+)
+
+// replica which failed with transient error isn't tried again for this time,
+// unless all of them are down
+const sourceDownTime = time.Second * 30
+
+// source with replicas holding the same objects: reads are served by the primary and
+// fail over to replicas on transient errors, or are spread between all of them with
+// round-robin. writes and deletes go to the primary only
+type failoverStore struct {
+	stores     []ObjectStore
+	names      []string
+	roundRobin bool
+	next       uint32
+
+	mu        sync.Mutex
+	downUntil []time.Time
+}
+
+// store of source and its replicas, see Config.Sources
+func newFailoverStore(c *Config) (ObjectStore, error) {
+	f := &failoverStore{}
+	switch c.Options.SourceBalance {
+	case "", SourceFailover:
+	case SourceRoundRobin:
+		f.roundRobin = true
+	default:
+		return nil, fmt.Errorf("invalid source_balance '%s', must be %s or %s", c.Options.SourceBalance, SourceFailover, SourceRoundRobin)
+	}
+	for _, e := range c.sources() {
+		s, err := newStore(e, c.Options.Bucket)
+		if err != nil {
+			return nil, err
+		}
+		f.stores = append(f.stores, s)
+		f.names = append(f.names, e.String())
+	}
+	f.downUntil = make([]time.Time, len(f.stores))
+	return f, nil
+}
+
+// source and all its replicas
+func (c *Config) sources() []Endpoint {
+	return append([]Endpoint{c.Source}, c.Sources...)
+}
+
+// sources shown in logs and reports
+// primary source, behind listing cache and replicas. optional interfaces of source are checked on it
+func primarySource(src ObjectStore) ObjectStore {
+	src = uncached(src)
+	if f, ok := src.(*failoverStore); ok {
+		return f.stores[0]
+	}
+	return src
+}
+
+func (c *Config) sourcesString() string {
+	var names []string
+	for _, e := range c.sources() {
+		names = append(names, e.String())
+	}
+	return strings.Join(names, ", ")
+}
+
+// indexes of stores in order they're tried: healthy ones starting with the primary,
+// or with the next one in turn for round-robin, then ones which are down
+func (f *failoverStore) order() []int {
+	first := 0
+	if f.roundRobin {
+		first = int(atomic.AddUint32(&f.next, 1)-1) % len(f.stores)
+	}
+	now := time.Now()
+	var up, down []int
+	f.mu.Lock()
+	for i := range f.stores {
+		idx := (first + i) % len(f.stores)
+		if now.Before(f.downUntil[idx]) {
+			down = append(down, idx)
+		} else {
+			up = append(up, idx)
+		}
+	}
+	f.mu.Unlock()
+	return append(up, down...)
+}
+
+// record result of request to store, returns whether the next store should be tried
+func (f *failoverStore) failed(idx int, err error) bool {
+	if err == nil || !classifyError(err).retryable() {
+		return false
+	}
+	f.mu.Lock()
+	wasUp := !time.Now().Before(f.downUntil[idx])
+	f.downUntil[idx] = time.Now().Add(sourceDownTime)
+	f.mu.Unlock()
+	if wasUp {
+		logWarn("source %s failed: %s, reading from other sources for %s", f.names[idx], err, sourceDownTime)
+	}
+	return true
+}
+
+// pages of listing are read from the same store, tokens are specific to it,
+// so index of the store is kept in the token
+func (f *failoverStore) List(ctx context.Context, prefix, token string, pageSize int) ([]Object, string, error) {
+	if token != "" {
+		i := strings.IndexByte(token, ':')
+		idx := -1
+		if i > 0 {
+			idx, _ = strconv.Atoi(token[:i])
+		}
+		if idx < 0 || idx >= len(f.stores) {
+			return nil, "", fmt.Errorf("invalid listing token '%s'", token)
+		}
+		objs, next, err := f.stores[idx].List(ctx, prefix, token[i+1:], pageSize)
+		return objs, listToken(idx, next), err
+	}
+	var err error
+	for _, idx := range f.order() {
+		var objs []Object
+		var next string
+		objs, next, err = f.stores[idx].List(ctx, prefix, "", pageSize)
+		if !f.failed(idx, err) {
+			return objs, listToken(idx, next), err
+		}
+	}
+	return nil, "", err
+}
+
+func listToken(idx int, next string) string {
+	if next == "" {
+		return ""
+	}
+	return strconv.Itoa(idx) + ":" + next
+}
+
+func (f *failoverStore) Stat(ctx context.Context, key string) (Object, error) {
+	var err error
+	for _, idx := range f.order() {
+		var obj Object
+		obj, err = f.stores[idx].Stat(ctx, key)
+		if !f.failed(idx, err) {
+			return obj, err
+		}
+	}
+	return Object{}, err
+}
+
+// failure in the middle of content marks store as down, so retry of the copy reads from another one
+func (f *failoverStore) Get(ctx context.Context, key string, offset, length int64) (io.ReadCloser, Object, error) {
+	var err error
+	for _, idx := range f.order() {
+		var r io.ReadCloser
+		var obj Object
+		r, obj, err = f.stores[idx].Get(ctx, key, offset, length)
+		if err == nil {
+			return &failoverReader{r, f, idx}, obj, nil
+		}
+		if !f.failed(idx, err) {
+			return nil, obj, err
+		}
+	}
+	return nil, Object{}, err
+}
+
+type failoverReader struct {
+	io.ReadCloser
+	f   *failoverStore
+	idx int
+}
+
+func (r *failoverReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if err != nil && err != io.EOF {
+		r.f.failed(r.idx, err)
+	}
+	return n, err
+}
+
+func (f *failoverStore) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) (int64, error) {
+	return f.stores[0].Put(ctx, key, r, size, contentType)
+}
+
+func (f *failoverStore) Delete(ctx context.Context, key string) error {
+	return f.stores[0].Delete(ctx, key)
+}
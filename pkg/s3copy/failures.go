@@ -0,0 +1,83 @@
+package s3copy
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// single line of failed objects file
+type failedObject struct {
+	Key   string    `json:"key"`
+	Class string    `json:"class"`
+	Error string    `json:"error"`
+	Time  time.Time `json:"time"`
+}
+
+// dead-letter file with objects which failed to copy, one json object per line
+type failureLog struct {
+	sync.Mutex
+	file *os.File
+	enc  *json.Encoder
+}
+
+// create (truncate) failed objects file
+func openFailureLog(path string) (*failureLog, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &failureLog{file: f, enc: json.NewEncoder(f)}, nil
+}
+
+func (fl *failureLog) record(key string, class errClass, err error) {
+	fl.Lock()
+	defer fl.Unlock()
+	logErr(fl.enc.Encode(failedObject{Key: key, Class: class.String(), Error: err.Error(), Time: time.Now().UTC()}))
+}
+
+func (fl *failureLog) close() {
+	logErr(fl.file.Close())
+}
+
+// read keys from failed objects file, written by previous run
+func readFailedObjects(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var keys []string
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var fo failedObject
+		if err := json.Unmarshal(scanner.Bytes(), &fo); err != nil {
+			return nil, err
+		}
+		keys = append(keys, fo.Key)
+	}
+	return keys, scanner.Err()
+}
+
+// stream of objects to retry, used instead of source listing in retry mode
+func failedObjectsCh(keys []string, doneCh <-chan struct{}) <-chan listEntry {
+	objCh := make(chan listEntry)
+	go func() {
+		defer close(objCh)
+		for _, key := range keys {
+			select {
+			case objCh <- listEntry{Object: Object{Key: key}}:
+			case <-doneCh:
+				return
+			}
+		}
+	}()
+	return objCh
+}
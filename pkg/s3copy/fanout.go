@@ -0,0 +1,130 @@
+package s3copy
+
+import (
+	"context"
+	"io"
+	"strings"
+)
+
+// destination writing every object to all of its stores, content read once from source
+// is streamed to all of them at the same time. object exists only if it exists in every
+// store, so object missing in any of them is copied again to all. listing and reads are
+// served by the first store
+type fanoutStore struct {
+	stores []ObjectStore
+}
+
+// store of destination and additional destinations, see Config.Destinations
+func newFanoutStore(c *Config) (ObjectStore, error) {
+	f := &fanoutStore{}
+	for _, e := range c.destinations() {
+		s, err := newStore(e, c.Options.Bucket)
+		if err != nil {
+			return nil, err
+		}
+		f.stores = append(f.stores, s)
+	}
+	return f, nil
+}
+
+// all destinations of config
+func (c *Config) destinations() []Endpoint {
+	return append([]Endpoint{c.Destination}, c.Destinations...)
+}
+
+// destinations shown in logs and reports
+func (c *Config) destinationsString() string {
+	var names []string
+	for _, e := range c.destinations() {
+		names = append(names, e.String())
+	}
+	return strings.Join(names, ", ")
+}
+
+func (f *fanoutStore) List(ctx context.Context, prefix, token string, pageSize int) ([]Object, string, error) {
+	return f.stores[0].List(ctx, prefix, token, pageSize)
+}
+
+// object of the store where it's the oldest, so it's re-copied if it's older than source in any store
+func (f *fanoutStore) Stat(ctx context.Context, key string) (Object, error) {
+	var oldest Object
+	for _, s := range f.stores {
+		obj, err := s.Stat(ctx, key)
+		if err != nil {
+			return Object{}, err
+		}
+		if oldest.Key == "" || obj.LastModified.Before(oldest.LastModified) {
+			oldest = obj
+		}
+	}
+	return oldest, nil
+}
+
+func (f *fanoutStore) Get(ctx context.Context, key string, offset, length int64) (io.ReadCloser, Object, error) {
+	return f.stores[0].Get(ctx, key, offset, length)
+}
+
+// stream r to all stores, object fails if upload to any of them failed
+func (f *fanoutStore) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) (int64, error) {
+	type putResult struct {
+		size int64
+		err  error
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	writers := make([]io.Writer, len(f.stores))
+	pipes := make([]*io.PipeWriter, len(f.stores))
+	resCh := make(chan putResult, len(f.stores))
+	for i, s := range f.stores {
+		pr, pw := io.Pipe()
+		writers[i], pipes[i] = pw, pw
+		go func(s ObjectStore) {
+			n, err := s.Put(ctx, key, pr, size, contentType)
+			// unblock writing of content if store stopped reading it
+			if err != nil {
+				pr.CloseWithError(err)
+			} else {
+				pr.Close()
+			}
+			resCh <- putResult{n, err}
+		}(s)
+	}
+
+	_, copyErr := io.Copy(io.MultiWriter(writers...), r)
+	for _, pw := range pipes {
+		pw.CloseWithError(copyErr)
+	}
+	if copyErr != nil {
+		cancel()
+	}
+	var written int64
+	err := copyErr
+	for range f.stores {
+		res := <-resCh
+		if res.err != nil && err == nil {
+			err = res.err
+		}
+		written = res.size
+	}
+	return written, err
+}
+
+// remove object from all stores, missing object is reported only if it's missing in all of them
+func (f *fanoutStore) Delete(ctx context.Context, key string) error {
+	var firstErr error
+	missing := 0
+	for _, s := range f.stores {
+		err := s.Delete(ctx, key)
+		switch {
+		case err == nil:
+		case classifyError(err) == errNotFound:
+			missing++
+		case firstErr == nil:
+			firstErr = err
+		}
+	}
+	if firstErr == nil && missing == len(f.stores) {
+		return ErrNotFound
+	}
+	return firstErr
+}
@@ -0,0 +1,125 @@
+package s3copy
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// decides if listed source object is copied, returns false to skip it
+type Filter func(obj Object) bool
+
+// accept only objects matching any of glob patterns, see matchKey
+func Include(patterns ...string) Filter {
+	return func(obj Object) bool { return matchKey(patterns, obj.Key) }
+}
+
+// reject objects matching any of glob patterns, see matchKey
+func Exclude(patterns ...string) Filter {
+	return func(obj Object) bool { return !matchKey(patterns, obj.Key) }
+}
+
+// pattern matches the whole key or its trailing part after any slash,
+// so "*.log" matches "dir/a.log" and "thumbs/*" matches "dir/thumbs/a.jpg".
+// invalid patterns don't match anything
+func matchKey(patterns []string, key string) bool {
+	for _, p := range patterns {
+		for name := key; ; {
+			if ok, _ := path.Match(p, name); ok {
+				return true
+			}
+			i := strings.Index(name, "/")
+			if i < 0 {
+				break
+			}
+			name = name[i+1:]
+		}
+	}
+	return false
+}
+
+// check glob patterns are valid
+func ValidatePatterns(patterns ...string) error {
+	for _, p := range patterns {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("invalid pattern '%s': %s", p, err)
+		}
+	}
+	return nil
+}
+
+// check all filters accept object
+func (cp *Copier) accepted(obj Object) bool {
+	for _, f := range cp.cfg.Run.Filters {
+		if !f(obj) {
+			return false
+		}
+	}
+	return true
+}
+
+// check user metadata of source object matches all patterns of metadata_filter, listing
+// doesn't return metadata, so object is read with HEAD request
+func (cp *Copier) metadataAccepted(obj Object, sp *span) (bool, error) {
+	filter := cp.cfg.Options.MetadataFilter
+	if len(filter) == 0 {
+		return true, nil
+	}
+	statSp := startRequestSpan("STAT", sp, false, cp.bucket, obj.Key)
+	countRequest(false, reqHead)
+	info, err := cp.src.Stat(cp.ctx, obj.Key)
+	statSp.end(err)
+	if err != nil {
+		return false, err
+	}
+	for name, pattern := range filter {
+		value, ok := info.Metadata[strings.ToLower(name)]
+		if !ok {
+			return false, nil
+		}
+		if matched, _ := path.Match(pattern, value); !matched {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// user metadata in response headers of store, names are lowercase without prefix
+func userMetadata(h http.Header, prefix string) map[string]string {
+	var md map[string]string
+	for name, values := range h {
+		if len(values) == 0 || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		if md == nil {
+			md = map[string]string{}
+		}
+		md[strings.ToLower(name[len(prefix):])] = values[0]
+	}
+	return md
+}
+
+// key belongs to shard of this copy, objects of other shards aren't processed at all
+func (cp *Copier) inShard(key string) bool {
+	n := cp.cfg.Run.ShardCount
+	return n <= 1 || keyShard(key, n) == cp.cfg.Run.ShardIndex
+}
+
+// object was modified since the last successful run, see RunOptions.SinceLastRun
+func (cp *Copier) modifiedSince(obj Object) bool {
+	return cp.since.IsZero() || !obj.LastModified.Before(cp.since)
+}
+
+// object is processed by this copy: it belongs to its shard and was modified since the last run
+func (cp *Copier) selected(obj Object) bool {
+	return cp.inShard(obj.Key) && cp.modifiedSince(obj)
+}
+
+// shard of key: FNV-1a hash of the key modulo number of shards
+func keyShard(key string, shards int) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(shards))
+}
@@ -0,0 +1,64 @@
+package s3copy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// hook commands are run with sh -c, object is described with environment variables
+func hookCommand(ctx context.Context, command, bucket string, obj Object, extra ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Env = append(os.Environ(),
+		"S3_COPY_DIR_BUCKET="+bucket,
+		"S3_COPY_DIR_KEY="+obj.Key,
+		"S3_COPY_DIR_SIZE="+strconv.FormatInt(obj.Size, 10),
+		"S3_COPY_DIR_ETAG="+obj.ETag,
+		"S3_COPY_DIR_CONTENT_TYPE="+obj.ContentType)
+	cmd.Env = append(cmd.Env, extra...)
+	return cmd
+}
+
+// run hook, error includes the end of its stderr
+func runHook(cmd *exec.Cmd) error {
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if len(msg) > 512 {
+			msg = msg[len(msg)-512:]
+		}
+		if msg != "" {
+			return fmt.Errorf("%s: %s", err, msg)
+		}
+		return err
+	}
+	return nil
+}
+
+// transform running shell command, content is piped through its stdin and stdout
+func hookTransform(command, bucket string) Transform {
+	return func(ctx context.Context, obj Object, r io.Reader, w io.Writer) (Object, error) {
+		cmd := hookCommand(ctx, command, bucket, obj)
+		cmd.Stdin, cmd.Stdout = r, w
+		if err := runHook(cmd); err != nil {
+			return Object{}, fmt.Errorf("transform_hook: %s", err)
+		}
+		return obj, nil
+	}
+}
+
+// run post_copy_hook for copied object, failed hook is logged and doesn't fail the object
+func (cp *Copier) postCopy(obj Object, result string, size int64) {
+	cmd := hookCommand(cp.ctx, cp.cfg.Options.PostCopyHook, cp.bucket, obj,
+		"S3_COPY_DIR_RESULT="+result, "S3_COPY_DIR_BYTES="+strconv.FormatInt(size, 10))
+	cmd.Stdout = os.Stderr
+	if err := runHook(cmd); err != nil {
+		logError("post_copy_hook of '%s/%s': %s", cp.bucket, obj.Key, err)
+	}
+}
@@ -0,0 +1,69 @@
+package s3copy
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// http client of object stores without overall timeout, bodies of large objects are streamed
+var storeHTTPClient = &http.Client{}
+
+// return non 2xx response as statusError with the beginning of response body, body is closed
+func checkResponse(resp *http.Response) error {
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+	defer resp.Body.Close()
+	b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+	status := resp.Status
+	if s := strings.TrimSpace(string(b)); s != "" {
+		status += ": " + s
+	}
+	return &statusError{resp.StatusCode, status}
+}
+
+// send request of object store, non 2xx responses are returned as statusError
+func doRequest(req *http.Request) (*http.Response, error) {
+	resp, err := storeHTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkResponse(resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// reader of request body with known length, empty body is sent without chunked encoding
+func requestBody(req *http.Request, r io.Reader, size int64) {
+	req.ContentLength = size
+	if size == 0 {
+		req.Body = http.NoBody
+		return
+	}
+	req.Body = ioutil.NopCloser(io.LimitReader(r, size))
+}
+
+// percent-encode key for url path, slashes are kept
+func escapeKey(key string) string {
+	parts := strings.Split(key, "/")
+	for i, p := range parts {
+		parts[i] = url.PathEscape(p)
+	}
+	return strings.Join(parts, "/")
+}
+
+// size of object in response, size of ranged response is taken from Content-Range
+func responseSize(h http.Header) int64 {
+	size, _ := strconv.ParseInt(h.Get("Content-Length"), 10, 64)
+	if cr := h.Get("Content-Range"); cr != "" {
+		if i := strings.LastIndex(cr, "/"); i >= 0 {
+			size, _ = strconv.ParseInt(cr[i+1:], 10, 64)
+		}
+	}
+	return size
+}
@@ -0,0 +1,451 @@
+package s3copy
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// states of a job
+const (
+	JobQueued   = "queued"
+	JobRunning  = "running"
+	JobFinished = "finished" // copy ran, result is in the report
+	JobFailed   = "failed"   // copy couldn't start
+	JobCanceled = "canceled"
+)
+
+var (
+	ErrJobNotFound = errors.New("job not found")
+	ErrJobDone     = errors.New("job is already done")
+	ErrJobActive   = errors.New("job is queued or running")
+	ErrJobIdle     = errors.New("job isn't running")
+)
+
+// copy job submitted to job manager: config of the copy and settings of its run
+type JobSpec struct {
+	Config Config     `json:"config"`
+	Run    JobOptions `json:"run"`
+}
+
+// settings of job run, same as flags of copy command
+type JobOptions struct {
+	Sync            bool     `json:"sync"`
+	Heal            bool     `json:"heal"`
+	Progress        bool     `json:"progress"`
+	RetryFailed     bool     `json:"retry_failed"`
+	MaxErrors       int64    `json:"max_errors"`
+	Retries         *int     `json:"retries,omitempty"`     // 3 if not set
+	RetryDelay      string   `json:"retry_delay,omitempty"` // 1s if not set
+	ReconcilePasses int      `json:"reconcile_passes"`
+	Compare         bool     `json:"compare"`
+	SinceLastRun    bool     `json:"since_last_run"`
+	Include         []string `json:"include,omitempty"`
+	Exclude         []string `json:"exclude,omitempty"`
+	BandwidthLimit  string   `json:"bandwidth_limit,omitempty"`
+}
+
+// set run options of config, returns options of NewCopier
+func (o *JobOptions) apply(c *Config) ([]Option, error) {
+	c.Run = RunOptions{Sync: o.Sync, Heal: o.Heal, Progress: o.Progress, RetryFailed: o.RetryFailed,
+		MaxErrors: o.MaxErrors, Retries: 3, RetryDelay: time.Second, ReconcilePasses: o.ReconcilePasses,
+		Compare: o.Compare, SinceLastRun: o.SinceLastRun}
+	if o.Retries != nil {
+		c.Run.Retries = *o.Retries
+	}
+	if o.RetryDelay != "" {
+		d, err := time.ParseDuration(o.RetryDelay)
+		if err != nil {
+			return nil, fmt.Errorf("invalid retry_delay: %s", err)
+		}
+		c.Run.RetryDelay = d
+	}
+	var opts []Option
+	if len(o.Include) > 0 {
+		if err := ValidatePatterns(o.Include...); err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithFilters(Include(o.Include...)))
+	}
+	if len(o.Exclude) > 0 {
+		if err := ValidatePatterns(o.Exclude...); err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithFilters(Exclude(o.Exclude...)))
+	}
+	if o.BandwidthLimit != "" {
+		limit, err := ParseByteSize(o.BandwidthLimit)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bandwidth_limit: %s", err)
+		}
+		opts = append(opts, WithBandwidthLimit(limit))
+	}
+	return opts, nil
+}
+
+// job and its state, progress is live while job is running
+type Job struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	// name of scheduled job which submitted the job, see RunSchedule
+	Schedule string     `json:"schedule,omitempty"`
+	Error    string     `json:"error,omitempty"`
+	Created  time.Time  `json:"created"`
+	Started  *time.Time `json:"started,omitempty"`
+	Finished *time.Time `json:"finished,omitempty"`
+	Progress *Progress  `json:"progress,omitempty"`
+	Report   *Report    `json:"report,omitempty"`
+	Spec     JobSpec    `json:"spec"`
+}
+
+// job is queued or running
+func (j Job) active() bool {
+	return j.Status == JobQueued || j.Status == JobRunning
+}
+
+// job with secrets of its config removed
+func (j Job) redacted() Job {
+	c := &j.Spec.Config
+	// slices are shared with the job
+	c.Sources = append([]Endpoint(nil), c.Sources...)
+	c.Destinations = append([]Endpoint(nil), c.Destinations...)
+	endpoints := []*Endpoint{&c.Source, &c.Destination}
+	for i := range c.Sources {
+		endpoints = append(endpoints, &c.Sources[i])
+	}
+	for i := range c.Destinations {
+		endpoints = append(endpoints, &c.Destinations[i])
+	}
+	for _, e := range endpoints {
+		if e.SecretKey != "" {
+			e.SecretKey = "<redacted>"
+		}
+	}
+	if c.Options.WebhookSecret != "" {
+		c.Options.WebhookSecret = "<redacted>"
+	}
+	return j
+}
+
+type jobEntry struct {
+	Job
+	cp       *Copier
+	cancel   context.CancelFunc
+	canceled bool
+}
+
+// runs submitted copy jobs, up to maxRunning at a time, in order of submission.
+// jobs are persisted as json files in dir, so queued and interrupted jobs are
+// started again when manager is opened after restart
+type JobManager struct {
+	dir        string
+	maxRunning int
+
+	mu       sync.Mutex
+	jobs     map[string]*jobEntry
+	running  int
+	shutdown bool
+	wg       sync.WaitGroup
+}
+
+func OpenJobManager(dir string, maxRunning int) (*JobManager, error) {
+	if maxRunning < 1 {
+		maxRunning = 1
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	m := &JobManager{dir: dir, maxRunning: maxRunning, jobs: map[string]*jobEntry{}}
+	for _, file := range files {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		e := &jobEntry{}
+		if err := json.Unmarshal(b, &e.Job); err != nil {
+			return nil, fmt.Errorf("loading job '%s': %s", file, err)
+		}
+		if e.Status == JobRunning {
+			logInfo("job %s was interrupted by restart, queued again", e.ID)
+			e.Status, e.Started, e.Progress = JobQueued, nil, nil
+			if err := m.save(e); err != nil {
+				return nil, err
+			}
+		}
+		m.jobs[e.ID] = e
+	}
+
+	m.mu.Lock()
+	m.schedule()
+	m.mu.Unlock()
+	return m, nil
+}
+
+// write job file atomically, file contains credentials of the config
+func (m *JobManager) save(e *jobEntry) error {
+	b, err := json.MarshalIndent(e.Job, "", "    ")
+	if err != nil {
+		return err
+	}
+	file := filepath.Join(m.dir, e.ID+".json")
+	if err := ioutil.WriteFile(file+".tmp", b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(file+".tmp", file)
+}
+
+// save job, failure is logged since job state in memory is still valid
+func (m *JobManager) persist(e *jobEntry) {
+	if err := m.save(e); err != nil {
+		logError("saving job %s: %s", e.ID, err)
+	}
+}
+
+// id sorts in order of submission
+func newJobID() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return time.Now().UTC().Format("20060102T150405") + "-" + hex.EncodeToString(b)
+}
+
+// queue job, invalid run options are rejected
+func (m *JobManager) Submit(spec JobSpec) (Job, error) {
+	return m.submit(spec, "")
+}
+
+func (m *JobManager) submit(spec JobSpec, schedule string) (Job, error) {
+	c := spec.Config
+	if err := c.Validate(); err != nil {
+		return Job{}, err
+	}
+	if _, err := spec.Run.apply(&c); err != nil {
+		return Job{}, err
+	}
+	e := &jobEntry{Job: Job{ID: newJobID(), Status: JobQueued, Schedule: schedule, Created: time.Now().UTC(), Spec: spec}}
+	if err := m.save(e); err != nil {
+		return Job{}, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.jobs[e.ID] = e
+	logInfo("job %s queued: '%s/%s' from %s to %s", e.ID, c.Options.Bucket, c.Options.Directory, c.Source.String(), c.Destination.String())
+	m.schedule()
+	return e.redacted(), nil
+}
+
+// job with live progress if it's running, secrets of its config are redacted
+func (m *JobManager) Get(id string) (Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.jobs[id]
+	if !ok {
+		return Job{}, ErrJobNotFound
+	}
+	return m.view(e), nil
+}
+
+// all jobs in order of submission
+func (m *JobManager) List() []Job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	jobs := make([]Job, 0, len(m.jobs))
+	for _, e := range m.jobs {
+		jobs = append(jobs, m.view(e))
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].ID < jobs[j].ID })
+	return jobs
+}
+
+func (m *JobManager) view(e *jobEntry) Job {
+	j := e.Job
+	if e.Status == JobRunning && e.cp != nil {
+		p := e.cp.Progress()
+		j.Progress = &p
+	}
+	return j.redacted()
+}
+
+// live status of running job, same as status endpoint of copy command
+func (m *JobManager) status(id string) (*Copier, time.Time, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.jobs[id]
+	if !ok {
+		return nil, time.Time{}, ErrJobNotFound
+	}
+	if e.Status != JobRunning || e.cp == nil {
+		return nil, time.Time{}, ErrJobIdle
+	}
+	return e.cp, *e.Started, nil
+}
+
+// cancel queued job or stop running one, in-flight copies of running job are interrupted
+func (m *JobManager) Cancel(id string) (Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.jobs[id]
+	if !ok {
+		return Job{}, ErrJobNotFound
+	}
+	switch e.Status {
+	case JobQueued:
+		now := time.Now().UTC()
+		e.Status, e.Finished = JobCanceled, &now
+		m.persist(e)
+		logInfo("job %s canceled", id)
+	case JobRunning:
+		e.canceled = true
+		if e.cp != nil {
+			e.cp.Stop()
+		}
+		e.cancel()
+	default:
+		return Job{}, ErrJobDone
+	}
+	return m.view(e), nil
+}
+
+// remove record of job which is done
+func (m *JobManager) Remove(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.jobs[id]
+	if !ok {
+		return ErrJobNotFound
+	}
+	if e.active() {
+		return ErrJobActive
+	}
+	if err := os.Remove(filepath.Join(m.dir, id+".json")); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	delete(m.jobs, id)
+	return nil
+}
+
+// stop running jobs and wait for them, in-flight copies are cancelled after grace period.
+// stopped jobs stay queued, so they are started again by the next manager of dir
+func (m *JobManager) Shutdown(grace time.Duration) {
+	m.mu.Lock()
+	m.shutdown = true
+	for _, e := range m.jobs {
+		if e.Status == JobRunning && e.cp != nil {
+			e.cp.Stop()
+		}
+	}
+	m.mu.Unlock()
+
+	doneCh := make(chan struct{})
+	go func() {
+		m.wg.Wait()
+		close(doneCh)
+	}()
+	select {
+	case <-doneCh:
+		return
+	case <-time.After(grace):
+	}
+	m.mu.Lock()
+	for _, e := range m.jobs {
+		if e.Status == JobRunning {
+			e.cancel()
+		}
+	}
+	m.mu.Unlock()
+	<-doneCh
+}
+
+// start queued jobs while there are free slots, called with lock held
+func (m *JobManager) schedule() {
+	if m.shutdown {
+		return
+	}
+	var queued []*jobEntry
+	for _, e := range m.jobs {
+		if e.Status == JobQueued {
+			queued = append(queued, e)
+		}
+	}
+	sort.Slice(queued, func(i, j int) bool { return queued[i].ID < queued[j].ID })
+	for _, e := range queued {
+		if m.running >= m.maxRunning {
+			return
+		}
+		now := time.Now().UTC()
+		e.Status, e.Started, e.Error, e.Report, e.Progress = JobRunning, &now, "", nil, nil
+		var ctx context.Context
+		ctx, e.cancel = context.WithCancel(context.Background())
+		m.persist(e)
+		m.running++
+		m.wg.Add(1)
+		go m.run(ctx, e)
+	}
+}
+
+func (m *JobManager) run(ctx context.Context, e *jobEntry) {
+	defer m.wg.Done()
+	logInfo("job %s started", e.ID)
+	c := e.Spec.Config
+	opts, err := e.Spec.Run.apply(&c)
+	var cp *Copier
+	if err == nil {
+		cp, err = NewCopier(&c, opts...)
+	}
+	var res *Result
+	if err == nil {
+		m.mu.Lock()
+		e.cp = cp
+		stop := e.canceled || m.shutdown
+		m.mu.Unlock()
+		if stop {
+			cp.Stop()
+		}
+		res, err = cp.Run(ctx)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.running--
+	e.cp = nil
+	e.cancel()
+	now := time.Now().UTC()
+	e.Finished = &now
+	if cp != nil {
+		p := cp.Progress()
+		e.Progress = &p
+	}
+	switch {
+	case e.canceled:
+		e.Status = JobCanceled
+	case m.shutdown:
+		// job is started again by the next manager
+		e.Status, e.Started, e.Finished = JobQueued, nil, nil
+	case err != nil:
+		e.Status, e.Error = JobFailed, err.Error()
+	default:
+		e.Status = JobFinished
+	}
+	if res != nil {
+		e.Report = res.Report
+	}
+	m.persist(e)
+	if e.Error != "" {
+		logError("job %s failed: %s", e.ID, e.Error)
+	} else {
+		logInfo("job %s %s", e.ID, e.Status)
+	}
+	m.schedule()
+}
@@ -0,0 +1,135 @@
+package s3copy
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// REST API of job manager:
+//
+//	GET    /jobs             list jobs
+//	POST   /jobs             submit JobSpec, returns queued job
+//	GET    /jobs/<id>        job with progress and report
+//	GET    /jobs/<id>/status live status of running job, same as /status of copy command
+//	POST   /jobs/<id>/control change concurrency, bandwidth limit or pause of running job, same as
+//	                         /control of copy command
+//	POST   /jobs/<id>/cancel cancel queued or running job
+//	DELETE /jobs/<id>        remove record of finished job
+//
+// requests must carry "Authorization: Bearer <token>" if token isn't empty.
+// dashboard page served on / asks for the token
+func (m *JobManager) Handler(token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/jobs", m.handleJobs)
+	mux.HandleFunc("/jobs/", m.handleJob)
+	dashboard := dashboardHandler("jobs")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			dashboard.ServeHTTP(w, r)
+			return
+		}
+		if !validToken(r, token) {
+			writeJSONError(w, http.StatusUnauthorized, "invalid or missing token")
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// request carries bearer token, any request is valid if token is empty
+func validToken(r *http.Request, token string) bool {
+	if token == "" {
+		return true
+	}
+	got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "    ")
+	logErr(enc.Encode(v))
+}
+
+func writeJSONError(w http.ResponseWriter, code int, msg string) {
+	writeJSON(w, code, map[string]string{"error": msg})
+}
+
+// status of job manager error
+func jobErrorStatus(err error) int {
+	switch err {
+	case ErrJobNotFound:
+		return http.StatusNotFound
+	case ErrJobDone, ErrJobActive, ErrJobIdle:
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+func (m *JobManager) handleJobs(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		writeJSON(w, http.StatusOK, m.List())
+	case "POST":
+		var spec JobSpec
+		dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&spec); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid job: "+err.Error())
+			return
+		}
+		job, err := m.Submit(spec)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusCreated, job)
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (m *JobManager) handleJob(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/jobs/"), "/")
+	id := parts[0]
+	var job Job
+	var err error
+	switch {
+	case len(parts) == 1 && r.Method == "GET":
+		job, err = m.Get(id)
+	case len(parts) == 1 && r.Method == "DELETE":
+		if err = m.Remove(id); err == nil {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	case len(parts) == 2 && parts[1] == "cancel" && r.Method == "POST":
+		job, err = m.Cancel(id)
+	case len(parts) == 2 && parts[1] == "status" && r.Method == "GET":
+		var cp *Copier
+		var start time.Time
+		if cp, start, err = m.status(id); err == nil {
+			cp.writeStatus(w, r, start)
+			return
+		}
+	case len(parts) == 2 && parts[1] == "control" && r.Method == "POST":
+		var cp *Copier
+		var start time.Time
+		if cp, start, err = m.status(id); err == nil {
+			cp.handleControl(w, r, start)
+			return
+		}
+	default:
+		writeJSONError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if err != nil {
+		writeJSONError(w, jobErrorStatus(err), err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
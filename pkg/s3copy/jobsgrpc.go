@@ -0,0 +1,307 @@
+package s3copy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// gRPC service of job manager, defined in jobs.proto
+const grpcService = "/s3copydir.jobs.v1.Jobs/"
+
+// how often progress of watched job is checked, event is sent only if it changed
+const grpcWatchInterval = time.Second
+
+// limit of request message, same as limit of REST API body
+const grpcMaxMessage = 1 << 20
+
+// gRPC status codes
+const (
+	grpcOK                 = 0
+	grpcInvalidArgument    = 3
+	grpcNotFound           = 5
+	grpcFailedPrecondition = 9
+	grpcUnimplemented      = 12
+	grpcInternal           = 13
+	grpcUnauthenticated    = 16
+)
+
+type grpcError struct {
+	code int
+	msg  string
+}
+
+func (e *grpcError) Error() string {
+	return e.msg
+}
+
+// gRPC status of job manager error
+func jobGRPCError(err error) *grpcError {
+	switch err {
+	case ErrJobNotFound:
+		return &grpcError{grpcNotFound, err.Error()}
+	case ErrJobDone, ErrJobActive, ErrJobIdle:
+		return &grpcError{grpcFailedPrecondition, err.Error()}
+	}
+	return &grpcError{grpcInternal, err.Error()}
+}
+
+// gRPC API of job manager, see jobs.proto. the handler must be served over HTTP/2,
+// either with TLS or with h2c. SubmitJob and WatchJob stream events of the job until
+// it's done, closing the stream doesn't cancel the job.
+// requests must carry "authorization: Bearer <token>" metadata if token isn't empty
+func (m *JobManager) GRPCHandler(token string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 2 || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+			http.Error(w, "gRPC over HTTP/2 is expected", http.StatusUnsupportedMediaType)
+			return
+		}
+		w.Header().Set("Content-Type", "application/grpc")
+		w.WriteHeader(http.StatusOK)
+		err := m.serveGRPC(w, r, token)
+		ge, ok := err.(*grpcError)
+		if err != nil && !ok {
+			ge = &grpcError{grpcInternal, err.Error()}
+		}
+		if ge == nil {
+			ge = &grpcError{grpcOK, ""}
+		}
+		w.Header().Set(http.TrailerPrefix+"Grpc-Status", strconv.Itoa(ge.code))
+		if ge.msg != "" {
+			w.Header().Set(http.TrailerPrefix+"Grpc-Message", grpcEscape(ge.msg))
+		}
+	})
+}
+
+func (m *JobManager) serveGRPC(w http.ResponseWriter, r *http.Request, token string) error {
+	if !validToken(r, token) {
+		return &grpcError{grpcUnauthenticated, "invalid or missing token"}
+	}
+	method := strings.TrimPrefix(r.URL.Path, grpcService)
+	if method == r.URL.Path || r.Method != "POST" {
+		return &grpcError{grpcUnimplemented, "unknown method " + r.URL.Path}
+	}
+	msg, err := readGRPCMessage(r.Body)
+	if err != nil {
+		return err
+	}
+	fields, err := pbStrings(msg)
+	if err != nil {
+		return &grpcError{grpcInvalidArgument, "invalid request: " + err.Error()}
+	}
+
+	var job Job
+	switch method {
+	case "SubmitJob":
+		var spec JobSpec
+		dec := json.NewDecoder(strings.NewReader(fields[1]))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&spec); err != nil {
+			return &grpcError{grpcInvalidArgument, "invalid job: " + err.Error()}
+		}
+		if job, err = m.Submit(spec); err != nil {
+			return &grpcError{grpcInvalidArgument, err.Error()}
+		}
+		return m.watchGRPC(w, r, job.ID)
+	case "WatchJob":
+		return m.watchGRPC(w, r, fields[1])
+	case "GetJob":
+		job, err = m.Get(fields[1])
+	case "CancelJob":
+		job, err = m.Cancel(fields[1])
+	default:
+		return &grpcError{grpcUnimplemented, "unknown method " + r.URL.Path}
+	}
+	if err != nil {
+		return jobGRPCError(err)
+	}
+	return writeGRPCMessage(w, jobEvent(job))
+}
+
+// stream events of job until it's done or client goes away
+func (m *JobManager) watchGRPC(w http.ResponseWriter, r *http.Request, id string) error {
+	ticker := time.NewTicker(grpcWatchInterval)
+	defer ticker.Stop()
+	var last []byte
+	for {
+		job, err := m.Get(id)
+		if err != nil {
+			return jobGRPCError(err)
+		}
+		if ev := jobEvent(job); !bytes.Equal(ev, last) {
+			if err := writeGRPCMessage(w, ev); err != nil {
+				return err
+			}
+			last = ev
+		}
+		if !job.active() {
+			return nil
+		}
+		select {
+		case <-r.Context().Done():
+			return r.Context().Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// JobEvent message of job
+func jobEvent(j Job) []byte {
+	var b []byte
+	b = pbAppendString(b, 1, j.ID)
+	b = pbAppendString(b, 2, j.Status)
+	b = pbAppendString(b, 3, j.Error)
+	if p := j.Progress; p != nil {
+		b = pbAppendInt(b, 4, p.Processed)
+		b = pbAppendInt(b, 5, p.Total)
+		b = pbAppendInt(b, 6, p.TotalBytes)
+		b = pbAppendInt(b, 7, p.Copied)
+		b = pbAppendInt(b, 8, p.Skipped)
+		b = pbAppendInt(b, 9, p.Failed)
+		b = pbAppendInt(b, 10, p.Bytes)
+		b = pbAppendInt(b, 11, int64(p.ActiveWorkers))
+		b = pbAppendInt(b, 12, int64(p.WorkerLimit))
+		if p.Paused {
+			b = pbAppendInt(b, 13, 1)
+		}
+	}
+	if j.Report != nil && !j.active() {
+		report, err := json.Marshal(j.Report)
+		logErr(err)
+		b = pbAppendString(b, 14, string(report))
+	}
+	return b
+}
+
+// read single uncompressed length-prefixed message of request
+func readGRPCMessage(r io.Reader) ([]byte, error) {
+	var hdr [5]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, &grpcError{grpcInvalidArgument, "reading request: " + err.Error()}
+	}
+	if hdr[0] != 0 {
+		return nil, &grpcError{grpcUnimplemented, "compressed messages aren't supported"}
+	}
+	size := binary.BigEndian.Uint32(hdr[1:])
+	if size > grpcMaxMessage {
+		return nil, &grpcError{grpcInvalidArgument, fmt.Sprintf("request message exceeds %d bytes", grpcMaxMessage)}
+	}
+	msg := make([]byte, size)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, &grpcError{grpcInvalidArgument, "reading request: " + err.Error()}
+	}
+	return msg, nil
+}
+
+func writeGRPCMessage(w http.ResponseWriter, msg []byte) error {
+	hdr := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(hdr[1:], uint32(len(msg)))
+	if _, err := w.Write(append(hdr, msg...)); err != nil {
+		return err
+	}
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
+
+// percent-encode grpc-message trailer
+func grpcEscape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c < 0x20 || c > 0x7e || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// protobuf wire format, only what messages of jobs.proto need.
+// fields with zero values are omitted as in proto3
+
+func pbAppendVarint(b []byte, v uint64) []byte {
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
+
+func pbAppendInt(b []byte, field int, v int64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = pbAppendVarint(b, uint64(field)<<3)
+	return pbAppendVarint(b, uint64(v))
+}
+
+func pbAppendString(b []byte, field int, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = pbAppendVarint(b, uint64(field)<<3|2)
+	b = pbAppendVarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+var errPBTruncated = errors.New("truncated message")
+
+func pbVarint(b []byte) (uint64, int, error) {
+	var v uint64
+	for i := 0; i < len(b) && i < 10; i++ {
+		v |= uint64(b[i]&0x7f) << (7 * uint(i))
+		if b[i] < 0x80 {
+			return v, i + 1, nil
+		}
+	}
+	return 0, 0, errPBTruncated
+}
+
+// length-delimited fields of message by number, other fields are skipped
+func pbStrings(b []byte) (map[int]string, error) {
+	fields := map[int]string{}
+	for len(b) > 0 {
+		tag, n, err := pbVarint(b)
+		if err != nil {
+			return nil, err
+		}
+		b = b[n:]
+		switch tag & 7 {
+		case 0:
+			_, n, err = pbVarint(b)
+		case 1:
+			n = 8
+		case 2:
+			var size uint64
+			size, n, err = pbVarint(b)
+			if err == nil && size > uint64(len(b)-n) {
+				err = errPBTruncated
+			}
+			if err == nil {
+				fields[int(tag>>3)] = string(b[n : n+int(size)])
+				n += int(size)
+			}
+		case 5:
+			n = 4
+		default:
+			err = fmt.Errorf("unsupported wire type %d", tag&7)
+		}
+		if err == nil && n > len(b) {
+			err = errPBTruncated
+		}
+		if err != nil {
+			return nil, err
+		}
+		b = b[n:]
+	}
+	return fields, nil
+}
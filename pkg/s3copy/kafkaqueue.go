@@ -0,0 +1,182 @@
+package s3copy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	kafkaV2Type     = "application/vnd.kafka.v2+json"
+	kafkaBinaryType = "application/vnd.kafka.binary.v2+json"
+	kafkaJSONType   = "application/vnd.kafka.json.v2+json"
+	// longest wait of fetch request for new records, in milliseconds
+	kafkaFetchTimeout = "5000"
+)
+
+// consumer of kafka topic in consumer group through confluent rest proxy (v2 api).
+// fetched records are handled, then their offsets are committed before the next fetch
+type kafkaFeed struct {
+	url   string
+	user  string
+	pass  string
+	topic string
+	group string
+}
+
+func newKafkaFeed(rawurl, topic, group string) (*kafkaFeed, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("invalid kafka rest proxy url '%s'", rawurl)
+	}
+	f := &kafkaFeed{topic: topic, group: group}
+	if u.User != nil {
+		f.user = u.User.Username()
+		f.pass, _ = u.User.Password()
+		u.User = nil
+	}
+	f.url = strings.TrimSuffix(u.String(), "/")
+	return f, nil
+}
+
+type kafkaRecord struct {
+	Value []byte `json:"value"`
+}
+
+func (f *kafkaFeed) changes(ctx context.Context, prefix string) <-chan changeEvent {
+	ch := make(chan changeEvent)
+	go func() {
+		defer close(ch)
+		for ctx.Err() == nil {
+			err := f.consume(ctx, prefix, ch)
+			if ctx.Err() != nil {
+				return
+			}
+			if classifyError(err) == errAuth {
+				select {
+				case ch <- changeEvent{Err: err}:
+				case <-ctx.Done():
+				}
+				return
+			}
+			logWarn("consuming kafka topic '%s': %s, retrying in %s", f.topic, err, listenRetryDelay)
+			select {
+			case <-time.After(listenRetryDelay):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+// create consumer instance, subscribe and send records to ch until request fails.
+// instance is removed on return, so its partitions are re-assigned right away
+func (f *kafkaFeed) consume(ctx context.Context, prefix string, ch chan<- changeEvent) error {
+	var inst struct {
+		BaseURI string `json:"base_uri"`
+	}
+	err := f.call(ctx, "POST", f.url+"/consumers/"+url.PathEscape(f.group), kafkaV2Type, map[string]string{
+		"format":             "binary",
+		"auto.offset.reset":  "earliest",
+		"auto.commit.enable": "false",
+	}, &inst)
+	if err != nil {
+		return err
+	}
+	defer f.call(context.Background(), "DELETE", inst.BaseURI, kafkaV2Type, nil, nil)
+	if err := f.call(ctx, "POST", inst.BaseURI+"/subscription", kafkaV2Type, map[string][]string{"topics": {f.topic}}, nil); err != nil {
+		return err
+	}
+	logInfo("consuming keys from kafka topic '%s', consumer group '%s'", f.topic, f.group)
+
+	for {
+		var records []kafkaRecord
+		if err := f.call(ctx, "GET", inst.BaseURI+"/records?timeout="+kafkaFetchTimeout, kafkaV2Type, nil, &records); err != nil {
+			return err
+		}
+		if len(records) == 0 {
+			continue
+		}
+		var wg sync.WaitGroup
+		for _, rec := range records {
+			ev, ok := acceptedQueueEvent(rec.Value, prefix)
+			if !ok {
+				continue
+			}
+			// failed objects are reported to results topic and failed_file,
+			// they don't block the partition
+			wg.Add(1)
+			ev.done = func(bool) { wg.Done() }
+			select {
+			case ch <- ev:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		doneCh := make(chan struct{})
+		go func() {
+			wg.Wait()
+			close(doneCh)
+		}()
+		select {
+		case <-doneCh:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		// empty body commits offsets of all fetched records
+		if err := f.call(ctx, "POST", inst.BaseURI+"/offsets", kafkaV2Type, nil, nil); err != nil {
+			return err
+		}
+	}
+}
+
+func (f *kafkaFeed) publish(topic, key string, value []byte) error {
+	body := map[string][]map[string]interface{}{
+		"records": {{"key": key, "value": json.RawMessage(value)}},
+	}
+	return f.call(context.Background(), "POST", f.url+"/topics/"+url.PathEscape(topic), kafkaJSONType, body, nil)
+}
+
+// json request of rest proxy, response is decoded into res
+func (f *kafkaFeed) call(ctx context.Context, method, u, contentType string, body, res interface{}) error {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, u, r)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", contentType)
+	// records are fetched with base64 encoded values
+	if strings.HasSuffix(req.URL.Path, "/records") {
+		req.Header.Set("Accept", kafkaBinaryType)
+	} else {
+		req.Header.Set("Accept", kafkaV2Type)
+	}
+	if f.user != "" {
+		req.SetBasicAuth(f.user, f.pass)
+	}
+	resp, err := doRequest(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if res == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(res)
+}
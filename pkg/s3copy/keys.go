@@ -0,0 +1,110 @@
+package s3copy
+
+import (
+	"fmt"
+	"golang.org/x/text/unicode/norm"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// steps of key normalization policy, see Options.KeyNormalization
+const (
+	// "a//b" and "/a" become "a/b" and "a", filesystem-like destinations can't store such keys
+	KeyCollapseSlashes = "collapse-slashes"
+	// unicode NFC form, e.g. decomposed "é" of files uploaded from macOS becomes "é"
+	KeyNFC = "nfc"
+	// %-sequences left by gateways which encoded keys twice are decoded, "a%20b" becomes "a b"
+	KeyDecodePercent = "decode-percent"
+)
+
+// function mapping source key to destination key by steps of the policy, applied in order.
+// nil if there are no steps, so keys are copied as they are
+func newKeyNormalizer(steps []string) (func(key string) string, error) {
+	if len(steps) == 0 {
+		return nil, nil
+	}
+	var funcs []func(string) string
+	for _, step := range steps {
+		switch step {
+		case KeyCollapseSlashes:
+			funcs = append(funcs, collapseSlashes)
+		case KeyNFC:
+			funcs = append(funcs, norm.NFC.String)
+		case KeyDecodePercent:
+			funcs = append(funcs, decodePercent)
+		default:
+			return nil, fmt.Errorf("unknown key normalization '%s', must be %s, %s or %s",
+				step, KeyCollapseSlashes, KeyNFC, KeyDecodePercent)
+		}
+	}
+	return func(key string) string {
+		for _, f := range funcs {
+			key = f(key)
+		}
+		return key
+	}, nil
+}
+
+func collapseSlashes(key string) string {
+	for strings.Contains(key, "//") {
+		key = strings.Replace(key, "//", "/", -1)
+	}
+	return strings.TrimPrefix(key, "/")
+}
+
+// key with invalid %-sequence is kept as it is. %2F stays encoded, decoded slash would
+// store "a%2Fb" under key of "a/b"
+func decodePercent(key string) string {
+	var b strings.Builder
+	for i := 0; i < len(key); i++ {
+		if key[i] != '%' {
+			b.WriteByte(key[i])
+			continue
+		}
+		if i+2 >= len(key) {
+			return key
+		}
+		c, err := strconv.ParseUint(key[i+1:i+3], 16, 8)
+		if err != nil {
+			return key
+		}
+		if c == '/' {
+			b.WriteString(key[i : i+3])
+		} else {
+			b.WriteByte(byte(c))
+		}
+		i += 2
+	}
+	return b.String()
+}
+
+// check key maps to a file path without changes. path cleaning would store "a//b",
+// "a/./b" or "a/../b" under another key, colliding with other objects
+func checkPathKey(key, store string) error {
+	if path.Clean("/"+key) == "/" || strings.HasSuffix(key, "/") {
+		return fmt.Errorf("invalid key '%s' for %s store", key, store)
+	}
+	if path.Clean("/"+key) != "/"+key {
+		hint := ""
+		if strings.HasPrefix(key, "/") || strings.Contains(key, "//") {
+			hint = ", key_normalization " + KeyCollapseSlashes + " fixes it"
+		}
+		return fmt.Errorf("key '%s' can't be stored in %s store without changing it%s", key, store, hint)
+	}
+	return nil
+}
+
+// key of object in destination, under generation of the run in backup mode
+func (cp *Copier) destKey(key string) string {
+	if cp.normalizeKey != nil {
+		key = cp.normalizeKey(key)
+	}
+	if cp.obfuscator != nil {
+		key = cp.obfuscator.key(key)
+	}
+	if cp.backup != nil {
+		key = cp.backup.key(cp.backup.generation().Name, key)
+	}
+	return key
+}
@@ -0,0 +1,69 @@
+package s3copy
+
+import "testing"
+
+func TestKeyNormalizer(t *testing.T) {
+	tests := []struct {
+		steps []string
+		key   string
+		want  string
+	}{
+		{[]string{KeyCollapseSlashes}, "a//b", "a/b"},
+		{[]string{KeyCollapseSlashes}, "/a///b/", "a/b/"},
+		{[]string{KeyCollapseSlashes}, "a b/c", "a b/c"},
+		{[]string{KeyNFC}, "cafe\u0301", "caf\u00e9"},
+		{[]string{KeyNFC}, "caf\u00e9", "caf\u00e9"},
+		{[]string{KeyDecodePercent}, "a%20b", "a b"},
+		{[]string{KeyDecodePercent}, "a b", "a b"},
+		{[]string{KeyDecodePercent}, "a%2Fb", "a%2Fb"},
+		{[]string{KeyDecodePercent}, "a%2fb%20c", "a%2fb c"},
+		{[]string{KeyDecodePercent}, "100%", "100%"},
+		{[]string{KeyDecodePercent}, "a%zzb%20", "a%zzb%20"},
+		{[]string{KeyDecodePercent}, "a%2", "a%2"},
+		{[]string{KeyDecodePercent, KeyNFC}, "caf%65%CC%81", "caf\u00e9"},
+		{[]string{KeyDecodePercent, KeyCollapseSlashes}, "a%2F%2Fb//c", "a%2F%2Fb/c"},
+	}
+	for _, tt := range tests {
+		normalize, err := newKeyNormalizer(tt.steps)
+		if err != nil {
+			t.Fatalf("newKeyNormalizer(%q): %s", tt.steps, err)
+		}
+		if got := normalize(tt.key); got != tt.want {
+			t.Errorf("%q of %q = %q, want %q", tt.steps, tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestKeyNormalizerSteps(t *testing.T) {
+	if normalize, err := newKeyNormalizer(nil); normalize != nil || err != nil {
+		t.Errorf("no steps = %v, %v, want nil normalizer", normalize != nil, err)
+	}
+	if _, err := newKeyNormalizer([]string{"lowercase"}); err == nil {
+		t.Error("unknown step is accepted")
+	}
+}
+
+func TestCheckPathKey(t *testing.T) {
+	tests := []struct {
+		key string
+		ok  bool
+	}{
+		{"a/b", true},
+		{"a b/c d", true},
+		{"cafe\u0301", true},
+		{"a%2Fb", true},
+		{"a//b", false},
+		{"/a", false},
+		{"a/./b", false},
+		{"a/../b", false},
+		{"a/", false},
+		{"", false},
+		{".", false},
+	}
+	for _, tt := range tests {
+		err := checkPathKey(tt.key, "local")
+		if (err == nil) != tt.ok {
+			t.Errorf("checkPathKey(%q) = %v, want ok %v", tt.key, err, tt.ok)
+		}
+	}
+}
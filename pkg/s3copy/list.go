@@ -0,0 +1,216 @@
+package s3copy
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+)
+
+// max number of keys S3 returns in a single ListObjectsV2 page
+const maxListPageSize = 1000
+
+// listed object or listing error
+type listEntry struct {
+	Object
+	Err error
+}
+
+// store keeping state of listings between pages, e.g. open files, released by listing which
+// stopped before its last page
+type listReleaser interface {
+	// token is the one of the next page listing would request
+	releaseListing(prefix, token string)
+}
+
+// release state of listing of store stopped before token of its next page
+func releaseListing(store ObjectStore, prefix, token string) {
+	if r, ok := store.(listReleaser); ok && token != "" {
+		r.releaseListing(prefix, token)
+	}
+}
+
+// list objects page by page with configurable page size.
+// continuation tokens of listed pages are passed to checkpoint (if set), so enumeration
+// of huge buckets can be resumed after restart, see listCheckpoint
+func listObjects(src ObjectStore, bucket, prefix string, pageSize int, checkpoint *listCheckpoint, doneCh <-chan struct{}) <-chan listEntry {
+	if pageSize <= 0 || pageSize > maxListPageSize {
+		pageSize = maxListPageSize
+	}
+
+	objCh := make(chan listEntry, pageSize)
+	go func() {
+		defer close(objCh)
+		// token of the next page, which isn't requested if listing stops early
+		var pending string
+		defer func() { releaseListing(src, prefix, pending) }()
+
+		token := checkpoint.token()
+		if token != "" {
+			logInfo("resuming listing of '%s/%s' from checkpoint '%s'", bucket, prefix, checkpoint.path)
+		}
+
+		for {
+			sp := startRequestSpan("LIST", nil, false, bucket, prefix)
+			countRequest(false, reqList)
+			objs, next, err := src.List(context.Background(), prefix, token, pageSize)
+			sp.setAttr(intAttr("s3_copy_dir.objects", int64(len(objs))))
+			sp.end(err)
+			if err != nil {
+				select {
+				case objCh <- listEntry{Err: err}:
+				case <-doneCh:
+				}
+				return
+			}
+
+			pending = next
+			checkpoint.fetched(token, next, objs)
+			logDebug("listed page of %d objects in '%s/%s'", len(objs), bucket, prefix)
+
+			for _, obj := range objs {
+				select {
+				case objCh <- listEntry{Object: obj}:
+				case <-doneCh:
+					return
+				}
+			}
+
+			if next == "" {
+				return
+			}
+			token, pending = next, ""
+		}
+	}()
+
+	return objCh
+}
+
+// page of listing with objects workers haven't finished yet
+type listPage struct {
+	token   string
+	pending int
+}
+
+// continuation token saved to checkpoint file is the one of the oldest page with objects
+// workers haven't finished, so resumed listing never starts past objects which weren't
+// copied. objects are buffered between listing and workers, the page being listed isn't
+// the one being copied
+type listCheckpoint struct {
+	sync.Mutex
+	path string
+	// token listing started from, loaded from the file
+	start string
+	pages []*listPage
+	// page of every listed object which isn't finished
+	pageOf map[string]*listPage
+	// token of the page following the last listed one
+	next  string
+	saved string
+}
+
+// checkpoint of listing saved to path, nil if path is empty
+func newListCheckpoint(path string) *listCheckpoint {
+	if path == "" {
+		return nil
+	}
+	start := loadListCheckpoint(path)
+	return &listCheckpoint{path: path, start: start, saved: start, pageOf: map[string]*listPage{}}
+}
+
+// token listing starts from, empty - from the beginning
+func (lc *listCheckpoint) token() string {
+	if lc == nil {
+		return ""
+	}
+	return lc.start
+}
+
+// record page listed with token, its objects are pending until they're done
+func (lc *listCheckpoint) fetched(token, next string, objs []Object) {
+	if lc == nil {
+		return
+	}
+	lc.Lock()
+	defer lc.Unlock()
+	page := &listPage{token: token, pending: len(objs)}
+	for _, obj := range objs {
+		lc.pageOf[obj.Key] = page
+	}
+	lc.pages = append(lc.pages, page)
+	lc.next = next
+	lc.advance()
+}
+
+// object of listing is finished by worker or passed over by dispatcher,
+// other keys aren't tracked and are ignored
+func (lc *listCheckpoint) done(key string) {
+	if lc == nil {
+		return
+	}
+	lc.Lock()
+	defer lc.Unlock()
+	page, ok := lc.pageOf[key]
+	if !ok {
+		return
+	}
+	delete(lc.pageOf, key)
+	page.pending--
+	lc.advance()
+}
+
+// save token of the oldest page with pending objects, or of the next page if all listed
+// pages are done. the last page is kept when listing ends, the checkpoint is cleared by the run
+func (lc *listCheckpoint) advance() {
+	for len(lc.pages) > 0 && lc.pages[0].pending == 0 {
+		lc.pages = lc.pages[1:]
+	}
+	token := lc.next
+	if len(lc.pages) > 0 {
+		token = lc.pages[0].token
+	}
+	if token == "" || token == lc.saved {
+		return
+	}
+	saveListCheckpoint(lc.path, token)
+	lc.saved = token
+}
+
+// read continuation token from checkpoint file, empty token means start from the beginning
+func loadListCheckpoint(path string) string {
+	if path == "" {
+		return ""
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logErr(err)
+		}
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// atomically replace checkpoint file with the given continuation token
+func saveListCheckpoint(path, token string) {
+	if path == "" {
+		return
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte(token+"\n"), 0644); err != nil {
+		logErr(err)
+		return
+	}
+	logErr(os.Rename(tmp, path))
+}
+
+// remove checkpoint file once the whole listing has been processed
+func clearListCheckpoint(path string) {
+	if path == "" {
+		return
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		logErr(err)
+	}
+}
@@ -0,0 +1,398 @@
+package s3copy
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// continuation tokens of pages served from listing cache, offset of the page in cached listing
+const listCacheToken = "list-cache:"
+
+// listing of source directory cached in local file, see Options.ListCache
+type ListCacheConfig struct {
+	// gzipped json lines with keys, sizes and ETags of source objects
+	File string `json:"file"`
+	// cached listing older than it is listed again from source, e.g. "1h"
+	TTL string `json:"ttl"`
+}
+
+// first line of cache file, cached listing is used only by config listing the same directory
+type listCacheHeader struct {
+	Source string    `json:"source"`
+	Bucket string    `json:"bucket"`
+	Prefix string    `json:"prefix"`
+	Listed time.Time `json:"listed"`
+}
+
+// object of cached listing
+type cachedObject struct {
+	Key          string    `json:"k"`
+	Size         int64     `json:"s"`
+	ETag         string    `json:"e"`
+	LastModified time.Time `json:"m"`
+	ContentType  string    `json:"t,omitempty"`
+}
+
+// source store serving listing of the directory from cache file while it's fresh. listing of
+// source is written to the cache as it's paged through, file is replaced once it's complete.
+// writes and deletes through the store remove the cache, it no longer matches source
+type listCacheStore struct {
+	ObjectStore
+	path    string
+	ttl     time.Duration
+	header  listCacheHeader
+	refresh bool
+
+	mu sync.Mutex
+	// open cache files of listings in progress by token of their next page, they're closed
+	// when listing stops early or when next page isn't requested within ttl
+	readers map[string]*listCacheReader
+	rec     *listCacheWriter
+}
+
+// source store with listing cache if it's configured. with refresh cached listing isn't used,
+// but listing of source is cached for later runs
+func newListCacheStore(src ObjectStore, c *Config, refresh bool) (ObjectStore, error) {
+	lc := c.Options.ListCache
+	if lc == nil {
+		return src, nil
+	}
+	ttl, err := time.ParseDuration(lc.TTL)
+	if err != nil {
+		return nil, err
+	}
+	return &listCacheStore{
+		ObjectStore: src, path: lc.File, ttl: ttl, refresh: refresh, readers: map[string]*listCacheReader{},
+		header: listCacheHeader{Source: c.sourcesString(), Bucket: c.Options.Bucket, Prefix: c.listPrefix()},
+	}, nil
+}
+
+// store behind listing cache, optional interfaces of stores are checked on it
+func uncached(store ObjectStore) ObjectStore {
+	if s, ok := store.(*listCacheStore); ok {
+		return s.ObjectStore
+	}
+	return store
+}
+
+func (s *listCacheStore) List(ctx context.Context, prefix, token string, pageSize int) ([]Object, string, error) {
+	// only listing of the whole directory is cached
+	if prefix != s.header.Prefix {
+		return s.ObjectStore.List(ctx, prefix, token, pageSize)
+	}
+	if strings.HasPrefix(token, listCacheToken) {
+		objs, next, err := s.cachedPage(token, pageSize)
+		if err == nil {
+			return objs, next, nil
+		}
+		// e.g. list_checkpoint of listing served from cache which expired since
+		logWarn("reading listing cache '%s': %s, listing source from the beginning", s.path, err)
+		token = ""
+	}
+	if token == "" && !s.refresh {
+		if listed, ok := s.fresh(); ok {
+			logInfo("listing '%s/%s' from cache '%s' of %s", s.header.Bucket, prefix, s.path, listed.Format(time.RFC3339))
+			objs, next, err := s.cachedPage(listCacheToken+"0", pageSize)
+			if err == nil {
+				return objs, next, nil
+			}
+			logWarn("reading listing cache '%s': %s, listing source", s.path, err)
+		}
+	}
+	objs, next, err := s.ObjectStore.List(ctx, prefix, token, pageSize)
+	s.record(token, objs, next, err)
+	return objs, next, err
+}
+
+func (s *listCacheStore) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) (int64, error) {
+	defer s.invalidate()
+	return s.ObjectStore.Put(ctx, key, r, size, contentType)
+}
+
+func (s *listCacheStore) Delete(ctx context.Context, key string) error {
+	defer s.invalidate()
+	return s.ObjectStore.Delete(ctx, key)
+}
+
+// time cached listing of the directory was listed, ok is false if there's none or it expired
+func (s *listCacheStore) fresh() (time.Time, bool) {
+	r, err := openListCache(s.path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logWarn("reading listing cache '%s': %s", s.path, err)
+		}
+		return time.Time{}, false
+	}
+	defer r.close()
+	h := r.header
+	if h.Source != s.header.Source || h.Bucket != s.header.Bucket || h.Prefix != s.header.Prefix {
+		logInfo("listing cache '%s' is of another directory, listing source", s.path)
+		return time.Time{}, false
+	}
+	if time.Since(h.Listed) > s.ttl {
+		logInfo("listing cache '%s' of %s expired, listing source", s.path, h.Listed.Format(time.RFC3339))
+		return time.Time{}, false
+	}
+	return h.Listed, true
+}
+
+// page of cached listing at offset of token. reader of the previous page continues,
+// otherwise the cache is opened and read up to the offset
+func (s *listCacheStore) cachedPage(token string, pageSize int) ([]Object, string, error) {
+	offset, err := strconv.ParseInt(strings.TrimPrefix(token, listCacheToken), 10, 64)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid token '%s'", token)
+	}
+	s.mu.Lock()
+	r := s.readers[token]
+	delete(s.readers, token)
+	s.mu.Unlock()
+	if r == nil {
+		if r, err = openListCache(s.path); err != nil {
+			return nil, "", err
+		}
+		for r.pos < offset {
+			if _, err := r.next(); err != nil {
+				r.close()
+				if err == io.EOF {
+					err = fmt.Errorf("offset %d is past the end of cached listing", offset)
+				}
+				return nil, "", err
+			}
+		}
+	}
+	var objs []Object
+	for len(objs) < pageSize {
+		obj, err := r.next()
+		if err == io.EOF {
+			r.close()
+			return objs, "", nil
+		}
+		if err != nil {
+			r.close()
+			return nil, "", err
+		}
+		objs = append(objs, obj)
+	}
+	next := listCacheToken + strconv.FormatInt(r.pos, 10)
+	r.used = time.Now()
+	s.mu.Lock()
+	for t, old := range s.readers {
+		if time.Since(old.used) > s.ttl {
+			old.close()
+			delete(s.readers, t)
+		}
+	}
+	s.readers[next] = r
+	s.mu.Unlock()
+	return objs, next, nil
+}
+
+// listing stopped before token, reader of its cached listing is closed. incomplete listing of
+// source being recorded is dropped
+func (s *listCacheStore) releaseListing(prefix, token string) {
+	if prefix != s.header.Prefix || !strings.HasPrefix(token, listCacheToken) {
+		s.mu.Lock()
+		if s.rec != nil && prefix == s.header.Prefix && token == s.rec.token {
+			s.rec.abort()
+			s.rec = nil
+		}
+		s.mu.Unlock()
+		releaseListing(s.ObjectStore, prefix, token)
+		return
+	}
+	s.mu.Lock()
+	r := s.readers[token]
+	delete(s.readers, token)
+	s.mu.Unlock()
+	if r != nil {
+		r.close()
+	}
+}
+
+// write page listed from source to cache. listing is recorded from its first page, pages of
+// other listings going on at the same time aren't
+func (s *listCacheStore) record(token string, objs []Object, next string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if token == "" && s.rec == nil && err == nil {
+		h := s.header
+		h.Listed = time.Now().UTC()
+		if s.rec, err = createListCache(s.path, h); err != nil {
+			logWarn("writing listing cache '%s': %s", s.path, err)
+			return
+		}
+	} else if s.rec == nil || token != s.rec.token {
+		return
+	}
+	if err == nil {
+		err = s.rec.write(objs)
+	}
+	if err != nil {
+		s.rec.abort()
+		s.rec = nil
+		return
+	}
+	s.rec.token = next
+	if next == "" {
+		if err := s.rec.commit(); err != nil {
+			logWarn("writing listing cache '%s': %s", s.path, err)
+		} else {
+			logInfo("cached listing of %d objects of '%s/%s' in '%s'", s.rec.count, s.header.Bucket, s.header.Prefix, s.path)
+		}
+		s.rec = nil
+	}
+}
+
+// source was modified, cached listing and listing being recorded are dropped
+func (s *listCacheStore) invalidate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.rec != nil {
+		s.rec.abort()
+		s.rec = nil
+	}
+	if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+		logErr(err)
+	}
+}
+
+// hashes of keys of fresh cached listing, nil if there's none. keys missing in it are certainly
+// missing in cached listing, keys present in it almost certainly are there
+func (s *listCacheStore) keyHashes() map[uint64]struct{} {
+	if s.refresh {
+		return nil
+	}
+	if _, ok := s.fresh(); !ok {
+		return nil
+	}
+	r, err := openListCache(s.path)
+	if err != nil {
+		return nil
+	}
+	defer r.close()
+	keys := map[uint64]struct{}{}
+	for {
+		obj, err := r.next()
+		if err == io.EOF {
+			return keys
+		}
+		if err != nil {
+			logWarn("reading listing cache '%s': %s", s.path, err)
+			return nil
+		}
+		keys[keyHash(obj.Key)] = struct{}{}
+	}
+}
+
+func keyHash(key string) uint64 {
+	h := fnv.New64a()
+	io.WriteString(h, key)
+	return h.Sum64()
+}
+
+type listCacheReader struct {
+	f      *os.File
+	gz     *gzip.Reader
+	dec    *json.Decoder
+	header listCacheHeader
+	pos    int64
+	// time page was last read from it
+	used time.Time
+}
+
+func openListCache(path string) (*listCacheReader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	gz, err := gzip.NewReader(bufio.NewReader(f))
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	r := &listCacheReader{f: f, gz: gz, dec: json.NewDecoder(gz)}
+	if err := r.dec.Decode(&r.header); err != nil {
+		r.close()
+		return nil, fmt.Errorf("reading header: %s", err)
+	}
+	return r, nil
+}
+
+func (r *listCacheReader) next() (Object, error) {
+	var co cachedObject
+	if err := r.dec.Decode(&co); err != nil {
+		return Object{}, err
+	}
+	r.pos++
+	return Object{Key: co.Key, Size: co.Size, ETag: co.ETag, LastModified: co.LastModified, ContentType: co.ContentType}, nil
+}
+
+func (r *listCacheReader) close() {
+	r.gz.Close()
+	r.f.Close()
+}
+
+// listing written to temporary file, which replaces the cache once listing is complete
+type listCacheWriter struct {
+	path string
+	f    *os.File
+	gz   *gzip.Writer
+	enc  *json.Encoder
+	// token of the next page of the listing
+	token string
+	count int64
+}
+
+func createListCache(path string, h listCacheHeader) (*listCacheWriter, error) {
+	f, err := os.Create(path + ".tmp")
+	if err != nil {
+		return nil, err
+	}
+	w := &listCacheWriter{path: path, f: f, gz: gzip.NewWriter(f)}
+	w.enc = json.NewEncoder(w.gz)
+	if err := w.enc.Encode(h); err != nil {
+		w.abort()
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *listCacheWriter) write(objs []Object) error {
+	for _, obj := range objs {
+		co := cachedObject{Key: obj.Key, Size: obj.Size, ETag: obj.ETag, LastModified: obj.LastModified, ContentType: obj.ContentType}
+		if err := w.enc.Encode(co); err != nil {
+			return err
+		}
+		w.count++
+	}
+	return nil
+}
+
+func (w *listCacheWriter) commit() error {
+	if err := w.gz.Close(); err != nil {
+		w.abort()
+		return err
+	}
+	if err := w.f.Close(); err != nil {
+		os.Remove(w.f.Name())
+		return err
+	}
+	return os.Rename(w.f.Name(), w.path)
+}
+
+func (w *listCacheWriter) abort() {
+	w.gz.Close()
+	w.f.Close()
+	os.Remove(w.f.Name())
+}
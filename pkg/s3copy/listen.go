@@ -0,0 +1,120 @@
+package s3copy
+
+import (
+	"context"
+	"errors"
+	"github.com/minio/minio-go"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// events received while copy is running are buffered up to this number,
+// on overflow they're dropped and directory is re-synced instead
+const listenBuffer = 100000
+
+// delay before listening again after notification stream failed
+const listenRetryDelay = time.Second * 5
+
+// change events from MinIO ListenBucketNotification API, it's not supported by AWS S3.
+// stream is re-opened when it fails, directory is re-synced since events could be lost
+func (s *minioStore) changes(ctx context.Context, prefix string) <-chan changeEvent {
+	in := make(chan changeEvent)
+	go func() {
+		defer close(in)
+		events := []string{"s3:ObjectCreated:*", "s3:ObjectRemoved:*"}
+		for attempt := 0; ; attempt++ {
+			if attempt > 0 {
+				select {
+				case in <- changeEvent{Resync: true}:
+				case <-ctx.Done():
+					return
+				}
+			}
+			err := s.listen(ctx, prefix, events, in)
+			if ctx.Err() != nil {
+				return
+			}
+			// endpoint isn't MinIO or credentials are invalid
+			if resp, ok := err.(minio.ErrorResponse); ok && resp.StatusCode == 501 || classifyError(err) == errAuth {
+				select {
+				case in <- changeEvent{Err: err}:
+				case <-ctx.Done():
+				}
+				return
+			}
+			logWarn("listening for notifications of '%s/%s': %s, retrying in %s", s.bucket, prefix, err, listenRetryDelay)
+			select {
+			case <-time.After(listenRetryDelay):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return bufferChanges(ctx, in, listenBuffer)
+}
+
+// send events of notification stream to ch until stream fails
+func (s *minioStore) listen(ctx context.Context, prefix string, events []string, ch chan<- changeEvent) error {
+	doneCh := make(chan struct{})
+	defer close(doneCh)
+	for info := range s.clnt.ListenBucketNotification(s.bucket, prefix, "", events, doneCh) {
+		if info.Err != nil {
+			return info.Err
+		}
+		for _, rec := range info.Records {
+			// keys in events are url-encoded
+			key, err := url.QueryUnescape(rec.S3.Object.Key)
+			if err != nil {
+				key = rec.S3.Object.Key
+			}
+			ev := changeEvent{Key: key, Deleted: strings.HasPrefix(rec.EventName, "s3:ObjectRemoved:")}
+			select {
+			case ch <- ev:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+	return errors.New("notification stream closed")
+}
+
+// relay events from in through buffer of limit events, so slow consumer doesn't block
+// the stream. overflowing events are replaced with single resync event
+func bufferChanges(ctx context.Context, in <-chan changeEvent, limit int) <-chan changeEvent {
+	out := make(chan changeEvent)
+	go func() {
+		defer close(out)
+		var queue []changeEvent
+		overflow := false
+		for in != nil || len(queue) > 0 {
+			var sendCh chan changeEvent
+			var next changeEvent
+			if len(queue) > 0 {
+				sendCh, next = out, queue[0]
+			}
+			select {
+			case ev, ok := <-in:
+				switch {
+				case !ok:
+					in = nil
+				case ev.Err != nil:
+					queue = append(queue, ev)
+				case len(queue) >= limit && !overflow:
+					logWarn("more than %d change events are pending, dropping them, directory will be re-synced", limit)
+					overflow = true
+				case !overflow:
+					queue = append(queue, ev)
+				}
+			case sendCh <- next:
+				queue = queue[1:]
+				if len(queue) == 0 && overflow {
+					queue, overflow = append(queue, changeEvent{Resync: true}), false
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
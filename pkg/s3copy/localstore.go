@@ -0,0 +1,136 @@
+package s3copy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// object store of local directory, keys are paths relative to root with slash separators.
+// only regular files are listed, symlinks and other special files are ignored
+type localStore struct {
+	root    string
+	cursors treeCursors
+}
+
+func newLocalStore(root string) (*localStore, error) {
+	if root == "" {
+		return nil, errors.New("path of local endpoint is empty")
+	}
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	return &localStore{root: abs}, nil
+}
+
+// path of the file, keys escaping root or changed by cleaning of path are rejected
+func (s *localStore) path(key string) (string, error) {
+	if err := checkPathKey(key, "local"); err != nil {
+		return "", err
+	}
+	return filepath.Join(s.root, filepath.FromSlash(key)), nil
+}
+
+func (s *localStore) readDir(dir string) ([]os.FileInfo, error) {
+	return ioutil.ReadDir(filepath.Join(s.root, filepath.FromSlash(dir)))
+}
+
+func (s *localStore) List(ctx context.Context, prefix, token string, pageSize int) ([]Object, string, error) {
+	return listTree(ctx, s, &s.cursors, prefix, token, pageSize)
+}
+
+func (s *localStore) Stat(ctx context.Context, key string) (Object, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return Object{}, err
+	}
+	fi, err := os.Stat(p)
+	if os.IsNotExist(err) || (err == nil && fi.IsDir()) {
+		return Object{}, ErrNotFound
+	}
+	if err != nil {
+		return Object{}, err
+	}
+	return fileObject(key, fi), nil
+}
+
+type limitedFile struct {
+	io.Reader
+	f *os.File
+}
+
+func (lf *limitedFile) Close() error {
+	return lf.f.Close()
+}
+
+func (s *localStore) Get(ctx context.Context, key string, offset, length int64) (io.ReadCloser, Object, error) {
+	obj, err := s.Stat(ctx, key)
+	if err != nil {
+		return nil, Object{}, err
+	}
+	p, _ := s.path(key)
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, Object{}, err
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, Object{}, err
+	}
+	var r io.Reader = f
+	if length >= 0 {
+		r = io.LimitReader(f, length)
+	}
+	return &limitedFile{Reader: r, f: f}, obj, nil
+}
+
+// file is written to temporary file in the same directory and renamed,
+// so readers never see partially written file
+func (s *localStore) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) (int64, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return 0, err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return 0, err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(p), ".s3-copy-dir-")
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(tmp, r)
+	if err == nil && n != size {
+		err = fmt.Errorf("read %d bytes of %d", n, size)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), p)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return n, err
+	}
+	return n, nil
+}
+
+// removing missing file isn't an error, same as in S3
+func (s *localStore) Delete(ctx context.Context, key string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
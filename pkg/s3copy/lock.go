@@ -0,0 +1,209 @@
+package s3copy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// default lease of lock object in destination bucket
+const defaultLockLease = time.Minute * 5
+
+// local lock file containing pid of the running copy, held with flock, so lock of a dead
+// process is released by the kernel
+type fileLock struct {
+	path string
+	file *os.File
+}
+
+// lock file, file left by a dead process is taken over
+func acquireFileLock(path string) (*fileLock, error) {
+	for i := 0; i < 2; i++ {
+		f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, err
+		}
+		if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+			b, _ := ioutil.ReadAll(f)
+			f.Close()
+			if err == syscall.EWOULDBLOCK {
+				return nil, fmt.Errorf("lock file '%s' is held by running process %s", path, strings.TrimSpace(string(b)))
+			}
+			return nil, err
+		}
+		// file could be removed by its holder between open and flock, then lock is taken again
+		// on the file created by the next copy
+		if fi, err := f.Stat(); err == nil {
+			if pfi, err := os.Stat(path); err != nil || !os.SameFile(fi, pfi) {
+				f.Close()
+				continue
+			}
+		}
+		if err := f.Truncate(0); err != nil {
+			f.Close()
+			return nil, err
+		}
+		if _, err := fmt.Fprintf(f, "%d\n", os.Getpid()); err != nil {
+			f.Close()
+			return nil, err
+		}
+		return &fileLock{path: path, file: f}, nil
+	}
+	return nil, fmt.Errorf("failed to acquire lock file '%s'", path)
+}
+
+// lock file is removed while it's still locked, so the next copy can't lock removed file
+func (fl *fileLock) release() {
+	logErr(os.Remove(fl.path))
+	logErr(fl.file.Close())
+}
+
+// content of lock object in destination bucket
+type lockLease struct {
+	Owner   string    `json:"owner"`
+	Expires time.Time `json:"expires"`
+}
+
+// lock object in destination bucket, protects from concurrent copies started on different hosts.
+// lease is refreshed while copy is running, lock of crashed copy expires after lease duration
+type objectLock struct {
+	store  ObjectStore
+	bucket string
+	key    string
+	owner  string
+	lease  time.Duration
+	// called once if lock is taken by another copy or lease expires before it's refreshed
+	lost   func()
+	stopCh chan struct{}
+	doneCh chan struct{}
+	// set by refresh once lock is lost, it isn't removed on release then
+	isLost bool
+}
+
+func acquireObjectLock(store ObjectStore, bucket, key string, lease time.Duration, lost func()) (*objectLock, error) {
+	host, _ := os.Hostname()
+	ol := &objectLock{
+		store:  store,
+		bucket: bucket,
+		key:    key,
+		owner:  fmt.Sprintf("%s/%d/%d", host, os.Getpid(), time.Now().UnixNano()),
+		lease:  lease,
+		lost:   lost,
+		stopCh: make(chan struct{}),
+		doneCh: make(chan struct{}),
+	}
+
+	current, err := ol.read()
+	if err != nil {
+		return nil, err
+	}
+	if current != nil && time.Now().Before(current.Expires) {
+		return nil, fmt.Errorf("lock object '%s/%s' is held by '%s' until %s", bucket, key, current.Owner, current.Expires.Format(time.RFC3339))
+	}
+	err = ol.write()
+	audit(auditEntry{Op: auditPut, Bucket: bucket, Key: key}, err)
+	if err != nil {
+		return nil, err
+	}
+
+	// S3 has no compare-and-swap for objects, so re-read lock after a short delay
+	// to detect concurrent copy which wrote the lock at the same time
+	time.Sleep(time.Second * 2)
+	current, err = ol.read()
+	if err != nil {
+		return nil, err
+	}
+	if current == nil || current.Owner != ol.owner {
+		return nil, fmt.Errorf("lock object '%s/%s' was taken by concurrent copy", bucket, key)
+	}
+
+	go ol.refresh()
+	return ol, nil
+}
+
+// read current lease, nil if lock object doesn't exist
+func (ol *objectLock) read() (*lockLease, error) {
+	obj, _, err := ol.store.Get(context.Background(), ol.key, 0, -1)
+	if err != nil {
+		if classifyError(err) == errNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer obj.Close()
+	b, err := ioutil.ReadAll(obj)
+	if err != nil {
+		return nil, err
+	}
+	l := &lockLease{}
+	if err := json.Unmarshal(b, l); err != nil {
+		return nil, fmt.Errorf("invalid lock object '%s/%s': %s", ol.bucket, ol.key, err)
+	}
+	return l, nil
+}
+
+func (ol *objectLock) write() error {
+	b, _ := json.Marshal(lockLease{Owner: ol.owner, Expires: time.Now().Add(ol.lease).UTC()})
+	_, err := ol.store.Put(context.Background(), ol.key, bytes.NewReader(b), int64(len(b)), "application/json")
+	return err
+}
+
+// extend lease periodically until lock is released. lock is re-read before each refresh, copy
+// is stopped once lock is owned by another copy or lease expired without being refreshed
+func (ol *objectLock) refresh() {
+	defer close(ol.doneCh)
+	ticker := time.NewTicker(ol.lease / 3)
+	defer ticker.Stop()
+	expires := time.Now().Add(ol.lease)
+	for {
+		select {
+		case <-ticker.C:
+		case <-ol.stopCh:
+			return
+		}
+		logDebug("refreshing lease of lock object '%s/%s'", ol.bucket, ol.key)
+		current, err := ol.read()
+		if err == nil && (current == nil || current.Owner != ol.owner) {
+			owner := "nobody"
+			if current != nil {
+				owner = "'" + current.Owner + "'"
+			}
+			logError("lock object '%s/%s' is held by %s, stopping copy", ol.bucket, ol.key, owner)
+			ol.isLost = true
+			ol.lost()
+			return
+		}
+		if err == nil {
+			err = ol.write()
+		}
+		if err == nil {
+			expires = time.Now().Add(ol.lease)
+			continue
+		}
+		logError("refreshing lock object lease: %s", err)
+		if time.Now().After(expires) {
+			logError("lease of lock object '%s/%s' expired, stopping copy", ol.bucket, ol.key)
+			ol.isLost = true
+			ol.lost()
+			return
+		}
+	}
+}
+
+// remove lock object, lock taken by another copy is kept
+func (ol *objectLock) release() {
+	close(ol.stopCh)
+	<-ol.doneCh
+	if ol.isLost {
+		return
+	}
+	err := ol.store.Delete(context.Background(), ol.key)
+	audit(auditEntry{Op: auditDelete, Bucket: ol.bucket, Key: ol.key}, err)
+	logErr(err)
+}
@@ -0,0 +1,242 @@
+package s3copy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// log formats
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// results of object copy
+const (
+	ResultCopied   = "copied"
+	ResultRecopied = "recopied"
+	ResultSkipped  = "skipped"
+	ResultFailed   = "failed"
+)
+
+// format of log output, set once on startup
+var logFormat = LogFormatText
+
+// log levels, messages below minLogLevel are dropped.
+// quiet level drops everything except summaries and the final result
+type LogLevel int
+
+const (
+	LevelDebug LogLevel = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+	LevelQuiet
+)
+
+var logLevelNames = map[string]LogLevel{
+	"debug": LevelDebug,
+	"info":  LevelInfo,
+	"warn":  LevelWarn,
+	"error": LevelError,
+	"quiet": LevelQuiet,
+}
+
+func (l LogLevel) String() string {
+	for name, level := range logLevelNames {
+		if level == l {
+			return name
+		}
+	}
+	return "info"
+}
+
+var minLogLevel = LevelInfo
+
+// log only every Nth successful or skipped object, failed objects are always logged
+var logSampleEvery int64 = 1
+
+// successful and skipped objects aren't logged while progress bar is shown
+var logObjects = true
+
+// log only every nth successful or skipped object
+func SetLogEvery(n int64) {
+	if n > 1 {
+		logSampleEvery = n
+	}
+}
+
+// drop messages below given level: debug, info, warn, error or quiet
+func SetLogLevel(name string) error {
+	level, ok := logLevelNames[name]
+	if !ok {
+		return fmt.Errorf("unknown log level '%s'", name)
+	}
+	minLogLevel = level
+	return nil
+}
+
+// log message with given level, warnings and errors are prefixed in text format
+func Logf(level LogLevel, format string, args ...interface{}) {
+	if level < minLogLevel {
+		return
+	}
+	if logFormat == LogFormatJSON {
+		writeEvent(struct {
+			Time  time.Time `json:"time"`
+			Event string    `json:"event"`
+			Level string    `json:"level"`
+			Msg   string    `json:"msg"`
+		}{time.Now().UTC(), "log", level.String(), fmt.Sprintf(format, args...)})
+		return
+	}
+	switch level {
+	case LevelWarn:
+		format = "WARN: " + format
+	case LevelError:
+		format = "ERROR: " + format
+	}
+	log.Printf(format, args...)
+}
+
+func logDebug(format string, args ...interface{}) { Logf(LevelDebug, format, args...) }
+func logInfo(format string, args ...interface{})  { Logf(LevelInfo, format, args...) }
+func logWarn(format string, args ...interface{})  { Logf(LevelWarn, format, args...) }
+func logError(format string, args ...interface{}) { Logf(LevelError, format, args...) }
+
+func logErr(err error) {
+	if err != nil {
+		logError("%s", err)
+	}
+}
+
+// summaries are printed regardless of log level
+func logSummary(format string, args ...interface{}) { Logf(LevelQuiet, format, args...) }
+
+// structured event emitted per object
+type objectEvent struct {
+	Time       time.Time `json:"time"`
+	Event      string    `json:"event"`
+	Bucket     string    `json:"bucket"`
+	Key        string    `json:"key"`
+	Bytes      int64     `json:"bytes"`
+	Duration   float64   `json:"duration_sec"`
+	Result     string    `json:"result"`
+	Reason     string    `json:"reason,omitempty"`
+	Skip       string    `json:"skip,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	ErrorClass string    `json:"error_class,omitempty"`
+	// error of failed object returned in Result
+	err error
+}
+
+// writes every line of standard logger as json event, so free-form log
+// messages don't break parsing of structured output
+type jsonLogWriter struct {
+	sync.Mutex
+	out io.Writer
+}
+
+func (w *jsonLogWriter) Write(p []byte) (int, error) {
+	w.Lock()
+	defer w.Unlock()
+	for _, line := range bytes.Split(bytes.TrimRight(p, "\n"), []byte("\n")) {
+		b, _ := json.Marshal(struct {
+			Time  time.Time `json:"time"`
+			Event string    `json:"event"`
+			Msg   string    `json:"msg"`
+		}{time.Now().UTC(), "log", string(line)})
+		if _, err := w.out.Write(append(b, '\n')); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// mutex serializes structured events with lines written by standard logger
+var jsonOut = &jsonLogWriter{out: os.Stderr}
+
+// redirect log output, must be called before SetLogFormat
+func SetLogOutput(w io.Writer) {
+	log.SetOutput(w)
+	jsonOut.out = w
+}
+
+// switch log output to given format
+func SetLogFormat(format string) error {
+	switch format {
+	case LogFormatText:
+	case LogFormatJSON:
+		log.SetFlags(0)
+		log.SetOutput(jsonOut)
+	default:
+		return fmt.Errorf("unknown log format '%s'", format)
+	}
+	logFormat = format
+	return nil
+}
+
+// write structured event, used only with json log format
+func writeEvent(v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		logErr(err)
+		return
+	}
+	jsonOut.Lock()
+	defer jsonOut.Unlock()
+	jsonOut.out.Write(append(b, '\n'))
+}
+
+// log result of object copy, current and total are used as progress prefix of text log line
+func logObject(ev objectEvent, current int64, total string) {
+	level := LevelInfo
+	if ev.Result == ResultFailed {
+		level = LevelError
+	} else if !logObjects || (logSampleEvery > 1 && current%logSampleEvery != 0) {
+		return
+	}
+	if level < minLogLevel {
+		return
+	}
+	if logFormat == LogFormatJSON {
+		ev.Time = time.Now().UTC()
+		ev.Event = "object"
+		writeEvent(ev)
+		return
+	}
+
+	switch ev.Result {
+	case ResultCopied:
+		logInfo("[%d%s] copied '%s/%s', %d bytes", current, total, ev.Bucket, ev.Key, ev.Bytes)
+	case ResultRecopied:
+		logInfo("[%d%s] re-copied '%s/%s', %s, %d bytes", current, total, ev.Bucket, ev.Key, ev.Reason, ev.Bytes)
+	case ResultSkipped:
+		logInfo("[%d%s] skipping '%s/%s', %s", current, total, ev.Bucket, ev.Key, ev.Reason)
+	case ResultFailed:
+		logError("[%d%s] copying '%s/%s' (%s): %s", current, total, ev.Bucket, ev.Key, ev.ErrorClass, ev.Error)
+	}
+}
+
+// log run level event (start, finish) regardless of log level,
+// fields are included only in json format
+func logRun(event string, fields map[string]interface{}, format string, args ...interface{}) {
+	if logFormat != LogFormatJSON {
+		logSummary(format, args...)
+		return
+	}
+	ev := map[string]interface{}{}
+	for k, v := range fields {
+		ev[k] = v
+	}
+	ev["time"] = time.Now().UTC()
+	ev["event"] = event
+	ev["msg"] = fmt.Sprintf(format, args...)
+	writeEvent(ev)
+}
@@ -0,0 +1,90 @@
+package s3copy
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// manifest formats
+const (
+	manifestCSV    = "csv"
+	manifestNDJSON = "ndjson"
+)
+
+// single copied object recorded in manifest
+type manifestEntry struct {
+	SourceKey      string    `json:"source_key"`
+	DestinationKey string    `json:"destination_key"`
+	Size           int64     `json:"size"`
+	ETag           string    `json:"etag"`
+	CopiedAt       time.Time `json:"copied_at"`
+}
+
+var manifestCSVHeader = []string{"source_key", "destination_key", "size", "etag", "copied_at"}
+
+// append-only record of every copied object, entries of subsequent runs are appended
+type manifest struct {
+	sync.Mutex
+	file   *os.File
+	format string
+	csv    *csv.Writer
+	enc    *json.Encoder
+}
+
+// open manifest for appending, format is guessed from file extension if not set
+func openManifest(path, format string) (*manifest, error) {
+	if format == "" {
+		format = manifestNDJSON
+		if strings.HasSuffix(path, ".csv") {
+			format = manifestCSV
+		}
+	}
+	if format != manifestCSV && format != manifestNDJSON {
+		return nil, fmt.Errorf("unknown manifest format '%s'", format)
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	m := &manifest{file: f, format: format}
+	if format == manifestNDJSON {
+		m.enc = json.NewEncoder(f)
+		return m, nil
+	}
+
+	m.csv = csv.NewWriter(f)
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if fi.Size() == 0 {
+		m.csv.Write(manifestCSVHeader)
+		m.csv.Flush()
+	}
+	return m, m.csv.Error()
+}
+
+// record copied object, every entry is flushed to the file immediately
+func (m *manifest) record(e manifestEntry) {
+	m.Lock()
+	defer m.Unlock()
+	if m.enc != nil {
+		logErr(m.enc.Encode(e))
+		return
+	}
+	m.csv.Write([]string{e.SourceKey, e.DestinationKey, strconv.FormatInt(e.Size, 10), e.ETag, e.CopiedAt.Format(time.RFC3339)})
+	m.csv.Flush()
+	logErr(m.csv.Error())
+}
+
+func (m *manifest) close() {
+	logErr(m.file.Close())
+}
@@ -0,0 +1,116 @@
+package s3copy
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+// prefix of all exposed metric names
+const metricsNamespace = "s3_copy_dir"
+
+// upper bounds of histogram buckets
+var (
+	durationBuckets = []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 300, 900}
+	sizeBuckets     = []float64{1 << 10, 16 << 10, 256 << 10, 1 << 20, 16 << 20, 64 << 20, 256 << 20, 1 << 30, 4 << 30}
+)
+
+// cumulative histogram in prometheus sense
+type histogram struct {
+	bounds []float64
+	counts []int64
+	sum    float64
+	count  int64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]int64, len(bounds))}
+}
+
+func (h *histogram) observe(v float64) {
+	for i, b := range h.bounds {
+		if v <= b {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+func (h *histogram) write(w io.Writer, name, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+	for i, b := range h.bounds {
+		fmt.Fprintf(w, "%s_bucket{le=\"%g\"} %d\n", name, b, h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n%s_sum %g\n%s_count %d\n", name, h.count, name, h.sum, name, h.count)
+}
+
+// histograms of processed objects, counters and gauges are read from copier on scrape
+type copyMetrics struct {
+	sync.Mutex
+	duration *histogram
+	size     *histogram
+}
+
+func newCopyMetrics() *copyMetrics {
+	return &copyMetrics{duration: newHistogram(durationBuckets), size: newHistogram(sizeBuckets)}
+}
+
+// record copied object, skipped and failed objects are only counted
+func (m *copyMetrics) record(ev objectEvent) {
+	if ev.Result != ResultCopied && ev.Result != ResultRecopied {
+		return
+	}
+	m.Lock()
+	m.duration.observe(ev.Duration)
+	m.size.observe(float64(ev.Bytes))
+	m.Unlock()
+}
+
+func writeMetric(w io.Writer, kind, name, help string, v interface{}) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %v\n", name, help, name, kind, name, v)
+}
+
+// render all metrics in prometheus text exposition format
+func (cp *Copier) writeMetrics(w io.Writer) {
+	counts := cp.oc.snapshot()
+	current, totalObjs, copied, skipped, failed, bytes := counts.Current, counts.Total, counts.Copied, counts.Skipped, counts.Failed, counts.Bytes
+
+	n := func(name string) string { return metricsNamespace + "_" + name }
+	writeMetric(w, "counter", n("objects_processed_total"), "Objects processed so far.", current)
+	writeMetric(w, "counter", n("objects_copied_total"), "Objects copied or re-copied to destination.", copied)
+	writeMetric(w, "counter", n("objects_skipped_total"), "Objects skipped as already existing in destination.", skipped)
+	writeMetric(w, "counter", n("objects_failed_total"), "Objects failed to copy.", failed)
+	writeMetric(w, "counter", n("bytes_copied_total"), "Bytes copied to destination.", bytes)
+
+	cp.breakdown.Lock()
+	classes := make([]string, 0, len(cp.breakdown.errors))
+	for class := range cp.breakdown.errors {
+		classes = append(classes, class)
+	}
+	sort.Strings(classes)
+	name := n("errors_total")
+	fmt.Fprintf(w, "# HELP %s Failed objects by error class.\n# TYPE %s counter\n", name, name)
+	for _, class := range classes {
+		fmt.Fprintf(w, "%s{class=\"%s\"} %d\n", name, class, cp.breakdown.errors[class])
+	}
+	cp.breakdown.Unlock()
+
+	cp.metrics.Lock()
+	cp.metrics.duration.write(w, n("object_copy_duration_seconds"), "Duration of object copy.")
+	cp.metrics.size.write(w, n("object_size_bytes"), "Size of copied objects.")
+	cp.metrics.Unlock()
+
+	// number of objects left is known only if objects were counted before copy
+	if totalObjs >= 0 {
+		writeMetric(w, "gauge", n("queue_depth"), "Objects left to process.", totalObjs-current)
+	}
+	writeMetric(w, "gauge", n("workers_active"), "Copy workers running.", cp.wl.running())
+	writeMetric(w, "gauge", n("workers_limit"), "Current limit of concurrent copy workers.", cp.wl.getLimit())
+	paused := 0
+	if cp.wl.isPaused() {
+		paused = 1
+	}
+	writeMetric(w, "gauge", n("paused"), "1 if copy is paused.", paused)
+}
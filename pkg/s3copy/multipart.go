@@ -0,0 +1,215 @@
+package s3copy
+
+import (
+	"fmt"
+	"github.com/minio/minio-go"
+	"io"
+	"time"
+)
+
+const (
+	// S3 limits for multipart uploads
+	minPartSize = 5 << 20
+	maxParts    = 10000
+)
+
+// state of multipart upload persisted after every uploaded part
+type multipartUpload struct {
+	UploadID string               `json:"upload_id"`
+	ETag     string               `json:"etag"` // source object ETag
+	Size     int64                `json:"size"`
+	PartSize int64                `json:"part_size"`
+	Parts    []minio.CompletePart `json:"parts"`
+	// state of sha256 of uploaded parts with checksum_metadata
+	Hash []byte `json:"hash,omitempty"`
+}
+
+// multipart upload is used for large objects of known size when state database is enabled
+// and destination store supports multipart uploads
+func (cp *Copier) resumable(obj Object) bool {
+	// transformed content is uploaded from temporary file
+	if _, ok := cp.dst.(multipartStore); !ok || len(cp.transforms) > 0 {
+		return false
+	}
+	return cp.state != nil && cp.multipartThreshold > 0 && obj.Size >= cp.multipartThreshold
+}
+
+// part size which keeps number of parts within S3 limits
+func partSizeFor(size, partSize int64) int64 {
+	if partSize < minPartSize {
+		partSize = minPartSize
+	}
+	if min := (size + maxParts - 1) / maxParts; partSize < min {
+		partSize = min
+	}
+	return partSize
+}
+
+// offset and length of part n of upload counted from 1, length is 0 past the last part
+func (u *multipartUpload) part(n int) (int64, int64) {
+	offset := int64(n-1) * u.PartSize
+	if offset >= u.Size {
+		return u.Size, 0
+	}
+	length := u.PartSize
+	if rest := u.Size - offset; rest < length {
+		length = rest
+	}
+	return offset, length
+}
+
+// copy object with multipart upload, uploaded parts are recorded in state database,
+// so interrupted upload continues from the last uploaded part after restart
+func (cp *Copier) resumableUpload(obj Object, dstETag string, sp *span) (int64, error) {
+	dst := cp.dst.(multipartStore)
+	// uploads are recorded in state by source key, dkey is the key in destination
+	bucket, key, dkey := cp.bucket, obj.Key, cp.destKey(obj.Key)
+
+	u := cp.state.getUpload(key)
+	if u != nil && (u.ETag != obj.ETag || u.Size != obj.Size) {
+		// source object changed since upload was started
+		countRequest(true, reqAbort)
+		err := dst.abortUpload(dkey, u.UploadID)
+		audit(auditEntry{Op: auditMultipartAbort, Bucket: bucket, Key: dkey, UploadID: u.UploadID}, err)
+		logErr(err)
+		u = nil
+	}
+	if u != nil {
+		// make sure upload wasn't aborted or expired in destination
+		countRequest(true, reqList)
+		if err := dst.uploadExists(dkey, u.UploadID); err != nil {
+			logWarn("can't resume upload of '%s/%s': %s", bucket, key, err)
+			// upload replaced in state couldn't be matched by cleanup anymore
+			countRequest(true, reqAbort)
+			abortErr := dst.abortUpload(dkey, u.UploadID)
+			audit(auditEntry{Op: auditMultipartAbort, Bucket: bucket, Key: dkey, UploadID: u.UploadID}, abortErr)
+			if classifyError(abortErr) != errNotFound {
+				logErr(abortErr)
+			}
+			u = nil
+		}
+	}
+	resumed := u != nil
+	if u == nil {
+		countRequest(true, reqCreateMP)
+		id, err := dst.newUpload(dkey, obj.ContentType)
+		audit(auditEntry{Op: auditMultipartCreate, Bucket: bucket, Key: dkey, UploadID: id, Size: obj.Size}, err)
+		if err != nil {
+			return 0, err
+		}
+		u = &multipartUpload{UploadID: id, ETag: obj.ETag, Size: obj.Size, PartSize: partSizeFor(obj.Size, cp.partSize)}
+		cp.state.saveUpload(key, u)
+	} else {
+		logInfo("resuming upload of '%s/%s' from part %d", bucket, key, len(u.Parts)+1)
+	}
+
+	sum := cp.uploadHash(u.Hash, resumed)
+	if sum == nil && resumed && cp.checksum != nil {
+		logWarn("checksum of '%s/%s' isn't recorded, its upload was started without it", bucket, key)
+	}
+
+	for n := len(u.Parts) + 1; ; n++ {
+		offset, length := u.part(n)
+		if length <= 0 {
+			break
+		}
+		if err := cp.ctx.Err(); err != nil {
+			return 0, err
+		}
+
+		partSp := startRequestSpan("PUT part", sp, true, bucket, key)
+		partSp.setAttr(intAttr("s3_copy_dir.part", int64(n)))
+		partSp.setAttr(intAttr("s3_copy_dir.size", length))
+		countRequest(false, reqGet)
+		countRequest(true, reqPutPart)
+		r, _, err := cp.src.Get(cp.ctx, key, offset, length)
+		if err != nil {
+			partSp.end(err)
+			return 0, err
+		}
+		var body io.Reader = cp.bandwidth.reader(cp.ctx, r)
+		if sum != nil {
+			body = io.TeeReader(body, sum)
+		}
+		etag, err := dst.putPart(cp.ctx, dkey, u.UploadID, n, body, length)
+		r.Close()
+		partSp.end(err)
+		if err != nil {
+			return 0, fmt.Errorf("uploading part %d: %s", n, err)
+		}
+
+		u.Parts = append(u.Parts, minio.CompletePart{PartNumber: n, ETag: etag})
+		u.Hash = hashState(sum)
+		cp.state.saveUpload(key, u)
+	}
+
+	countRequest(true, reqComplete)
+	var err error
+	if cp.conditional != nil {
+		err = cp.conditional.completeUploadIf(cp.ctx, dkey, u.UploadID, u.Parts, dstETag)
+	} else {
+		err = dst.completeUpload(dkey, u.UploadID, u.Parts)
+	}
+	audit(auditEntry{Op: auditMultipartComplete, Bucket: bucket, Key: dkey, UploadID: u.UploadID, Size: u.Size}, err)
+	if classifyError(err) == errConflict {
+		// object of concurrent writer is kept, upload would never be completed
+		countRequest(true, reqAbort)
+		abortErr := dst.abortUpload(dkey, u.UploadID)
+		audit(auditEntry{Op: auditMultipartAbort, Bucket: bucket, Key: dkey, UploadID: u.UploadID}, abortErr)
+		logErr(abortErr)
+		cp.state.deleteUpload(key)
+	}
+	if err != nil {
+		return 0, err
+	}
+	cp.state.deleteUpload(key)
+	cp.recordChecksum(dkey, sum, obj.ContentType)
+	return u.Size, nil
+}
+
+// abort incomplete multipart uploads in destination which were started more than olderThan ago,
+// with dryRun uploads are only logged
+func cleanupUploads(dst multipartStore, state *copyState, bucket, dir string, olderThan time.Duration, dryRun bool) int {
+	doneCh := make(chan struct{})
+	defer close(doneCh)
+
+	code := ExitOK
+	var count, size int64
+	for u := range dst.listUploads(dir, doneCh) {
+		if u.Err != nil {
+			logError("listing incomplete uploads: %s", u.Err)
+			return ExitError
+		}
+		if time.Since(u.Initiated) < olderThan {
+			continue
+		}
+		if dryRun {
+			logInfo("would abort upload of '%s/%s' started at %s, %s uploaded", bucket, u.Key, u.Initiated, FormatBytes(u.Size))
+			count++
+			size += u.Size
+			continue
+		}
+		err := dst.abortUpload(u.Key, u.UploadID)
+		audit(auditEntry{Op: auditMultipartAbort, Bucket: bucket, Key: u.Key, UploadID: u.UploadID}, err)
+		if err != nil {
+			logError("aborting upload of '%s/%s' started at %s: %s", bucket, u.Key, u.Initiated, err)
+			code = ExitPartial
+			continue
+		}
+		if state != nil {
+			if su := state.getUpload(u.Key); su != nil && su.UploadID == u.UploadID {
+				state.deleteUpload(u.Key)
+			}
+		}
+		count++
+		size += u.Size
+		logInfo("aborted upload of '%s/%s' started at %s, %s uploaded", bucket, u.Key, u.Initiated, FormatBytes(u.Size))
+	}
+
+	if dryRun {
+		logSummary("dry run: %d incomplete uploads older than %s would be aborted, %s of parts", count, olderThan, FormatBytes(size))
+	} else {
+		logSummary("aborted %d incomplete uploads older than %s, %s of parts freed", count, olderThan, FormatBytes(size))
+	}
+	return code
+}
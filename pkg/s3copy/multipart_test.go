@@ -0,0 +1,110 @@
+package s3copy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/minio/minio-go"
+	"testing"
+)
+
+func TestPartSizeFor(t *testing.T) {
+	tests := []struct {
+		size, partSize, want int64
+	}{
+		{100 << 20, 0, minPartSize},
+		{100 << 20, 1 << 20, minPartSize},
+		{100 << 20, 16 << 20, 16 << 20},
+		{maxParts * minPartSize, minPartSize, minPartSize},
+		{maxParts*minPartSize + 1, minPartSize, minPartSize + 1},
+		{5 << 40, 64 << 20, (5<<40 + maxParts - 1) / maxParts},
+	}
+	for _, tt := range tests {
+		got := partSizeFor(tt.size, tt.partSize)
+		if got != tt.want {
+			t.Errorf("partSizeFor(%d, %d) = %d, want %d", tt.size, tt.partSize, got, tt.want)
+		}
+		if parts := (tt.size + got - 1) / got; parts > maxParts {
+			t.Errorf("partSizeFor(%d, %d) = %d gives %d parts", tt.size, tt.partSize, got, parts)
+		}
+	}
+}
+
+func TestUploadPart(t *testing.T) {
+	u := &multipartUpload{Size: 25, PartSize: 10}
+	tests := []struct {
+		n              int
+		offset, length int64
+	}{
+		{1, 0, 10},
+		{2, 10, 10},
+		{3, 20, 5},
+		{4, 25, 0},
+		{5, 25, 0},
+	}
+	for _, tt := range tests {
+		if offset, length := u.part(tt.n); offset != tt.offset || length != tt.length {
+			t.Errorf("part(%d) = %d, %d, want %d, %d", tt.n, offset, length, tt.offset, tt.length)
+		}
+	}
+}
+
+// upload resumed from state continues after its uploaded parts and covers the rest of object
+func TestUploadPartResume(t *testing.T) {
+	tests := []struct {
+		size, partSize int64
+		uploaded       int
+	}{
+		{25, 10, 0},
+		{25, 10, 2},
+		{30, 10, 3},
+		{minPartSize*3 + 1, minPartSize, 1},
+	}
+	for _, tt := range tests {
+		u := &multipartUpload{Size: tt.size, PartSize: tt.partSize, Parts: make([]minio.CompletePart, tt.uploaded)}
+		next := int64(len(u.Parts)) * u.PartSize
+		for n := len(u.Parts) + 1; ; n++ {
+			offset, length := u.part(n)
+			if length <= 0 {
+				break
+			}
+			if offset != next {
+				t.Errorf("size %d: part %d starts at %d, want %d", tt.size, n, offset, next)
+			}
+			next = offset + length
+		}
+		if next != tt.size {
+			t.Errorf("size %d, %d parts uploaded: parts end at %d", tt.size, tt.uploaded, next)
+		}
+	}
+}
+
+func TestUploadHashState(t *testing.T) {
+	cp := &Copier{checksum: &minioStore{}}
+	parts := []string{"first part ", "second part ", "last part"}
+	want := sha256.Sum256([]byte(parts[0] + parts[1] + parts[2]))
+
+	// hash is saved after every part and restored on resume
+	var state []byte
+	for i, p := range parts {
+		h := cp.uploadHash(state, i > 0)
+		if h == nil {
+			t.Fatalf("part %d: hash isn't restored from state", i+1)
+		}
+		h.Write([]byte(p))
+		state = hashState(h)
+	}
+	h := cp.uploadHash(state, true)
+	if got := hex.EncodeToString(h.Sum(nil)); got != hex.EncodeToString(want[:]) {
+		t.Errorf("restored hash = %s, want %s", got, hex.EncodeToString(want[:]))
+	}
+
+	if h := cp.uploadHash(nil, true); h != nil {
+		t.Errorf("upload started without checksum has hash")
+	}
+	if h := cp.uploadHash([]byte("invalid"), true); h != nil {
+		t.Errorf("invalid state gives hash")
+	}
+	if h := (&Copier{}).uploadHash(state, true); h != nil || hashState(nil) != nil {
+		t.Errorf("hash without checksum_metadata")
+	}
+}
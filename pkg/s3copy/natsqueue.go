@@ -0,0 +1,223 @@
+package s3copy
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	natsDialTimeout = time.Second * 10
+	natsDefaultPort = "4222"
+)
+
+// consumer of nats subject in queue group, speaks core nats protocol.
+// connection is re-established when it fails, messages sent meanwhile are lost
+type natsFeed struct {
+	addr    string
+	tls     bool
+	user    string
+	pass    string
+	subject string
+	group   string
+
+	// current connection, nil while disconnected. writes are serialized
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func newNATSFeed(rawurl, subject, group string) (*natsFeed, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil || u.Host == "" || (u.Scheme != "nats" && u.Scheme != "tls") {
+		return nil, fmt.Errorf("invalid nats url '%s', must be nats://host:port or tls://host:port", rawurl)
+	}
+	f := &natsFeed{addr: u.Host, tls: u.Scheme == "tls", subject: subject, group: group}
+	if u.Port() == "" {
+		f.addr = net.JoinHostPort(u.Hostname(), natsDefaultPort)
+	}
+	if u.User != nil {
+		f.user = u.User.Username()
+		f.pass, _ = u.User.Password()
+	}
+	return f, nil
+}
+
+func (f *natsFeed) changes(ctx context.Context, prefix string) <-chan changeEvent {
+	ch := make(chan changeEvent)
+	go func() {
+		defer close(ch)
+		for ctx.Err() == nil {
+			err := f.consume(ctx, prefix, ch)
+			if ctx.Err() != nil {
+				return
+			}
+			if strings.Contains(strings.ToLower(err.Error()), "authorization violation") {
+				select {
+				case ch <- changeEvent{Err: err}:
+				case <-ctx.Done():
+				}
+				return
+			}
+			logWarn("consuming nats subject '%s': %s, reconnecting in %s", f.subject, err, listenRetryDelay)
+			select {
+			case <-time.After(listenRetryDelay):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+// connect, subscribe and send messages to ch until connection fails
+func (f *natsFeed) consume(ctx context.Context, prefix string, ch chan<- changeEvent) error {
+	conn, r, err := f.connect()
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	f.conn = conn
+	f.mu.Unlock()
+	closedCh := make(chan struct{})
+	defer func() {
+		f.mu.Lock()
+		f.conn = nil
+		f.mu.Unlock()
+		close(closedCh)
+		conn.Close()
+	}()
+	// unblock reading once copy is stopped
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-closedCh:
+		}
+	}()
+	if err := f.write("SUB " + f.subject + " " + f.group + " 1\r\n"); err != nil {
+		return err
+	}
+	logInfo("consuming keys from nats subject '%s', queue group '%s'", f.subject, f.group)
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		switch {
+		case line == "PING":
+			if err := f.write("PONG\r\n"); err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			return errors.New("nats server: " + strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+		case strings.HasPrefix(line, "MSG "):
+			// MSG <subject> <sid> [reply-to] <size>
+			fields := strings.Fields(line)
+			size, err := strconv.Atoi(fields[len(fields)-1])
+			if err != nil {
+				return fmt.Errorf("invalid nats message '%s'", line)
+			}
+			payload := make([]byte, size+2)
+			if _, err := io.ReadFull(r, payload); err != nil {
+				return err
+			}
+			ev, ok := acceptedQueueEvent(payload[:size], prefix)
+			if !ok {
+				continue
+			}
+			select {
+			case ch <- ev:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+}
+
+func (f *natsFeed) connect() (net.Conn, *bufio.Reader, error) {
+	conn, err := net.DialTimeout("tcp", f.addr, natsDialTimeout)
+	if err != nil {
+		return nil, nil, err
+	}
+	conn.SetDeadline(time.Now().Add(natsDialTimeout))
+	r := bufio.NewReader(conn)
+	line, err := r.ReadString('\n')
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	if !strings.HasPrefix(line, "INFO ") {
+		conn.Close()
+		return nil, nil, fmt.Errorf("unexpected greeting of nats server: %.100s", line)
+	}
+	var info struct {
+		TLSRequired bool `json:"tls_required"`
+	}
+	json.Unmarshal([]byte(strings.TrimPrefix(line, "INFO ")), &info)
+	if f.tls || info.TLSRequired {
+		host, _, _ := net.SplitHostPort(f.addr)
+		tc := tls.Client(conn, &tls.Config{ServerName: host})
+		if err := tc.Handshake(); err != nil {
+			conn.Close()
+			return nil, nil, err
+		}
+		conn, r = tc, bufio.NewReader(tc)
+	}
+
+	opts := map[string]interface{}{"verbose": false, "pedantic": false, "name": "s3-copy-dir", "lang": "go", "version": "1"}
+	switch {
+	case f.user != "" && f.pass != "":
+		opts["user"], opts["pass"] = f.user, f.pass
+	case f.user != "":
+		opts["auth_token"] = f.user
+	}
+	b, _ := json.Marshal(opts)
+	// PONG confirms that connection was accepted, errors are sent before it
+	if _, err := conn.Write([]byte("CONNECT " + string(b) + "\r\nPING\r\n")); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			conn.Close()
+			return nil, nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if strings.HasPrefix(line, "-ERR") {
+			conn.Close()
+			return nil, nil, errors.New("nats server: " + strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+		}
+		if line == "PONG" {
+			break
+		}
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, r, nil
+}
+
+func (f *natsFeed) write(s string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.conn == nil {
+		return errors.New("not connected to nats server")
+	}
+	_, err := io.WriteString(f.conn, s)
+	return err
+}
+
+func (f *natsFeed) publish(subject, key string, value []byte) error {
+	return f.write("PUB " + subject + " " + strconv.Itoa(len(value)) + "\r\n" + string(value) + "\r\n")
+}
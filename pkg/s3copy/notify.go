@@ -0,0 +1,139 @@
+package s3copy
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// notification requests are retried with exponential backoff
+	notifyRetries    = 3
+	notifyRetryDelay = time.Second * 2
+	notifyTimeout    = time.Second * 10
+
+	// header with hex encoded HMAC-SHA256 of request body
+	signatureHeader = "X-S3-Copy-Dir-Signature"
+)
+
+// POST body to url, failed requests and 5xx responses are retried
+func postWithRetry(url, contentType string, body []byte, headers map[string]string) error {
+	client := http.Client{Timeout: notifyTimeout}
+	delay := notifyRetryDelay
+	var err error
+	for attempt := 0; ; attempt++ {
+		err = post(&client, url, contentType, body, headers)
+		if err == nil || attempt >= notifyRetries {
+			return err
+		}
+		if se, ok := err.(*statusError); ok && se.code/100 == 4 {
+			return err
+		}
+		logWarn("retrying notification to '%s' in %s: %s", url, delay, err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// unsuccessful http response
+type statusError struct {
+	code   int
+	status string
+}
+
+func (se *statusError) Error() string {
+	return "server responded with " + se.status
+}
+
+func post(client *http.Client, url, contentType string, body []byte, headers map[string]string) error {
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return &statusError{resp.StatusCode, resp.Status}
+	}
+	return nil
+}
+
+// post final report to webhook, body is signed with secret (if set),
+// so receiver can verify notification was sent by this copy
+func notifyWebhook(url, secret string, r *Report) error {
+	body, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	headers := map[string]string{}
+	if secret != "" {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		headers[signatureHeader] = fmt.Sprintf("sha256=%s", hex.EncodeToString(mac.Sum(nil)))
+	}
+	return postWithRetry(url, "application/json", body, headers)
+}
+
+// post message to slack incoming webhook
+func notifySlack(url, text string) error {
+	body, err := json.Marshal(map[string]string{"text": text})
+	if err != nil {
+		return err
+	}
+	return postWithRetry(url, "application/json", body, nil)
+}
+
+// slack message with summary numbers of finished copy
+func slackReportText(r *Report) string {
+	status := ":white_check_mark: s3-copy-dir finished"
+	if r.ExitCode != ExitOK {
+		status = fmt.Sprintf(":x: s3-copy-dir failed (exit code %d)", r.ExitCode)
+	}
+	return fmt.Sprintf("%s: %s\n`%s/%s` from %s to %s\n%d processed, %d copied, %d skipped, %d failed, %s in %s",
+		status, r.Result, r.Bucket, r.Directory, r.Source, r.Destination,
+		r.Processed, r.Copied, r.Skipped, r.Failed, FormatBytes(r.Bytes),
+		time.Duration(r.DurationSec*float64(time.Second)).Round(time.Second))
+}
+
+// notify slack and ping url about copy which couldn't start, e.g. because of held lock
+func notifyStartFailed(c *Config, code int, err error) {
+	if c.Options.SlackWebhookURL != "" {
+		text := fmt.Sprintf(":x: s3-copy-dir failed (exit code %d)\n`%s/%s` from %s to %s\n%s",
+			code, c.Options.Bucket, c.Options.Directory, c.Source.String(), c.Destination.String(), err)
+		if err := notifySlack(c.Options.SlackWebhookURL, text); err != nil {
+			logError("sending slack notification: %s", err)
+		}
+	}
+	if c.Options.PingURL != "" {
+		pingHealthcheck(c.Options.PingURL, pingFail, err.Error())
+	}
+}
+
+// healthchecks.io style ping url suffixes
+const (
+	pingStart   = "/start"
+	pingSuccess = ""
+	pingFail    = "/fail"
+)
+
+// ping dead-man switch url on start, success or failure of copy, body is shown
+// in the monitoring service as ping details. missed ping raises alert on its side
+func pingHealthcheck(url, suffix, body string) {
+	url = strings.TrimSuffix(url, "/") + suffix
+	if err := postWithRetry(url, "text/plain", []byte(body), nil); err != nil {
+		logError("pinging '%s': %s", url, err)
+	}
+}
@@ -0,0 +1,370 @@
+package s3copy
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+)
+
+// modes of key obfuscation, see Options.KeyObfuscation
+const (
+	// HMAC-SHA256 of key, names can be translated back only with the mapping
+	KeyObfuscationHash = "hash"
+	// key encrypted deterministically with AES, names can be decrypted with the secret alone
+	KeyObfuscationEncrypt = "encrypt"
+)
+
+// shortest secret accepted in secret_file
+const minObfuscationSecret = 16
+
+// how often mapping with new names is saved while copy is running, so names of objects
+// copied before a crash aren't lost
+const keyMapSaveInterval = time.Second * 30
+
+// objects of untrusted destination are stored under obfuscated names of their keys below
+// directory, mapping of names to source keys is kept encrypted in destination, so restore
+// translates them back. the same key always gets the same name, so reruns skip copied objects
+type KeyObfuscationConfig struct {
+	// "hash" or "encrypt"
+	Mode string `json:"mode"`
+	// file with secret of at least 16 bytes, e.g. created with `head -c 32 /dev/urandom`.
+	// losing it makes names and mapping unreadable
+	SecretFile string `json:"secret_file"`
+	// key of encrypted mapping object in destination, must be outside of directory
+	Mapping string `json:"mapping"`
+}
+
+// first line of mapping, mapping of another directory or mode isn't used
+type keyMapHeader struct {
+	Directory string `json:"directory"`
+	Mode      string `json:"mode"`
+}
+
+// entry of mapping: key below directory and its name in destination
+type keyMapEntry struct {
+	Key  string `json:"k"`
+	Name string `json:"n"`
+}
+
+// names of keys below directory prefix, keys are recorded as they're named and
+// the mapping is saved to destination when the run ends
+type keyObfuscator struct {
+	mode    string
+	prefix  string
+	mapping string
+	// keys derived from the secret for names, their encryption and the mapping
+	nameKey, cryptKey, mapKey []byte
+
+	mu    sync.Mutex
+	names map[string]string
+	dirty bool
+	// number of names added, mapping saved meanwhile doesn't have the later ones
+	added int
+	// saves of mapping one at a time
+	saveMu sync.Mutex
+}
+
+func newKeyObfuscator(c *Config) (*keyObfuscator, error) {
+	ko := c.Options.KeyObfuscation
+	if ko == nil {
+		return nil, nil
+	}
+	if ko.Mode != KeyObfuscationHash && ko.Mode != KeyObfuscationEncrypt {
+		return nil, fmt.Errorf("unknown key obfuscation mode '%s', must be %s or %s", ko.Mode, KeyObfuscationHash, KeyObfuscationEncrypt)
+	}
+	secret, err := ioutil.ReadFile(ko.SecretFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading secret of key obfuscation: %s", err)
+	}
+	secret = bytes.TrimSpace(secret)
+	if len(secret) < minObfuscationSecret {
+		return nil, fmt.Errorf("secret in '%s' is shorter than %d bytes", ko.SecretFile, minObfuscationSecret)
+	}
+	derive := func(purpose string) []byte {
+		h := hmac.New(sha256.New, secret)
+		io.WriteString(h, "s3-copy-dir "+purpose)
+		return h.Sum(nil)
+	}
+	return &keyObfuscator{
+		mode: ko.Mode, prefix: c.listPrefix(), mapping: ko.Mapping, names: map[string]string{},
+		nameKey: derive("key name"), cryptKey: derive("key encryption"), mapKey: derive("key mapping"),
+	}, nil
+}
+
+// key in destination, keys outside of directory and directory itself are kept
+func (o *keyObfuscator) key(key string) string {
+	if !strings.HasPrefix(key, o.prefix) || len(key) == len(o.prefix) {
+		return key
+	}
+	rel := key[len(o.prefix):]
+	o.mu.Lock()
+	name, ok := o.names[rel]
+	o.mu.Unlock()
+	if ok {
+		return o.prefix + name
+	}
+	name = o.name(rel)
+	o.mu.Lock()
+	o.names[rel], o.dirty = name, true
+	o.added++
+	o.mu.Unlock()
+	return o.prefix + name
+}
+
+// in encrypt mode synthetic IV is MAC of the key, so encryption is deterministic,
+// and decrypted key is authenticated by it
+func (o *keyObfuscator) name(rel string) string {
+	mac := hmac.New(sha256.New, o.nameKey)
+	io.WriteString(mac, rel)
+	sum := mac.Sum(nil)
+	if o.mode == KeyObfuscationHash {
+		return hex.EncodeToString(sum)
+	}
+	iv := sum[:aes.BlockSize]
+	block, _ := aes.NewCipher(o.cryptKey)
+	out := make([]byte, aes.BlockSize+len(rel))
+	copy(out, iv)
+	cipher.NewCTR(block, iv).XORKeyStream(out[aes.BlockSize:], []byte(rel))
+	return base64.RawURLEncoding.EncodeToString(out)
+}
+
+// key below directory of name decrypted in encrypt mode, ok is false if it isn't a name of key
+func (o *keyObfuscator) decrypt(name string) (string, bool) {
+	if o.mode != KeyObfuscationEncrypt {
+		return "", false
+	}
+	b, err := base64.RawURLEncoding.DecodeString(name)
+	if err != nil || len(b) < aes.BlockSize {
+		return "", false
+	}
+	block, _ := aes.NewCipher(o.cryptKey)
+	rel := make([]byte, len(b)-aes.BlockSize)
+	cipher.NewCTR(block, b[:aes.BlockSize]).XORKeyStream(rel, b[aes.BlockSize:])
+	if o.name(string(rel)) != name {
+		return "", false
+	}
+	return string(rel), true
+}
+
+// read mapping of destination, names of keys found in it aren't computed again
+func (o *keyObfuscator) load(ctx context.Context, dst ObjectStore) error {
+	r, _, err := dst.Get(ctx, o.mapping, 0, -1)
+	if classifyError(err) == errNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	sealed, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	aead, err := o.mapCipher()
+	if err != nil {
+		return err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return errors.New("mapping is truncated")
+	}
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(o.mapping))
+	if err != nil {
+		return errors.New("mapping can't be decrypted, secret differs from the one it was written with")
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(plain))
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(gz)
+	var h keyMapHeader
+	if err := dec.Decode(&h); err != nil {
+		return fmt.Errorf("reading mapping header: %s", err)
+	}
+	if h.Directory != o.prefix || h.Mode != o.mode {
+		return fmt.Errorf("mapping is of directory '%s' with mode %s, not '%s' with mode %s", h.Directory, h.Mode, o.prefix, o.mode)
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for {
+		var e keyMapEntry
+		if err := dec.Decode(&e); err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("reading mapping: %s", err)
+		}
+		o.names[e.Key] = e.Name
+	}
+	logInfo("loaded mapping of %d obfuscated keys from '%s'", len(o.names), o.mapping)
+	return nil
+}
+
+// write mapping to destination if keys were named since it was saved or loaded. the whole
+// mapping replaces the previous one, so mapping object is always complete. keys are named
+// while it's written, names added meanwhile are written by the next save
+func (o *keyObfuscator) save(ctx context.Context, dst ObjectStore, bucket string) error {
+	o.saveMu.Lock()
+	defer o.saveMu.Unlock()
+	o.mu.Lock()
+	if !o.dirty {
+		o.mu.Unlock()
+		return nil
+	}
+	plain, err := o.encodeMapping()
+	added, count := o.added, len(o.names)
+	o.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	aead, err := o.mapCipher()
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	body := aead.Seal(nonce, nonce, plain, []byte(o.mapping))
+	_, err = dst.Put(ctx, o.mapping, bytes.NewReader(body), int64(len(body)), "application/octet-stream")
+	audit(auditEntry{Op: auditPut, Bucket: bucket, Key: o.mapping, Size: int64(len(body))}, err)
+	if err != nil {
+		return err
+	}
+	o.mu.Lock()
+	if o.added == added {
+		o.dirty = false
+	}
+	o.mu.Unlock()
+	logInfo("saved mapping of %d obfuscated keys to '%s/%s'", count, bucket, o.mapping)
+	return nil
+}
+
+// save mapping periodically until stopCh is closed
+func (o *keyObfuscator) run(dst ObjectStore, bucket string, stopCh <-chan struct{}) {
+	ticker := time.NewTicker(keyMapSaveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+			if err := o.save(context.Background(), dst, bucket); err != nil {
+				logWarn("saving mapping of obfuscated keys '%s': %s", o.mapping, err)
+			}
+		}
+	}
+}
+
+// gzipped json lines of mapping, called with names locked
+func (o *keyObfuscator) encodeMapping() ([]byte, error) {
+	var plain bytes.Buffer
+	gz := gzip.NewWriter(&plain)
+	w := bufio.NewWriter(gz)
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(keyMapHeader{Directory: o.prefix, Mode: o.mode}); err != nil {
+		return nil, err
+	}
+	for key, name := range o.names {
+		if err := enc.Encode(keyMapEntry{Key: key, Name: name}); err != nil {
+			return nil, err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return plain.Bytes(), nil
+}
+
+func (o *keyObfuscator) mapCipher() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(o.mapKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// function translating names of destination keys back to source keys, from mapping, or
+// decrypted in encrypt mode. ok is false for keys which aren't names of source keys
+func (o *keyObfuscator) reverse() func(key string) (string, bool) {
+	o.mu.Lock()
+	keys := make(map[string]string, len(o.names))
+	for key, name := range o.names {
+		keys[name] = key
+	}
+	o.mu.Unlock()
+	return func(key string) (string, bool) {
+		if !strings.HasPrefix(key, o.prefix) {
+			return "", false
+		}
+		name := key[len(o.prefix):]
+		if rel, ok := keys[name]; ok {
+			return o.prefix + rel, true
+		}
+		if rel, ok := o.decrypt(name); ok {
+			return o.prefix + rel, true
+		}
+		return "", false
+	}
+}
+
+// create copier restoring obfuscated directory of destination into source, names are
+// translated back to source keys, objects of directory which aren't named keys are skipped
+func newKeyMapRestorer(c *Config, ro RestoreOptions) (*Copier, error) {
+	if ro.Generation != "" || !ro.At.IsZero() {
+		return nil, errors.New("backup isn't configured, generations can't be restored")
+	}
+	ko, err := newKeyObfuscator(c)
+	if err != nil {
+		return nil, err
+	}
+	dst, err := destinationStore(c)
+	if err != nil {
+		return nil, err
+	}
+	if err := ko.load(context.Background(), dst); err != nil {
+		return nil, fmt.Errorf("loading mapping of obfuscated keys '%s': %s", ko.mapping, err)
+	}
+	sourceKey := ko.reverse()
+	rc := restoreConfig(c, c.Options.Directory, func(key string) string {
+		if k, ok := sourceKey(key); ok {
+			return k
+		}
+		return key
+	})
+	rc.Run.Heal = ro.Overwrite
+	rc.Run.Filters = append([]Filter{func(obj Object) bool {
+		_, ok := sourceKey(obj.Key)
+		if !ok {
+			logDebug("skipping '%s/%s', it isn't a name of obfuscated key", c.Options.Bucket, obj.Key)
+		}
+		return ok
+	}}, rc.Run.Filters...)
+	cp, err := NewCopier(rc)
+	if err != nil {
+		return nil, err
+	}
+	cp.normalizeKey = func(key string) string {
+		k, _ := sourceKey(key)
+		return k
+	}
+	logInfo("restoring obfuscated '%s/%s' into source", c.Options.Bucket, c.Options.Directory)
+	return cp, nil
+}
@@ -0,0 +1,82 @@
+package s3copy
+
+import "time"
+
+// option of NewCopier, overrides settings loaded from config file
+type Option func(c *Config)
+
+// copy up to n objects concurrently
+func WithConcurrency(n int) Option {
+	return func(c *Config) { c.Options.Concurrency = n }
+}
+
+// adjust number of concurrent copies at runtime, up to configured concurrency
+func WithAutoConcurrency() Option {
+	return func(c *Config) { c.Options.AutoConcurrency = true }
+}
+
+// copy only objects accepted by all filters, rejected objects are reported as skipped
+func WithFilters(filters ...Filter) Option {
+	return func(c *Config) { c.Run.Filters = append(c.Run.Filters, filters...) }
+}
+
+// limit total rate of data read from source to bytesPerSec, 0 - unlimited
+func WithBandwidthLimit(bytesPerSec int64) Option {
+	return func(c *Config) { c.Run.BandwidthLimit = bytesPerSec }
+}
+
+// retry transient errors up to n times, delay is doubled on every attempt
+func WithRetries(n int, delay time.Duration) Option {
+	return func(c *Config) { c.Run.Retries, c.Run.RetryDelay = n, delay }
+}
+
+// abort copy once n objects failed, 0 - never abort
+func WithMaxErrors(n int64) Option {
+	return func(c *Config) { c.Run.MaxErrors = n }
+}
+
+// re-copy objects existing in destination which are older than source
+func WithSync() Option {
+	return func(c *Config) { c.Run.Sync = true }
+}
+
+// re-copy objects existing in destination with content different from source
+func WithHeal() Option {
+	return func(c *Config) { c.Run.Heal = true }
+}
+
+// count objects before copying, so progress has total
+func WithProgress() Option {
+	return func(c *Config) { c.Run.Progress = true }
+}
+
+// process only objects modified since the last successful run recorded in state file
+func WithSinceLastRun() Option {
+	return func(c *Config) { c.Run.SinceLastRun = true }
+}
+
+// list source and destination after the copy and report totals of both sides in Report.Comparison
+func WithCompare() Option {
+	return func(c *Config) { c.Run.Compare = true }
+}
+
+// collect outcome of every processed object in Result.Objects,
+// memory grows with the number of objects
+func WithObjectResults() Option {
+	return func(c *Config) { c.Run.ObjectResults = true }
+}
+
+// pass content of objects through transforms before upload, in order
+func WithTransforms(transforms ...Transform) Option {
+	return func(c *Config) { c.Run.Transforms = append(c.Run.Transforms, transforms...) }
+}
+
+// copy between custom stores instead of configured endpoints, nil store keeps the endpoint
+func WithStores(src, dst ObjectStore) Option {
+	return func(c *Config) { c.Run.SourceStore, c.Run.DestinationStore = src, dst }
+}
+
+// copy only shard index of count shards of the directory, keys are assigned to shards by hash
+func WithShard(index, count int) Option {
+	return func(c *Config) { c.Run.ShardIndex, c.Run.ShardCount = index, count }
+}
@@ -0,0 +1,369 @@
+package s3copy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"time"
+)
+
+// actions of pipeline steps
+const (
+	StepCopy          = "copy"
+	StepSync          = "sync"
+	StepVerify        = "verify"
+	StepRemove        = "rm"
+	StepRemoveOrphans = "delete-orphans"
+)
+
+// outcomes of pipeline steps and batch tenants
+const (
+	StepSucceeded = "succeeded"
+	StepFailed    = "failed"
+	StepSkipped   = "skipped" // dependency didn't succeed or pipeline was stopped
+)
+
+// step of pipeline, it runs only after all steps it depends on succeeded
+type PipelineStep struct {
+	Name   string `json:"name"`
+	Action string `json:"action"`
+	// names of steps which must succeed before the step runs
+	DependsOn []string `json:"depends_on,omitempty"`
+	// config of the step: inline or path of config file, config of pipeline by default
+	Config     *Config    `json:"config,omitempty"`
+	ConfigFile string     `json:"config_file,omitempty"`
+	Run        JobOptions `json:"run"`
+	// compare content of objects with verify
+	Checksum bool `json:"checksum,omitempty"`
+	// endpoint to remove objects from with rm, destination by default
+	Target string `json:"target,omitempty"`
+	// rm and delete-orphans only log objects which would be removed
+	DryRun bool `json:"dry_run,omitempty"`
+	// objects processed by the step at the same time, concurrency of its config by default
+	Concurrency int `json:"concurrency,omitempty"`
+}
+
+// workflow of steps run in order of their dependencies, see LoadPipeline.
+// steps which don't depend on each other run at the same time
+type Pipeline struct {
+	// config of steps which don't set their own
+	Config     *Config        `json:"config,omitempty"`
+	ConfigFile string         `json:"config_file,omitempty"`
+	Steps      []PipelineStep `json:"steps"`
+	// steps running at the same time, 0 - all steps whose dependencies succeeded
+	MaxParallel int `json:"max_parallel,omitempty"`
+	// objects processed by all running steps at the same time, 0 - only limits of steps apply
+	MaxConcurrency int `json:"max_concurrency,omitempty"`
+
+	order   []int
+	workers *workerLimiter
+	mu      sync.Mutex
+	running map[string]*Copier
+	stopped bool
+}
+
+// outcome of pipeline step, report is set for copy and sync steps
+type StepResult struct {
+	Name     string     `json:"name"`
+	Action   string     `json:"action"`
+	Status   string     `json:"status"`
+	ExitCode int        `json:"exit_code"`
+	Error    string     `json:"error,omitempty"`
+	Started  *time.Time `json:"started,omitempty"`
+	Finished *time.Time `json:"finished,omitempty"`
+	Report   *Report    `json:"report,omitempty"`
+}
+
+// load pipeline file: {"config_file": "...", "steps": [<PipelineStep>, ...]}. config files
+// are loaded, dependencies must refer to existing steps and must not form a cycle
+func LoadPipeline(file string) (*Pipeline, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	p := &Pipeline{}
+	if err := json.Unmarshal(b, p); err != nil {
+		return nil, fmt.Errorf("loading pipeline '%s': %s", file, err)
+	}
+	if err := p.load(); err != nil {
+		return nil, fmt.Errorf("pipeline '%s': %s", file, err)
+	}
+	return p, nil
+}
+
+func (p *Pipeline) load() error {
+	if len(p.Steps) == 0 {
+		return errors.New("no steps")
+	}
+	if p.MaxParallel < 0 || p.MaxConcurrency < 0 {
+		return errors.New("max_parallel and max_concurrency must not be negative")
+	}
+	if err := loadStepConfig(&p.Config, p.ConfigFile); err != nil {
+		return err
+	}
+	steps := map[string]int{}
+	for i := range p.Steps {
+		st := &p.Steps[i]
+		if st.Name == "" {
+			return fmt.Errorf("name of step %d is empty", i+1)
+		}
+		if _, ok := steps[st.Name]; ok {
+			return fmt.Errorf("duplicate step name '%s'", st.Name)
+		}
+		steps[st.Name] = i
+		if err := st.load(p.Config); err != nil {
+			return fmt.Errorf("step '%s': %s", st.Name, err)
+		}
+	}
+	for _, st := range p.Steps {
+		for _, dep := range st.DependsOn {
+			if _, ok := steps[dep]; !ok {
+				return fmt.Errorf("step '%s' depends on unknown step '%s'", st.Name, dep)
+			}
+		}
+	}
+
+	// steps are ordered by dependencies, keeping order of the file where it's possible
+	state := make([]int, len(p.Steps)) // 0 - not visited, 1 - visiting, 2 - ordered
+	var visit func(i int) error
+	visit = func(i int) error {
+		switch state[i] {
+		case 1:
+			return fmt.Errorf("dependency cycle at step '%s'", p.Steps[i].Name)
+		case 2:
+			return nil
+		}
+		state[i] = 1
+		for _, dep := range p.Steps[i].DependsOn {
+			if err := visit(steps[dep]); err != nil {
+				return err
+			}
+		}
+		state[i] = 2
+		p.order = append(p.order, i)
+		return nil
+	}
+	for i := range p.Steps {
+		if err := visit(i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// config is inline or loaded from file, not both. both are validated
+func loadStepConfig(c **Config, file string) error {
+	if file == "" {
+		if *c != nil {
+			return (*c).Validate()
+		}
+		return nil
+	}
+	if *c != nil {
+		return errors.New("only one of config and config_file can be set")
+	}
+	var err error
+	*c, err = LoadConfig(file)
+	return err
+}
+
+func (st *PipelineStep) load(defaultConfig *Config) error {
+	if err := loadStepConfig(&st.Config, st.ConfigFile); err != nil {
+		return err
+	}
+	if st.Config == nil {
+		st.Config = defaultConfig
+	}
+	if st.Config == nil {
+		return errors.New("config or config_file must be set for the step or the pipeline")
+	}
+	switch st.Action {
+	case StepCopy, StepSync:
+		c := *st.Config
+		_, err := st.Run.apply(&c)
+		return err
+	case StepVerify, StepRemoveOrphans:
+	case StepRemove:
+		if st.Target == "" {
+			st.Target = TargetDestination
+		}
+		if st.Target != TargetSource && st.Target != TargetDestination {
+			return fmt.Errorf("unknown target '%s', must be source or destination", st.Target)
+		}
+	default:
+		return fmt.Errorf("unknown action '%s', must be %s, %s, %s, %s or %s",
+			st.Action, StepCopy, StepSync, StepVerify, StepRemove, StepRemoveOrphans)
+	}
+	return nil
+}
+
+// run steps in order of dependencies, up to MaxParallel at a time. step starts once all
+// its dependencies succeeded and is skipped if any of them didn't. results are in order
+// of steps in the pipeline file
+func (p *Pipeline) Run(ctx context.Context) []StepResult {
+	p.mu.Lock()
+	p.running = map[string]*Copier{}
+	p.mu.Unlock()
+	if p.MaxConcurrency > 0 {
+		p.workers = newWorkerLimiter(p.MaxConcurrency)
+	}
+	results := make([]StepResult, len(p.Steps))
+	status := map[string]string{}
+	started := make([]bool, len(p.Steps))
+	doneCh := make(chan int)
+	running := 0
+	for {
+		// order of steps is topological, so skipped step is seen by its dependents in the same pass
+		for _, i := range p.order {
+			st := &p.Steps[i]
+			if started[i] || p.MaxParallel > 0 && running >= p.MaxParallel {
+				continue
+			}
+			var pending, failedDep string
+			for _, dep := range st.DependsOn {
+				switch status[dep] {
+				case "":
+					pending = dep
+				case StepSucceeded:
+				default:
+					failedDep = dep
+				}
+			}
+			res := &results[i]
+			res.Name, res.Action = st.Name, st.Action
+			switch {
+			case failedDep != "":
+				res.Status, res.Error = StepSkipped, fmt.Sprintf("step '%s' %s", failedDep, status[failedDep])
+				logWarn("pipeline step '%s' skipped, step '%s' it depends on %s", st.Name, failedDep, status[failedDep])
+			case pending != "":
+				continue
+			case p.isStopped() || ctx.Err() != nil:
+				res.Status, res.Error = StepSkipped, "pipeline stopped"
+			default:
+				started[i] = true
+				running++
+				go func(i int) {
+					p.runStep(ctx, &p.Steps[i], &results[i])
+					doneCh <- i
+				}(i)
+				continue
+			}
+			started[i] = true
+			status[st.Name] = res.Status
+		}
+		if running == 0 {
+			return results
+		}
+		i := <-doneCh
+		running--
+		status[p.Steps[i].Name] = results[i].Status
+	}
+}
+
+func (p *Pipeline) runStep(ctx context.Context, st *PipelineStep, res *StepResult) {
+	c := *st.Config
+	c.Run.sharedWorkers = p.workers
+	if st.Concurrency > 0 {
+		c.Options.Concurrency = st.Concurrency
+	}
+	logInfo("pipeline step '%s' started: %s of '%s/%s'", st.Name, st.Action, c.Options.Bucket, c.Options.Directory)
+	started := time.Now().UTC()
+	res.Started = &started
+
+	var err error
+	res.ExitCode = ExitOK
+	switch st.Action {
+	case StepCopy, StepSync:
+		var opts []Option
+		opts, err = st.Run.apply(&c)
+		c.Run.sharedWorkers = p.workers
+		if st.Action == StepSync {
+			c.Run.Sync = true
+		}
+		var cp *Copier
+		if err == nil {
+			cp, err = NewCopier(&c, opts...)
+		}
+		if err == nil {
+			p.mu.Lock()
+			p.running[st.Name] = cp
+			if p.stopped {
+				cp.Stop()
+			}
+			p.mu.Unlock()
+			var r *Result
+			if r, err = cp.Run(ctx); err == nil {
+				res.Report, res.ExitCode = r.Report, r.ExitCode
+			}
+			p.mu.Lock()
+			delete(p.running, st.Name)
+			p.mu.Unlock()
+		}
+	case StepVerify:
+		var vr *VerifyResult
+		if vr, err = Verify(&c, st.Checksum); err == nil && vr.Missing+vr.Differ+vr.Failed > 0 {
+			res.ExitCode = ExitPartial
+		}
+	case StepRemove, StepRemoveOrphans:
+		var failed int64
+		if st.Action == StepRemove {
+			_, failed, err = Remove(&c, st.Target, st.DryRun)
+		} else {
+			_, failed, err = RemoveOrphans(&c, st.DryRun)
+		}
+		if err == nil && failed > 0 {
+			res.ExitCode = ExitPartial
+		}
+	}
+
+	finished := time.Now().UTC()
+	res.Finished = &finished
+	if err != nil {
+		res.Error, res.ExitCode = err.Error(), ExitError
+	}
+	if res.ExitCode == ExitOK {
+		res.Status = StepSucceeded
+		logInfo("pipeline step '%s' succeeded in %s", st.Name, finished.Sub(started).Round(time.Second))
+		return
+	}
+	res.Status = StepFailed
+	if err != nil {
+		logError("pipeline step '%s' failed: %s", st.Name, err)
+	} else {
+		logError("pipeline step '%s' failed with exit code %d", st.Name, res.ExitCode)
+	}
+}
+
+// stop running copy steps gracefully and skip steps which didn't start yet
+func (p *Pipeline) Stop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.stopped = true
+	for _, cp := range p.running {
+		cp.Stop()
+	}
+}
+
+func (p *Pipeline) isStopped() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.stopped
+}
+
+// exit code of pipeline run: ok if all steps succeeded, otherwise code
+// of the first failed step, or partial if steps were only skipped
+func PipelineExitCode(results []StepResult) int {
+	code := ExitOK
+	for _, res := range results {
+		switch {
+		case res.Status == StepFailed:
+			return res.ExitCode
+		case res.Status == StepSkipped:
+			code = ExitPartial
+		}
+	}
+	return code
+}
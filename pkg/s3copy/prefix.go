@@ -0,0 +1,123 @@
+package s3copy
+
+import "sync"
+
+// objects waiting for a slot of their prefix, dispatching blocks above it
+const maxDeferredObjects = 100000
+
+// limit of concurrent copies of objects sharing key prefix, see Options.PrefixConcurrency.
+// S3 throttles requests per prefix, so objects of one hot prefix don't use all workers
+type PrefixLimit struct {
+	// path segments of keys below directory forming their prefix, 1 by default
+	Depth int `json:"depth"`
+	// copies of objects of one prefix at the same time
+	Limit int `json:"limit"`
+}
+
+// objects of prefix at its limit are deferred instead of blocking the dispatcher, so objects of
+// other prefixes are copied meanwhile. copy which frees a slot of prefix takes its next deferred object
+type prefixLimiter struct {
+	sync.Mutex
+	cond  *sync.Cond
+	depth int
+	limit int
+	// copies holding a slot of prefix and objects waiting for one
+	active   map[string]int
+	deferred map[string][]Object
+	waiting  int
+}
+
+func newPrefixLimiter(l *PrefixLimit) *prefixLimiter {
+	if l == nil {
+		return nil
+	}
+	pl := &prefixLimiter{depth: l.Depth, limit: l.Limit, active: map[string]int{}, deferred: map[string][]Object{}}
+	if pl.depth < 1 {
+		pl.depth = 1
+	}
+	pl.cond = sync.NewCond(pl)
+	return pl
+}
+
+// prefix of key relative to directory, objects directly in directory share "/" prefix
+func (pl *prefixLimiter) prefix(dir, key string) string {
+	return keyPrefix(dir, key, pl.depth)
+}
+
+// take slot of prefix, or defer object if prefix is at its limit. returns false if it was deferred
+func (pl *prefixLimiter) acquire(prefix string, obj Object) bool {
+	pl.Lock()
+	defer pl.Unlock()
+	for pl.active[prefix] >= pl.limit && pl.waiting >= maxDeferredObjects {
+		pl.cond.Wait()
+	}
+	if pl.active[prefix] >= pl.limit {
+		pl.deferred[prefix] = append(pl.deferred[prefix], obj)
+		pl.waiting++
+		return false
+	}
+	pl.active[prefix]++
+	return true
+}
+
+// next deferred object of prefix, slot of the finished copy is passed to it. slot is
+// released if there is none
+func (pl *prefixLimiter) next(prefix string) (Object, bool) {
+	pl.Lock()
+	defer pl.Unlock()
+	defer pl.cond.Broadcast()
+	if q := pl.deferred[prefix]; len(q) > 0 {
+		obj := q[0]
+		if len(q) == 1 {
+			delete(pl.deferred, prefix)
+		} else {
+			pl.deferred[prefix] = q[1:]
+		}
+		pl.waiting--
+		return obj, true
+	}
+	if pl.active[prefix]--; pl.active[prefix] == 0 {
+		delete(pl.active, prefix)
+	}
+	return Object{}, false
+}
+
+// deferred objects of prefix are dropped, copy was stopped
+func (pl *prefixLimiter) drop(prefix string) {
+	pl.Lock()
+	defer pl.Unlock()
+	pl.waiting -= len(pl.deferred[prefix])
+	delete(pl.deferred, prefix)
+	if pl.active[prefix]--; pl.active[prefix] == 0 {
+		delete(pl.active, prefix)
+	}
+	pl.cond.Broadcast()
+}
+
+// some copies still hold slots of their prefixes
+func (pl *prefixLimiter) busy() bool {
+	if pl == nil {
+		return false
+	}
+	pl.Lock()
+	defer pl.Unlock()
+	return len(pl.active) > 0
+}
+
+// copy object holding slot of its prefix, then deferred objects of the prefix one by one
+func (cp *Copier) copyInPrefix(obj Object, prefix string, overwriteOlder bool) {
+	cp.copyObj(obj, overwriteOlder)
+	for {
+		next, ok := cp.prefixes.next(prefix)
+		if !ok {
+			return
+		}
+		cp.wl.acquire()
+		if cp.stopped() != ExitOK {
+			cp.wl.release()
+			cp.prefixes.drop(prefix)
+			return
+		}
+		cp.copyObj(next, overwriteOlder)
+	}
+}
@@ -0,0 +1,118 @@
+package s3copy
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"strings"
+)
+
+// returned by preflight check when credentials lack permissions required by the copy
+type PreflightError struct {
+	// missing permissions, e.g. "PUT on destination 127.0.0.1:9000"
+	Missing []string
+}
+
+func (e *PreflightError) Error() string {
+	return "missing permissions: " + strings.Join(e.Missing, ", ")
+}
+
+// outcome of single probe of preflight check
+type preflightProbe struct {
+	missing []string
+}
+
+// permission is missing if request was denied, other errors abort the check
+func (p *preflightProbe) check(op, target string, err error) error {
+	switch {
+	case err == nil:
+		logDebug("preflight: %s on %s allowed", op, target)
+	case classifyError(err) == errAuth:
+		p.missing = append(p.missing, op+" on "+target)
+		logError("preflight: %s on %s denied: %s", op, target, err)
+	default:
+		return fmt.Errorf("preflight check of %s on %s: %s", op, target, err)
+	}
+	return nil
+}
+
+// check permissions required by the copy with requests of the same kind it does: LIST, STAT
+// and GET of the first object on source, STAT, PUT and DELETE of probe object on every
+// destination. DELETE is required only when the run removes objects (listen, lock object).
+// all missing permissions are reported at once with PreflightError
+func preflight(ctx context.Context, src, dst ObjectStore, c *Config) error {
+	p := &preflightProbe{}
+	source := "source " + c.Source.String()
+	dir := c.Options.Directory
+
+	objs, next, err := src.List(ctx, c.listPrefix(), "", 1)
+	releaseListing(src, c.listPrefix(), next)
+	if err := p.check("LIST", source, err); err != nil {
+		return err
+	}
+	switch {
+	case err != nil:
+	case len(objs) == 0:
+		logWarn("preflight: source directory '%s/%s' is empty, GET on source isn't checked", c.Options.Bucket, dir)
+	default:
+		key := objs[0].Key
+		_, err = src.Stat(ctx, key)
+		if err := p.check("STAT", source, err); err != nil {
+			return err
+		}
+		r, _, err := src.Get(ctx, key, 0, 1)
+		if err == nil {
+			_, err = io.Copy(ioutil.Discard, r)
+			r.Close()
+		}
+		if err := p.check("GET", source, err); err != nil {
+			return err
+		}
+	}
+
+	b := make([]byte, 4)
+	rand.Read(b)
+	probe := path.Join(dir, ".s3-copy-dir-preflight-"+hex.EncodeToString(b))
+	deletes := c.Run.Listen || c.Options.LockObject != ""
+	stores := destinationStores(dst)
+	for i, s := range stores {
+		target := "destination"
+		if names := c.destinations(); len(names) == len(stores) {
+			target += " " + names[i].String()
+		}
+		// probe doesn't exist yet, so not found means STAT is allowed
+		_, err := s.Stat(ctx, probe)
+		if classifyError(err) == errNotFound {
+			err = nil
+		}
+		if err := p.check("STAT", target, err); err != nil {
+			return err
+		}
+		_, err = s.Put(ctx, probe, bytes.NewReader(nil), 0, "application/octet-stream")
+		if err := p.check("PUT", target, err); err != nil {
+			return err
+		}
+		if err != nil {
+			continue
+		}
+		err = s.Delete(ctx, probe)
+		if err != nil && !deletes {
+			logWarn("preflight: probe object '%s/%s' can't be removed from %s: %s", c.Options.Bucket, probe, target, err)
+			continue
+		}
+		if err := p.check("DELETE", target, err); err != nil {
+			return err
+		}
+	}
+
+	if len(p.missing) > 0 {
+		return &PreflightError{Missing: p.missing}
+	}
+	logInfo("preflight: permissions of source and destination are sufficient")
+	return nil
+}
@@ -0,0 +1,112 @@
+package s3copy
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// width of the bar itself, without counters
+const progressBarWidth = 30
+
+// live progress bar rendered on the last line of terminal,
+// log lines are printed above it through progressBar writer
+type progressBar struct {
+	sync.Mutex
+	out    io.Writer
+	oc     *objCounter
+	start  time.Time
+	line   string
+	stopCh chan struct{}
+	doneCh chan struct{}
+}
+
+func newProgressBar(out io.Writer, oc *objCounter) *progressBar {
+	return &progressBar{
+		out:    out,
+		oc:     oc,
+		start:  time.Now(),
+		stopCh: make(chan struct{}),
+		doneCh: make(chan struct{}),
+	}
+}
+
+// write log line above progress bar
+func (pb *progressBar) Write(p []byte) (int, error) {
+	pb.Lock()
+	defer pb.Unlock()
+	fmt.Fprint(pb.out, "\r\033[K")
+	n, err := pb.out.Write(p)
+	fmt.Fprint(pb.out, pb.line)
+	return n, err
+}
+
+// redraw progress bar periodically until stopped
+func (pb *progressBar) run() {
+	defer close(pb.doneCh)
+	ticker := time.NewTicker(time.Millisecond * 250)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			pb.render()
+		case <-pb.stopCh:
+			pb.render()
+			pb.Lock()
+			fmt.Fprintln(pb.out)
+			pb.line = ""
+			pb.Unlock()
+			return
+		}
+	}
+}
+
+func (pb *progressBar) stop() {
+	close(pb.stopCh)
+	<-pb.doneCh
+}
+
+func (pb *progressBar) render() {
+	snap := pb.oc.snapshot()
+	current, total, totalBytes, failed, bytes := snap.Current, snap.Total, snap.TotalBytes, snap.Failed, snap.Bytes
+
+	elapsed := time.Since(pb.start)
+	rate := float64(bytes) / elapsed.Seconds()
+	objRate := float64(current) / elapsed.Seconds()
+
+	counts := fmt.Sprintf("%d", current)
+	eta := "?"
+	ratio := -1.0
+	if total > 0 {
+		counts = fmt.Sprintf("%d/%d", current, total)
+		ratio = float64(current) / float64(total)
+		if objRate > 0 {
+			eta = (time.Duration(float64(total-current)/objRate) * time.Second).Round(time.Second).String()
+		}
+	}
+	if totalBytes > 0 {
+		ratio = float64(bytes) / float64(totalBytes)
+		if rate > 0 {
+			eta = (time.Duration(float64(totalBytes-bytes)/rate) * time.Second).Round(time.Second).String()
+		}
+	}
+
+	bar := strings.Repeat("?", progressBarWidth)
+	if ratio >= 0 {
+		if ratio > 1 {
+			ratio = 1
+		}
+		done := int(ratio * progressBarWidth)
+		bar = strings.Repeat("=", done) + strings.Repeat(" ", progressBarWidth-done)
+	}
+
+	line := fmt.Sprintf("[%s] %s objects, %s, %s/s, %.1f obj/s, ETA %s, %d failed",
+		bar, counts, FormatBytes(bytes), FormatBytes(int64(rate)), objRate, eta, failed)
+
+	pb.Lock()
+	defer pb.Unlock()
+	pb.line = line
+	fmt.Fprint(pb.out, "\r\033[K"+line)
+}
@@ -0,0 +1,43 @@
+package s3copy
+
+import (
+	"time"
+)
+
+// quarantine of destination, nil if it isn't configured
+func newQuarantine(c *Config, store ObjectStore, now time.Time) *aside {
+	if c.Options.Quarantine == "" {
+		return nil
+	}
+	return newAside(store, c.Options.Bucket, c.Options.Quarantine, now)
+}
+
+// copy destination object with content different from source to quarantine, so it's kept
+// for investigation when it's re-copied. object isn't re-copied if it can't be quarantined
+func (cp *Copier) quarantineObj(key string) error {
+	countRequest(true, reqCopy)
+	if err := cp.quarantine.copy(cp.ctx, key); err != nil {
+		return &opError{"quarantine", err}
+	}
+	logWarn("copied '%s/%s' to quarantine '%s/%s', its content didn't match source", cp.bucket, key, cp.bucket, cp.quarantine.key(key))
+	return nil
+}
+
+// quarantine mismatched object found by verify and copy it again from source
+func (cp *Copier) repairObj(obj Object) error {
+	key := cp.destKey(obj.Key)
+	if err := cp.quarantineObj(key); err != nil {
+		return err
+	}
+	r, srcInfo, err := cp.src.Get(cp.ctx, obj.Key, 0, -1)
+	if err != nil {
+		return &opError{"get", err}
+	}
+	defer r.Close()
+	size, err := cp.dst.Put(cp.ctx, key, r, srcInfo.Size, srcInfo.ContentType)
+	audit(auditEntry{Op: auditPut, Bucket: cp.bucket, Key: key, Size: size}, err)
+	if err != nil {
+		return &opError{"put", err}
+	}
+	return nil
+}
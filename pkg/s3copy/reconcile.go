@@ -0,0 +1,53 @@
+package s3copy
+
+import "time"
+
+// objects modified slightly before pass start are re-checked too, to tolerate clock skew
+// between this host and source endpoint
+const reconcileClockSkew = time.Minute
+
+// re-list source after the copy and copy objects created or modified since the previous pass,
+// repeated until a pass finds no changes or maxPasses is reached. returns false if listing failed
+func (cp *Copier) reconcile(dir string, pageSize int, since time.Time, maxPasses int) bool {
+	for pass := 1; pass <= maxPasses; pass++ {
+		passStart := time.Now()
+		logInfo("reconciliation pass %d of %d: looking for objects modified since %s", pass, maxPasses, since.Format(time.RFC3339))
+
+		doneCh := make(chan struct{})
+		changed := 0
+		modifiedCh := make(chan listEntry)
+		go func(since time.Time) {
+			defer close(modifiedCh)
+			for obj := range listObjects(cp.src, cp.bucket, dir, pageSize, nil, doneCh) {
+				if obj.Err == nil && (!cp.inShard(obj.Key) || obj.LastModified.Before(since.Add(-reconcileClockSkew))) {
+					continue
+				}
+				changed++
+				select {
+				case modifiedCh <- obj:
+				case <-doneCh:
+					return
+				}
+			}
+		}(since)
+
+		listed := cp.dispatch(modifiedCh, true)
+		close(doneCh)
+		cp.wait()
+		if !listed {
+			return false
+		}
+		if cp.stopped() != ExitOK {
+			return true
+		}
+
+		logInfo("reconciliation pass %d: %d objects modified during previous pass", pass, changed)
+		if changed == 0 {
+			return true
+		}
+		since = passStart
+	}
+
+	logWarn("reconciliation stopped after %d passes, source is still being modified", maxPasses)
+	return true
+}
@@ -0,0 +1,161 @@
+package s3copy
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// change of source object received from bucket notifications or queue
+type changeEvent struct {
+	Key     string
+	Deleted bool
+	// events may have been lost, whole directory must be re-synced
+	Resync bool
+	// feed failed and can't recover, replication stops
+	Err error
+	// object listed by the feed, it's copied without stat of source
+	Object *Object
+	// called once event was handled, ok is false if handling failed. may be nil
+	done func(ok bool)
+}
+
+func (ev changeEvent) handled(ok bool) {
+	if ev.done != nil {
+		ev.done(ok)
+	}
+}
+
+// source of change events of source objects
+type changeFeed interface {
+	// start receiving events of keys with prefix, channel is closed once ctx is cancelled.
+	// events generated before copy finished are buffered or kept by the feed
+	changes(ctx context.Context, prefix string) <-chan changeEvent
+}
+
+// events of the same key are handled one at a time in order of arrival,
+// events of different keys concurrently
+type keyQueue struct {
+	sync.Mutex
+	pending map[string][]changeEvent
+}
+
+// queue event, returns true if there's no handler of its key running
+func (q *keyQueue) push(ev changeEvent) bool {
+	q.Lock()
+	defer q.Unlock()
+	if evs, ok := q.pending[ev.Key]; ok {
+		q.pending[ev.Key] = append(evs, ev)
+		return false
+	}
+	q.pending[ev.Key] = nil
+	return true
+}
+
+// next queued event of key, false once there are none and handler must exit
+func (q *keyQueue) pop(key string) (changeEvent, bool) {
+	q.Lock()
+	defer q.Unlock()
+	evs := q.pending[key]
+	if len(evs) == 0 {
+		delete(q.pending, key)
+		return changeEvent{}, false
+	}
+	q.pending[key] = evs[1:]
+	return evs[0], true
+}
+
+// replicate changes of source objects until copy is stopped: created and modified objects
+// are copied, deleted ones are removed from destination once they're confirmed missing in source
+func (cp *Copier) replicate(events <-chan changeEvent, dir string, pageSize int) {
+	logInfo("replicating changes of '%s/%s'", cp.bucket, dir)
+	q := &keyQueue{pending: map[string][]changeEvent{}}
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		var ev changeEvent
+		var ok bool
+		select {
+		case <-cp.stopCh:
+			return
+		case ev, ok = <-events:
+		}
+		if !ok {
+			return
+		}
+		switch {
+		case ev.Err != nil:
+			logError("aborting replication, receiving change events: %s", ev.Err)
+			cp.stop(ExitAborted)
+			return
+		case ev.Resync:
+			logWarn("change events of '%s/%s' may have been lost, re-syncing directory", cp.bucket, dir)
+			ev.handled(cp.syncPass(dir, pageSize))
+		case !cp.inShard(ev.Key) || !cp.accepted(Object{Key: ev.Key}):
+			ev.handled(true)
+		case q.push(ev):
+			wg.Add(1)
+			go func(ev changeEvent) {
+				defer wg.Done()
+				for ok := true; ok; ev, ok = q.pop(ev.Key) {
+					cp.wl.acquire()
+					// events left once copy is stopped aren't handled, feeds deliver them again
+					if cp.stopped() != ExitOK {
+						cp.wl.release()
+						continue
+					}
+					ev.handled(cp.applyChange(ev))
+				}
+			}(ev)
+		}
+	}
+}
+
+// copy or delete object of event, worker slot is released. returns false if it failed
+func (cp *Copier) applyChange(ev changeEvent) bool {
+	if ev.Object != nil {
+		return cp.copyObj(*ev.Object, cp.cfg.Run.Sync) != ResultFailed
+	}
+	countRequest(false, reqHead)
+	obj, err := cp.src.Stat(cp.ctx, ev.Key)
+	class := classifyError(err)
+	if err != nil && class != errNotFound {
+		cp.wl.release()
+		logError("replicating '%s/%s': stat of source: %s", cp.bucket, ev.Key, err)
+		return false
+	}
+	if err == nil {
+		// object deleted and created again is copied as well
+		return cp.copyObj(obj, true) != ResultFailed
+	}
+	defer cp.wl.release()
+	if !ev.Deleted {
+		logDebug("'%s/%s' was removed from source before it was replicated", cp.bucket, ev.Key)
+		return true
+	}
+	// DELETE requests are free, they aren't counted
+	err = deleteObject(cp.ctx, cp.dst, cp.trash, cp.bucket, cp.destKey(ev.Key))
+	if err != nil && classifyError(err) != errNotFound {
+		logError("replicating removal of '%s/%s': %s", cp.bucket, ev.Key, err)
+		return false
+	}
+	if cp.trash != nil && err == nil {
+		logInfo("moved '%s/%s' to trash, it was removed from source", cp.bucket, ev.Key)
+	} else {
+		logInfo("removed '%s/%s', it was removed from source", cp.bucket, ev.Key)
+	}
+	cp.publishResult(objectEvent{Key: ev.Key, Result: resultRemoved})
+	cp.results.write(objectEvent{Bucket: cp.bucket, Key: ev.Key, Result: resultRemoved})
+	return true
+}
+
+// copy every object of directory missing or older in destination, returns false if listing failed
+func (cp *Copier) syncPass(dir string, pageSize int) bool {
+	start := time.Now()
+	doneCh := make(chan struct{})
+	listed := cp.dispatch(listObjects(cp.src, cp.bucket, dir, pageSize, nil, doneCh), true)
+	close(doneCh)
+	cp.wait()
+	logInfo("re-sync of '%s/%s' took %s", cp.bucket, dir, time.Since(start).Round(time.Second))
+	return listed
+}
@@ -0,0 +1,215 @@
+package s3copy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// counters of objects under a single top-level prefix
+type PrefixStats struct {
+	Copied  int64 `json:"copied"`
+	Skipped int64 `json:"skipped"`
+	Failed  int64 `json:"failed"`
+	Bytes   int64 `json:"bytes"`
+	// failures under the prefix by error class
+	Errors map[string]int64 `json:"errors_by_class,omitempty"`
+}
+
+// results of copied objects broken down by error class, reason of skip and top-level prefix
+type breakdown struct {
+	sync.Mutex
+	dir      string
+	errors   map[string]int64
+	skips    map[string]int64
+	prefixes map[string]*PrefixStats
+}
+
+func newBreakdown(dir string) *breakdown {
+	return &breakdown{dir: dir, errors: map[string]int64{}, skips: map[string]int64{}, prefixes: map[string]*PrefixStats{}}
+}
+
+// first path element of key relative to copied directory, "/" for objects directly in it
+func topPrefix(dir, key string) string {
+	rel := strings.TrimPrefix(strings.TrimPrefix(key, dir), "/")
+	if i := strings.Index(rel, "/"); i >= 0 {
+		return rel[:i+1]
+	}
+	return "/"
+}
+
+func (b *breakdown) record(ev objectEvent) {
+	b.Lock()
+	defer b.Unlock()
+
+	p := topPrefix(b.dir, ev.Key)
+	ps, ok := b.prefixes[p]
+	if !ok {
+		ps = &PrefixStats{}
+		b.prefixes[p] = ps
+	}
+	switch ev.Result {
+	case ResultCopied, ResultRecopied:
+		ps.Copied++
+		ps.Bytes += ev.Bytes
+	case ResultSkipped:
+		ps.Skipped++
+		b.skips[ev.Skip]++
+	case ResultFailed:
+		ps.Failed++
+		if ps.Errors == nil {
+			ps.Errors = map[string]int64{}
+		}
+		ps.Errors[ev.ErrorClass]++
+		b.errors[ev.ErrorClass]++
+	}
+}
+
+// max number of prefixes listed in failures summary
+const summaryPrefixes = 10
+
+type namedCount struct {
+	name  string
+	count int64
+}
+
+// sort counts by value descending, ties by name
+func sortedCounts(m map[string]int64) []namedCount {
+	res := make([]namedCount, 0, len(m))
+	for name, count := range m {
+		res = append(res, namedCount{name, count})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].count != res[j].count {
+			return res[i].count > res[j].count
+		}
+		return res[i].name < res[j].name
+	})
+	return res
+}
+
+func formatCounts(counts []namedCount) string {
+	parts := make([]string, len(counts))
+	for i, c := range counts {
+		parts[i] = fmt.Sprintf("%s %d", c.name, c.count)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// print skipped objects by reason, so objects which exist in destination stand out from
+// the ones which weren't copied for other reasons
+func (b *breakdown) logSkips() {
+	b.Lock()
+	defer b.Unlock()
+	if len(b.skips) > 0 {
+		logSummary("skipped by reason: %s", formatCounts(sortedCounts(b.skips)))
+	}
+}
+
+// print failures by error class and by top-level prefix with the most failures,
+// so failures concentrated under a single prefix stand out
+func (b *breakdown) logFailures() {
+	b.Lock()
+	defer b.Unlock()
+	if len(b.errors) == 0 {
+		return
+	}
+	logSummary("failures by error class: %s", formatCounts(sortedCounts(b.errors)))
+
+	failed := map[string]int64{}
+	for p, ps := range b.prefixes {
+		if ps.Failed > 0 {
+			failed[p] = ps.Failed
+		}
+	}
+	byPrefix := sortedCounts(failed)
+	for i, p := range byPrefix {
+		if i == summaryPrefixes {
+			logSummary("failures by prefix: ... %d more prefixes", len(byPrefix)-summaryPrefixes)
+			break
+		}
+		logSummary("failures by prefix: '%s' %d (%s)", p.name, p.count, formatCounts(sortedCounts(b.prefixes[p.name].Errors)))
+	}
+}
+
+// final report of the run written as json
+type Report struct {
+	Source      string    `json:"source"`
+	Destination string    `json:"destination"`
+	Bucket      string    `json:"bucket"`
+	Directory   string    `json:"directory"`
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end"`
+	DurationSec float64   `json:"duration_sec"`
+	ExitCode    int       `json:"exit_code"`
+	Result      string    `json:"result"`
+
+	Processed  int64   `json:"processed"`
+	Copied     int64   `json:"copied"`
+	Skipped    int64   `json:"skipped"`
+	Failed     int64   `json:"failed"`
+	Bytes      int64   `json:"bytes"`
+	Throughput float64 `json:"throughput_bytes_per_sec"`
+
+	// latency percentiles of requests by operation: STAT, GET, PUT
+	Latency map[string]LatencyStats `json:"latency"`
+	// requests, egress and estimated bill according to price table
+	Cost *CostEstimate `json:"cost"`
+
+	ErrorsByClass map[string]int64        `json:"errors_by_class"`
+	Prefixes      map[string]*PrefixStats `json:"prefixes"`
+	// skipped objects by reason, e.g. "exists" or "filtered"
+	SkippedByReason map[string]int64 `json:"skipped_by_reason,omitempty"`
+	// totals of source and destination listed after the copy, with compare
+	Comparison *Comparison `json:"comparison,omitempty"`
+}
+
+// collect final report of copy from counters and breakdown
+func (cp *Copier) finalReport(c *Config, start time.Time, code int, result string) *Report {
+	end := time.Now()
+	r := &Report{
+		Source:      c.sourcesString(),
+		Destination: c.destinationsString(),
+		Bucket:      c.Options.Bucket,
+		Directory:   c.Options.Directory,
+		Start:       start.UTC(),
+		End:         end.UTC(),
+		DurationSec: end.Sub(start).Seconds(),
+		ExitCode:    code,
+		Result:      result,
+	}
+
+	counts := cp.oc.snapshot()
+	r.Processed, r.Copied, r.Skipped, r.Failed, r.Bytes = counts.Current, counts.Copied, counts.Skipped, counts.Failed, counts.Bytes
+	if r.DurationSec > 0 {
+		r.Throughput = float64(r.Bytes) / r.DurationSec
+	}
+	r.Latency = cp.latency.stats()
+	srcReqs, dstReqs := requests.snapshot()
+	r.Cost = estimateCost(c.Options.Prices, srcReqs, dstReqs, r.Bytes)
+
+	cp.breakdown.Lock()
+	r.ErrorsByClass = cp.breakdown.errors
+	r.Prefixes = cp.breakdown.prefixes
+	r.SkippedByReason = cp.breakdown.skips
+	cp.breakdown.Unlock()
+	return r
+}
+
+// write report atomically, so automation never reads partially written file
+func writeReport(path string, r *Report) error {
+	b, err := json.MarshalIndent(r, "", "    ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, append(b, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,200 @@
+package s3copy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// generation of backup restored into source directory, see NewRestorer
+type RestoreOptions struct {
+	// name of generation, if empty the latest complete generation is restored
+	Generation string
+	// restore the latest complete generation finished at or before it, zero - the latest one
+	At time.Time
+	// re-copy objects existing in source with content different from the generation,
+	// otherwise only missing objects are restored
+	Overwrite bool
+}
+
+// objects of generation restore would copy, see PlanRestore
+type RestorePlan struct {
+	Generation string `json:"generation"`
+	// objects missing in source
+	Objects int64 `json:"objects"`
+	Bytes   int64 `json:"bytes"`
+	// objects existing in source, kept, or re-copied if content differs with Overwrite
+	Existing int64 `json:"existing"`
+}
+
+// generations of backup recorded in index of destination, oldest first
+func BackupGenerations(c *Config) ([]Generation, error) {
+	if c.Options.Backup == nil {
+		return nil, errors.New("backup isn't configured")
+	}
+	dst, err := destinationStore(c)
+	if err != nil {
+		return nil, err
+	}
+	b := &backupRun{cfg: c.Options.Backup}
+	idx, err := loadBackupIndex(context.Background(), dst, b.indexKey())
+	if err != nil {
+		return nil, fmt.Errorf("loading backup index: %s", err)
+	}
+	return idx.Generations, nil
+}
+
+// generation picked by options of restore
+func selectGeneration(gens []Generation, ro RestoreOptions) (Generation, error) {
+	if ro.Generation != "" {
+		for _, g := range gens {
+			if g.Name == ro.Generation {
+				return g, nil
+			}
+		}
+		return Generation{}, fmt.Errorf("backup generation '%s' not found", ro.Generation)
+	}
+	for i := len(gens) - 1; i >= 0; i-- {
+		g := gens[i]
+		if g.Complete && (ro.At.IsZero() || g.Finished != nil && !g.Finished.After(ro.At)) {
+			return g, nil
+		}
+	}
+	if !ro.At.IsZero() {
+		return Generation{}, fmt.Errorf("no complete backup generation finished before %s", ro.At.UTC().Format(time.RFC3339))
+	}
+	return Generation{}, errors.New("backup has no complete generations")
+}
+
+// create copier restoring generation of backup from destination into directory of source,
+// keys of generation are mapped back to source keys. options override settings of config as
+// with NewCopier, filters match source keys. objects are restored as they're stored in backup,
+// normalized keys and transformed content aren't reversed, chunked objects are reassembled.
+// without backup, directory with obfuscated keys is restored with names translated back to
+// source keys, and directory with chunked objects is restored as it is
+func NewRestorer(conf *Config, ro RestoreOptions, opts ...Option) (*Copier, error) {
+	cp, _, err := newRestorer(conf, ro, opts...)
+	// restored objects are written to source, its cached listing no longer matches it
+	if lc := conf.Options.ListCache; err == nil && lc != nil {
+		if err := os.Remove(lc.File); err != nil && !os.IsNotExist(err) {
+			logErr(err)
+		}
+	}
+	return cp, err
+}
+
+func newRestorer(conf *Config, ro RestoreOptions, opts ...Option) (*Copier, Generation, error) {
+	c := &Config{}
+	*c = *conf
+	for _, o := range opts {
+		o(c)
+	}
+	if c.Options.Backup == nil && c.Options.KeyObfuscation != nil {
+		cp, err := newKeyMapRestorer(c, ro)
+		return cp, Generation{}, err
+	}
+	if c.Options.Backup == nil && c.Options.ChunkObjects != nil {
+		cp, err := newChunkRestorer(c, ro)
+		return cp, Generation{}, err
+	}
+	gens, err := BackupGenerations(c)
+	if err != nil {
+		return nil, Generation{}, err
+	}
+	gen, err := selectGeneration(gens, ro)
+	if err != nil {
+		return nil, Generation{}, err
+	}
+	if !gen.Complete {
+		logWarn("backup generation '%s' is incomplete, objects it's missing aren't restored", gen.Name)
+	}
+	b := &backupRun{cfg: c.Options.Backup}
+	genPrefix := b.key(gen.Name, "")
+	sourceKey := func(key string) string { return strings.TrimPrefix(key, genPrefix) }
+	rc := restoreConfig(c, b.key(gen.Name, c.Options.Directory), sourceKey)
+	rc.Run.Heal = ro.Overwrite
+	cp, err := NewCopier(rc)
+	if err != nil {
+		return nil, Generation{}, err
+	}
+	cp.normalizeKey = sourceKey
+	logInfo("restoring backup generation '%s' started at %s into '%s/%s'",
+		gen.Name, gen.Started.Format(time.RFC3339), c.Options.Bucket, c.Options.Directory)
+	return cp, gen, nil
+}
+
+// config of copy restoring dir of destination with its replicas into source. only options of
+// copying itself are kept, so state, checkpoints and reports of backup runs aren't touched.
+// filters match keys translated to source keys by sourceKey
+func restoreConfig(c *Config, dir string, sourceKey func(key string) string) *Config {
+	o := c.Options
+	rc := &Config{
+		Source:      c.Destination,
+		Sources:     c.Destinations,
+		Destination: c.Source,
+		Options: Options{
+			Bucket: o.Bucket, Directory: dir, RawPrefix: o.RawPrefix,
+			Concurrency: o.Concurrency, AutoConcurrency: o.AutoConcurrency, ListPageSize: o.ListPageSize,
+			LockFile: o.LockFile, LockLease: o.LockLease, Prices: o.Prices, AuditFile: o.AuditFile,
+			SourceBalance: o.SourceBalance, CreateBucket: o.CreateBucket, Preflight: o.Preflight,
+			PrefixConcurrency: o.PrefixConcurrency, BandwidthSchedule: o.BandwidthSchedule,
+		},
+		Run: RunOptions{
+			Progress: c.Run.Progress, MaxErrors: c.Run.MaxErrors, Retries: c.Run.Retries, RetryDelay: c.Run.RetryDelay,
+			Compare: c.Run.Compare, SummaryInterval: c.Run.SummaryInterval,
+			MetricsAddr: c.Run.MetricsAddr, StatusAddr: c.Run.StatusAddr, StatusControl: c.Run.StatusControl, OTLPEndpoint: c.Run.OTLPEndpoint,
+			ProgressBar: c.Run.ProgressBar, BandwidthLimit: c.Run.BandwidthLimit, ObjectResults: c.Run.ObjectResults,
+			ResultsOutput: c.Run.ResultsOutput, Callbacks: c.Run.Callbacks,
+			SourceStore: c.Run.DestinationStore, DestinationStore: c.Run.SourceStore, sharedWorkers: c.Run.sharedWorkers,
+			chunkedSource: o.ChunkObjects != nil,
+		},
+	}
+	for _, f := range c.Run.Filters {
+		f := f
+		rc.Run.Filters = append(rc.Run.Filters, func(obj Object) bool {
+			obj.Key = sourceKey(obj.Key)
+			return f(obj)
+		})
+	}
+	return rc
+}
+
+// list objects restore of generation would copy without writing anything, every object is
+// checked in source
+func PlanRestore(c *Config, ro RestoreOptions, opts ...Option) (*RestorePlan, error) {
+	cp, gen, err := newRestorer(c, ro, opts...)
+	if err != nil {
+		return nil, err
+	}
+	plan := &RestorePlan{Generation: gen.Name}
+	doneCh := make(chan struct{})
+	defer close(doneCh)
+	for obj := range listObjects(cp.src, cp.bucket, cp.cfg.listPrefix(), cp.cfg.Options.ListPageSize, nil, doneCh) {
+		if obj.Err != nil {
+			return nil, fmt.Errorf("listing objects: %s", obj.Err)
+		}
+		if !cp.accepted(obj.Object) {
+			continue
+		}
+		key := cp.destKey(obj.Key)
+		_, err := cp.dst.Stat(cp.ctx, key)
+		switch {
+		case err == nil:
+			plan.Existing++
+			if ro.Overwrite {
+				logInfo("dry run: would restore '%s/%s' if its content differs", cp.bucket, key)
+			}
+		case classifyError(err) == errNotFound:
+			plan.Objects++
+			plan.Bytes += obj.Size
+			logInfo("dry run: would restore '%s/%s' (%s)", cp.bucket, key, FormatBytes(obj.Size))
+		default:
+			return nil, fmt.Errorf("checking '%s/%s' in source: %s", cp.bucket, key, err)
+		}
+	}
+	logSummary("dry run: %d objects (%s) missing in source would be restored, %d exist in source", plan.Objects, FormatBytes(plan.Bytes), plan.Existing)
+	return plan, nil
+}
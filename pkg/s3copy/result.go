@@ -0,0 +1,95 @@
+package s3copy
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// outcome of a single processed object
+type ObjectResult struct {
+	Key string
+	// bytes copied
+	Size int64
+	// one of ResultCopied, ResultRecopied, ResultSkipped or ResultFailed
+	Result string
+	// why object was skipped or re-copied
+	Reason     string
+	Err        error
+	ErrorClass string
+	Duration   time.Duration
+}
+
+// copy error of a failed object
+type ObjectError struct {
+	Key   string
+	Class string
+	Err   error
+}
+
+func (e *ObjectError) Error() string {
+	return e.Key + ": " + e.Err.Error()
+}
+
+// result of Run: aggregate stats of the final report, errors of failed objects
+// and, with WithObjectResults, outcome of every processed object
+type Result struct {
+	*Report
+	Objects []ObjectResult
+	Errors  []*ObjectError
+}
+
+// collects outcomes of objects while copy is running
+type resultCollector struct {
+	sync.Mutex
+	// keep outcomes of all objects, not only failed ones
+	all     bool
+	objects []ObjectResult
+	errors  []*ObjectError
+	// outcomes are streamed as json lines
+	out io.Writer
+}
+
+func (ev *objectEvent) objectResult() ObjectResult {
+	return ObjectResult{Key: ev.Key, Size: ev.Bytes, Result: ev.Result, Reason: ev.Reason,
+		Err: ev.err, ErrorClass: ev.ErrorClass, Duration: time.Duration(ev.Duration * float64(time.Second))}
+}
+
+func (rc *resultCollector) record(r ObjectResult) {
+	if r.Result != ResultFailed && !rc.all {
+		return
+	}
+	rc.Lock()
+	defer rc.Unlock()
+	if rc.all {
+		rc.objects = append(rc.objects, r)
+	}
+	if r.Result == ResultFailed {
+		rc.errors = append(rc.errors, &ObjectError{Key: r.Key, Class: r.ErrorClass, Err: r.Err})
+	}
+}
+
+// write outcome of object as json line, lines of concurrent objects aren't interleaved
+func (rc *resultCollector) write(ev objectEvent) {
+	if rc.out == nil {
+		return
+	}
+	ev.Time = time.Now().UTC()
+	ev.Event = "object"
+	b, err := json.Marshal(ev)
+	if err != nil {
+		return
+	}
+	rc.Lock()
+	defer rc.Unlock()
+	if _, err := rc.out.Write(append(b, '\n')); err != nil {
+		logWarn("writing result of '%s': %s", ev.Key, err)
+	}
+}
+
+func (rc *resultCollector) result(report *Report) *Result {
+	rc.Lock()
+	defer rc.Unlock()
+	return &Result{Report: report, Objects: rc.objects, Errors: rc.errors}
+}
@@ -0,0 +1,739 @@
+package s3copy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// count objects and their total size in a dir to show progress during copying, only objects
+// accepted by in are counted if it isn't nil
+func countDirObjects(src ObjectStore, bucket, dir string, pageSize int, in func(obj Object) bool) (int64, int64, error) {
+	logInfo("starting counting objects in '%s/%s'", bucket, dir)
+
+	var count, size int64
+
+	// print objects count periodically, count is read while listing loop updates it
+	stopCh := make(chan struct{})
+	doneCh := make(chan struct{})
+	go func() {
+		defer close(doneCh)
+		ticker := time.NewTicker(time.Second * 5)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stopCh:
+				return
+			case <-ticker.C:
+				logInfo("still counting objects: %d ...", atomic.LoadInt64(&count))
+			}
+		}
+	}()
+
+	defer func() {
+		close(stopCh)
+		<-doneCh
+	}()
+
+	listDoneCh := make(chan struct{})
+	defer close(listDoneCh)
+	for obj := range listObjects(src, bucket, dir, pageSize, nil, listDoneCh) {
+		if obj.Err != nil {
+			return 0, 0, fmt.Errorf("listing objects: %s", obj.Err)
+		}
+		if in != nil && !in(obj.Object) {
+			continue
+		}
+		atomic.AddInt64(&count, 1)
+		size += obj.Size
+	}
+
+	logInfo("total objects in '%s/%s': %d, %s", bucket, dir, count, FormatBytes(size))
+	return count, size, nil
+}
+
+// error of copy which couldn't start because another copy holds the lock
+type LockError struct {
+	Err error
+}
+
+func (e *LockError) Error() string {
+	return e.Err.Error()
+}
+
+// create copier of configured directory, options override settings of config, which
+// itself isn't modified. config is validated and clients are initialized,
+// but nothing is requested until Run
+func NewCopier(conf *Config, opts ...Option) (*Copier, error) {
+	c := &Config{}
+	*c = *conf
+	for _, o := range opts {
+		o(c)
+	}
+	if c.Run.ShardCount < 0 || c.Run.ShardCount > 0 && (c.Run.ShardIndex < 0 || c.Run.ShardIndex >= c.Run.ShardCount) {
+		return nil, fmt.Errorf("invalid shard %d of %d, index must be from 0 to count-1", c.Run.ShardIndex, c.Run.ShardCount)
+	}
+	// instances copying other shards hold their own locks
+	if c.Run.ShardCount > 1 && c.Options.LockObject != "" {
+		c.Options.LockObject += fmt.Sprintf(".shard-%d-of-%d", c.Run.ShardIndex, c.Run.ShardCount)
+	}
+	// empty directory of mistyped config would copy the whole bucket
+	if c.Options.Directory == "" && !c.Options.EntireBucket && !c.Run.Consume {
+		return nil, fmt.Errorf("directory is empty, set entire_bucket to copy whole bucket '%s'", c.Options.Bucket)
+	}
+	src, err := sourceStore(c)
+	if err != nil {
+		return nil, err
+	}
+	dst, err := destinationStore(c)
+	if err != nil {
+		return nil, err
+	}
+	if c.Run.RetryFailed && c.Options.FailedFile == "" {
+		return nil, errors.New("failed_file must be set to retry failed objects")
+	}
+	transforms, err := loadTransforms(c)
+	if err != nil {
+		return nil, err
+	}
+	if c.Run.RetryFailed && (c.Run.WatchInterval > 0 || c.Run.Listen) {
+		return nil, errors.New("watch and listen can't be used with retry of failed objects")
+	}
+	if c.Options.Backup != nil && (c.Options.StateFile != "" || c.Run.RetryFailed || c.Run.WatchInterval > 0 || c.Run.Listen || c.Run.Consume) {
+		return nil, errors.New("backup can't be used with state_file, retry of failed objects, watch, listen or consume, every generation is written in full")
+	}
+	if c.Run.SinceLastRun && c.Options.StateFile == "" {
+		return nil, errors.New("state_file must be set to copy objects modified since the last run, successful runs are recorded in it")
+	}
+	if c.Run.SinceLastRun && (c.Run.RetryFailed || c.Run.Consume) {
+		return nil, errors.New("since last run can't be used with retry of failed objects or consume")
+	}
+	if c.Run.WatchInterval > 0 && c.Run.Listen {
+		return nil, errors.New("watch and listen can't be used together")
+	}
+	if c.Run.Consume && (c.Run.RetryFailed || c.Run.WatchInterval > 0 || c.Run.Listen || c.Run.ReconcilePasses > 0 || c.Run.Compare) {
+		return nil, errors.New("consume can't be used with retry of failed objects, watch, listen, reconcile passes or compare")
+	}
+	var feed changeFeed
+	var queue queueFeed
+	switch {
+	case c.Run.Consume && c.Run.Coordinator != "":
+		feed = newCoordinatorFeed(c.Run.Coordinator, c.Run.CoordinatorToken, c.Run.WorkerName)
+	case c.Run.Consume && c.Options.Queue == nil:
+		return nil, errors.New("queue must be set to consume keys")
+	case c.Run.Consume:
+		if queue, err = newQueueFeed(c.Options.Queue); err != nil {
+			return nil, err
+		}
+		feed = queue
+	case c.Run.Listen && c.Options.SQSQueueURL != "":
+		if feed, err = newSQSFeed(c.Options.SQSQueueURL, c.Source, c.Options.Bucket); err != nil {
+			return nil, err
+		}
+	case c.Run.Listen:
+		// notifications of source with replicas are received from the primary
+		var ok bool
+		if feed, ok = primarySource(src).(changeFeed); !ok {
+			return nil, errors.New("source doesn't support bucket notifications, listen requires MinIO source or sqs_queue_url")
+		}
+	}
+	if c.Options.ObjectLock && !c.Options.CreateBucket {
+		return nil, errors.New("object_lock requires create_bucket, object lock can be enabled only when bucket is created")
+	}
+	if c.Run.Heal && len(transforms) > 0 {
+		return nil, errors.New("heal can't be used with transforms, transformed content never matches source")
+	}
+	if c.Options.SkipPolicy == SkipPolicyIfSameETag && len(transforms) > 0 {
+		return nil, errors.New("skip policy if-same-etag can't be used with transforms, transformed content never matches source")
+	}
+	var checksum checksumStore
+	if c.Options.ChecksumMetadata != "" {
+		var ok bool
+		if checksum, ok = dst.(checksumStore); !ok {
+			return nil, errors.New("destination doesn't support metadata of objects, checksum_metadata requires single S3 destination")
+		}
+	}
+	var conditional conditionalStore
+	if c.Options.ConditionalPut {
+		var ok bool
+		if conditional, ok = dst.(conditionalStore); !ok {
+			return nil, errors.New("destination doesn't support conditional writes, conditional_put requires single S3 destination")
+		}
+	}
+	schedule, err := parseBandwidthSchedule(c.Options.BandwidthSchedule)
+	if err != nil {
+		return nil, fmt.Errorf("bandwidth_schedule: %s", err)
+	}
+
+	// copy objects, limit workers concurrency with worker limiter,
+	// auto tuner adjusts the limit at runtime up to the configured concurrency
+	wl := c.workerLimiter()
+	cp := &Copier{cfg: c, src: src, dst: dst, bucket: c.Options.Bucket, wl: wl, oc: newObjCounter(),
+		inflight: newInflightObjects(), breakdown: newBreakdown(c.Options.Directory), metrics: newCopyMetrics(),
+		recentErrors: &recentErrors{}, latency: newOpLatencies(), history: newThroughputHistory(), ctx: context.Background(),
+		retries: c.Run.Retries, retryDelay: c.Run.RetryDelay, heal: c.Run.Heal, maxErrors: c.Run.MaxErrors,
+		lockLease: defaultLockLease, stopCh: make(chan struct{}),
+		results: &resultCollector{all: c.Run.ObjectResults, out: c.Run.ResultsOutput}, bandwidth: newBandwidthLimiter(c.Run.BandwidthLimit, schedule),
+		transforms: transforms, feed: feed, queue: queue, conditional: conditional, checksum: checksum}
+	if c.Options.AutoConcurrency {
+		cp.at = newAutoTuner(wl, c.Options.Concurrency)
+	}
+	if c.Run.WatchInterval > 0 && c.Options.StateFile == "" {
+		cp.synced = &syncedObjects{etags: map[string]string{}}
+	}
+	if c.Options.LockLease != "" {
+		if cp.lockLease, err = time.ParseDuration(c.Options.LockLease); err != nil {
+			return nil, err
+		}
+	}
+	if c.Options.MultipartThreshold != "" {
+		if cp.multipartThreshold, err = ParseByteSize(c.Options.MultipartThreshold); err != nil {
+			return nil, err
+		}
+	}
+	if c.Options.PartSize != "" {
+		if cp.partSize, err = ParseByteSize(c.Options.PartSize); err != nil {
+			return nil, err
+		}
+	}
+	if cp.normalizeKey, err = newKeyNormalizer(c.Options.KeyNormalization); err != nil {
+		return nil, err
+	}
+	if c.Options.KeyObfuscation != nil && c.Options.Backup != nil {
+		return nil, errors.New("key_obfuscation can't be used with backup")
+	}
+	if c.Options.KeyObfuscation != nil && (c.Run.ShardCount > 1 || c.Run.Consume) {
+		return nil, errors.New("key_obfuscation can't be used with shards or workers of queue, their runs would overwrite mapping of each other")
+	}
+	if cp.obfuscator, err = newKeyObfuscator(c); err != nil {
+		return nil, err
+	}
+	if c.Options.CapacityLimit != "" {
+		if cp.capacityLimit, err = ParseByteSize(c.Options.CapacityLimit); err != nil {
+			return nil, err
+		}
+	}
+	if c.Options.CleanupUploads != "" {
+		if cp.cleanupAge, err = time.ParseDuration(c.Options.CleanupUploads); err != nil {
+			return nil, err
+		}
+		if _, ok := dst.(multipartStore); !ok {
+			return nil, errors.New("destination doesn't support multipart uploads, cleanup_uploads requires S3 destination")
+		}
+	}
+	now := time.Now()
+	cp.trash, cp.quarantine = newTrash(c, dst, now), newQuarantine(c, dst, now)
+	cp.prefixes = newPrefixLimiter(c.Options.PrefixConcurrency)
+	if cp.expiry, err = newSourceExpiry(c, src, transforms); err != nil {
+		return nil, err
+	}
+	cp.stats = &statsDumper{cp: cp, start: now, lastTime: now}
+	return cp, nil
+}
+
+// copy objects and return result with the final report, exit code of the run is set in the report.
+// cancelling ctx interrupts in-flight copies, Stop lets them finish.
+// error is returned only if copy couldn't start
+func (cp *Copier) Run(ctx context.Context) (*Result, error) {
+	c, f := cp.cfg, cp.cfg.Run
+	logRun("run_start", map[string]interface{}{
+		"source":      c.sourcesString(),
+		"destination": c.destinationsString(),
+		"bucket":      c.Options.Bucket,
+		"directory":   c.Options.Directory,
+	}, "source: '%s', destination: '%s', path: '%s/%s'",
+		c.sourcesString(),
+		c.destinationsString(),
+		c.Options.Bucket,
+		c.Options.Directory)
+	if c.Options.SlackWebhookURL != "" {
+		text := fmt.Sprintf(":arrow_forward: s3-copy-dir started\n`%s/%s` from %s to %s",
+			c.Options.Bucket, c.Options.Directory, c.sourcesString(), c.destinationsString())
+		if err := notifySlack(c.Options.SlackWebhookURL, text); err != nil {
+			logError("sending slack notification: %s", err)
+		}
+	}
+	if c.Options.PingURL != "" {
+		pingHealthcheck(c.Options.PingURL, pingStart, "")
+	}
+
+	// export spans of list pages and object requests, remaining spans are flushed on exit
+	if f.OTLPEndpoint != "" {
+		startTracer(f.OTLPEndpoint, c.Source.String(), c.Destination.String())
+		defer stopTracer()
+	}
+
+	// prevent overlapping copies with local lock file and/or lock object in destination bucket,
+	// acquired before buckets are changed, so runs racing on the same bucket don't both change it
+	if c.Options.LockFile != "" {
+		fl, err := acquireFileLock(c.Options.LockFile)
+		if err != nil {
+			notifyStartFailed(c, ExitLocked, err)
+			return nil, &LockError{err}
+		}
+		defer fl.release()
+	}
+	// bucket of fresh destination is created before lock object is written to it
+	if c.Options.CreateBucket {
+		if err := createBucket(ctx, cp.dst, c); err != nil {
+			notifyStartFailed(c, ExitError, err)
+			return nil, err
+		}
+	}
+	if c.Options.LockObject != "" {
+		// copy which lost its lock is interrupted, another copy writes the same objects
+		lockCtx, lockLost := context.WithCancel(ctx)
+		defer lockLost()
+		ctx = lockCtx
+		ol, err := acquireObjectLock(cp.dst, c.Options.Bucket, c.Options.LockObject, cp.lockLease, func() {
+			cp.stop(ExitAborted)
+			lockLost()
+		})
+		if err != nil {
+			notifyStartFailed(c, ExitLocked, err)
+			return nil, &LockError{err}
+		}
+		defer ol.release()
+	}
+	if c.Options.Preflight {
+		if err := preflight(ctx, cp.src, cp.dst, c); err != nil {
+			notifyStartFailed(c, ExitError, err)
+			return nil, err
+		}
+	}
+	if c.Options.EnableVersioning {
+		if err := enableVersioning(ctx, cp.dst, c); err != nil {
+			notifyStartFailed(c, ExitError, err)
+			return nil, err
+		}
+	}
+	if len(c.bucketSettings()) > 0 {
+		if err := copyBucketSettings(ctx, cp.src, cp.dst, c); err != nil {
+			notifyStartFailed(c, ExitError, err)
+			return nil, err
+		}
+	}
+	if cp.expiry != nil {
+		if err := cp.expiry.ensureRule(ctx, c.Options.Bucket); err != nil {
+			notifyStartFailed(c, ExitError, err)
+			return nil, err
+		}
+	}
+
+	// every backup run writes objects to its own generation
+	if c.Options.Backup != nil {
+		b, err := startBackup(ctx, cp.dst, c, time.Now())
+		if err != nil {
+			notifyStartFailed(c, ExitError, err)
+			return nil, err
+		}
+		cp.backup = b
+	}
+	// names of keys copied by previous runs are kept in mapping, which is written periodically
+	// while the run copies objects and when it ends
+	if cp.obfuscator != nil {
+		if err := cp.obfuscator.load(ctx, cp.dst); err != nil {
+			err = fmt.Errorf("loading mapping of obfuscated keys '%s': %s", c.Options.KeyObfuscation.Mapping, err)
+			notifyStartFailed(c, ExitError, err)
+			return nil, err
+		}
+		mappingStopCh := make(chan struct{})
+		go cp.obfuscator.run(cp.dst, cp.bucket, mappingStopCh)
+		defer func() {
+			close(mappingStopCh)
+			if err := cp.obfuscator.save(context.Background(), cp.dst, cp.bucket); err != nil {
+				logError("saving mapping of obfuscated keys '%s': %s", c.Options.KeyObfuscation.Mapping, err)
+			}
+		}()
+	}
+
+	// in retry mode objects to copy are read from failed objects file of previous run
+	var retryKeys []string
+	var err error
+	if f.RetryFailed {
+		retryKeys, err = readFailedObjects(c.Options.FailedFile)
+		if err != nil {
+			return nil, err
+		}
+		logInfo("retrying %d failed objects from '%s'", len(retryKeys), c.Options.FailedFile)
+	}
+
+	// track copied objects in state database, if enabled
+	if c.Options.StateFile != "" {
+		if cp.state, err = openCopyState(c.Options.StateFile, c.Options.Bucket, c.Options.Directory, c.destinationsString(), cp.stateKeys()); err != nil {
+			return nil, err
+		}
+		defer cp.state.close()
+	}
+	// objects not modified since the last successful run are passed over
+	if f.SinceLastRun {
+		if r := cp.state.lastRun(cp.runName()); r != nil {
+			cp.since = r.Started.Add(-reconcileClockSkew)
+			logInfo("copying objects modified since the last successful run started at %s", r.Started.Format(time.RFC3339))
+		} else {
+			logInfo("no successful run recorded in state file, all objects are copied")
+		}
+	}
+
+	// count objects in source dir, if enabled
+	oc, counted := cp.oc, false
+	if f.RetryFailed {
+		oc.setTotal(int64(len(retryKeys)), 0)
+	} else if f.Progress && !f.Consume {
+		count, size, err := countDirObjects(cp.src, c.Options.Bucket, c.listPrefix(), c.Options.ListPageSize, cp.selected)
+		if err != nil {
+			logWarn("progress is shown without total, counting objects failed: %s", err)
+		} else {
+			oc.setTotal(count, size)
+			counted = true
+		}
+	}
+	if c.Options.CheckCapacity {
+		if f.RetryFailed || f.Consume {
+			logWarn("capacity check skipped, size of objects to copy is unknown before retry or consume")
+		} else {
+			// capacity isn't checked against size of partially listed directory
+			size := oc.snapshot().TotalBytes
+			if !counted {
+				var err error
+				if _, size, err = countDirObjects(cp.src, c.Options.Bucket, c.listPrefix(), c.Options.ListPageSize, cp.selected); err != nil {
+					err = fmt.Errorf("checking capacity: %s", err)
+					notifyStartFailed(c, ExitError, err)
+					return nil, err
+				}
+			}
+			if err := checkCapacity(ctx, cp.dst, c, size, cp.capacityLimit); err != nil {
+				notifyStartFailed(c, ExitError, err)
+				return nil, err
+			}
+		}
+	}
+
+	// changes are received from the start, so ones made during copy aren't missed
+	var changes <-chan changeEvent
+	if cp.feed != nil {
+		feedCtx, stopFeed := context.WithCancel(ctx)
+		defer stopFeed()
+		changes = cp.feed.changes(feedCtx, c.listPrefix())
+	}
+
+	doneCh := make(chan struct{})
+	// channel with stream of objects (<-chan ObjectInfo), listed page by page
+	var objCh <-chan listEntry
+	switch {
+	case f.RetryFailed:
+		objCh = failedObjectsCh(retryKeys, doneCh)
+	case f.Consume:
+		// nothing is listed, keys come from the queue or coordinator
+		noObjects := make(chan listEntry)
+		close(noObjects)
+		objCh = noObjects
+	default:
+		cp.checkpoint = newListCheckpoint(c.Options.ListCheckpoint)
+		objCh = listObjects(cp.src, c.Options.Bucket, c.listPrefix(), c.Options.ListPageSize, cp.checkpoint, doneCh)
+	}
+
+	historyStopCh := make(chan struct{})
+	defer close(historyStopCh)
+	go cp.history.run(oc, historyStopCh)
+	if cp.at != nil {
+		tunerStopCh := make(chan struct{})
+		defer close(tunerStopCh)
+		go cp.at.run(tunerStopCh)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	cp.ctx = ctx
+	// record objects which failed to copy, if enabled
+	if c.Options.FailedFile != "" {
+		if cp.failures, err = openFailureLog(c.Options.FailedFile); err != nil {
+			return nil, err
+		}
+		defer cp.failures.close()
+	}
+	// record copied objects in manifest, if enabled
+	if c.Options.ManifestFile != "" {
+		if cp.manifest, err = openManifest(c.Options.ManifestFile, c.Options.ManifestFormat); err != nil {
+			return nil, err
+		}
+		defer cp.manifest.close()
+	}
+	// record skipped objects with reasons, if enabled
+	if c.Options.SkipReport != "" {
+		if cp.skips, err = openSkipReport(c.Options.SkipReport); err != nil {
+			return nil, err
+		}
+		defer cp.skips.close()
+	}
+
+	// metrics and status can share the same address
+	if f.MetricsAddr != "" {
+		if err := serveHTTP(cp, f.MetricsAddr, true, f.StatusAddr == f.MetricsAddr); err != nil {
+			return nil, err
+		}
+	}
+	if f.StatusAddr != "" && f.StatusAddr != f.MetricsAddr {
+		if err := serveHTTP(cp, f.StatusAddr, false, true); err != nil {
+			return nil, err
+		}
+	}
+	// on terminal render progress bar instead of per-object log lines,
+	// otherwise print progress summary periodically, if enabled
+	if f.ProgressBar {
+		pb := newProgressBar(os.Stderr, oc)
+		log.SetOutput(pb)
+		logObjects = false
+		go pb.run()
+		defer func() {
+			pb.stop()
+			log.SetOutput(os.Stderr)
+			logObjects = true
+		}()
+	} else if f.SummaryInterval > 0 {
+		summaryStopCh := make(chan struct{})
+		defer close(summaryStopCh)
+		go printSummaries(cp, f.SummaryInterval, summaryStopCh)
+	}
+
+	// final progress is reported before Run returns
+	if f.Callbacks.OnProgress != nil {
+		progressStopCh, progressDoneCh := make(chan struct{}), make(chan struct{})
+		go func() {
+			cp.reportProgress(progressStopCh)
+			close(progressDoneCh)
+		}()
+		defer func() { close(progressStopCh); <-progressDoneCh }()
+	}
+
+	runStart := time.Now()
+	// run resumed from checkpoint didn't list objects before it, the checkpoint is loaded
+	// once before listing starts, listing saves it as objects are copied
+	resumed := cp.checkpoint.token() != ""
+	listed := cp.dispatch(objCh, f.Sync)
+	close(doneCh)
+	cp.wait()
+
+	// copy objects created or modified in source while copy was running
+	if listed && cp.stopped() == ExitOK && !f.RetryFailed && f.ReconcilePasses > 0 {
+		listed = cp.reconcile(c.listPrefix(), c.Options.ListPageSize, runStart, f.ReconcilePasses)
+	}
+	// watch or replicate changes until stopped, interrupt is the regular end of both
+	continuous := listed && cp.stopped() == ExitOK && !f.RetryFailed && (f.WatchInterval > 0 || cp.feed != nil)
+	if continuous && f.WatchInterval > 0 {
+		cp.watch(c.listPrefix(), c.Options.ListPageSize, f.WatchInterval)
+	}
+	if continuous && cp.feed != nil {
+		cp.replicate(changes, c.listPrefix(), c.Options.ListPageSize)
+	}
+
+	code, msg := ExitOK, "copy completed"
+	counts := oc.snapshot()
+	switch {
+	case cp.stopped() == ExitAborted:
+		code, msg = ExitAborted, "copy aborted"
+	case continuous && counts.Failed > 0:
+		code, msg = ExitPartial, "copy stopped, some objects failed"
+	case continuous:
+		msg = "copy stopped"
+	case cp.stopped() == ExitInterrupted:
+		code, msg = ExitInterrupted, "copy interrupted"
+	case !listed:
+		code, msg = ExitError, "copy incomplete, listing of source objects failed"
+	case counts.Failed > 0:
+		code, msg = ExitPartial, "copy completed with failures"
+	}
+	// interrupted or aborted copy is known to be incomplete
+	var comparison *Comparison
+	if f.Compare && listed && cp.stopped() == ExitOK {
+		var err error
+		if comparison, err = cp.compare(c.listPrefix(), c.Options.ListPageSize); err != nil {
+			logError("comparison of source and destination: %s", err)
+		}
+	}
+	if code == ExitOK || code == ExitPartial {
+		if !f.RetryFailed {
+			clearListCheckpoint(c.Options.ListCheckpoint)
+		}
+	}
+	// start of the run is recorded, objects modified while it was listing aren't missed by
+	// the next run. runs which didn't see all objects of the directory aren't recorded
+	if cp.state != nil && code == ExitOK && !continuous && !resumed && !f.RetryFailed && !f.Consume && len(f.Filters) == 0 {
+		cp.state.saveRun(cp.runName(), runRecord{Started: runStart.UTC(), Finished: time.Now().UTC()})
+	}
+	// failed or interrupted generation is continued by the next run of the same day
+	if cp.backup != nil && code == ExitOK {
+		if err := cp.backup.finish(ctx, cp.dst, c); err != nil {
+			logError("finishing backup generation: %s", err)
+		}
+	} else if cp.backup != nil {
+		logWarn("backup generation '%s' is incomplete, it's continued by the next run", cp.backup.generation().Name)
+	}
+	// uploads left by failed runs are aborted even if this one failed, interrupted run ends right away
+	if cp.cleanupAge > 0 && code != ExitInterrupted {
+		logInfo("aborting incomplete uploads in '%s/%s' older than %s", cp.bucket, cp.destKey(c.listPrefix()), cp.cleanupAge)
+		cleanupUploads(cp.dst.(multipartStore), cp.state, cp.bucket, cp.destKey(c.listPrefix()), cp.cleanupAge, false)
+	}
+	if n := atomic.LoadInt64(&cp.unmodified); n > 0 {
+		logInfo("%d objects not modified since the last run were passed over", n)
+	}
+	cp.expiry.logSummary()
+
+	elapsed := time.Since(runStart)
+	logRun("run_end", map[string]interface{}{
+		"exit_code":    code,
+		"processed":    counts.Current,
+		"copied":       counts.Copied,
+		"skipped":      counts.Skipped,
+		"failed":       counts.Failed,
+		"bytes":        counts.Bytes,
+		"duration_sec": elapsed.Seconds(),
+	}, "%s, %d objects processed, %d copied, %d skipped, %d failed, %d bytes in %s",
+		msg, counts.Current, counts.Copied, counts.Skipped, counts.Failed, counts.Bytes, elapsed.Round(time.Second))
+	if l := cp.latency.summary(); l != "" {
+		logSummary("latency: %s", l)
+	}
+	cp.breakdown.logSkips()
+	cp.breakdown.logFailures()
+	if comparison != nil {
+		comparison.log()
+	}
+
+	report := cp.finalReport(c, runStart, code, msg)
+	report.Comparison = comparison
+	logSummary("%s", report.Cost.summary())
+	if c.Options.ReportFile != "" {
+		if err := writeReport(c.Options.ReportFile, report); err != nil {
+			logError("writing report: %s", err)
+		}
+	}
+	if c.Options.WebhookURL != "" {
+		if err := notifyWebhook(c.Options.WebhookURL, c.Options.WebhookSecret, report); err != nil {
+			logError("posting report to webhook: %s", err)
+		}
+	}
+	if c.Options.SlackWebhookURL != "" {
+		if err := notifySlack(c.Options.SlackWebhookURL, slackReportText(report)); err != nil {
+			logError("sending slack notification: %s", err)
+		}
+	}
+	if c.Options.PingURL != "" {
+		suffix := pingSuccess
+		if code != ExitOK {
+			suffix = pingFail
+		}
+		pingHealthcheck(c.Options.PingURL, suffix, fmt.Sprintf("%s, %d processed, %d copied, %d skipped, %d failed",
+			report.Result, report.Processed, report.Copied, report.Skipped, report.Failed))
+	}
+	return cp.results.result(report), nil
+}
+
+// stop dispatching new objects, in-flight copies are allowed to finish
+// and Run returns with interrupted exit code
+func (cp *Copier) Stop() {
+	cp.stop(ExitInterrupted)
+}
+
+// pause dispatching of new objects, in-flight copies are drained.
+// returns false if copy is already stopped
+func (cp *Copier) Pause() bool {
+	if cp.stopped() != ExitOK {
+		return false
+	}
+	cp.wl.pause()
+	return true
+}
+
+func (cp *Copier) Resume() {
+	cp.wl.resume()
+}
+
+func (cp *Copier) Paused() bool {
+	return cp.wl.isPaused()
+}
+
+// change number of workers of running copy, in-flight copies above the new limit are allowed
+// to finish. with auto concurrency it's the new maximum of the tuner
+func (cp *Copier) SetConcurrency(n int) {
+	if n < 1 {
+		n = 1
+	}
+	if cp.at != nil {
+		cp.at.setMax(n)
+	} else {
+		cp.wl.setLimit(n)
+	}
+	logInfo("concurrency set to %d", n)
+}
+
+// change limit of bytes per second read from source of running copy, 0 - unlimited.
+// the limit replaces bandwidth schedule for the rest of the copy
+func (cp *Copier) SetBandwidthLimit(bytesPerSec int64) {
+	cp.bandwidth.set(bytesPerSec)
+	if bytesPerSec > 0 {
+		logInfo("bandwidth limit set to %s/s", FormatBytes(bytesPerSec))
+	} else {
+		logInfo("bandwidth limit removed")
+	}
+}
+
+// limit of bytes per second in effect, 0 - unlimited
+func (cp *Copier) BandwidthLimit() int64 {
+	return cp.bandwidth.limit()
+}
+
+// snapshot of copy progress
+type Progress struct {
+	Processed int64 `json:"processed"`
+	// total number and size of objects, -1 and 0 if objects weren't counted
+	Total      int64 `json:"total"`
+	TotalBytes int64 `json:"total_bytes"`
+	Copied     int64 `json:"copied"`
+	Skipped    int64 `json:"skipped"`
+	Failed     int64 `json:"failed"`
+	Bytes      int64 `json:"bytes"`
+
+	ActiveWorkers int  `json:"active_workers"`
+	WorkerLimit   int  `json:"worker_limit"`
+	Paused        bool `json:"paused"`
+}
+
+func (cp *Copier) Progress() Progress {
+	counts := cp.oc.snapshot()
+	p := Progress{Processed: counts.Current, Total: counts.Total, TotalBytes: counts.TotalBytes,
+		Copied: counts.Copied, Skipped: counts.Skipped, Failed: counts.Failed, Bytes: counts.Bytes}
+	p.ActiveWorkers, p.WorkerLimit, p.Paused = cp.wl.running(), cp.wl.getLimit(), cp.wl.isPaused()
+	return p
+}
+
+// log snapshot of live statistics
+func (cp *Copier) DumpStats() {
+	cp.stats.dump()
+}
+
+// abort stale incomplete multipart uploads, returns exit code
+func CleanupUploads(c *Config, olderThan time.Duration, dryRun bool) (int, error) {
+	store, err := destinationStore(c)
+	if err != nil {
+		return ExitConfigError, err
+	}
+	dst, ok := store.(multipartStore)
+	if !ok {
+		return ExitConfigError, errors.New("destination doesn't support multipart uploads")
+	}
+
+	var state *copyState
+	if c.Options.StateFile != "" {
+		// only uploads are read, they don't depend on keys of destination objects
+		state, err = openCopyState(c.Options.StateFile, c.Options.Bucket, c.Options.Directory, c.destinationsString(), "")
+		if err != nil {
+			return ExitError, err
+		}
+		defer state.close()
+	}
+	return cleanupUploads(dst, state, c.Options.Bucket, c.listPrefix(), olderThan, dryRun), nil
+}
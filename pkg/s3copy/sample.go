@@ -0,0 +1,201 @@
+package s3copy
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// settings of sampled verify, see VerifySample
+type SampleOptions struct {
+	// percent of objects checked, e.g. 5
+	Percent float64
+	// objects are picked by hash of key with seed, the same seed picks the same objects. 0 - random
+	Seed int64
+	// confidence of upper bound of mismatch rate, 0.95 by default
+	Confidence float64
+}
+
+// outcome of sampled verify
+type SampleResult struct {
+	Seed    int64 `json:"seed"`
+	Listed  int64 `json:"listed"`
+	Sampled int64 `json:"sampled"`
+	Missing int64 `json:"missing"`
+	Differ  int64 `json:"differ"`
+	Failed  int64 `json:"failed"`
+	// share of missing and different objects among sampled ones, and upper bound
+	// of the share in the whole directory with given confidence
+	MismatchRate  float64 `json:"mismatch_rate"`
+	MismatchUpper float64 `json:"mismatch_upper"`
+	Confidence    float64 `json:"confidence"`
+}
+
+// check content of a random share of objects: sampled objects are downloaded from source and
+// destination at the same time and their md5 sums are compared, ETags aren't trusted. summary
+// reports how many objects of the whole directory may be bad with given confidence
+func VerifySample(c *Config, o SampleOptions) (*SampleResult, error) {
+	if o.Percent <= 0 || o.Percent > 100 {
+		return nil, fmt.Errorf("invalid sample of %g%%, must be above 0 and at most 100", o.Percent)
+	}
+	if o.Confidence == 0 {
+		o.Confidence = 0.95
+	}
+	if o.Confidence <= 0 || o.Confidence >= 1 {
+		return nil, fmt.Errorf("invalid confidence %g, must be between 0 and 1, e.g. 0.95", o.Confidence)
+	}
+	if c.Options.TransformHook != "" || len(c.Options.TransformPlugins) > 0 {
+		return nil, errors.New("content of transformed objects never matches source, sampled verify doesn't apply")
+	}
+	if o.Seed == 0 {
+		o.Seed = rand.New(rand.NewSource(time.Now().UnixNano())).Int63()
+	}
+	src, err := sourceStore(c)
+	if err != nil {
+		return nil, err
+	}
+	dst, err := destinationStore(c)
+	if err != nil {
+		return nil, err
+	}
+	normalizeKey, err := newKeyNormalizer(c.Options.KeyNormalization)
+	if err != nil {
+		return nil, err
+	}
+	obfuscator, err := newKeyObfuscator(c)
+	if err != nil {
+		return nil, err
+	}
+	cp := &Copier{src: src, dst: dst, bucket: c.Options.Bucket, ctx: context.Background(), normalizeKey: normalizeKey, obfuscator: obfuscator}
+	logInfo("verifying %g%% of objects in '%s/%s', seed %d", o.Percent, c.Options.Bucket, c.Options.Directory, o.Seed)
+
+	res := &SampleResult{Seed: o.Seed, Confidence: o.Confidence}
+	wl := c.workerLimiter()
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	doneCh := make(chan struct{})
+	defer close(doneCh)
+	for obj := range listObjects(src, c.Options.Bucket, c.listPrefix(), c.Options.ListPageSize, nil, doneCh) {
+		if obj.Err != nil {
+			err = fmt.Errorf("listing objects: %s", obj.Err)
+			break
+		}
+		res.Listed++
+		if !sampled(obj.Key, o.Seed, o.Percent) {
+			continue
+		}
+		wl.acquire()
+		wg.Add(1)
+		go func(obj Object) {
+			defer func() { wl.release(); wg.Done() }()
+			result := cp.compareContent(obj)
+			mu.Lock()
+			defer mu.Unlock()
+			res.Sampled++
+			switch result {
+			case verifyMissing:
+				res.Missing++
+			case verifyDiffer:
+				res.Differ++
+			case verifyFailed:
+				res.Failed++
+			}
+		}(obj.Object)
+	}
+	wg.Wait()
+
+	// objects which failed to check are counted as neither good nor bad
+	if checked := res.Sampled - res.Failed; checked > 0 {
+		bad := res.Missing + res.Differ
+		res.MismatchRate = float64(bad) / float64(checked)
+		res.MismatchUpper = wilsonUpper(bad, checked, res.Listed, o.Confidence)
+	}
+	logSummary("sampled %d of %d objects: %d missing in destination, %d different, %d failed to check",
+		res.Sampled, res.Listed, res.Missing, res.Differ, res.Failed)
+	if res.Sampled > res.Failed {
+		logSummary("mismatch rate %.3f%%, at most %.3f%% of objects (~%d of %d) mismatch with %g%% confidence",
+			res.MismatchRate*100, res.MismatchUpper*100, int64(math.Ceil(res.MismatchUpper*float64(res.Listed))), res.Listed, o.Confidence*100)
+	}
+	return res, err
+}
+
+// key is picked if its hash with seed falls into percent of hash space
+func sampled(key string, seed int64, percent float64) bool {
+	h := fnv.New64a()
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], uint64(seed))
+	h.Write(b[:])
+	io.WriteString(h, key)
+	return float64(h.Sum64()) < percent/100*math.MaxUint64
+}
+
+// download object from both sides at the same time and compare md5 of content
+func (cp *Copier) compareContent(obj Object) int {
+	key := cp.destKey(obj.Key)
+	var srcSum, dstSum []byte
+	var srcErr, dstErr error
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		srcSum, srcErr = md5Of(cp.ctx, cp.src, obj.Key)
+	}()
+	go func() {
+		defer wg.Done()
+		dstSum, dstErr = md5Of(cp.ctx, cp.dst, key)
+	}()
+	wg.Wait()
+	switch {
+	case classifyError(dstErr) == errNotFound:
+		logWarn("missing in destination '%s/%s'", cp.bucket, obj.Key)
+		return verifyMissing
+	case srcErr != nil:
+		logError("reading source '%s/%s': %s", cp.bucket, obj.Key, srcErr)
+		return verifyFailed
+	case dstErr != nil:
+		logError("reading destination '%s/%s': %s", cp.bucket, key, dstErr)
+		return verifyFailed
+	case string(srcSum) != string(dstSum):
+		logWarn("content differs '%s/%s'", cp.bucket, obj.Key)
+		return verifyDiffer
+	}
+	logDebug("content matches '%s/%s'", cp.bucket, obj.Key)
+	return verifyOK
+}
+
+func md5Of(ctx context.Context, store ObjectStore, key string) ([]byte, error) {
+	r, _, err := store.Get(ctx, key, 0, -1)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	h := md5.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// one-sided upper bound of proportion bad/n by Wilson score interval, it doesn't collapse to 0
+// when no bad objects were found, unlike normal approximation. sample from population of
+// objects is corrected for its size, bound of sample of all objects is the proportion itself
+func wilsonUpper(bad, n, population int64, confidence float64) float64 {
+	z := math.Sqrt2 * math.Erfinv(2*confidence-1)
+	if population > 1 && n < population {
+		z *= math.Sqrt(float64(population-n) / float64(population-1))
+	} else if n >= population {
+		z = 0
+	}
+	p, fn := float64(bad)/float64(n), float64(n)
+	center := p + z*z/(2*fn)
+	margin := z * math.Sqrt(p*(1-p)/fn+z*z/(4*fn*fn))
+	return math.Min(1, (center+margin)/(1+z*z/fn))
+}
@@ -0,0 +1,137 @@
+package s3copy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"time"
+)
+
+// job submitted to job manager on schedule, see LoadSchedule
+type ScheduledJob struct {
+	Name string `json:"name"`
+	// 5-field cron expression (minute hour day-of-month month day-of-week),
+	// @hourly/@daily/@weekly/@monthly/@yearly or "@every <duration>"
+	Cron string `json:"cron"`
+	// time zone of cron expression, e.g. "Europe/Berlin", local time by default
+	TimeZone string `json:"timezone,omitempty"`
+	// config of the copy: inline or path of config file
+	Config     *Config    `json:"config,omitempty"`
+	ConfigFile string     `json:"config_file,omitempty"`
+	Run        JobOptions `json:"run"`
+
+	sched *cronSchedule
+}
+
+// load schedule file: {"jobs": [<ScheduledJob>, ...]}, config files of the jobs are loaded too
+func LoadSchedule(file string) ([]ScheduledJob, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var s struct {
+		Jobs []ScheduledJob `json:"jobs"`
+	}
+	if err := json.Unmarshal(b, &s); err != nil {
+		return nil, fmt.Errorf("loading schedule '%s': %s", file, err)
+	}
+	names := map[string]bool{}
+	for i := range s.Jobs {
+		sj := &s.Jobs[i]
+		if err := sj.load(); err != nil {
+			return nil, fmt.Errorf("schedule '%s', job '%s': %s", file, sj.Name, err)
+		}
+		if names[sj.Name] {
+			return nil, fmt.Errorf("schedule '%s': duplicate job name '%s'", file, sj.Name)
+		}
+		names[sj.Name] = true
+	}
+	return s.Jobs, nil
+}
+
+func (sj *ScheduledJob) load() error {
+	if sj.Name == "" {
+		return errors.New("name is empty")
+	}
+	loc := time.Local
+	if sj.TimeZone != "" {
+		var err error
+		if loc, err = time.LoadLocation(sj.TimeZone); err != nil {
+			return err
+		}
+	}
+	var err error
+	if sj.sched, err = parseCron(sj.Cron, loc); err != nil {
+		return err
+	}
+	if sj.sched.next(time.Now()).IsZero() {
+		return fmt.Errorf("cron expression '%s' never matches", sj.Cron)
+	}
+	switch {
+	case sj.Config != nil && sj.ConfigFile != "":
+		return errors.New("only one of config and config_file can be set")
+	case sj.ConfigFile != "":
+		if sj.Config, err = LoadConfig(sj.ConfigFile); err != nil {
+			return err
+		}
+	case sj.Config == nil:
+		return errors.New("either config or config_file must be set")
+	default:
+		if err := sj.Config.Validate(); err != nil {
+			return err
+		}
+	}
+	c := *sj.Config
+	_, err = sj.Run.apply(&c)
+	return err
+}
+
+// submit scheduled jobs on their schedules until stopCh is closed. run of a job
+// is skipped if its previous job is still queued or running
+func (m *JobManager) RunSchedule(jobs []ScheduledJob, stopCh <-chan struct{}) {
+	doneCh := make(chan struct{})
+	for i := range jobs {
+		go func(sj *ScheduledJob) {
+			m.runScheduled(sj, stopCh)
+			doneCh <- struct{}{}
+		}(&jobs[i])
+	}
+	for range jobs {
+		<-doneCh
+	}
+}
+
+func (m *JobManager) runScheduled(sj *ScheduledJob, stopCh <-chan struct{}) {
+	t := time.Now()
+	for {
+		if t = sj.sched.next(t); t.IsZero() {
+			logWarn("schedule '%s': cron expression '%s' never matches", sj.Name, sj.Cron)
+			return
+		}
+		logInfo("schedule '%s': next run at %s", sj.Name, t.Format(time.RFC3339))
+		timer := time.NewTimer(time.Until(t))
+		select {
+		case <-stopCh:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		m.submitScheduled(sj)
+	}
+}
+
+func (m *JobManager) submitScheduled(sj *ScheduledJob) {
+	m.mu.Lock()
+	for _, e := range m.jobs {
+		if e.Schedule == sj.Name && e.active() {
+			m.mu.Unlock()
+			logWarn("schedule '%s': skipping run, previous job %s is still %s", sj.Name, e.ID, e.Status)
+			return
+		}
+	}
+	m.mu.Unlock()
+	if _, err := m.submit(JobSpec{Config: *sj.Config, Run: sj.Run}, sj.Name); err != nil {
+		logError("schedule '%s': submitting job: %s", sj.Name, err)
+	}
+}
@@ -0,0 +1,190 @@
+package s3copy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// object store of directory on SFTP server, keys are paths relative to root directory.
+// access_key is the user, secret_key its password and key_file an optional private key.
+// host key is checked against known_hosts, ~/.ssh/known_hosts by default
+type sftpStore struct {
+	client  *sftp.Client
+	root    string
+	cursors treeCursors
+}
+
+func newSFTPStore(e Endpoint) (*sftpStore, error) {
+	var auth []ssh.AuthMethod
+	if e.KeyFile != "" {
+		b, err := ioutil.ReadFile(e.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		signer, err := ssh.ParsePrivateKey(b)
+		if err != nil {
+			return nil, fmt.Errorf("parsing key file '%s': %s", e.KeyFile, err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if e.SecretKey != "" {
+		auth = append(auth, ssh.Password(e.SecretKey))
+	}
+	if len(auth) == 0 {
+		return nil, errors.New("sftp endpoint requires secret_key or key_file")
+	}
+
+	hostsFile := e.KnownHosts
+	if hostsFile == "" {
+		hostsFile = filepath.Join(os.Getenv("HOME"), ".ssh", "known_hosts")
+	}
+	hostKeys, err := knownhosts.New(hostsFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading known hosts: %s", err)
+	}
+
+	addr := e.Endpoint
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "22")
+	}
+	conn, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{User: e.AccessKey, Auth: auth, HostKeyCallback: hostKeys})
+	if err != nil {
+		return nil, err
+	}
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	root := e.Path
+	if root == "" {
+		root = "."
+	}
+	return &sftpStore{client: client, root: root}, nil
+}
+
+// remote path of the file, keys escaping root or changed by cleaning of path are rejected
+func (s *sftpStore) path(key string) (string, error) {
+	if err := checkPathKey(key, "sftp"); err != nil {
+		return "", err
+	}
+	return path.Join(s.root, key), nil
+}
+
+func (s *sftpStore) readDir(dir string) ([]os.FileInfo, error) {
+	return s.client.ReadDir(path.Join(s.root, dir))
+}
+
+func (s *sftpStore) List(ctx context.Context, prefix, token string, pageSize int) ([]Object, string, error) {
+	return listTree(ctx, s, &s.cursors, prefix, token, pageSize)
+}
+
+func (s *sftpStore) Stat(ctx context.Context, key string) (Object, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return Object{}, err
+	}
+	fi, err := s.client.Stat(p)
+	if os.IsNotExist(err) || (err == nil && fi.IsDir()) {
+		return Object{}, ErrNotFound
+	}
+	if err != nil {
+		return Object{}, err
+	}
+	return fileObject(key, fi), nil
+}
+
+type sftpReader struct {
+	io.Reader
+	f *sftp.File
+}
+
+func (r *sftpReader) Close() error {
+	return r.f.Close()
+}
+
+func (s *sftpStore) Get(ctx context.Context, key string, offset, length int64) (io.ReadCloser, Object, error) {
+	obj, err := s.Stat(ctx, key)
+	if err != nil {
+		return nil, Object{}, err
+	}
+	p, _ := s.path(key)
+	f, err := s.client.Open(p)
+	if err != nil {
+		return nil, Object{}, err
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, Object{}, err
+	}
+	var r io.Reader = f
+	if length >= 0 {
+		r = io.LimitReader(f, length)
+	}
+	return &sftpReader{Reader: r, f: f}, obj, nil
+}
+
+// file is written under temporary name and renamed, so partner never picks up partial file
+func (s *sftpStore) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) (int64, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return 0, err
+	}
+	dir := path.Dir(p)
+	if err := s.client.MkdirAll(dir); err != nil {
+		return 0, err
+	}
+	tmp := path.Join(dir, ".s3-copy-dir-"+path.Base(p))
+	f, err := s.client.Create(tmp)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(f, r)
+	if err == nil && n != size {
+		err = fmt.Errorf("read %d bytes of %d", n, size)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = s.rename(tmp, p)
+	}
+	if err != nil {
+		s.client.Remove(tmp)
+		return n, err
+	}
+	return n, nil
+}
+
+// plain SFTP rename fails if target exists, posix-rename extension replaces it
+func (s *sftpStore) rename(from, to string) error {
+	if err := s.client.PosixRename(from, to); err == nil {
+		return nil
+	}
+	if err := s.client.Remove(to); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return s.client.Rename(from, to)
+}
+
+// removing missing file isn't an error, same as in S3
+func (s *sftpStore) Delete(ctx context.Context, key string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := s.client.Remove(p); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package s3copy
+
+import "strings"
+
+// policies of objects existing in destination, see Options.SkipPolicy
+const (
+	// existing objects are always re-copied
+	SkipPolicyNever = "never"
+	// existing objects are kept
+	SkipPolicyIfExists = "if-exists"
+	// existing objects of the same size as source are kept
+	SkipPolicyIfSameSize = "if-same-size"
+	// existing objects with content matching source are kept, compared as with heal
+	SkipPolicyIfSameETag = "if-same-etag"
+	// existing objects are kept unless source was modified after them, as with sync
+	SkipPolicyIfNotNewer = "if-not-newer"
+	// nothing is written, missing objects are skipped as well, so skip report shows
+	// which objects the copy would write
+	SkipPolicyAlways = "always"
+)
+
+var skipPolicies = []string{SkipPolicyNever, SkipPolicyIfExists, SkipPolicyIfSameSize, SkipPolicyIfSameETag, SkipPolicyIfNotNewer, SkipPolicyAlways}
+
+func validSkipPolicy(policy string) bool {
+	for _, p := range skipPolicies {
+		if p == policy {
+			return true
+		}
+	}
+	return false
+}
+
+func skipPoliciesString() string {
+	return strings.Join(skipPolicies, ", ")
+}
+
+// checks of object existing in destination: with skip_policy older objects are re-copied
+// only with if-not-newer and content is compared only with if-same-etag, without it
+// sync (overwriteOlder) re-copies older objects and heal compares content
+func (cp *Copier) existingChecks(overwriteOlder bool) (policy string, older, content bool) {
+	switch policy = cp.cfg.Options.SkipPolicy; policy {
+	case "":
+		return "", overwriteOlder, cp.heal
+	case SkipPolicyIfNotNewer:
+		return policy, true, false
+	case SkipPolicyIfSameETag:
+		return policy, false, true
+	}
+	return policy, false, false
+}
@@ -0,0 +1,72 @@
+package s3copy
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// reasons of skipped objects in skip report, json output of objects and skipped_by_reason of report
+const (
+	// excluded by filters, e.g. --include, --exclude or metadata_filter
+	SkipFiltered = "filtered"
+	// recorded as copied with the same ETag in state database, destination isn't checked
+	SkipState = "state"
+	// exists in destination, its content isn't compared
+	SkipExists = "exists"
+	// exists in destination with content matching source, compared with heal or if-same-etag policy
+	SkipSameContent = "same-content"
+	// exists in destination with the same size as source, with if-same-size policy
+	SkipSameSize = "same-size"
+	// exists in destination and source wasn't modified after it, with sync or if-not-newer policy
+	SkipNotNewer = "not-newer"
+	// missing in destination, nothing is written with always policy
+	SkipPolicy = "policy"
+	// removed from source after listing
+	SkipRemoved = "removed"
+	// written to destination concurrently, conditional write kept it
+	SkipConflict = "conflict"
+	// source object is in archive storage class and must be restored before it can be read,
+	// it's counted as failed and recorded in failed_file
+	SkipArchived = "archived"
+	// destination rejected object as too large, see chunk_objects. it's counted as failed
+	SkipTooLarge = "too-large"
+)
+
+// skipped object recorded in skip report
+type skipEntry struct {
+	Time   time.Time `json:"time"`
+	Bucket string    `json:"bucket"`
+	Key    string    `json:"key"`
+	Reason string    `json:"reason"`
+	Detail string    `json:"detail"`
+	Error  string    `json:"error,omitempty"`
+}
+
+// append-only json lines of skipped objects with reasons, entries of subsequent runs are appended
+type skipReport struct {
+	sync.Mutex
+	file *os.File
+	enc  *json.Encoder
+}
+
+func openSkipReport(path string) (*skipReport, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &skipReport{file: f, enc: json.NewEncoder(f)}, nil
+}
+
+// record skipped object, every entry is written to the file immediately
+func (sr *skipReport) record(ev objectEvent) {
+	sr.Lock()
+	defer sr.Unlock()
+	logErr(sr.enc.Encode(skipEntry{Time: time.Now().UTC(), Bucket: ev.Bucket, Key: ev.Key,
+		Reason: ev.Skip, Detail: ev.Reason, Error: ev.Error}))
+}
+
+func (sr *skipReport) close() {
+	logErr(sr.file.Close())
+}
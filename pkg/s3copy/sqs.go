@@ -0,0 +1,264 @@
+package s3copy
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	sqsAPIVersion = "2012-11-05"
+	// long polling time of ReceiveMessage, maximum allowed by SQS
+	sqsWaitTime = "20"
+	// received messages are hidden from other consumers for this long, unhandled ones are redelivered
+	sqsVisibilityTimeout = "300"
+)
+
+// S3 event notifications consumed from SQS queue, AWS sources don't support
+// ListenBucketNotification. queue keeps messages until they're deleted, so nothing
+// is buffered: message is deleted once all its events were handled, otherwise it's
+// received again after visibility timeout
+type sqsFeed struct {
+	queueURL  string
+	region    string
+	accessKey string
+	secretKey string
+	bucket    string
+}
+
+// feed of queue url, credentials of source endpoint are used to access the queue.
+// region is taken from queue url, e.g. https://sqs.eu-west-1.amazonaws.com/123456789012/queue
+func newSQSFeed(queueURL string, e Endpoint, bucket string) (*sqsFeed, error) {
+	u, err := url.Parse(queueURL)
+	if err != nil || u.Host == "" || (u.Scheme != "https" && u.Scheme != "http") {
+		return nil, fmt.Errorf("invalid sqs_queue_url '%s'", queueURL)
+	}
+	region := e.Region
+	if parts := strings.Split(u.Hostname(), "."); len(parts) == 4 && parts[0] == "sqs" {
+		region = parts[1]
+	}
+	if region == "" {
+		region = "us-east-1"
+	}
+	return &sqsFeed{queueURL: queueURL, region: region, accessKey: e.AccessKey, secretKey: e.SecretKey, bucket: bucket}, nil
+}
+
+type sqsMessage struct {
+	ReceiptHandle string
+	Body          string
+}
+
+// S3 event notification, see https://docs.aws.amazon.com/AmazonS3/latest/userguide/notification-content-structure.html
+type s3EventMessage struct {
+	Records []struct {
+		EventName string `json:"eventName"`
+		S3        struct {
+			Bucket struct {
+				Name string `json:"name"`
+			} `json:"bucket"`
+			Object struct {
+				Key string `json:"key"`
+			} `json:"object"`
+		} `json:"s3"`
+	}
+	// test message sent when notification is configured
+	Event string
+	// set when notification is delivered through SNS topic
+	Type    string
+	Message string
+}
+
+func (f *sqsFeed) changes(ctx context.Context, prefix string) <-chan changeEvent {
+	ch := make(chan changeEvent)
+	go func() {
+		defer close(ch)
+		for ctx.Err() == nil {
+			msgs, err := f.receive(ctx)
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				// invalid credentials or queue, or missing permissions
+				if se, ok := err.(*statusError); ok && se.code == 400 || classifyError(err) == errAuth {
+					select {
+					case ch <- changeEvent{Err: err}:
+					case <-ctx.Done():
+					}
+					return
+				}
+				logWarn("receiving messages of '%s': %s, retrying in %s", f.queueURL, err, listenRetryDelay)
+				select {
+				case <-time.After(listenRetryDelay):
+				case <-ctx.Done():
+					return
+				}
+				continue
+			}
+			for _, m := range msgs {
+				for _, ev := range f.events(m, prefix) {
+					select {
+					case ch <- ev:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}
+		}
+	}()
+	return ch
+}
+
+// change events of message, message is deleted once all of them were handled successfully.
+// message without events of prefix is deleted right away, unknown message is left in queue
+func (f *sqsFeed) events(m sqsMessage, prefix string) []changeEvent {
+	var msg s3EventMessage
+	err := json.Unmarshal([]byte(m.Body), &msg)
+	if err == nil && msg.Type == "Notification" {
+		body := msg.Message
+		msg = s3EventMessage{}
+		err = json.Unmarshal([]byte(body), &msg)
+	}
+	if err != nil || (msg.Records == nil && msg.Event == "") {
+		logWarn("message of '%s' isn't S3 event notification, leaving it in queue: %.200s", f.queueURL, m.Body)
+		return nil
+	}
+
+	var evs []changeEvent
+	for _, rec := range msg.Records {
+		key, err := url.QueryUnescape(rec.S3.Object.Key)
+		if err != nil {
+			key = rec.S3.Object.Key
+		}
+		if rec.S3.Bucket.Name != f.bucket || !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		evs = append(evs, changeEvent{Key: key, Deleted: strings.HasPrefix(rec.EventName, "ObjectRemoved:")})
+	}
+	if len(evs) == 0 {
+		f.delete(m.ReceiptHandle)
+		return nil
+	}
+
+	var mu sync.Mutex
+	pending, failed := len(evs), false
+	done := func(ok bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		pending--
+		failed = failed || !ok
+		if pending == 0 && !failed {
+			f.delete(m.ReceiptHandle)
+		}
+	}
+	for i := range evs {
+		evs[i].done = done
+	}
+	return evs
+}
+
+// long poll of up to 10 messages
+func (f *sqsFeed) receive(ctx context.Context) ([]sqsMessage, error) {
+	var res struct {
+		Messages []sqsMessage `xml:"ReceiveMessageResult>Message"`
+	}
+	err := f.call(ctx, url.Values{
+		"Action":              {"ReceiveMessage"},
+		"MaxNumberOfMessages": {"10"},
+		"WaitTimeSeconds":     {sqsWaitTime},
+		"VisibilityTimeout":   {sqsVisibilityTimeout},
+	}, &res)
+	return res.Messages, err
+}
+
+func (f *sqsFeed) delete(receiptHandle string) {
+	err := f.call(context.Background(), url.Values{"Action": {"DeleteMessage"}, "ReceiptHandle": {receiptHandle}}, nil)
+	if err != nil {
+		logWarn("deleting handled message of '%s', it will be received again: %s", f.queueURL, err)
+	}
+}
+
+// send action of query api, xml response is decoded into res
+func (f *sqsFeed) call(ctx context.Context, params url.Values, res interface{}) error {
+	params.Set("Version", sqsAPIVersion)
+	body := params.Encode()
+	req, err := http.NewRequest(http.MethodPost, f.queueURL, strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	f.sign(req, body)
+	resp, err := doRequest(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if res == nil {
+		return nil
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(res); err != nil {
+		return errors.New("decoding sqs response: " + err.Error())
+	}
+	return nil
+}
+
+// AWS signature version 4 of sqs request
+func (f *sqsFeed) sign(req *http.Request, body string) {
+	signV4(req, sha256Hex(body), f.accessKey, f.secretKey, f.region, "sqs")
+}
+
+// sign request with AWS signature version 4, payloadHash is hex sha256 of the body
+func signV4(req *http.Request, payloadHash, accessKey, secretKey, region, service string) {
+	t := time.Now().UTC()
+	date := t.Format("20060102")
+	req.Header.Set("X-Amz-Date", t.Format("20060102T150405Z"))
+	req.Header.Set("Host", req.URL.Host)
+
+	var names []string
+	for k := range req.Header {
+		names = append(names, strings.ToLower(k))
+	}
+	sort.Strings(names)
+	var headers strings.Builder
+	for _, k := range names {
+		headers.WriteString(k + ":" + strings.TrimSpace(req.Header.Get(k)) + "\n")
+	}
+	signed := strings.Join(names, ";")
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonical := strings.Join([]string{req.Method, path, req.URL.RawQuery, headers.String(), signed, payloadHash}, "\n")
+
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	toSign := "AWS4-HMAC-SHA256\n" + t.Format("20060102T150405Z") + "\n" + scope + "\n" + sha256Hex(canonical)
+	key := []byte("AWS4" + secretKey)
+	for _, s := range []string{date, region, service, "aws4_request"} {
+		key = hmacSHA256(key, s)
+	}
+	req.Header.Del("Host")
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+accessKey+"/"+scope+
+		", SignedHeaders="+signed+", Signature="+hex.EncodeToString(hmacSHA256(key, toSign)))
+}
+
+func hmacSHA256(key []byte, s string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(s))
+	return mac.Sum(nil)
+}
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
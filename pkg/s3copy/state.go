@@ -0,0 +1,150 @@
+package s3copy
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"strings"
+	"time"
+)
+
+// persistent record of successfully copied objects, stored in local bolt database.
+// objects recorded with the same ETag as in the source listing are skipped on restart
+// without stat-ing them in destination. state of incomplete multipart uploads is kept
+// in separate bolt bucket to resume them after restart, as well as successful runs
+type copyState struct {
+	db      *bolt.DB
+	bucket  []byte
+	uploads []byte
+	runs    []byte
+}
+
+// start and end of successful run, see RunOptions.SinceLastRun
+type runRecord struct {
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+}
+
+// open (or create) state database, keys are tracked per source bucket and directory and
+// destination they're copied to. dest identifies destination endpoints, uploads belong to them.
+// keys identifies keys of objects in destination, see stateKeys
+func openCopyState(path, bucket, dir, dest, keys string) (*copyState, error) {
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: time.Second * 5})
+	if err != nil {
+		return nil, err
+	}
+	copied := bucket + "/" + dir + " -> " + dest + keys
+	cs := &copyState{
+		db:      db,
+		bucket:  []byte(copied),
+		uploads: []byte("uploads:" + bucket + "/" + dir + " -> " + dest),
+		runs:    []byte("runs:" + copied),
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, b := range [][]byte{cs.bucket, cs.uploads, cs.runs} {
+			if _, err := tx.CreateBucketIfNotExists(b); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return cs, nil
+}
+
+// check if object with given ETag was already copied
+func (cs *copyState) isCopied(key, etag string) bool {
+	copied := false
+	logErr(cs.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(cs.bucket).Get([]byte(key))
+		copied = v != nil && string(v) == etag
+		return nil
+	}))
+	return copied
+}
+
+// record successfully copied object, concurrent calls are batched into single transaction
+func (cs *copyState) markCopied(key, etag string) {
+	logErr(cs.db.Batch(func(tx *bolt.Tx) error {
+		return tx.Bucket(cs.bucket).Put([]byte(key), []byte(etag))
+	}))
+}
+
+// get state of incomplete multipart upload, nil if there is no upload for the key
+func (cs *copyState) getUpload(key string) *multipartUpload {
+	var u *multipartUpload
+	logErr(cs.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(cs.uploads).Get([]byte(key))
+		if v == nil {
+			return nil
+		}
+		u = &multipartUpload{}
+		return json.Unmarshal(v, u)
+	}))
+	return u
+}
+
+func (cs *copyState) saveUpload(key string, u *multipartUpload) {
+	b, err := json.Marshal(u)
+	logErr(err)
+	logErr(cs.db.Batch(func(tx *bolt.Tx) error {
+		return tx.Bucket(cs.uploads).Put([]byte(key), b)
+	}))
+}
+
+func (cs *copyState) deleteUpload(key string) {
+	logErr(cs.db.Batch(func(tx *bolt.Tx) error {
+		return tx.Bucket(cs.uploads).Delete([]byte(key))
+	}))
+}
+
+// destination keys of copied objects depend on key normalization and obfuscation, state
+// recorded with other ones doesn't tell which objects exist in destination. backup doesn't use state
+func (cp *Copier) stateKeys() string {
+	var keys string
+	o := cp.cfg.Options
+	if len(o.KeyNormalization) > 0 {
+		keys += " normalized:" + strings.Join(o.KeyNormalization, ",")
+	}
+	if ko := o.KeyObfuscation; ko != nil {
+		keys += " obfuscated:" + ko.Mode + ":" + ko.SecretFile
+	}
+	return keys
+}
+
+// name of run record in state, shards of the directory are recorded separately
+func (cp *Copier) runName() string {
+	if n := cp.cfg.Run.ShardCount; n > 1 {
+		return fmt.Sprintf("shard-%d-of-%d", cp.cfg.Run.ShardIndex, n)
+	}
+	return "all"
+}
+
+// last successful run of the copy, nil if there is none. name distinguishes shards
+func (cs *copyState) lastRun(name string) *runRecord {
+	var r *runRecord
+	logErr(cs.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(cs.runs).Get([]byte(name))
+		if v == nil {
+			return nil
+		}
+		r = &runRecord{}
+		return json.Unmarshal(v, r)
+	}))
+	return r
+}
+
+func (cs *copyState) saveRun(name string, r runRecord) {
+	b, err := json.Marshal(r)
+	logErr(err)
+	logErr(cs.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(cs.runs).Put([]byte(name), b)
+	}))
+}
+
+func (cs *copyState) close() {
+	logErr(cs.db.Close())
+}
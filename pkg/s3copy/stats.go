@@ -0,0 +1,258 @@
+package s3copy
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// number of slowest in-flight objects included in stats dump
+const slowestInflight = 5
+
+// progress counters of the copy, updated by workers and read by reporters without locking.
+// fields are accessed only atomically, int64 fields come first to stay 64-bit aligned
+type objCounter struct {
+	totalCount int64 // -1 if objects weren't counted
+	totalBytes int64 // size of counted objects
+	current    int64
+	copied     int64
+	skipped    int64
+	failed     int64
+	bytes      int64
+}
+
+// counters read at one moment, fields are loaded separately so they can be off by in-flight objects
+type counterSnapshot struct {
+	Total      int64
+	TotalBytes int64
+	Current    int64
+	Copied     int64
+	Skipped    int64
+	Failed     int64
+	Bytes      int64
+}
+
+func newObjCounter() *objCounter {
+	return &objCounter{totalCount: -1}
+}
+
+// set number and size of objects to process, -1 if they are unknown
+func (oc *objCounter) setTotal(count, size int64) {
+	atomic.StoreInt64(&oc.totalCount, count)
+	atomic.StoreInt64(&oc.totalBytes, size)
+}
+
+// count processed object, returns number of processed and failed objects including it
+func (oc *objCounter) record(ev objectEvent) (current, failed int64) {
+	switch ev.Result {
+	case ResultCopied, ResultRecopied:
+		atomic.AddInt64(&oc.copied, 1)
+		atomic.AddInt64(&oc.bytes, ev.Bytes)
+	case ResultSkipped:
+		atomic.AddInt64(&oc.skipped, 1)
+	case ResultFailed:
+		failed = atomic.AddInt64(&oc.failed, 1)
+	}
+	if ev.Result != ResultFailed {
+		failed = atomic.LoadInt64(&oc.failed)
+	}
+	return atomic.AddInt64(&oc.current, 1), failed
+}
+
+func (oc *objCounter) snapshot() counterSnapshot {
+	return counterSnapshot{
+		Total:      atomic.LoadInt64(&oc.totalCount),
+		TotalBytes: atomic.LoadInt64(&oc.totalBytes),
+		Current:    atomic.LoadInt64(&oc.current),
+		Copied:     atomic.LoadInt64(&oc.copied),
+		Skipped:    atomic.LoadInt64(&oc.skipped),
+		Failed:     atomic.LoadInt64(&oc.failed),
+		Bytes:      atomic.LoadInt64(&oc.bytes),
+	}
+}
+
+// total formatted for progress log prefix, empty if objects weren't counted
+func (oc *objCounter) total() string {
+	return formatTotal(atomic.LoadInt64(&oc.totalCount))
+}
+
+func (s counterSnapshot) total() string {
+	return formatTotal(s.Total)
+}
+
+func formatTotal(total int64) string {
+	if total == -1 {
+		return ""
+	}
+	return "/" + strconv.FormatInt(total, 10)
+}
+
+// objects being copied right now with copy start time
+type inflightObjects struct {
+	sync.Mutex
+	objects map[string]time.Time
+}
+
+func newInflightObjects() *inflightObjects {
+	return &inflightObjects{objects: map[string]time.Time{}}
+}
+
+func (in *inflightObjects) add(key string, start time.Time) {
+	in.Lock()
+	in.objects[key] = start
+	in.Unlock()
+}
+
+func (in *inflightObjects) remove(key string) {
+	in.Lock()
+	delete(in.objects, key)
+	in.Unlock()
+}
+
+type inflightObject struct {
+	key     string
+	elapsed time.Duration
+}
+
+// return up to n objects which are being copied for the longest time
+func (in *inflightObjects) slowest(n int) []inflightObject {
+	in.Lock()
+	now := time.Now()
+	objs := make([]inflightObject, 0, len(in.objects))
+	for key, start := range in.objects {
+		objs = append(objs, inflightObject{key, now.Sub(start)})
+	}
+	in.Unlock()
+
+	sort.Slice(objs, func(i, j int) bool { return objs[i].elapsed > objs[j].elapsed })
+	if len(objs) > n {
+		objs = objs[:n]
+	}
+	return objs
+}
+
+// prints snapshot of copy statistics, throughput is calculated for the whole run
+// and for the period since previous snapshot
+type statsDumper struct {
+	cp        *Copier
+	start     time.Time
+	lastTime  time.Time
+	lastBytes int64
+}
+
+func (sd *statsDumper) dump() {
+	counts := sd.cp.oc.snapshot()
+	current, total, copied, skipped, failed, bytes := counts.Current, counts.total(), counts.Copied, counts.Skipped, counts.Failed, counts.Bytes
+
+	now := time.Now()
+	elapsed := now.Sub(sd.start)
+	recent := now.Sub(sd.lastTime)
+	logSummary("stats: %d processed%s, %d copied, %d skipped, %d failed, %s transferred in %s",
+		current, total, copied, skipped, failed, FormatBytes(bytes), elapsed.Round(time.Second))
+	logSummary("stats: throughput %s/s overall, %s/s over last %s, active workers %d/%d, paused %t",
+		FormatBytes(int64(float64(bytes)/elapsed.Seconds())),
+		FormatBytes(int64(float64(bytes-sd.lastBytes)/recent.Seconds())), recent.Round(time.Second),
+		sd.cp.wl.running(), sd.cp.wl.getLimit(), sd.cp.wl.isPaused())
+	if l := sd.cp.latency.summary(); l != "" {
+		logSummary("stats: latency %s", l)
+	}
+	for _, obj := range sd.cp.inflight.slowest(slowestInflight) {
+		logSummary("stats: in-flight '%s/%s' for %s", sd.cp.bucket, obj.key, obj.elapsed.Round(time.Second))
+	}
+	sd.lastTime, sd.lastBytes = now, bytes
+}
+
+// print one line progress summary every interval until stopCh is closed
+func printSummaries(cp *Copier, interval time.Duration, stopCh <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	start := time.Now()
+	for {
+		select {
+		case <-ticker.C:
+			counts := cp.oc.snapshot()
+			current, total, copied, skipped, failed, bytes := counts.Current, counts.total(), counts.Copied, counts.Skipped, counts.Failed, counts.Bytes
+			elapsed := time.Since(start)
+			logSummary("progress: %d%s processed, %d copied, %d skipped, %d failed, %s transferred, %s/s, elapsed %s",
+				current, total, copied, skipped, failed, FormatBytes(bytes),
+				FormatBytes(int64(float64(bytes)/elapsed.Seconds())), elapsed.Round(time.Second))
+			if l := cp.latency.summary(); l != "" {
+				logSummary("progress: latency %s", l)
+			}
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+// max number of latency samples kept per operation, older samples are replaced at random
+// so percentiles represent the whole run with bounded memory
+const latencySamples = 10000
+
+// latency of requests (GET, PUT, STAT) sampled per operation
+type opLatencies struct {
+	sync.Mutex
+	samples map[string][]time.Duration
+	seen    map[string]int64
+}
+
+func newOpLatencies() *opLatencies {
+	return &opLatencies{samples: map[string][]time.Duration{}, seen: map[string]int64{}}
+}
+
+// reservoir sampling of request latencies
+func (ol *opLatencies) record(op string, d time.Duration) {
+	ol.Lock()
+	defer ol.Unlock()
+	ol.seen[op]++
+	if s := ol.samples[op]; len(s) < latencySamples {
+		ol.samples[op] = append(s, d)
+	} else if i := rand.Int63n(ol.seen[op]); i < latencySamples {
+		s[i] = d
+	}
+}
+
+// percentiles of single operation latency
+type LatencyStats struct {
+	Count int64   `json:"count"`
+	P50   float64 `json:"p50_sec"`
+	P95   float64 `json:"p95_sec"`
+	P99   float64 `json:"p99_sec"`
+}
+
+func (ol *opLatencies) stats() map[string]LatencyStats {
+	ol.Lock()
+	defer ol.Unlock()
+	res := map[string]LatencyStats{}
+	for op, s := range ol.samples {
+		sorted := append([]time.Duration(nil), s...)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		res[op] = LatencyStats{
+			Count: ol.seen[op],
+			P50:   percentile(sorted, 50).Seconds(),
+			P95:   percentile(sorted, 95).Seconds(),
+			P99:   percentile(sorted, 99).Seconds(),
+		}
+	}
+	return res
+}
+
+// one line summary of latencies, e.g. "GET p50 20ms p95 80ms p99 150ms"
+func (ol *opLatencies) summary() string {
+	stats := ol.stats()
+	var parts []string
+	for _, op := range []string{"STAT", "GET", "PUT"} {
+		st, ok := stats[op]
+		if !ok {
+			continue
+		}
+		sec := func(v float64) time.Duration { return time.Duration(v * float64(time.Second)).Round(time.Millisecond) }
+		parts = append(parts, fmt.Sprintf("%s p50 %s p95 %s p99 %s", op, sec(st.P50), sec(st.P95), sec(st.P99)))
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,231 @@
+package s3copy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// number of most recent failures shown on status endpoint
+const recentErrorsSize = 20
+
+type recentError struct {
+	Time  time.Time `json:"time"`
+	Key   string    `json:"key"`
+	Class string    `json:"class"`
+	Error string    `json:"error"`
+}
+
+// ring buffer of the most recent failures
+type recentErrors struct {
+	sync.Mutex
+	errors []recentError
+	next   int
+}
+
+func (re *recentErrors) record(ev objectEvent) {
+	re.Lock()
+	defer re.Unlock()
+	e := recentError{Time: time.Now().UTC(), Key: ev.Key, Class: ev.ErrorClass, Error: ev.Error}
+	if len(re.errors) < recentErrorsSize {
+		re.errors = append(re.errors, e)
+		return
+	}
+	re.errors[re.next] = e
+	re.next = (re.next + 1) % recentErrorsSize
+}
+
+// recent failures, newest first
+func (re *recentErrors) list() []recentError {
+	re.Lock()
+	defer re.Unlock()
+	res := make([]recentError, 0, len(re.errors))
+	for i := len(re.errors) - 1; i >= 0; i-- {
+		res = append(res, re.errors[(re.next+i)%len(re.errors)])
+	}
+	return res
+}
+
+type inflightStatus struct {
+	Key        string  `json:"key"`
+	ElapsedSec float64 `json:"elapsed_sec"`
+}
+
+// live progress returned by status endpoint
+type copyStatus struct {
+	Bucket     string    `json:"bucket"`
+	Start      time.Time `json:"start"`
+	ElapsedSec float64   `json:"elapsed_sec"`
+
+	Processed  int64   `json:"processed"`
+	Total      int64   `json:"total,omitempty"`
+	Copied     int64   `json:"copied"`
+	Skipped    int64   `json:"skipped"`
+	Failed     int64   `json:"failed"`
+	Bytes      int64   `json:"bytes"`
+	TotalBytes int64   `json:"total_bytes,omitempty"`
+	Rate       float64 `json:"rate_bytes_per_sec"`
+	ObjRate    float64 `json:"rate_objects_per_sec"`
+	ETASec     float64 `json:"eta_sec,omitempty"`
+
+	WorkersActive int              `json:"workers_active"`
+	WorkersLimit  int              `json:"workers_limit"`
+	Paused        bool             `json:"paused"`
+	Inflight      []inflightStatus `json:"slowest_inflight"`
+	RecentErrors  []recentError    `json:"recent_errors"`
+	// bytes per second read from source, 0 - unlimited
+	BandwidthLimit int64 `json:"bandwidth_limit"`
+
+	Prefixes map[string]PrefixStats `json:"prefixes"`
+	// samples of counters, only with ?history=1
+	History []throughputSample `json:"history,omitempty"`
+}
+
+func (cp *Copier) status(start time.Time) *copyStatus {
+	st := &copyStatus{Bucket: cp.bucket, Start: start.UTC()}
+	elapsed := time.Since(start)
+	st.ElapsedSec = elapsed.Seconds()
+
+	counts := cp.oc.snapshot()
+	st.Processed, st.Copied, st.Skipped, st.Failed, st.Bytes = counts.Current, counts.Copied, counts.Skipped, counts.Failed, counts.Bytes
+	total, totalBytes := counts.Total, counts.TotalBytes
+
+	st.Rate = float64(st.Bytes) / elapsed.Seconds()
+	st.ObjRate = float64(st.Processed) / elapsed.Seconds()
+	// ETA is known only if objects were counted before copy
+	if total > 0 {
+		st.Total = total
+		if st.ObjRate > 0 {
+			st.ETASec = float64(total-st.Processed) / st.ObjRate
+		}
+	}
+	if totalBytes > 0 {
+		st.TotalBytes = totalBytes
+		if st.Rate > 0 {
+			st.ETASec = float64(totalBytes-st.Bytes) / st.Rate
+		}
+	}
+
+	st.WorkersActive, st.WorkersLimit, st.Paused = cp.wl.running(), cp.wl.getLimit(), cp.wl.isPaused()
+	st.BandwidthLimit = cp.BandwidthLimit()
+	for _, obj := range cp.inflight.slowest(slowestInflight) {
+		st.Inflight = append(st.Inflight, inflightStatus{obj.key, obj.elapsed.Seconds()})
+	}
+	st.RecentErrors = cp.recentErrors.list()
+
+	cp.breakdown.Lock()
+	st.Prefixes = make(map[string]PrefixStats, len(cp.breakdown.prefixes))
+	for p, ps := range cp.breakdown.prefixes {
+		s := *ps
+		s.Errors = make(map[string]int64, len(ps.Errors))
+		for class, n := range ps.Errors {
+			s.Errors[class] = n
+		}
+		st.Prefixes[p] = s
+	}
+	cp.breakdown.Unlock()
+	return st
+}
+
+// status endpoint of the copy, history of counters is included with ?history=1
+func (cp *Copier) writeStatus(w http.ResponseWriter, r *http.Request, start time.Time) {
+	st := cp.status(start)
+	if r.URL.Query().Get("history") == "1" {
+		st.History = cp.history.list()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "    ")
+	logErr(enc.Encode(st))
+}
+
+// changes of running copy posted to control endpoint, unset fields aren't changed
+type controlRequest struct {
+	Concurrency *int `json:"concurrency"`
+	// bytes per second, e.g. "50MiB", "unlimited" or "0" - no limit
+	BandwidthLimit *string `json:"bandwidth_limit"`
+	Paused         *bool   `json:"paused"`
+}
+
+// apply posted changes of concurrency, bandwidth limit and pause to running copy, responds with status
+func (cp *Copier) handleControl(w http.ResponseWriter, r *http.Request, start time.Time) {
+	if r.Method != "POST" {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	var req controlRequest
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid request: "+err.Error())
+		return
+	}
+	if req.Concurrency != nil && *req.Concurrency < 1 {
+		writeJSONError(w, http.StatusBadRequest, "concurrency must be at least 1")
+		return
+	}
+	var limit int64
+	if req.BandwidthLimit != nil && !strings.EqualFold(*req.BandwidthLimit, "unlimited") && *req.BandwidthLimit != "0" {
+		var err error
+		if limit, err = ParseByteSize(*req.BandwidthLimit); err != nil || limit <= 0 {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("invalid bandwidth limit '%s', e.g. 50MiB or unlimited", *req.BandwidthLimit))
+			return
+		}
+	}
+	if req.Paused != nil && *req.Paused && !cp.Paused() {
+		if !cp.Pause() {
+			writeJSONError(w, http.StatusConflict, "copy is stopping")
+			return
+		}
+		logInfo("pausing, waiting for %d in-flight copies", cp.wl.running())
+	}
+	if req.Concurrency != nil {
+		cp.SetConcurrency(*req.Concurrency)
+	}
+	if req.BandwidthLimit != nil {
+		cp.SetBandwidthLimit(limit)
+	}
+	if req.Paused != nil && !*req.Paused && cp.Paused() {
+		cp.Resume()
+		logInfo("resuming")
+	}
+	writeJSON(w, http.StatusOK, cp.status(start))
+}
+
+// serve /metrics and/or /status on addr in background, server lives until process exits
+func serveHTTP(cp *Copier, addr string, metrics, status bool) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	if metrics {
+		mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+			cp.writeMetrics(w)
+		})
+		logInfo("serving metrics on http://%s/metrics", ln.Addr())
+	}
+	if status {
+		start := time.Now()
+		mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+			cp.writeStatus(w, r, start)
+		})
+		if cp.cfg.Run.StatusControl {
+			mux.HandleFunc("/control", func(w http.ResponseWriter, r *http.Request) {
+				cp.handleControl(w, r, start)
+			})
+			logInfo("accepting changes of running copy on http://%s/control", ln.Addr())
+		}
+		mux.Handle("/", dashboardHandler("status"))
+		logInfo("serving status on http://%s/status, dashboard on http://%s/", ln.Addr(), ln.Addr())
+	}
+	go func() {
+		logError("http server: %s", http.Serve(ln, mux))
+	}()
+	return nil
+}
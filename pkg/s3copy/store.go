@@ -0,0 +1,209 @@
+package s3copy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/minio/minio-go"
+	"io"
+)
+
+// storage backend of source or destination, bound to a bucket (or its equivalent).
+// copy, listing, verification and other commands are programmed against this interface
+type ObjectStore interface {
+	// list page of objects with given prefix. token is the opaque position of the page,
+	// empty for the first page. returned next token is empty on the last page
+	List(ctx context.Context, prefix, token string, pageSize int) (objs []Object, next string, err error)
+	// get info of object, missing object is reported with ErrNotFound or NoSuchKey error response
+	Stat(ctx context.Context, key string) (Object, error)
+	// read length bytes of object content starting at offset, negative length reads till the end
+	Get(ctx context.Context, key string, offset, length int64) (io.ReadCloser, Object, error)
+	// write object of known size, returns number of written bytes
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) (int64, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// returned by stores other than S3 for missing objects
+var ErrNotFound = errors.New("object not found")
+
+// object store supporting multipart uploads, used for resumable copy of large objects
+// and cleanup of stale uploads
+type multipartStore interface {
+	newUpload(key, contentType string) (string, error)
+	// check upload wasn't aborted or expired
+	uploadExists(key, uploadID string) error
+	putPart(ctx context.Context, key, uploadID string, n int, r io.Reader, size int64) (string, error)
+	completeUpload(key, uploadID string, parts []minio.CompletePart) error
+	abortUpload(key, uploadID string) error
+	listUploads(prefix string, doneCh <-chan struct{}) <-chan minio.ObjectMultipartInfo
+}
+
+// object store of S3 compatible endpoint
+type minioStore struct {
+	clnt   *minio.Client
+	bucket string
+	// endpoint of requests the client doesn't support
+	endpoint Endpoint
+}
+
+// store of configured endpoint and bucket
+func newStore(e Endpoint, bucket string) (ObjectStore, error) {
+	switch e.Type {
+	case "", EndpointS3:
+	case EndpointLocal:
+		return newLocalStore(e.Path)
+	case EndpointAzure:
+		return newAzureStore(e, bucket)
+	case EndpointSFTP:
+		return newSFTPStore(e)
+	case EndpointB2:
+		return newB2Store(e, bucket)
+	case EndpointSwift:
+		return newSwiftStore(e, bucket)
+	case EndpointWebDAV:
+		return newWebDAVStore(e)
+	case EndpointURLs:
+		return newURLListStore(e.Path)
+	default:
+		return nil, fmt.Errorf("unknown endpoint type '%s', must be s3, local, azure, sftp, b2, swift, webdav or urls", e.Type)
+	}
+	clnt, err := minio.New(e.Endpoint, e.AccessKey, e.SecretKey, e.SSL)
+	if err != nil {
+		return nil, err
+	}
+	return &minioStore{clnt: clnt, bucket: bucket, endpoint: e}, nil
+}
+
+// store of source endpoint, or of source and its replicas if there are any.
+// custom store set with WithStores takes precedence
+func sourceStore(c *Config) (ObjectStore, error) {
+	var src ObjectStore
+	var err error
+	switch {
+	case c.Run.SourceStore != nil:
+		src = c.Run.SourceStore
+	case len(c.Sources) > 0:
+		src, err = newFailoverStore(c)
+	default:
+		src, err = newStore(c.Source, c.Options.Bucket)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if c.Run.chunkedSource {
+		src = &chunkedStore{ObjectStore: src, bucket: c.Options.Bucket}
+	}
+	// passes of watch and listen must see changes of source
+	return newListCacheStore(src, c, c.Run.RefreshListCache || c.Run.WatchInterval > 0 || c.Run.Listen)
+}
+
+// store of destination endpoint, or of all destinations if there are more of them.
+// custom store set with WithStores takes precedence
+func destinationStore(c *Config) (ObjectStore, error) {
+	var dst ObjectStore
+	var err error
+	switch {
+	case c.Run.DestinationStore != nil:
+		dst = c.Run.DestinationStore
+	case len(c.Destinations) > 0:
+		dst, err = newFanoutStore(c)
+	default:
+		dst, err = newStore(c.Destination, c.Options.Bucket)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newChunkedStore(dst, c)
+}
+
+func infoObject(info minio.ObjectInfo) Object {
+	return Object{Key: info.Key, Size: info.Size, ETag: info.ETag, LastModified: info.LastModified, ContentType: info.ContentType,
+		Checksum: info.Metadata.Get("X-Amz-Meta-" + checksumMetaKey)}
+}
+
+func (s *minioStore) List(ctx context.Context, prefix, token string, pageSize int) ([]Object, string, error) {
+	core := minio.Core{Client: s.clnt}
+	res, err := core.ListObjectsV2(s.bucket, prefix, token, false, "", pageSize, "")
+	if err != nil {
+		return nil, "", err
+	}
+	objs := make([]Object, len(res.Contents))
+	for i, info := range res.Contents {
+		objs[i] = infoObject(info)
+	}
+	if !res.IsTruncated {
+		return objs, "", nil
+	}
+	return objs, res.NextContinuationToken, nil
+}
+
+func (s *minioStore) Stat(ctx context.Context, key string) (Object, error) {
+	info, err := s.clnt.StatObject(s.bucket, key, minio.StatObjectOptions{})
+	if err != nil {
+		return Object{}, err
+	}
+	obj := infoObject(info)
+	obj.Metadata = userMetadata(info.Metadata, "X-Amz-Meta-")
+	return obj, nil
+}
+
+func (s *minioStore) Get(ctx context.Context, key string, offset, length int64) (io.ReadCloser, Object, error) {
+	opts := minio.GetObjectOptions{}
+	if length > 0 {
+		opts.SetRange(offset, offset+length-1)
+	} else if offset > 0 {
+		opts.SetRange(offset, 0)
+	}
+	obj, err := s.clnt.GetObjectWithContext(ctx, s.bucket, key, opts)
+	if err != nil {
+		return nil, Object{}, err
+	}
+	// GetObject doesn't send request until object is read, stat it
+	// so failed GET is reported here and not as error of the following read
+	info, err := obj.Stat()
+	if err != nil {
+		obj.Close()
+		return nil, Object{}, err
+	}
+	return obj, infoObject(info), nil
+}
+
+func (s *minioStore) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) (int64, error) {
+	return s.clnt.PutObjectWithContext(ctx, s.bucket, key, r, size, minio.PutObjectOptions{ContentType: contentType})
+}
+
+func (s *minioStore) Delete(ctx context.Context, key string) error {
+	return s.clnt.RemoveObject(s.bucket, key)
+}
+
+func (s *minioStore) newUpload(key, contentType string) (string, error) {
+	core := minio.Core{Client: s.clnt}
+	return core.NewMultipartUpload(s.bucket, key, minio.PutObjectOptions{ContentType: contentType})
+}
+
+func (s *minioStore) uploadExists(key, uploadID string) error {
+	core := minio.Core{Client: s.clnt}
+	_, err := core.ListObjectParts(s.bucket, key, uploadID, 0, 1)
+	return err
+}
+
+func (s *minioStore) putPart(ctx context.Context, key, uploadID string, n int, r io.Reader, size int64) (string, error) {
+	core := minio.Core{Client: s.clnt}
+	part, err := core.PutObjectPart(s.bucket, key, uploadID, n, r, size, "", "", nil)
+	return part.ETag, err
+}
+
+func (s *minioStore) completeUpload(key, uploadID string, parts []minio.CompletePart) error {
+	core := minio.Core{Client: s.clnt}
+	_, err := core.CompleteMultipartUpload(s.bucket, key, uploadID, parts)
+	return err
+}
+
+func (s *minioStore) abortUpload(key, uploadID string) error {
+	core := minio.Core{Client: s.clnt}
+	return core.AbortMultipartUpload(s.bucket, key, uploadID)
+}
+
+func (s *minioStore) listUploads(prefix string, doneCh <-chan struct{}) <-chan minio.ObjectMultipartInfo {
+	return s.clnt.ListIncompleteUploads(s.bucket, prefix, true, doneCh)
+}
@@ -0,0 +1,375 @@
+package s3copy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// larger objects are uploaded as static large objects, segments are
+	// stored in <container>_segments container like swift client does
+	swiftMaxObject   = 5 << 30
+	swiftSegmentSize = 1 << 30
+)
+
+// object store of OpenStack Swift container, authenticated with keystone v3 password.
+// endpoint is the keystone url, e.g. keystone.example.com:5000/v3, access_key is the
+// user, secret_key the password. object-store url is taken from the service catalog.
+// large objects (DLO and SLO) are read as their concatenated content
+type swiftStore struct {
+	authURL   string
+	user      string
+	password  string
+	domain    string
+	project   string
+	region    string
+	container string
+
+	mu         sync.Mutex
+	token      string
+	storageURL string
+}
+
+func newSwiftStore(e Endpoint, container string) (*swiftStore, error) {
+	if e.Project == "" {
+		return nil, errors.New("swift endpoint requires project")
+	}
+	scheme := "http://"
+	if e.SSL {
+		scheme = "https://"
+	}
+	s := &swiftStore{authURL: scheme + strings.TrimSuffix(e.Endpoint, "/"), user: e.AccessKey,
+		password: e.SecretKey, domain: e.Domain, project: e.Project, region: e.Region, container: container}
+	if s.domain == "" {
+		s.domain = "Default"
+	}
+	if err := s.authorize(""); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// get new keystone token, unless it was already refreshed since stale token was issued
+func (s *swiftStore) authorize(stale string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if stale != "" && s.token != stale {
+		return nil
+	}
+
+	domain := map[string]string{"name": s.domain}
+	body, err := json.Marshal(map[string]interface{}{"auth": map[string]interface{}{
+		"identity": map[string]interface{}{"methods": []string{"password"}, "password": map[string]interface{}{
+			"user": map[string]interface{}{"name": s.user, "domain": domain, "password": s.password}}},
+		"scope": map[string]interface{}{"project": map[string]interface{}{"name": s.project, "domain": domain}},
+	}})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", s.authURL+"/auth/tokens", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := doRequest(req)
+	if err != nil {
+		return fmt.Errorf("keystone authentication: %s", err)
+	}
+	defer resp.Body.Close()
+
+	var res struct {
+		Token struct {
+			Catalog []struct {
+				Type      string
+				Endpoints []struct {
+					Interface string
+					Region    string
+					URL       string
+				}
+			}
+		}
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return err
+	}
+	for _, svc := range res.Token.Catalog {
+		if svc.Type != "object-store" {
+			continue
+		}
+		for _, ep := range svc.Endpoints {
+			if ep.Interface == "public" && (s.region == "" || ep.Region == s.region) {
+				s.token = resp.Header.Get("X-Subject-Token")
+				s.storageURL = strings.TrimSuffix(ep.URL, "/")
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("no public object-store endpoint in keystone catalog, region '%s'", s.region)
+}
+
+func (s *swiftStore) session() (string, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.token, s.storageURL
+}
+
+// url of object in container, or of container if key is empty
+func swiftURL(storageURL, container, key string, query url.Values) string {
+	u := storageURL + "/" + url.PathEscape(container)
+	if key != "" {
+		u += "/" + escapeKey(key)
+	}
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	return u
+}
+
+// send request without body with auth token, expired token is refreshed and request
+// is repeated once. missing object is reported with ErrNotFound
+func (s *swiftStore) send(ctx context.Context, newReq func(storageURL string) (*http.Request, error)) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		token, storageURL := s.session()
+		req, err := newReq(storageURL)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("X-Auth-Token", token)
+		resp, err := storeHTTPClient.Do(req.WithContext(ctx))
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode == 401 && attempt == 0 {
+			resp.Body.Close()
+			if err := s.authorize(token); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if resp.StatusCode == 404 {
+			resp.Body.Close()
+			return nil, ErrNotFound
+		}
+		if err := checkResponse(resp); err != nil {
+			return nil, err
+		}
+		return resp, nil
+	}
+}
+
+type swiftListEntry struct {
+	Name         string `json:"name"`
+	Bytes        int64  `json:"bytes"`
+	Hash         string `json:"hash"`
+	LastModified string `json:"last_modified"`
+	ContentType  string `json:"content_type"`
+}
+
+// listing of static large object shows its size in swift_bytes parameter of content type
+func (e *swiftListEntry) object() Object {
+	size, contentType := e.Bytes, e.ContentType
+	if i := strings.Index(contentType, ";swift_bytes="); i >= 0 {
+		size, _ = strconv.ParseInt(contentType[i+len(";swift_bytes="):], 10, 64)
+		contentType = contentType[:i]
+	}
+	modified, _ := time.Parse("2006-01-02T15:04:05.999999", e.LastModified)
+	return Object{Key: e.Name, Size: size, ETag: e.Hash, LastModified: modified, ContentType: contentType}
+}
+
+// empty objects may be manifests of dynamic large objects, which are listed with
+// size of manifest only, their real size is resolved with HEAD
+func (s *swiftStore) List(ctx context.Context, prefix, token string, pageSize int) ([]Object, string, error) {
+	query := url.Values{"format": {"json"}, "limit": {strconv.Itoa(pageSize)}}
+	if prefix != "" {
+		query.Set("prefix", prefix)
+	}
+	if token != "" {
+		query.Set("marker", token)
+	}
+	resp, err := s.send(ctx, func(storageURL string) (*http.Request, error) {
+		return http.NewRequest("GET", swiftURL(storageURL, s.container, "", query), nil)
+	})
+	if err == ErrNotFound {
+		return nil, "", fmt.Errorf("swift container '%s' not found", s.container)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	var entries []swiftListEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, "", err
+	}
+	objs := make([]Object, 0, len(entries))
+	for _, e := range entries {
+		obj := e.object()
+		if obj.Size == 0 {
+			if obj, err = s.Stat(ctx, e.Name); err == ErrNotFound {
+				continue
+			} else if err != nil {
+				return nil, "", err
+			}
+		}
+		objs = append(objs, obj)
+	}
+	next := ""
+	if len(entries) == pageSize {
+		next = entries[len(entries)-1].Name
+	}
+	return objs, next, nil
+}
+
+// info of object from response headers, ETag of large object is md5 of its segments' ETags
+func swiftObject(key string, h http.Header) Object {
+	modified, _ := http.ParseTime(h.Get("Last-Modified"))
+	return Object{Key: key, Size: responseSize(h), ETag: strings.Trim(h.Get("ETag"), `"`),
+		LastModified: modified, ContentType: h.Get("Content-Type")}
+}
+
+func (s *swiftStore) Stat(ctx context.Context, key string) (Object, error) {
+	resp, err := s.send(ctx, func(storageURL string) (*http.Request, error) {
+		return http.NewRequest("HEAD", swiftURL(storageURL, s.container, key, nil), nil)
+	})
+	if err != nil {
+		return Object{}, err
+	}
+	resp.Body.Close()
+	obj := swiftObject(key, resp.Header)
+	obj.Metadata = userMetadata(resp.Header, "X-Object-Meta-")
+	return obj, nil
+}
+
+func (s *swiftStore) Get(ctx context.Context, key string, offset, length int64) (io.ReadCloser, Object, error) {
+	resp, err := s.send(ctx, func(storageURL string) (*http.Request, error) {
+		req, err := http.NewRequest("GET", swiftURL(storageURL, s.container, key, nil), nil)
+		if err != nil {
+			return nil, err
+		}
+		if length > 0 {
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+		} else if offset > 0 {
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		}
+		return req, nil
+	})
+	if err != nil {
+		return nil, Object{}, err
+	}
+	return resp.Body, swiftObject(key, resp.Header), nil
+}
+
+// upload body with single PUT, token is refreshed before the upload if it expired
+func (s *swiftStore) put(ctx context.Context, container, key string, query url.Values, r io.Reader, size int64, header http.Header) (*http.Response, error) {
+	token, storageURL := s.session()
+	req, err := http.NewRequest("PUT", swiftURL(storageURL, container, key, query), nil)
+	if err != nil {
+		return nil, err
+	}
+	requestBody(req, r, size)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("X-Auth-Token", token)
+	resp, err := doRequest(req.WithContext(ctx))
+	if se, ok := err.(*statusError); ok && se.code == 401 {
+		// body is consumed, object is retried by copier with refreshed token
+		if err := s.authorize(token); err != nil {
+			return nil, err
+		}
+		return nil, &statusError{503, se.status}
+	}
+	return resp, err
+}
+
+func (s *swiftStore) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) (int64, error) {
+	if size > swiftMaxObject {
+		return s.putSegments(ctx, key, r, size, contentType)
+	}
+	header := http.Header{}
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	resp, err := s.put(ctx, s.container, key, nil, r, size, header)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return size, nil
+}
+
+// upload segments of static large object and its manifest
+func (s *swiftStore) putSegments(ctx context.Context, key string, r io.Reader, size int64, contentType string) (int64, error) {
+	segments := s.container + "_segments"
+	resp, err := s.put(ctx, segments, "", nil, nil, 0, nil)
+	if err != nil {
+		return 0, fmt.Errorf("creating segments container: %s", err)
+	}
+	resp.Body.Close()
+
+	type segment struct {
+		Path      string `json:"path"`
+		ETag      string `json:"etag"`
+		SizeBytes int64  `json:"size_bytes"`
+	}
+	var manifest []segment
+	prefix := fmt.Sprintf("%s/%d/%d/", key, time.Now().Unix(), size)
+	var written int64
+	for i := 1; written < size; i++ {
+		length := int64(swiftSegmentSize)
+		if rest := size - written; rest < length {
+			length = rest
+		}
+		name := fmt.Sprintf("%s%08d", prefix, i)
+		resp, err := s.put(ctx, segments, name, nil, r, length, nil)
+		if err != nil {
+			return written, fmt.Errorf("uploading segment %d: %s", i, err)
+		}
+		resp.Body.Close()
+		manifest = append(manifest, segment{Path: "/" + segments + "/" + name,
+			ETag: strings.Trim(resp.Header.Get("ETag"), `"`), SizeBytes: length})
+		written += length
+	}
+
+	b, err := json.Marshal(manifest)
+	if err != nil {
+		return written, err
+	}
+	header := http.Header{}
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	resp, err = s.put(ctx, s.container, key, url.Values{"multipart-manifest": {"put"}}, bytes.NewReader(b), int64(len(b)), header)
+	if err != nil {
+		return written, fmt.Errorf("uploading manifest: %s", err)
+	}
+	resp.Body.Close()
+	return written, nil
+}
+
+// segments of static large object are removed with its manifest,
+// removing missing object isn't an error, same as in S3
+func (s *swiftStore) Delete(ctx context.Context, key string) error {
+	resp, err := s.send(ctx, func(storageURL string) (*http.Request, error) {
+		return http.NewRequest("DELETE", swiftURL(storageURL, s.container, key,
+			url.Values{"multipart-manifest": {"delete"}}), nil)
+	})
+	if err == ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
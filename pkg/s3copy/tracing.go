@@ -0,0 +1,243 @@
+package s3copy
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// spans are exported in batches with this interval or once batch is full
+	traceExportInterval = time.Second * 5
+	traceBatchSize      = 512
+	// spans over this limit are dropped if collector doesn't keep up
+	traceMaxQueue = 8192
+
+	// OTLP span kinds and status codes
+	spanKindInternal = 1
+	spanKindClient   = 3
+	spanStatusError  = 2
+)
+
+// global tracer, nil if tracing is disabled. spans are exported
+// to OTLP/HTTP collector as json, so no extra dependencies are required
+var tracer *otlpTracer
+
+type otlpAttr struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// span in OTLP/JSON encoding
+type otlpSpan struct {
+	TraceID      string     `json:"traceId"`
+	SpanID       string     `json:"spanId"`
+	ParentSpanID string     `json:"parentSpanId,omitempty"`
+	Name         string     `json:"name"`
+	Kind         int        `json:"kind"`
+	Start        string     `json:"startTimeUnixNano"`
+	End          string     `json:"endTimeUnixNano"`
+	Attributes   []otlpAttr `json:"attributes,omitempty"`
+	Status       otlpStatus `json:"status"`
+
+	start time.Time
+}
+
+// span being recorded, all methods are no-op on nil span
+type span struct {
+	s *otlpSpan
+}
+
+type otlpTracer struct {
+	sync.Mutex
+	url         string
+	source      string
+	destination string
+	queue       []*otlpSpan
+	dropped     int64
+	flushCh     chan struct{}
+	stopCh      chan struct{}
+	doneCh      chan struct{}
+}
+
+// start exporting spans to collector at endpoint, e.g. http://localhost:4318.
+// source and destination endpoints are attached to spans of requests to them
+func startTracer(endpoint, source, destination string) {
+	tracer = &otlpTracer{
+		url:         strings.TrimSuffix(endpoint, "/") + "/v1/traces",
+		source:      source,
+		destination: destination,
+		flushCh:     make(chan struct{}, 1),
+		stopCh:      make(chan struct{}),
+		doneCh:      make(chan struct{}),
+	}
+	go tracer.run()
+}
+
+// export remaining spans and stop tracer
+func stopTracer() {
+	if tracer == nil {
+		return
+	}
+	close(tracer.stopCh)
+	<-tracer.doneCh
+	tracer = nil
+}
+
+func randomID(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// start span, root span of a new trace if parent is nil
+func startSpan(name string, parent *span, kind int, attrs ...otlpAttr) *span {
+	if tracer == nil {
+		return nil
+	}
+	now := time.Now()
+	s := &otlpSpan{SpanID: randomID(8), Name: name, Kind: kind, Attributes: attrs, start: now}
+	if parent != nil {
+		s.TraceID, s.ParentSpanID = parent.s.TraceID, parent.s.SpanID
+	} else {
+		s.TraceID = randomID(16)
+	}
+	return &span{s}
+}
+
+// span of a request to source or destination endpoint
+func startRequestSpan(name string, parent *span, dst bool, bucket, key string) *span {
+	if tracer == nil {
+		return nil
+	}
+	endpoint := tracer.source
+	if dst {
+		endpoint = tracer.destination
+	}
+	return startSpan(name, parent, spanKindClient,
+		strAttr("server.address", endpoint), strAttr("aws.s3.bucket", bucket), strAttr("aws.s3.key", key))
+}
+
+func strAttr(key, v string) otlpAttr {
+	return otlpAttr{key, map[string]interface{}{"stringValue": v}}
+}
+
+func intAttr(key string, v int64) otlpAttr {
+	return otlpAttr{key, map[string]interface{}{"intValue": strconv.FormatInt(v, 10)}}
+}
+
+func (sp *span) setAttr(a otlpAttr) {
+	if sp != nil {
+		sp.s.Attributes = append(sp.s.Attributes, a)
+	}
+}
+
+// finish span and queue it for export, err marks span as failed
+func (sp *span) end(err error) {
+	if sp == nil {
+		return
+	}
+	s := sp.s
+	s.Start = strconv.FormatInt(s.start.UnixNano(), 10)
+	s.End = strconv.FormatInt(time.Now().UnixNano(), 10)
+	if err != nil {
+		s.Status = otlpStatus{Code: spanStatusError, Message: err.Error()}
+		s.Attributes = append(s.Attributes, strAttr("error.type", classifyError(err).String()))
+	}
+	tracer.add(s)
+}
+
+func (t *otlpTracer) add(s *otlpSpan) {
+	t.Lock()
+	defer t.Unlock()
+	if len(t.queue) >= traceMaxQueue {
+		t.dropped++
+		return
+	}
+	t.queue = append(t.queue, s)
+	if len(t.queue) >= traceBatchSize {
+		select {
+		case t.flushCh <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (t *otlpTracer) run() {
+	defer close(t.doneCh)
+	ticker := time.NewTicker(traceExportInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-t.flushCh:
+		case <-t.stopCh:
+			for t.flush() {
+			}
+			return
+		}
+		t.flush()
+	}
+}
+
+// export single batch of queued spans, returns true if more spans are queued
+func (t *otlpTracer) flush() bool {
+	t.Lock()
+	n := minInt(len(t.queue), traceBatchSize)
+	batch := t.queue[:n]
+	t.queue = t.queue[n:]
+	dropped := t.dropped
+	t.dropped = 0
+	more := len(t.queue) > 0
+	t.Unlock()
+
+	if dropped > 0 {
+		logWarn("tracing: dropped %d spans, collector doesn't keep up", dropped)
+	}
+	if len(batch) == 0 {
+		return false
+	}
+	if err := t.export(batch); err != nil {
+		logWarn("tracing: exporting %d spans: %s", len(batch), err)
+		return false
+	}
+	return more
+}
+
+func (t *otlpTracer) export(spans []*otlpSpan) error {
+	req := map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{"attributes": []otlpAttr{strAttr("service.name", "s3-copy-dir")}},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]string{"name": "s3-copy-dir"},
+				"spans": spans,
+			}},
+		}},
+	}
+	b, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	client := http.Client{Timeout: time.Second * 10}
+	resp, err := client.Post(t.url, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("collector responded with %s", resp.Status)
+	}
+	return nil
+}
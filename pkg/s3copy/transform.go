@@ -0,0 +1,98 @@
+package s3copy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"plugin"
+)
+
+// transforms content of object before upload: reads source content from r and writes
+// content to upload to w. returned object carries metadata of transformed content,
+// only its ContentType is used, key and size are kept/computed by the copier
+type Transform func(ctx context.Context, obj Object, r io.Reader, w io.Writer) (Object, error)
+
+// symbol looked up in transform plugins, see LoadTransformPlugin
+const transformSymbol = "Transform"
+
+// load Go plugin (built with -buildmode=plugin against the same version of this package)
+// exporting Transform function with signature of Transform type
+func LoadTransformPlugin(file string) (Transform, error) {
+	p, err := plugin.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("loading transform plugin '%s': %s", file, err)
+	}
+	sym, err := p.Lookup(transformSymbol)
+	if err != nil {
+		return nil, fmt.Errorf("transform plugin '%s': %s", file, err)
+	}
+	switch t := sym.(type) {
+	case func(context.Context, Object, io.Reader, io.Writer) (Object, error):
+		return t, nil
+	case *Transform:
+		return *t, nil
+	}
+	return nil, fmt.Errorf("transform plugin '%s': %s has type %T, expected %T", file, transformSymbol, sym, Transform(nil))
+}
+
+// transforms of the copy in order: transform_hook, transform_plugins, then transforms of embedder
+func loadTransforms(c *Config) ([]Transform, error) {
+	var transforms []Transform
+	if c.Options.TransformHook != "" {
+		transforms = append(transforms, hookTransform(c.Options.TransformHook, c.Options.Bucket))
+	}
+	for _, file := range c.Options.TransformPlugins {
+		t, err := LoadTransformPlugin(file)
+		if err != nil {
+			return nil, err
+		}
+		transforms = append(transforms, t)
+	}
+	return append(transforms, c.Run.Transforms...), nil
+}
+
+// pass object content through transforms, output of each one goes to temporary file, so
+// size of transformed content is known before upload. returned file is removed on close
+func (cp *Copier) transform(obj Object, r io.Reader) (*tempFile, Object, error) {
+	var out *tempFile
+	for _, t := range cp.transforms {
+		f, err := ioutil.TempFile("", "s3-copy-dir-transform-")
+		if err != nil {
+			return nil, Object{}, err
+		}
+		tf := &tempFile{f}
+		res, err := t(cp.ctx, obj, r, f)
+		if err != nil && cp.ctx.Err() != nil {
+			err = cp.ctx.Err()
+		}
+		if out != nil {
+			out.Close()
+		}
+		out = tf
+		if err == nil {
+			obj.ContentType = res.ContentType
+			obj.Size, err = f.Seek(0, io.SeekCurrent)
+		}
+		if err == nil {
+			_, err = f.Seek(0, io.SeekStart)
+		}
+		if err != nil {
+			out.Close()
+			return nil, Object{}, err
+		}
+		r = f
+	}
+	return out, obj, nil
+}
+
+type tempFile struct {
+	*os.File
+}
+
+func (tf *tempFile) Close() error {
+	err := tf.File.Close()
+	os.Remove(tf.Name())
+	return err
+}
@@ -0,0 +1,107 @@
+package s3copy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// time layout of trash and quarantine prefixes of the run, e.g. "20240601T120000Z"
+const asideStampFormat = "20060102T150405Z"
+
+// prefix of destination objects are set aside under, <prefix>/<time>/<key>: orphans and objects
+// removed from source are moved to trash instead of deleting them, see Options.Trash, and objects
+// failing verification are copied to quarantine before they're overwritten, see Options.Quarantine
+type aside struct {
+	store  ObjectStore
+	bucket string
+	prefix string
+	stamp  string
+}
+
+func newAside(store ObjectStore, bucket, prefix string, now time.Time) *aside {
+	return &aside{store: store, bucket: bucket, prefix: strings.Trim(prefix, "/"), stamp: now.UTC().Format(asideStampFormat)}
+}
+
+// trash of destination, nil if it isn't configured
+func newTrash(c *Config, store ObjectStore, now time.Time) *aside {
+	if c.Options.Trash == "" {
+		return nil
+	}
+	return newAside(store, c.Options.Bucket, c.Options.Trash, now)
+}
+
+func (a *aside) key(key string) string {
+	return a.prefix + "/" + a.stamp + "/" + key
+}
+
+// objects already set aside aren't removed again
+func (a *aside) contains(key string) bool {
+	return strings.HasPrefix(key, a.prefix+"/")
+}
+
+// copy object under the prefix, server-side if store supports it
+func (a *aside) copy(ctx context.Context, key string) error {
+	akey := a.key(key)
+	var err error
+	if sc, ok := a.store.(serverCopyStore); ok {
+		err = sc.copyObject(ctx, key, akey)
+	} else {
+		var r io.ReadCloser
+		var obj Object
+		if r, obj, err = a.store.Get(ctx, key, 0, -1); err == nil {
+			_, err = a.store.Put(ctx, akey, r, obj.Size, obj.ContentType)
+			r.Close()
+		}
+	}
+	audit(auditEntry{Op: auditPut, Bucket: a.bucket, Key: akey}, err)
+	return err
+}
+
+// copy object under the prefix and delete it, object which couldn't be copied isn't deleted
+func (a *aside) move(ctx context.Context, key string) error {
+	if err := a.copy(ctx, key); err != nil {
+		return fmt.Errorf("moving to trash: %s", err)
+	}
+	return a.store.Delete(ctx, key)
+}
+
+// delete object of store or move it to trash if it isn't nil
+func deleteObject(ctx context.Context, store ObjectStore, t *aside, bucket, key string) error {
+	var err error
+	if t != nil {
+		err = t.move(ctx, key)
+	} else {
+		err = store.Delete(ctx, key)
+	}
+	audit(auditEntry{Op: auditDelete, Bucket: bucket, Key: key}, err)
+	return err
+}
+
+// remove objects moved to trash more than olderThan ago, with dryRun objects are only logged
+func PurgeTrash(c *Config, olderThan time.Duration, dryRun bool) (removed, failed int64, err error) {
+	if c.Options.Trash == "" {
+		return 0, 0, errors.New("trash isn't configured")
+	}
+	dst, err := destinationStore(c)
+	if err != nil {
+		return 0, 0, err
+	}
+	tc := *c
+	tc.Options.Directory, tc.Options.RawPrefix = strings.Trim(c.Options.Trash, "/"), false
+	prefix := tc.Options.Directory + "/"
+	cutoff := time.Now().Add(-olderThan)
+	expired := func(key string) (bool, error) {
+		stamp := strings.TrimPrefix(key, prefix)
+		if i := strings.Index(stamp, "/"); i >= 0 {
+			stamp = stamp[:i]
+		}
+		t, err := time.Parse(asideStampFormat, stamp)
+		// objects which weren't moved by trash are kept
+		return err == nil && t.Before(cutoff), nil
+	}
+	return removeObjects(&tc, dst, TargetDestination, dryRun, removal{filter: expired, what: "objects trashed before " + cutoff.UTC().Format(time.RFC3339)})
+}
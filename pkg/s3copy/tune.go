@@ -0,0 +1,242 @@
+package s3copy
+
+import (
+	"sync"
+	"time"
+)
+
+// limits number of concurrently running workers, unlike buffered channel
+// the limit can be changed while workers are running
+type workerLimiter struct {
+	sync.Mutex
+	cond   *sync.Cond
+	limit  int
+	active int
+	paused bool
+	// limiter shared with other copies, its slot is taken as well
+	parent *workerLimiter
+}
+
+func newWorkerLimiter(limit int) *workerLimiter {
+	if limit < 1 {
+		limit = 1
+	}
+	wl := &workerLimiter{limit: limit}
+	wl.cond = sync.NewCond(wl)
+	return wl
+}
+
+// limiter of workers of the copy up to configured concurrency, taking also slots of shared workers
+func (c *Config) workerLimiter() *workerLimiter {
+	wl := newWorkerLimiter(c.Options.Concurrency)
+	wl.parent = c.Run.sharedWorkers
+	return wl
+}
+
+// block until there is a free worker slot and limiter isn't paused
+func (wl *workerLimiter) acquire() {
+	wl.Lock()
+	for wl.paused || wl.active >= wl.limit {
+		wl.cond.Wait()
+	}
+	wl.active++
+	wl.Unlock()
+	if wl.parent != nil {
+		wl.parent.acquire()
+	}
+}
+
+func (wl *workerLimiter) release() {
+	if wl.parent != nil {
+		wl.parent.release()
+	}
+	wl.Lock()
+	wl.active--
+	wl.Unlock()
+	wl.cond.Broadcast()
+}
+
+func (wl *workerLimiter) setLimit(limit int) {
+	if limit < 1 {
+		limit = 1
+	}
+	wl.Lock()
+	wl.limit = limit
+	wl.Unlock()
+	wl.cond.Broadcast()
+}
+
+// block new workers, running workers are allowed to finish
+func (wl *workerLimiter) pause() {
+	wl.Lock()
+	wl.paused = true
+	wl.Unlock()
+}
+
+func (wl *workerLimiter) resume() {
+	wl.Lock()
+	wl.paused = false
+	wl.Unlock()
+	wl.cond.Broadcast()
+}
+
+func (wl *workerLimiter) isPaused() bool {
+	wl.Lock()
+	defer wl.Unlock()
+	return wl.paused
+}
+
+func (wl *workerLimiter) getLimit() int {
+	wl.Lock()
+	defer wl.Unlock()
+	return wl.limit
+}
+
+func (wl *workerLimiter) running() int {
+	wl.Lock()
+	defer wl.Unlock()
+	return wl.active
+}
+
+const (
+	// how often auto tuner re-evaluates concurrency
+	tuneInterval = time.Second * 15
+	// error rate above which concurrency is reduced
+	tuneMaxErrorRate = 0.05
+	// latency growth (relative to the best observed) above which concurrency is reduced
+	tuneMaxLatencyGrowth = 3.0
+	// throughput must grow at least by this factor to keep ramping up
+	tuneMinGain = 1.05
+)
+
+// adjusts worker limit based on throughput, error rate and latency of copy operations.
+// it starts from a single worker and doubles the limit while throughput grows,
+// then settles on the best observed worker count. on errors or latency spikes
+// the limit is halved and then slowly grows back to the best known value
+type autoTuner struct {
+	sync.Mutex
+	wl  *workerLimiter
+	max int
+
+	// stats for the current interval
+	objects int64
+	bytes   int64
+	errors  int64
+	latency time.Duration
+
+	bestThroughput float64
+	bestLatency    time.Duration
+	bestLimit      int
+	converged      bool
+}
+
+func newAutoTuner(wl *workerLimiter, max int) *autoTuner {
+	if max < 1 {
+		max = 1
+	}
+	wl.setLimit(1)
+	return &autoTuner{wl: wl, max: max, bestLimit: 1}
+}
+
+// concurrency set while copy is running is the new maximum and the best known limit,
+// tuner still backs off from it on errors
+func (at *autoTuner) setMax(max int) {
+	at.Lock()
+	defer at.Unlock()
+	at.max, at.bestLimit, at.converged = max, max, true
+	at.wl.setLimit(max)
+}
+
+// record result of a single copy operation
+func (at *autoTuner) record(size int64, d time.Duration, err error) {
+	at.Lock()
+	defer at.Unlock()
+	at.objects++
+	at.latency += d
+	if err != nil {
+		at.errors++
+	} else {
+		at.bytes += size
+	}
+}
+
+// periodically re-evaluate concurrency until stopCh is closed
+func (at *autoTuner) run(stopCh <-chan struct{}) {
+	ticker := time.NewTicker(tuneInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+			at.adjust(tuneInterval)
+		}
+	}
+}
+
+func (at *autoTuner) adjust(interval time.Duration) {
+	at.Lock()
+	defer at.Unlock()
+
+	objects, bytes, errors, latency := at.objects, at.bytes, at.errors, at.latency
+	at.objects, at.bytes, at.errors, at.latency = 0, 0, 0, 0
+	if objects == 0 {
+		return
+	}
+
+	limit := at.wl.getLimit()
+	errRate := float64(errors) / float64(objects)
+	avgLatency := latency / time.Duration(objects)
+	// prefer bytes/sec, objects/sec is used when only empty objects were copied
+	throughput := float64(bytes) / interval.Seconds()
+	if bytes == 0 {
+		throughput = float64(objects) / interval.Seconds()
+	}
+
+	unhealthy := errRate > tuneMaxErrorRate ||
+		(at.bestLatency > 0 && float64(avgLatency) > float64(at.bestLatency)*tuneMaxLatencyGrowth)
+
+	newLimit := limit
+	switch {
+	case unhealthy:
+		newLimit = maxInt(limit/2, 1)
+		at.converged = true
+		logInfo("auto concurrency: error rate %.1f%%, avg latency %s, reducing workers %d -> %d",
+			errRate*100, avgLatency, limit, newLimit)
+	case !at.converged && throughput > at.bestThroughput*tuneMinGain:
+		at.bestThroughput = throughput
+		at.bestLatency = avgLatency
+		at.bestLimit = limit
+		newLimit = minInt(limit*2, at.max)
+		if newLimit == limit {
+			at.converged = true
+			logInfo("auto concurrency: reached max of %d workers", limit)
+		} else {
+			logInfo("auto concurrency: throughput improved, increasing workers %d -> %d", limit, newLimit)
+		}
+	case !at.converged:
+		// ramping up doesn't help anymore, fall back to the best known limit
+		newLimit = at.bestLimit
+		at.converged = true
+		logInfo("auto concurrency: converged on %d workers", newLimit)
+	case limit < at.bestLimit:
+		// slowly recover towards the best known limit after backing off
+		newLimit = limit + 1
+	}
+
+	at.wl.setLimit(newLimit)
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,211 @@
+package s3copy
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// HEAD requests sent concurrently while listing page of urls
+const urlListHeadConcurrency = 8
+
+var errReadOnlyStore = errors.New("url list store is read-only")
+
+// read-only object store of HTTP(S) urls listed in manifest, one url per line with optional
+// key after whitespace, blank lines and lines starting with # are ignored. key defaults to
+// host and path of the url, e.g. downloads.example.com/pub/file.iso
+type urlListStore struct {
+	keys []string
+	urls map[string]string
+}
+
+func newURLListStore(manifest string) (*urlListStore, error) {
+	if manifest == "" {
+		return nil, errors.New("path of url list manifest is empty")
+	}
+	var r io.ReadCloser
+	if strings.HasPrefix(manifest, "http://") || strings.HasPrefix(manifest, "https://") {
+		resp, err := storeHTTPClient.Get(manifest)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkResponse(resp); err != nil {
+			return nil, fmt.Errorf("downloading url list '%s': %s", manifest, err)
+		}
+		r = resp.Body
+	} else {
+		f, err := os.Open(manifest)
+		if err != nil {
+			return nil, err
+		}
+		r = f
+	}
+	defer r.Close()
+
+	s := &urlListStore{urls: map[string]string{}}
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) > 2 {
+			return nil, fmt.Errorf("url list '%s', line %d: expected url and optional key", manifest, n)
+		}
+		u, err := url.Parse(fields[0])
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("url list '%s', line %d: invalid url '%s'", manifest, n, fields[0])
+		}
+		key := u.Host + path.Clean("/"+u.Path)
+		if len(fields) == 2 {
+			key = strings.TrimPrefix(fields[1], "/")
+		}
+		if key == "" || strings.HasSuffix(key, "/") {
+			return nil, fmt.Errorf("url list '%s', line %d: invalid key '%s'", manifest, n, key)
+		}
+		if _, ok := s.urls[key]; ok {
+			return nil, fmt.Errorf("url list '%s', line %d: duplicate key '%s'", manifest, n, key)
+		}
+		s.urls[key] = u.String()
+		s.keys = append(s.keys, key)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	sort.Strings(s.keys)
+	return s, nil
+}
+
+// urls are HEAD requested to get size, ETag and modification time. url which
+// can't be requested is listed with key only, so its copy fails with the error
+func (s *urlListStore) List(ctx context.Context, prefix, token string, pageSize int) ([]Object, string, error) {
+	i := sort.SearchStrings(s.keys, prefix)
+	if token != "" {
+		i = sort.Search(len(s.keys), func(i int) bool { return s.keys[i] > token })
+	}
+	var page []string
+	for ; i < len(s.keys) && strings.HasPrefix(s.keys[i], prefix) && len(page) < pageSize; i++ {
+		page = append(page, s.keys[i])
+	}
+
+	objs := make([]Object, len(page))
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, urlListHeadConcurrency)
+	for n, key := range page {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(n int, key string) {
+			defer func() { <-sem; wg.Done() }()
+			obj, err := s.Stat(ctx, key)
+			if err != nil {
+				logDebug("HEAD '%s': %s", s.urls[key], err)
+				obj = Object{Key: key}
+			}
+			objs[n] = obj
+		}(n, key)
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, "", err
+	}
+
+	next := ""
+	if len(page) == pageSize && i < len(s.keys) && strings.HasPrefix(s.keys[i], prefix) {
+		next = page[len(page)-1]
+	}
+	return objs, next, nil
+}
+
+// info of url from response headers. servers without ETag get one derived from
+// size and modification time, same as files
+func urlObject(key string, resp *http.Response) (Object, error) {
+	size := responseSize(resp.Header)
+	if resp.ContentLength < 0 && resp.Header.Get("Content-Range") == "" {
+		return Object{}, fmt.Errorf("server didn't send size of '%s'", resp.Request.URL)
+	}
+	modified, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	etag := strings.Trim(strings.TrimPrefix(resp.Header.Get("ETag"), "W/"), `"`)
+	if etag == "" {
+		etag = fmt.Sprintf("%x-%x", modified.UnixNano(), size)
+	}
+	return Object{Key: key, Size: size, ETag: etag, LastModified: modified.UTC(),
+		ContentType: resp.Header.Get("Content-Type")}, nil
+}
+
+// request url of key, missing key or 404 response is reported with ErrNotFound
+func (s *urlListStore) do(ctx context.Context, method, key string, header http.Header) (*http.Response, error) {
+	u, ok := s.urls[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	req, err := http.NewRequest(method, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	// transparently decompressed response has no size
+	req.Header.Set("Accept-Encoding", "identity")
+	resp, err := doRequest(req.WithContext(ctx))
+	if se, ok := err.(*statusError); ok && (se.code == 404 || se.code == 410) {
+		return nil, ErrNotFound
+	}
+	return resp, err
+}
+
+func (s *urlListStore) Stat(ctx context.Context, key string) (Object, error) {
+	resp, err := s.do(ctx, "HEAD", key, nil)
+	if err != nil {
+		return Object{}, err
+	}
+	resp.Body.Close()
+	return urlObject(key, resp)
+}
+
+func (s *urlListStore) Get(ctx context.Context, key string, offset, length int64) (io.ReadCloser, Object, error) {
+	header := http.Header{}
+	if length > 0 {
+		header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	} else if offset > 0 {
+		header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := s.do(ctx, "GET", key, header)
+	if err != nil {
+		return nil, Object{}, err
+	}
+	obj, err := urlObject(key, resp)
+	// servers ignoring Range send whole content
+	if err == nil && offset > 0 && resp.StatusCode != http.StatusPartialContent {
+		err = fmt.Errorf("server doesn't support ranged GET of '%s'", resp.Request.URL)
+	}
+	if err != nil {
+		resp.Body.Close()
+		return nil, Object{}, err
+	}
+	if length >= 0 {
+		return struct {
+			io.Reader
+			io.Closer
+		}{io.LimitReader(resp.Body, length), resp.Body}, obj, nil
+	}
+	return resp.Body, obj, nil
+}
+
+func (s *urlListStore) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) (int64, error) {
+	return 0, errReadOnlyStore
+}
+
+func (s *urlListStore) Delete(ctx context.Context, key string) error {
+	return errReadOnlyStore
+}
@@ -0,0 +1,276 @@
+package s3copy
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// returned by Validate and LoadConfig when config has invalid or missing fields
+type ConfigError struct {
+	// config file, empty for configs which weren't loaded from file
+	File string
+	// problems prefixed by field path, e.g. "options.concurrency: must be at least 1"
+	Problems []string
+}
+
+func (e *ConfigError) Error() string {
+	msg := "invalid config"
+	if e.File != "" {
+		msg += " '" + e.File + "'"
+	}
+	return msg + ": " + strings.Join(e.Problems, "; ")
+}
+
+type configProblems []string
+
+func (p *configProblems) add(field, format string, args ...interface{}) {
+	*p = append(*p, field+": "+fmt.Sprintf(format, args...))
+}
+
+// check fields of config, which json leaves zero if they're missing, before anything is
+// copied. all problems are reported at once with ConfigError. run options aren't checked,
+// they're validated by NewCopier
+func (c *Config) Validate() error {
+	var p configProblems
+	c.Source.validate(&p, "source", false)
+	for i, e := range c.Sources {
+		e.validate(&p, fmt.Sprintf("sources[%d]", i), false)
+	}
+	c.Destination.validate(&p, "destination", true)
+	for i, e := range c.Destinations {
+		e.validate(&p, fmt.Sprintf("destinations[%d]", i), true)
+	}
+	buckets := false
+	for _, e := range append(c.sources(), c.destinations()...) {
+		buckets = buckets || e.hasBucket()
+	}
+	c.Options.validate(&p, buckets)
+	if len(p) > 0 {
+		return &ConfigError{Problems: p}
+	}
+	return nil
+}
+
+// endpoint of s3, azure, b2 or swift stores objects in bucket of options
+func (e Endpoint) hasBucket() bool {
+	switch e.Type {
+	case "", EndpointS3, EndpointAzure, EndpointB2, EndpointSwift:
+		return true
+	}
+	return false
+}
+
+func (e Endpoint) validate(p *configProblems, field string, dest bool) {
+	required := func(name, value string) {
+		if value == "" {
+			p.add(field+"."+name, "must be set for %s endpoint", e.typeName())
+		}
+	}
+	switch e.Type {
+	case "", EndpointS3, EndpointSwift:
+		required("endpoint", e.Endpoint)
+		required("access_key", e.AccessKey)
+		required("secret_key", e.SecretKey)
+	case EndpointLocal:
+		required("path", e.Path)
+	case EndpointURLs:
+		required("path", e.Path)
+		if dest {
+			p.add(field+".type", "urls endpoint is read-only, it can't be destination")
+		}
+	case EndpointAzure:
+		required("access_key", e.AccessKey)
+		required("secret_key", e.SecretKey)
+		if _, err := base64.StdEncoding.DecodeString(e.SecretKey); e.SecretKey != "" && err != nil {
+			p.add(field+".secret_key", "azure account key must be base64: %s", err)
+		}
+	case EndpointB2:
+		required("access_key", e.AccessKey)
+		required("secret_key", e.SecretKey)
+	case EndpointSFTP:
+		required("endpoint", e.Endpoint)
+		required("access_key", e.AccessKey)
+		if e.SecretKey == "" && e.KeyFile == "" {
+			p.add(field+".secret_key", "secret_key or key_file must be set for sftp endpoint")
+		}
+	case EndpointWebDAV:
+		required("endpoint", e.Endpoint)
+	default:
+		p.add(field+".type", "unknown endpoint type '%s', must be s3, local, azure, sftp, b2, swift, webdav or urls", e.Type)
+	}
+}
+
+func (e Endpoint) typeName() string {
+	if e.Type == "" {
+		return EndpointS3
+	}
+	return e.Type
+}
+
+func (o *Options) validate(p *configProblems, buckets bool) {
+	if buckets && o.Bucket == "" {
+		p.add("options.bucket", "must be set")
+	}
+	if o.EntireBucket && o.Directory != "" {
+		p.add("options.entire_bucket", "can't be set with directory '%s'", o.Directory)
+	}
+	if o.Concurrency < 1 {
+		p.add("options.concurrency", "must be at least 1")
+	}
+	if pc := o.PrefixConcurrency; pc != nil {
+		if pc.Depth < 0 {
+			p.add("options.prefix_concurrency.depth", "must not be negative")
+		}
+		if pc.Limit < 1 {
+			p.add("options.prefix_concurrency.limit", "must be at least 1")
+		}
+	}
+	if _, err := parseBandwidthSchedule(o.BandwidthSchedule); err != nil {
+		p.add("options.bandwidth_schedule", "%s", err)
+	}
+	if o.ListPageSize < 0 {
+		p.add("options.list_page_size", "must not be negative")
+	}
+	if o.LockLease != "" {
+		if d, err := time.ParseDuration(o.LockLease); err != nil || d <= 0 {
+			p.add("options.lock_lease", "invalid duration '%s', e.g. 5m", o.LockLease)
+		}
+	}
+	if o.CleanupUploads != "" {
+		if d, err := time.ParseDuration(o.CleanupUploads); err != nil || d <= 0 {
+			p.add("options.cleanup_uploads", "invalid duration '%s', e.g. 24h", o.CleanupUploads)
+		}
+	}
+	sizes := []struct{ name, value string }{
+		{"multipart_threshold", o.MultipartThreshold},
+		{"part_size", o.PartSize},
+		{"capacity_limit", o.CapacityLimit},
+	}
+	for _, s := range sizes {
+		if s.value == "" {
+			continue
+		}
+		if _, err := ParseByteSize(s.value); err != nil {
+			p.add("options."+s.name, "invalid size '%s', e.g. 64MiB", s.value)
+		}
+	}
+	if o.MultipartThreshold != "" && o.StateFile == "" {
+		p.add("options.multipart_threshold", "requires state_file, parts of uploads are tracked in it")
+	}
+	switch o.SourceBalance {
+	case "", SourceFailover, SourceRoundRobin:
+	default:
+		p.add("options.source_balance", "invalid value '%s', must be %s or %s", o.SourceBalance, SourceFailover, SourceRoundRobin)
+	}
+	if _, err := newKeyNormalizer(o.KeyNormalization); err != nil {
+		p.add("options.key_normalization", "%s", err)
+	}
+	if ko := o.KeyObfuscation; ko != nil {
+		if ko.Mode != KeyObfuscationHash && ko.Mode != KeyObfuscationEncrypt {
+			p.add("options.key_obfuscation.mode", "unknown mode '%s', must be %s or %s", ko.Mode, KeyObfuscationHash, KeyObfuscationEncrypt)
+		}
+		if ko.SecretFile == "" {
+			p.add("options.key_obfuscation.secret_file", "must be set")
+		}
+		if o.Backup != nil {
+			p.add("options.key_obfuscation", "can't be used with backup")
+		}
+	}
+	if co := o.ChunkObjects; co != nil {
+		if size, err := ParseByteSize(co.Size); err != nil || size < maxChunkManifest {
+			p.add("options.chunk_objects.size", "invalid size '%s', e.g. 4GiB", co.Size)
+		}
+	}
+	names := make([]string, 0, len(o.MetadataFilter))
+	for name := range o.MetadataFilter {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if name == "" {
+			p.add("options.metadata_filter", "names of metadata must not be empty")
+		} else if err := ValidatePatterns(o.MetadataFilter[name]); err != nil {
+			p.add("options.metadata_filter."+name, "%s", err)
+		}
+	}
+	if o.SkipPolicy != "" && !validSkipPolicy(o.SkipPolicy) {
+		p.add("options.skip_policy", "unknown policy '%s', must be one of %s", o.SkipPolicy, skipPoliciesString())
+	}
+	switch o.ChecksumMetadata {
+	case "", checksumSHA256:
+	default:
+		p.add("options.checksum_metadata", "unknown checksum '%s', must be %s", o.ChecksumMetadata, checksumSHA256)
+	}
+	switch o.ManifestFormat {
+	case "", manifestCSV, manifestNDJSON:
+	default:
+		p.add("options.manifest_format", "unknown format '%s', must be %s or %s", o.ManifestFormat, manifestCSV, manifestNDJSON)
+	}
+	if o.ObjectLock && !o.CreateBucket {
+		p.add("options.object_lock", "requires create_bucket, object lock can be enabled only when bucket is created")
+	}
+	if b := o.Backup; b != nil {
+		if strings.Trim(b.Prefix, "/") == "" {
+			p.add("options.backup.prefix", "must be set, generations are written under it")
+		}
+		if b.Keep < 0 {
+			p.add("options.backup.keep", "must not be negative")
+		}
+	}
+	if lc := o.ListCache; lc != nil {
+		if lc.File == "" {
+			p.add("options.list_cache.file", "must be set")
+		}
+		if d, err := time.ParseDuration(lc.TTL); err != nil || d <= 0 {
+			p.add("options.list_cache.ttl", "invalid duration '%s', e.g. 1h", lc.TTL)
+		}
+	}
+	if e := o.ExpireSource; e != nil {
+		if _, _, ok := e.tag(); !ok {
+			p.add("options.expire_source.tag", "invalid tag '%s', must be key=value", e.Tag)
+		}
+		if e.Days < 0 {
+			p.add("options.expire_source.days", "must not be negative")
+		}
+	}
+	asides := []struct{ name, value string }{{"trash", o.Trash}, {"quarantine", o.Quarantine}}
+	if ko := o.KeyObfuscation; ko != nil {
+		asides = append(asides, struct{ name, value string }{"key_obfuscation.mapping", ko.Mapping})
+		if strings.Trim(ko.Mapping, "/") == "" {
+			p.add("options.key_obfuscation.mapping", "must be set, names of keys are translated back with it")
+		}
+	}
+	for _, a := range asides {
+		if a.value == "" {
+			continue
+		}
+		prefix, dir := strings.Trim(a.value, "/")+"/", strings.Trim(o.Directory, "/")+"/"
+		if o.RawPrefix {
+			// raw prefix "a/b" covers also "a/b-old/..."
+			dir = strings.TrimSuffix(dir, "/")
+		}
+		switch {
+		case prefix == "/":
+			p.add("options."+a.name, "must not be empty")
+		case strings.Trim(o.Directory, "/") == "":
+			// whole bucket is copied, the prefix would be listed, copied and removed as orphans
+			p.add("options."+a.name, "can't be used without directory, it would be inside of copied bucket")
+		case strings.HasPrefix(prefix, dir) || strings.HasPrefix(dir, prefix):
+			p.add("options."+a.name, "must be outside of directory '%s'", o.Directory)
+		}
+	}
+	if o.Trash != "" && o.Quarantine != "" && strings.Trim(o.Trash, "/") == strings.Trim(o.Quarantine, "/") {
+		p.add("options.quarantine", "must differ from trash, purge would remove quarantined objects")
+	}
+	if q := o.Queue; q != nil {
+		if q.Type != QueueNATS && q.Type != QueueKafka {
+			p.add("options.queue.type", "unknown queue type '%s', must be %s or %s", q.Type, QueueNATS, QueueKafka)
+		}
+		if q.Topic == "" {
+			p.add("options.queue.topic", "must be set")
+		}
+	}
+}
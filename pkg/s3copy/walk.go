@@ -0,0 +1,158 @@
+package s3copy
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// directory tree of files, local or remote. keys are slash separated paths relative to its root
+type fileTree interface {
+	// entries of directory, "" is the root
+	readDir(dir string) ([]os.FileInfo, error)
+}
+
+// keys are listed in order of directory walk, which compares paths segment by segment
+// ("a/b" goes before "a-b"), so compare with separator sorting before any other byte
+func keyLess(a, b string) bool {
+	return strings.Replace(a, "/", "\x00", -1) < strings.Replace(b, "/", "\x00", -1)
+}
+
+// ETag of file is derived from its size and modification time, so changed file gets new ETag.
+// it never looks like md5, so content comparison hashes files
+func fileObject(key string, fi os.FileInfo) Object {
+	return Object{
+		Key:          key,
+		Size:         fi.Size(),
+		ETag:         fmt.Sprintf("%x-%x", fi.ModTime().UnixNano(), fi.Size()),
+		LastModified: fi.ModTime().UTC(),
+	}
+}
+
+// cursors of listings kept between pages. abandoned listings are evicted once there are more
+const maxTreeCursors = 16
+
+// directory being walked, entries before next are done
+type treeFrame struct {
+	dir     string
+	entries []os.FileInfo
+	next    int
+}
+
+// state of walk after the last object of a page, next page resumes from it without reading
+// directories again
+type treeCursor struct {
+	key    string
+	frames []treeFrame
+}
+
+// cursors of listings of tree by prefix and continuation token, zero value is ready to use
+type treeCursors struct {
+	sync.Mutex
+	cursors []*treeCursor
+}
+
+// remove and return frames of walk saved for token
+func (tc *treeCursors) take(prefix, token string) []treeFrame {
+	tc.Lock()
+	defer tc.Unlock()
+	for i, c := range tc.cursors {
+		if c.key == prefix+"\x00"+token {
+			tc.cursors = append(tc.cursors[:i], tc.cursors[i+1:]...)
+			return c.frames
+		}
+	}
+	return nil
+}
+
+func (tc *treeCursors) save(prefix, token string, frames []treeFrame) {
+	tc.Lock()
+	defer tc.Unlock()
+	if len(tc.cursors) >= maxTreeCursors {
+		tc.cursors = tc.cursors[1:]
+	}
+	tc.cursors = append(tc.cursors, &treeCursor{key: prefix + "\x00" + token, frames: frames})
+}
+
+// entries of directory sorted by name
+func readSortedDir(t fileTree, dir string) ([]os.FileInfo, error) {
+	entries, err := t.readDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+// list page of regular files of the tree with key prefix. token is the last key of the
+// previous page, walk resumes from cursor saved with it. without cursor, e.g. it was evicted,
+// walk starts from the root and subtrees with all keys before token or not matching prefix
+// aren't read
+func listTree(ctx context.Context, t fileTree, tc *treeCursors, prefix, token string, pageSize int) ([]Object, string, error) {
+	var frames []treeFrame
+	if token != "" {
+		frames = tc.take(prefix, token)
+	}
+	if frames == nil {
+		dir := prefix
+		if !strings.HasSuffix(prefix, "/") {
+			dir = path.Dir(prefix)
+		}
+		start := strings.Trim(path.Clean("/"+dir), "/")
+		entries, err := readSortedDir(t, start)
+		if os.IsNotExist(err) {
+			return nil, "", nil
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		frames = []treeFrame{{dir: start, entries: entries}}
+	}
+
+	var objs []Object
+	for len(frames) > 0 {
+		if err := ctx.Err(); err != nil {
+			return nil, "", err
+		}
+		f := &frames[len(frames)-1]
+		if f.next == len(f.entries) {
+			frames = frames[:len(frames)-1]
+			continue
+		}
+		fi := f.entries[f.next]
+		key := path.Join(f.dir, fi.Name())
+		if fi.IsDir() {
+			f.next++
+			// skip directory if all of its keys precede the token or don't match prefix
+			if token != "" && !strings.HasPrefix(token, key+"/") && keyLess(key, token) {
+				continue
+			}
+			if !strings.HasPrefix(key+"/", prefix) && !strings.HasPrefix(prefix, key+"/") {
+				continue
+			}
+			entries, err := readSortedDir(t, key)
+			if err != nil {
+				return nil, "", err
+			}
+			frames = append(frames, treeFrame{dir: key, entries: entries})
+			continue
+		}
+		if !fi.Mode().IsRegular() || !strings.HasPrefix(key, prefix) || token != "" && !keyLess(token, key) {
+			f.next++
+			continue
+		}
+		if len(objs) == pageSize {
+			// file starts the next page
+			next := objs[len(objs)-1].Key
+			tc.save(prefix, next, frames)
+			return objs, next, nil
+		}
+		f.next++
+		objs = append(objs, fileObject(key, fi))
+	}
+	return objs, "", nil
+}
@@ -0,0 +1,93 @@
+package s3copy
+
+import (
+	"sync"
+	"time"
+)
+
+// ETags of objects copied or found in sync with destination during watch,
+// used instead of state database when state_file isn't set
+type syncedObjects struct {
+	sync.Mutex
+	etags map[string]string
+}
+
+// record object which is in sync with destination
+func (cp *Copier) markSynced(key, etag string) {
+	if cp.state != nil {
+		cp.state.markCopied(key, etag)
+	}
+	if cp.synced != nil && etag != "" {
+		cp.synced.Lock()
+		cp.synced.etags[key] = etag
+		cp.synced.Unlock()
+	}
+}
+
+// object didn't change since it was copied or checked
+func (cp *Copier) unchanged(obj Object) bool {
+	if obj.ETag == "" {
+		return false
+	}
+	if cp.state != nil {
+		return cp.state.isCopied(obj.Key, obj.ETag)
+	}
+	cp.synced.Lock()
+	defer cp.synced.Unlock()
+	return cp.synced.etags[obj.Key] == obj.ETag
+}
+
+// re-sync directory every interval until copy is stopped. every pass lists source and
+// dispatches only new and modified objects, unchanged ones are skipped without requests
+// to destination. failed listing is retried on the next pass
+func (cp *Copier) watch(dir string, pageSize int, interval time.Duration) {
+	// counted totals of the first pass don't apply to the following ones
+	cp.oc.setTotal(-1, 0)
+
+	for pass := 1; ; pass++ {
+		logInfo("watching '%s/%s', next pass in %s", cp.bucket, dir, interval)
+		select {
+		case <-cp.stopCh:
+			return
+		case <-cp.ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+		passStart := time.Now()
+
+		doneCh, listDoneCh := make(chan struct{}), make(chan struct{})
+		changed, unchanged := 0, 0
+		changedCh := make(chan listEntry)
+		go func() {
+			defer close(listDoneCh)
+			defer close(changedCh)
+			for obj := range listObjects(cp.src, cp.bucket, dir, pageSize, nil, doneCh) {
+				// objects of other shards aren't counted
+				if obj.Err == nil && !cp.inShard(obj.Key) {
+					continue
+				}
+				if obj.Err == nil && (!cp.accepted(obj.Object) || cp.unchanged(obj.Object)) {
+					unchanged++
+					continue
+				}
+				changed++
+				select {
+				case changedCh <- obj:
+				case <-doneCh:
+					return
+				}
+			}
+		}()
+
+		listed := cp.dispatch(changedCh, true)
+		close(doneCh)
+		<-listDoneCh
+		cp.wait()
+		if !listed {
+			logWarn("watch pass %d: listing failed, retrying on the next pass", pass)
+			continue
+		}
+		logInfo("watch pass %d: %d new or modified objects, %d unchanged, took %s",
+			pass, changed, unchanged, time.Since(passStart).Round(time.Second))
+	}
+}
@@ -0,0 +1,292 @@
+package s3copy
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+const davPropfind = `<?xml version="1.0" encoding="utf-8"?><d:propfind xmlns:d="DAV:"><d:prop>` +
+	`<d:resourcetype/><d:getcontentlength/><d:getlastmodified/></d:prop></d:propfind>`
+
+// object store of WebDAV server (Nextcloud, ownCloud, Apache mod_dav), keys are paths
+// relative to path of the endpoint. endpoint is the dav url without scheme, e.g.
+// cloud.example.com/remote.php/dav/files/mirror, access_key and secret_key are
+// credentials of basic auth
+type webdavStore struct {
+	base     string
+	root     string
+	user     string
+	password string
+	// collections known to exist, so MKCOL is sent once per directory
+	dirs    sync.Map
+	cursors treeCursors
+}
+
+func newWebDAVStore(e Endpoint) (*webdavStore, error) {
+	addr := "http://" + e.Endpoint
+	if e.SSL {
+		addr = "https://" + e.Endpoint
+	}
+	base, err := url.Parse(strings.TrimSuffix(addr, "/"))
+	if err != nil {
+		return nil, err
+	}
+	return &webdavStore{base: base.String(), root: strings.Trim(path.Clean("/"+e.Path), "/"),
+		user: e.AccessKey, password: e.SecretKey}, nil
+}
+
+// url of collection or file, "" is the root
+func (s *webdavStore) url(key string) string {
+	return s.davURL(path.Join(s.root, path.Clean("/"+key)))
+}
+
+// url of path relative to endpoint
+func (s *webdavStore) davURL(p string) string {
+	return s.base + strings.TrimSuffix(escapeKey(path.Clean("/"+p)), "/")
+}
+
+// url of file, keys of root and collections are rejected
+func (s *webdavStore) fileURL(key string) (string, error) {
+	if err := checkPathKey(key, "webdav"); err != nil {
+		return "", err
+	}
+	return s.url(key), nil
+}
+
+func (s *webdavStore) do(ctx context.Context, method, u string, body io.Reader, size int64, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequest(method, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		requestBody(req, body, size)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if s.user != "" {
+		req.SetBasicAuth(s.user, s.password)
+	}
+	return doRequest(req.WithContext(ctx))
+}
+
+type davMultistatus struct {
+	Responses []struct {
+		Href     string `xml:"DAV: href"`
+		Propstat []struct {
+			Prop struct {
+				Collection    *struct{} `xml:"DAV: resourcetype>collection"`
+				ContentLength int64     `xml:"DAV: getcontentlength"`
+				LastModified  string    `xml:"DAV: getlastmodified"`
+			} `xml:"DAV: prop"`
+			Status string `xml:"DAV: status"`
+		} `xml:"DAV: propstat"`
+	} `xml:"DAV: response"`
+}
+
+// file info of PROPFIND response entry
+type davFileInfo struct {
+	name     string
+	size     int64
+	modified time.Time
+	dir      bool
+}
+
+func (fi *davFileInfo) Name() string       { return fi.name }
+func (fi *davFileInfo) Size() int64        { return fi.size }
+func (fi *davFileInfo) ModTime() time.Time { return fi.modified }
+func (fi *davFileInfo) IsDir() bool        { return fi.dir }
+func (fi *davFileInfo) Sys() interface{}   { return nil }
+func (fi *davFileInfo) Mode() os.FileMode {
+	if fi.dir {
+		return os.ModeDir | 0755
+	}
+	return 0644
+}
+
+// PROPFIND of key with depth 0 or 1, entries are returned with the key itself first.
+// missing key is reported with os.ErrNotExist
+func (s *webdavStore) propfind(ctx context.Context, key, depth string) ([]os.FileInfo, error) {
+	u := s.url(key)
+	resp, err := s.do(ctx, "PROPFIND", u, strings.NewReader(davPropfind), int64(len(davPropfind)),
+		http.Header{"Depth": {depth}, "Content-Type": {"application/xml"}})
+	if se, ok := err.(*statusError); ok && se.code == 404 {
+		return nil, &os.PathError{Op: "propfind", Path: key, Err: os.ErrNotExist}
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var ms davMultistatus
+	if err := xml.NewDecoder(resp.Body).Decode(&ms); err != nil {
+		return nil, err
+	}
+	self, _ := url.Parse(u)
+	var entries []os.FileInfo
+	for _, r := range ms.Responses {
+		href, err := url.Parse(r.Href)
+		if err != nil {
+			return nil, err
+		}
+		fi := &davFileInfo{name: path.Base(strings.TrimSuffix(href.Path, "/"))}
+		for _, ps := range r.Propstat {
+			if !strings.Contains(ps.Status, " 200 ") {
+				continue
+			}
+			fi.dir = ps.Prop.Collection != nil
+			fi.size = ps.Prop.ContentLength
+			fi.modified, _ = http.ParseTime(ps.Prop.LastModified)
+		}
+		if strings.TrimSuffix(href.Path, "/") == strings.TrimSuffix(self.Path, "/") {
+			entries = append([]os.FileInfo{fi}, entries...)
+			continue
+		}
+		entries = append(entries, fi)
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("empty PROPFIND response of '%s'", key)
+	}
+	return entries, nil
+}
+
+func (s *webdavStore) readDir(dir string) ([]os.FileInfo, error) {
+	entries, err := s.propfind(context.Background(), dir, "1")
+	if err != nil {
+		return nil, err
+	}
+	return entries[1:], nil
+}
+
+func (s *webdavStore) List(ctx context.Context, prefix, token string, pageSize int) ([]Object, string, error) {
+	return listTree(ctx, s, &s.cursors, prefix, token, pageSize)
+}
+
+func (s *webdavStore) Stat(ctx context.Context, key string) (Object, error) {
+	if _, err := s.fileURL(key); err != nil {
+		return Object{}, err
+	}
+	entries, err := s.propfind(ctx, key, "0")
+	if os.IsNotExist(err) || (err == nil && entries[0].IsDir()) {
+		return Object{}, ErrNotFound
+	}
+	if err != nil {
+		return Object{}, err
+	}
+	return fileObject(key, entries[0]), nil
+}
+
+func (s *webdavStore) Get(ctx context.Context, key string, offset, length int64) (io.ReadCloser, Object, error) {
+	obj, err := s.Stat(ctx, key)
+	if err != nil {
+		return nil, Object{}, err
+	}
+	u, _ := s.fileURL(key)
+	header := http.Header{}
+	if length > 0 {
+		header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	} else if offset > 0 {
+		header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := s.do(ctx, "GET", u, nil, 0, header)
+	if err != nil {
+		return nil, Object{}, err
+	}
+	// servers ignoring Range send whole file
+	if offset > 0 && resp.StatusCode != http.StatusPartialContent {
+		resp.Body.Close()
+		return nil, Object{}, fmt.Errorf("webdav server doesn't support ranged GET of '%s'", key)
+	}
+	if length >= 0 {
+		return struct {
+			io.Reader
+			io.Closer
+		}{io.LimitReader(resp.Body, length), resp.Body}, obj, nil
+	}
+	return resp.Body, obj, nil
+}
+
+// create collection and its parents, existing collections are answered with 405.
+// dir is relative to endpoint, which must exist
+func (s *webdavStore) mkdirAll(ctx context.Context, dir string) error {
+	if dir == "" || dir == "." {
+		return nil
+	}
+	if _, ok := s.dirs.Load(dir); ok {
+		return nil
+	}
+	if err := s.mkdirAll(ctx, path.Dir(dir)); err != nil {
+		return err
+	}
+	resp, err := s.do(ctx, "MKCOL", s.davURL(dir), nil, 0, nil)
+	if se, ok := err.(*statusError); ok && se.code == 405 {
+		err = nil
+	} else if err == nil {
+		resp.Body.Close()
+	}
+	if err != nil {
+		return fmt.Errorf("creating collection '%s': %s", dir, err)
+	}
+	s.dirs.Store(dir, true)
+	return nil
+}
+
+// file is uploaded under temporary name and moved, so users of the share never see partial file
+func (s *webdavStore) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) (int64, error) {
+	u, err := s.fileURL(key)
+	if err != nil {
+		return 0, err
+	}
+	dir := strings.TrimPrefix(path.Dir(path.Clean("/"+key)), "/")
+	if err := s.mkdirAll(ctx, path.Join(s.root, dir)); err != nil {
+		return 0, err
+	}
+	tmp := s.url(path.Join(dir, ".s3-copy-dir-"+path.Base(key)))
+	header := http.Header{}
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	resp, err := s.do(ctx, "PUT", tmp, r, size, header)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+
+	resp, err = s.do(ctx, "MOVE", tmp, nil, 0, http.Header{"Destination": {u}, "Overwrite": {"T"}})
+	if err != nil {
+		if resp, derr := s.do(context.Background(), "DELETE", tmp, nil, 0, nil); derr == nil {
+			resp.Body.Close()
+		}
+		return 0, err
+	}
+	resp.Body.Close()
+	return size, nil
+}
+
+// removing missing file isn't an error, same as in S3
+func (s *webdavStore) Delete(ctx context.Context, key string) error {
+	if _, err := s.Stat(ctx, key); err == ErrNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	u, _ := s.fileURL(key)
+	resp, err := s.do(ctx, "DELETE", u, nil, 0, nil)
+	if se, ok := err.(*statusError); ok && se.code == 404 {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
@@ -0,0 +1,208 @@
+package s3copy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// idle worker asks coordinator for new unit with this interval
+	workerPollInterval = time.Second * 2
+	// units copied at the same time, so workers don't idle while waiting for the slowest objects of a unit
+	workerUnits = 2
+)
+
+// copy worker of distributed copy: units of objects are leased from coordinator,
+// renewed while they're copied and reported once done. feed ends when copy is complete
+type coordinatorFeed struct {
+	url    string
+	token  string
+	worker string
+	client *http.Client
+}
+
+func newCoordinatorFeed(url, token, worker string) *coordinatorFeed {
+	if worker == "" {
+		host, _ := os.Hostname()
+		worker = host + "-" + randomID(4)
+	}
+	return &coordinatorFeed{url: strings.TrimSuffix(url, "/"), token: token, worker: worker,
+		client: &http.Client{Timeout: time.Minute}}
+}
+
+func (f *coordinatorFeed) changes(ctx context.Context, prefix string) <-chan changeEvent {
+	ch := make(chan changeEvent)
+	go func() {
+		defer close(ch)
+		logInfo("working for coordinator %s as '%s'", f.url, f.worker)
+		var wg sync.WaitGroup
+		defer wg.Wait()
+		sem := make(chan struct{}, workerUnits)
+		for {
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			var l unitLease
+			code, err := f.call(ctx, "/lease", unitResult{Worker: f.worker}, &l)
+			switch {
+			case ctx.Err() != nil:
+				return
+			case code == http.StatusGone:
+				logInfo("coordinator reported copy is complete")
+				return
+			case code == http.StatusUnauthorized:
+				select {
+				case ch <- changeEvent{Err: errors.New("coordinator rejected token")}:
+				case <-ctx.Done():
+				}
+				return
+			case err != nil || code == http.StatusNoContent:
+				if err != nil {
+					logWarn("leasing unit from coordinator: %s, retrying in %s", err, listenRetryDelay)
+				}
+				<-sem
+				delay := workerPollInterval
+				if err != nil {
+					delay = listenRetryDelay
+				}
+				select {
+				case <-time.After(delay):
+				case <-ctx.Done():
+					return
+				}
+				continue
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				f.work(ctx, l, ch)
+				<-sem
+			}()
+		}
+	}()
+	return ch
+}
+
+// send objects of unit to ch, renew lease until all of them are handled and report result
+func (f *coordinatorFeed) work(ctx context.Context, l unitLease, ch chan<- changeEvent) {
+	logInfo("leased unit %s of %d objects", l.ID, len(l.Objects))
+	doneCh := make(chan struct{})
+	go f.renew(ctx, l, doneCh)
+	defer close(doneCh)
+
+	var mu sync.Mutex
+	var failed []string
+	var wg sync.WaitGroup
+	for i := range l.Objects {
+		obj := &l.Objects[i]
+		wg.Add(1)
+		ev := changeEvent{Key: obj.Key, Object: obj, done: func(ok bool) {
+			if !ok {
+				mu.Lock()
+				failed = append(failed, obj.Key)
+				mu.Unlock()
+			}
+			wg.Done()
+		}}
+		select {
+		case ch <- ev:
+		case <-ctx.Done():
+			return
+		}
+	}
+	// objects aren't handled once copy is stopped, unit is re-assigned by coordinator
+	handledCh := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(handledCh)
+	}()
+	select {
+	case <-handledCh:
+	case <-ctx.Done():
+		return
+	}
+	mu.Lock()
+	res := unitResult{Worker: f.worker, Processed: int64(len(l.Objects)), Failed: failed}
+	mu.Unlock()
+	for attempt := 0; ; attempt++ {
+		code, err := f.call(context.Background(), "/leases/"+l.ID+"/complete", res, nil)
+		switch {
+		case err == nil && code == http.StatusNotFound:
+			logWarn("unit %s was re-assigned before it was completed", l.ID)
+		case err == nil:
+			logInfo("completed unit %s, %d objects, %d failed", l.ID, len(l.Objects), len(failed))
+		case attempt < 3:
+			time.Sleep(listenRetryDelay)
+			continue
+		default:
+			logError("reporting unit %s to coordinator: %s, it will be re-assigned", l.ID, err)
+		}
+		return
+	}
+}
+
+// renew lease of unit every third of its duration until doneCh is closed
+func (f *coordinatorFeed) renew(ctx context.Context, l unitLease, doneCh <-chan struct{}) {
+	ticker := time.NewTicker(time.Duration(l.LeaseSec * float64(time.Second) / 3))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-doneCh:
+			return
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		code, err := f.call(ctx, "/leases/"+l.ID+"/renew", unitResult{Worker: f.worker}, nil)
+		switch {
+		case err != nil:
+			logWarn("renewing lease of unit %s: %s", l.ID, err)
+		case code == http.StatusNotFound:
+			logWarn("lease of unit %s expired, it was re-assigned to another worker", l.ID)
+			return
+		}
+	}
+}
+
+// POST json request to coordinator, returns status code. non 2xx statuses
+// other than 401, 404 and 410 are returned as errors
+func (f *coordinatorFeed) call(ctx context.Context, path string, body, res interface{}) (int, error) {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequest("POST", f.url+path, bytes.NewReader(b))
+	if err != nil {
+		return 0, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	if f.token != "" {
+		req.Header.Set("Authorization", "Bearer "+f.token)
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusUnauthorized, http.StatusNotFound, http.StatusGone, http.StatusNoContent:
+		return resp.StatusCode, nil
+	}
+	if err := checkResponse(resp); err != nil {
+		return resp.StatusCode, err
+	}
+	if res != nil {
+		return resp.StatusCode, json.NewDecoder(resp.Body).Decode(res)
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"github.com/dabealu/s3-copy-dir/pkg/s3copy"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// send notification to systemd, no-op when not running as systemd service with Type=notify
+func sdNotify(state string) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return
+	}
+	if socket[0] == '@' {
+		// abstract socket
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		logDebug("sd_notify: %s", err)
+		return
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		logDebug("sd_notify: %s", err)
+	}
+}
+
+// watchdog interval requested by systemd (WatchdogSec=), 0 if watchdog is disabled
+func sdWatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid, err := strconv.Atoi(os.Getenv("WATCHDOG_PID")); err == nil && pid != os.Getpid() {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// notify systemd that service is ready and keep STATUS updated with status until stopCh is
+// closed, then notify it's stopping. watchdog is pinged only when status reports that service
+// is alive, so systemd restarts service which is stuck for WatchdogSec
+func sdSupervise(status func() (string, bool), stopCh <-chan struct{}) {
+	if os.Getenv("NOTIFY_SOCKET") == "" {
+		return
+	}
+	sdNotify("READY=1")
+
+	watchdog := sdWatchdogInterval()
+	interval := time.Second * 10
+	if watchdog > 0 && watchdog/3 < interval {
+		interval = watchdog / 3
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-stopCh:
+			sdNotify("STOPPING=1")
+			return
+		}
+		state, alive := status()
+		if watchdog > 0 && alive {
+			state += "\nWATCHDOG=1"
+		}
+		sdNotify(state)
+	}
+}
+
+// status of copy with live progress, copy is alive while objects are being processed (or
+// copy is paused)
+func copyStatus(cp *s3copy.Copier) func() (string, bool) {
+	lastCurrent := int64(-1)
+	return func() (string, bool) {
+		p := cp.Progress()
+		total := ""
+		if p.Total >= 0 {
+			total = fmt.Sprintf("/%d", p.Total)
+		}
+		state := fmt.Sprintf("STATUS=%d%s processed, %d copied, %d failed, %s transferred",
+			p.Processed, total, p.Copied, p.Failed, s3copy.FormatBytes(p.Bytes))
+		alive := p.Processed != lastCurrent || p.Paused
+		lastCurrent = p.Processed
+		return state, alive
+	}
+}
+
+// status of daemon with numbers of running and queued jobs, daemon is alive while it answers,
+// jobs making no progress don't stop pings
+func daemonStatus(m *s3copy.JobManager) func() (string, bool) {
+	return func() (string, bool) {
+		var running, queued int
+		for _, j := range m.List() {
+			switch j.Status {
+			case s3copy.JobRunning:
+				running++
+			case s3copy.JobQueued:
+				queued++
+			}
+		}
+		return fmt.Sprintf("STATUS=%d jobs running, %d queued", running, queued), true
+	}
+}
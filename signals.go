@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"github.com/dabealu/s3-copy-dir/pkg/s3copy"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// copier or pipeline which stops dispatching new objects on Stop
+type stopper interface {
+	Stop()
+}
+
+// on SIGINT/SIGTERM stop dispatching new objects and let in-flight copies finish,
+// in-flight copies are cancelled when grace period expires or on the second signal.
+// returned func stops signal handling
+func handleShutdown(cp stopper, cancel context.CancelFunc, grace time.Duration) func() {
+	sigCh := make(chan os.Signal, 2)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	doneCh := make(chan struct{})
+
+	go func() {
+		select {
+		case sig := <-sigCh:
+			logWarn("received %s, waiting up to %s for in-flight copies, repeat to cancel them now", sig, grace)
+			cp.Stop()
+		case <-doneCh:
+			return
+		}
+
+		timer := time.NewTimer(grace)
+		defer timer.Stop()
+		select {
+		case sig := <-sigCh:
+			logWarn("received %s, cancelling in-flight copies", sig)
+		case <-timer.C:
+			logWarn("grace period expired, cancelling in-flight copies")
+		case <-doneCh:
+			return
+		}
+		cancel()
+	}()
+
+	return func() {
+		signal.Stop(sigCh)
+		close(doneCh)
+	}
+}
+
+// pause copying on SIGTSTP, resume on SIGCONT, SIGUSR2 toggles between them.
+// paused copy doesn't dispatch new objects, in-flight copies are drained.
+// returned func stops signal handling
+func handlePause(cp *s3copy.Copier) func() {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGTSTP, syscall.SIGCONT, syscall.SIGUSR2)
+	doneCh := make(chan struct{})
+
+	go func() {
+		for {
+			select {
+			case sig := <-sigCh:
+				pause := sig == syscall.SIGTSTP || (sig == syscall.SIGUSR2 && !cp.Paused())
+				if pause && cp.Pause() {
+					logWarn("received %s, pausing, waiting for %d in-flight copies", sig, cp.Progress().ActiveWorkers)
+					go logDrained(cp, doneCh)
+				} else if !pause && cp.Paused() {
+					cp.Resume()
+					logWarn("received %s, resuming", sig)
+				}
+			case <-doneCh:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		signal.Stop(sigCh)
+		close(doneCh)
+	}
+}
+
+// log once all in-flight copies of paused copy are done
+func logDrained(cp *s3copy.Copier, doneCh <-chan struct{}) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p := cp.Progress()
+			if !p.Paused {
+				return
+			}
+			if p.ActiveWorkers == 0 {
+				logWarn("paused, all in-flight copies completed")
+				return
+			}
+		case <-doneCh:
+			return
+		}
+	}
+}
+
+// print snapshot of live statistics on SIGUSR1. returned func stops signal handling
+func handleStatsDump(cp *s3copy.Copier) func() {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGUSR1)
+	doneCh := make(chan struct{})
+
+	go func() {
+		for {
+			select {
+			case <-sigCh:
+				cp.DumpStats()
+			case <-doneCh:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		signal.Stop(sigCh)
+		close(doneCh)
+	}
+}